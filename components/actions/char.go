@@ -28,6 +28,31 @@ func (a Char) CreateHandler(p flowapi.ActionProvider) (flowapi.ActionHandler, er
 	return NewCharActionHandler(p.Context(), rune(char[0]), p.Args().Boolean("rightShift"), p.Args().Duration("modDelay"))
 }
 
+func (a Char) EmittedUsages(args flowapi.Arguments) ([]hidapi.Usage, error) {
+	char := args.String("char")
+	if len(char) != 1 {
+		return nil, fmt.Errorf("char must be a single character")
+	}
+	return charUsages(rune(char[0]), args.Boolean("rightShift"))
+}
+
+// charUsages returns the key of the character and the shift key if the character is shifted.
+func charUsages(char rune, rightShift bool) ([]hidapi.Usage, error) {
+	key, shift, err := GetAsciiCharKey(char)
+	if err != nil {
+		return nil, err
+	}
+	usages := []hidapi.Usage{hidapi.NewUsage(usagepages.KeyboardKeypad, uint16(key))}
+	if shift {
+		shiftKey := usagepages.KeyLeftShift
+		if rightShift {
+			shiftKey = usagepages.KeyRightShift
+		}
+		usages = append(usages, hidapi.NewUsage(usagepages.KeyboardKeypad, uint16(shiftKey)))
+	}
+	return usages, nil
+}
+
 func NewCharActionHandler(ctx context.Context, char rune, rightShift bool, modDelay time.Duration) (flowapi.ActionHandler, error) {
 	key, shift, err := GetAsciiCharKey(char)
 	if err != nil {
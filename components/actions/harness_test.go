@@ -0,0 +1,85 @@
+package actions
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/neuroplastio/neio-agent/flowapi"
+	"github.com/neuroplastio/neio-agent/hidapi"
+	"github.com/neuroplastio/neio-agent/pkg/clock"
+	"go.uber.org/zap"
+)
+
+var testStart = time.Unix(0, 0)
+
+// harness runs actions the way the bind node does, on the test goroutine and a virtual clock.
+type harness struct {
+	t     *testing.T
+	clock *clock.Virtual
+	pool  *flowapi.ActionContextPool
+	// events are emitted events formatted as "usages@offset"
+	events []string
+}
+
+func newHarness(t *testing.T) *harness {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	h := &harness{
+		t:     t,
+		clock: clock.NewVirtual(testStart),
+	}
+	h.pool = flowapi.NewActionContextPool(ctx, zap.NewNop(), h.emit, flowapi.WithClock(h.clock))
+	return h
+}
+
+func (h *harness) emit(event *hidapi.Event) {
+	h.events = append(h.events, fmt.Sprintf("%s@%s", event, event.Timestamp().Sub(testStart)))
+	event.Release()
+}
+
+// input handles an input event with usages changed by fn. Like the bind node, activations
+// interrupt other actions.
+func (h *harness) input(fn func(ac flowapi.ActionContext)) {
+	ac := h.pool.New(hidapi.NewEventAt(h.clock.Now()))
+	fn(ac)
+	for _, usage := range ac.HIDEvent().Usages() {
+		if usage.Type == hidapi.UsageEventActivate {
+			h.pool.Interrupt(ac)
+			break
+		}
+	}
+	if !ac.HIDEvent().IsEmpty() {
+		h.emit(ac.HIDEvent())
+	}
+}
+
+func (h *harness) press(handler flowapi.ActionHandler) flowapi.ActionFinalizer {
+	var fin flowapi.ActionFinalizer
+	h.input(func(ac flowapi.ActionContext) {
+		fin = handler(ac)
+	})
+	return fin
+}
+
+func (h *harness) release(fin flowapi.ActionFinalizer) {
+	h.input(fin.Call)
+}
+
+func (h *harness) advance(d time.Duration) {
+	h.clock.Advance(d)
+	select {
+	case <-h.pool.Timer():
+		h.pool.RunTimers()
+	default:
+	}
+}
+
+func (h *harness) expect(events ...string) {
+	h.t.Helper()
+	if fmt.Sprint(h.events) != fmt.Sprint(events) {
+		h.t.Fatalf("expected events %q, got %q", events, h.events)
+	}
+	h.events = nil
+}
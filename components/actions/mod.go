@@ -34,13 +34,14 @@ func NewModHandler(ctx context.Context, modifier []hidapi.Usage, action flowapi.
 	return func(ac flowapi.ActionContext) flowapi.ActionFinalizer {
 		ac.HIDEvent().Activate(modifier...)
 		return ac.Async(func(async flowapi.AsyncActionContext) {
-			<-async.After(duration)
-			fin := async.Action(action)
-			async.OnFinish(func(ac flowapi.ActionContext) {
-				if fin != nil {
-					fin(ac)
-				}
+			var fin flowapi.ActionFinalizer
+			async.After(duration, func(ac flowapi.ActionContext) {
+				fin = action(ac)
+			})
+			async.OnRelease(func(ac flowapi.ActionContext) {
+				fin.Call(ac)
 				ac.HIDEvent().Deactivate(modifier...)
+				async.Finish()
 			})
 		})
 	}
@@ -32,27 +32,21 @@ func NewRepeatActionHandler(ctx context.Context, action flowapi.ActionHandler, d
 	return func(ac flowapi.ActionContext) flowapi.ActionFinalizer {
 		fin := action(ac)
 		return ac.Async(func(async flowapi.AsyncActionContext) {
-			select {
-			case <-async.After(delay - half):
-			case <-async.Finished():
-				async.Finish(fin)
-				return
+			var press, release func(ac flowapi.ActionContext)
+			press = func(ac flowapi.ActionContext) {
+				fin = action(ac)
+				async.After(half, release)
 			}
-			for {
-				async.Finish(fin)
-				select {
-				case <-time.After(half):
-				case <-async.Finished():
-					return
-				}
-				fin = async.Action(action)
-				select {
-				case <-time.After(half):
-				case <-async.Finished():
-					async.Finish(fin)
-					return
-				}
+			release = func(ac flowapi.ActionContext) {
+				fin.Call(ac)
+				fin = nil
+				async.After(half, press)
 			}
+			async.After(delay-half, release)
+			async.OnRelease(func(ac flowapi.ActionContext) {
+				fin.Call(ac)
+				async.Finish()
+			})
 		})
 	}
 }
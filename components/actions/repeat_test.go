@@ -0,0 +1,29 @@
+package actions
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/neuroplastio/neio-agent/flowapi"
+)
+
+func TestRepeat(t *testing.T) {
+	h := newHarness(t)
+	a := usageA.String()
+	repeat := NewRepeatActionHandler(context.Background(), flowapi.NewToggleActionHandler(usageA), 100*time.Millisecond, 20*time.Millisecond, time.Millisecond)
+
+	fin := h.press(repeat)
+	h.advance(135 * time.Millisecond)
+	h.release(fin)
+	h.expect(
+		"+"+a+"@0s",
+		"-"+a+"@90ms",
+		"+"+a+"@100ms",
+		"-"+a+"@110ms",
+		"+"+a+"@120ms",
+		"-"+a+"@130ms",
+	)
+	h.advance(time.Second)
+	h.expect()
+}
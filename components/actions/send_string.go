@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"github.com/neuroplastio/neio-agent/flowapi"
+	"github.com/neuroplastio/neio-agent/hidapi"
 )
 
 type SendString struct{}
@@ -36,27 +37,47 @@ func (a SendString) CreateHandler(p flowapi.ActionProvider) (flowapi.ActionHandl
 	return NewActionChainHandler(p.Context(), actions, delay), nil
 }
 
+func (a SendString) EmittedUsages(args flowapi.Arguments) ([]hidapi.Usage, error) {
+	var usages []hidapi.Usage
+	for _, c := range args.String("value") {
+		charUsages, err := charUsages(c, args.Boolean("rightShift"))
+		if err != nil {
+			return nil, err
+		}
+		usages = append(usages, charUsages...)
+	}
+	return usages, nil
+}
+
 func NewActionChainHandler(ctx context.Context, actions []flowapi.ActionHandler, delay time.Duration) flowapi.ActionHandler {
 	if len(actions) == 0 {
 		return NewActionNoneHandler()
 	}
 	return func(ac flowapi.ActionContext) flowapi.ActionFinalizer {
 		return ac.Async(func(async flowapi.AsyncActionContext) {
-			for _, action := range actions {
-				fin := async.Action(action)
-				select {
-				case <-async.After(delay):
-				case <-async.Interrupt():
-					async.Finish(fin)
-					return
-				}
-				async.Finish(fin)
-				select {
-				case <-async.After(delay):
-				case <-async.Interrupt():
-					return
-				}
+			var (
+				fin  flowapi.ActionFinalizer
+				next int
+			)
+			var press func(ac flowapi.ActionContext)
+			press = func(ac flowapi.ActionContext) {
+				fin = actions[next](ac)
+				next++
+				async.After(delay, func(ac flowapi.ActionContext) {
+					fin.Call(ac)
+					fin = nil
+					if next == len(actions) {
+						async.Finish()
+						return
+					}
+					async.After(delay, press)
+				})
 			}
+			async.OnInterrupt(func(ac flowapi.ActionContext) {
+				fin.Call(ac)
+				async.Finish()
+			})
+			press(ac)
 		})
 	}
 }
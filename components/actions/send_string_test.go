@@ -0,0 +1,36 @@
+package actions
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/neuroplastio/neio-agent/flowapi"
+)
+
+func TestSendString(t *testing.T) {
+	h := newHarness(t)
+	a, b, shift := usageA.String(), usageB.String(), usageShift.String()
+
+	charA, err := NewCharActionHandler(context.Background(), 'a', false, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	charB, err := NewCharActionHandler(context.Background(), 'B', false, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fin := h.press(NewActionChainHandler(context.Background(), []flowapi.ActionHandler{charA, charB}, 4*time.Millisecond))
+	h.release(fin)
+	h.advance(time.Second)
+	h.expect(
+		"+"+a+"@0s",
+		"-"+a+"@4ms",
+		"+"+shift+"@8ms",
+		"+"+b+"@9ms",
+		"-"+b+", -"+shift+"@12ms",
+	)
+	if h.pool.Active() != 0 {
+		t.Fatalf("expected no active actions, got %d", h.pool.Active())
+	}
+}
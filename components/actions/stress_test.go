@@ -0,0 +1,140 @@
+package actions
+
+import (
+	"context"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/neuroplastio/neio-agent/flowapi"
+	"github.com/neuroplastio/neio-agent/hidapi"
+	"github.com/neuroplastio/neio-agent/hidapi/hidusage/usagepages"
+	"go.uber.org/zap"
+)
+
+// TestActionsStress presses keys bound to tapHold, repeat and sendString from several goroutines
+// and checks that every activated usage is deactivated in the end.
+func TestActionsStress(t *testing.T) {
+	key := func(k uint8) hidapi.Usage {
+		return hidapi.NewUsage(usagepages.KeyboardKeypad, uint16(k))
+	}
+	chars := make([]flowapi.ActionHandler, 0, 4)
+	for _, c := range "aB!1" {
+		char, err := NewCharActionHandler(context.Background(), c, false, 300*time.Microsecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chars = append(chars, char)
+	}
+	handlers := []flowapi.ActionHandler{
+		NewActionTapHoldHandler(context.Background(),
+			flowapi.NewToggleActionHandler(key(usagepages.KeyC)),
+			flowapi.NewToggleActionHandler(key(usagepages.KeyLeftControl)),
+			2*time.Millisecond, time.Millisecond, true,
+		),
+		NewRepeatActionHandler(context.Background(), flowapi.NewToggleActionHandler(key(usagepages.KeyD)), 3*time.Millisecond, 2*time.Millisecond, time.Millisecond),
+		NewActionChainHandler(context.Background(), chars, 500*time.Microsecond),
+	}
+
+	type op struct {
+		key   int
+		press bool
+	}
+	ops := make(chan op)
+	idle := make(chan chan bool)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	counts := make(map[hidapi.Usage]int)
+	var negative []string
+	record := func(event *hidapi.Event) {
+		for _, usage := range event.Usages() {
+			switch usage.Type {
+			case hidapi.UsageEventActivate:
+				counts[usage.Usage]++
+			case hidapi.UsageEventDeactivate:
+				counts[usage.Usage]--
+				if counts[usage.Usage] < 0 {
+					negative = append(negative, usage.Usage.String())
+				}
+			}
+		}
+		event.Release()
+	}
+
+	loopDone := make(chan struct{})
+	go func() {
+		defer close(loopDone)
+		pool := flowapi.NewActionContextPool(ctx, zap.NewNop(), record)
+		fins := make([]flowapi.ActionFinalizer, len(handlers))
+		pressed := make([]bool, len(handlers))
+		for {
+			select {
+			case op := <-ops:
+				ac := pool.New(hidapi.NewEvent())
+				switch {
+				case op.press && !pressed[op.key]:
+					pressed[op.key] = true
+					fins[op.key] = handlers[op.key](ac)
+				case !op.press && pressed[op.key]:
+					pressed[op.key] = false
+					fins[op.key].Call(ac)
+					fins[op.key] = nil
+				}
+				if op.press {
+					pool.Interrupt(ac)
+				}
+				record(ac.HIDEvent())
+			case <-pool.Timer():
+				pool.RunTimers()
+			case reply := <-idle:
+				reply <- pool.Active() == 0
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(seed int64) {
+			defer wg.Done()
+			rnd := rand.New(rand.NewSource(seed))
+			for j := 0; j < 300; j++ {
+				ops <- op{key: rnd.Intn(len(handlers)), press: rnd.Intn(2) == 0}
+				time.Sleep(time.Duration(rnd.Intn(1500)) * time.Microsecond)
+			}
+		}(int64(i))
+	}
+	wg.Wait()
+	for i := range handlers {
+		ops <- op{key: i, press: false}
+	}
+
+	deadline := time.After(5 * time.Second)
+	for {
+		reply := make(chan bool)
+		idle <- reply
+		if <-reply {
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("actions did not finish")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	cancel()
+	<-loopDone
+
+	if len(negative) > 0 {
+		t.Errorf("usages deactivated before activation: %v", negative)
+	}
+	for usage, count := range counts {
+		if count != 0 {
+			t.Errorf("usage %s has %d activations left", usage, count)
+		}
+	}
+}
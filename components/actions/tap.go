@@ -28,9 +28,11 @@ func (a Tap) CreateHandler(p flowapi.ActionProvider) (flowapi.ActionHandler, err
 func NewActionTapHandler(ctx context.Context, action flowapi.ActionHandler, tapDuration time.Duration) flowapi.ActionHandler {
 	return func(ac flowapi.ActionContext) flowapi.ActionFinalizer {
 		return ac.Async(func(async flowapi.AsyncActionContext) {
-			fin := async.Action(action)
-			<-async.After(tapDuration)
-			async.Finish(fin)
+			fin := action(ac)
+			async.After(tapDuration, func(ac flowapi.ActionContext) {
+				fin.Call(ac)
+				async.Finish()
+			})
 		})
 	}
 }
@@ -35,17 +35,35 @@ func (a TapHold) CreateHandler(p flowapi.ActionProvider) (flowapi.ActionHandler,
 func NewActionTapHoldHandler(ctx context.Context, onTap flowapi.ActionHandler, onHold flowapi.ActionHandler, delay time.Duration, tapDuration time.Duration, interrupt bool) flowapi.ActionHandler {
 	return func(ac flowapi.ActionContext) flowapi.ActionFinalizer {
 		return ac.Async(func(async flowapi.AsyncActionContext) {
-			select {
-			case <-async.Interrupt():
-				async.OnFinish(async.Action(onHold))
-				<-async.After(tapDuration)
-			case <-async.After(delay):
-				async.OnFinish(async.Action(onHold))
-			case <-async.Finished():
-				fin := async.Action(onTap)
-				<-async.After(tapDuration)
-				async.Finish(fin)
+			var (
+				fin  flowapi.ActionFinalizer
+				held bool
+			)
+			var cancelHold func()
+			hold := func(ac flowapi.ActionContext) {
+				cancelHold()
+				async.OnInterrupt(nil)
+				held = true
+				fin = onHold(ac)
 			}
+			cancelHold = async.After(delay, hold)
+			if interrupt {
+				async.OnInterrupt(hold)
+			}
+			async.OnRelease(func(ac flowapi.ActionContext) {
+				if held {
+					fin.Call(ac)
+					async.Finish()
+					return
+				}
+				cancelHold()
+				async.OnInterrupt(nil)
+				fin = onTap(ac)
+				async.After(tapDuration, func(ac flowapi.ActionContext) {
+					fin.Call(ac)
+					async.Finish()
+				})
+			})
 		})
 	}
 }
@@ -0,0 +1,67 @@
+package actions
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/neuroplastio/neio-agent/flowapi"
+	"github.com/neuroplastio/neio-agent/hidapi"
+	"github.com/neuroplastio/neio-agent/hidapi/hidusage/usagepages"
+)
+
+var (
+	usageA     = hidapi.NewUsage(usagepages.KeyboardKeypad, uint16(usagepages.KeyA))
+	usageB     = hidapi.NewUsage(usagepages.KeyboardKeypad, uint16(usagepages.KeyB))
+	usageShift = hidapi.NewUsage(usagepages.KeyboardKeypad, uint16(usagepages.KeyLeftShift))
+)
+
+func TestTapHold(t *testing.T) {
+	const (
+		delay       = 250 * time.Millisecond
+		tapDuration = 10 * time.Millisecond
+	)
+	tapHold := NewActionTapHoldHandler(context.Background(),
+		flowapi.NewToggleActionHandler(usageA),
+		flowapi.NewToggleActionHandler(usageShift),
+		delay, tapDuration, true,
+	)
+	a, shift, b := usageA.String(), usageShift.String(), usageB.String()
+
+	t.Run("hold", func(t *testing.T) {
+		h := newHarness(t)
+		fin := h.press(tapHold)
+		h.advance(delay - time.Millisecond)
+		h.expect()
+		h.advance(time.Millisecond)
+		h.expect("+" + shift + "@250ms")
+		h.advance(50 * time.Millisecond)
+		h.release(fin)
+		h.expect("-" + shift + "@300ms")
+	})
+
+	t.Run("tap", func(t *testing.T) {
+		h := newHarness(t)
+		fin := h.press(tapHold)
+		h.advance(100 * time.Millisecond)
+		h.release(fin)
+		h.expect("+" + a + "@100ms")
+		h.advance(time.Second)
+		h.expect("-" + a + "@110ms")
+	})
+
+	t.Run("interrupt", func(t *testing.T) {
+		h := newHarness(t)
+		fin := h.press(tapHold)
+		h.advance(50 * time.Millisecond)
+		h.press(flowapi.NewToggleActionHandler(usageB))
+		h.expect("+"+shift+"@50ms", "+"+b+"@50ms")
+		h.advance(time.Second)
+		h.expect()
+		h.release(fin)
+		h.expect("-" + shift + "@1.05s")
+		if h.pool.Active() != 0 {
+			t.Fatalf("expected no active actions, got %d", h.pool.Active())
+		}
+	})
+}
@@ -3,11 +3,14 @@ package nodes
 import (
 	"context"
 	"fmt"
+	"slices"
+	"strings"
 
 	"github.com/neuroplastio/neio-agent/flowapi"
 	"github.com/neuroplastio/neio-agent/flowapi/flowdsl"
 	"github.com/neuroplastio/neio-agent/hidapi"
 	"github.com/neuroplastio/neio-agent/hidapi/hidusage"
+	"github.com/neuroplastio/neio-agent/pkg/clock"
 	"go.uber.org/zap"
 )
 
@@ -86,22 +89,103 @@ func (b *Bind) Configure(c flowapi.NodeConfigurator) error {
 	return nil
 }
 
-func (b *Bind) Run(ctx context.Context, up flowapi.Stream, down flowapi.Stream) error {
-	in := up.Subscribe(ctx)
-	sendCh := make(chan *hidapi.Event)
-	actionPool := flowapi.NewActionContextPool(ctx, b.log, sendCh)
-	go func() {
-		for {
-			select {
-			case event := <-sendCh:
-				down.Broadcast(flowapi.Event{
-					HID: event,
-				})
-			case <-ctx.Done():
-				return
+func (b *Bind) DescribeGraph(c flowapi.NodeConfigurator) (flowapi.NodeGraphDescription, error) {
+	var config bindConfig
+	if err := c.Unmarshal(&config); err != nil {
+		return flowapi.NodeGraphDescription{}, err
+	}
+	desc := flowapi.NodeGraphDescription{
+		Bindings: make([]flowapi.Binding, 0, len(config.Map)),
+	}
+	for _, item := range config.Map {
+		desc.Bindings = append(desc.Bindings, flowapi.Binding{
+			Usage:     item.UsageString,
+			Statement: item.StatementString,
+		})
+	}
+	return desc, nil
+}
+
+// AnalyzeUsages reports mappings that can never be triggered and mappings that share trigger
+// usages, which fire together in config order. Mappings are sorted to report findings in a stable order.
+func (b *Bind) AnalyzeUsages(a flowapi.UsageAnalysis) error {
+	var config bindConfig
+	if err := a.Unmarshal(&config); err != nil {
+		return err
+	}
+	in, complete := a.Upstream()
+	out := flowapi.NewUsageSet()
+	consumed := flowapi.NewUsageSet()
+	triggers := make([]flowapi.UsageSet, len(config.Map))
+	slices.SortFunc(config.Map, func(a, b flowdsl.YAMLExpressionMapItem) int {
+		return strings.Compare(a.UsageString, b.UsageString)
+	})
+	for i, item := range config.Map {
+		usages, err := hidapi.ParseUsages(item.Usage.Usages)
+		if err != nil {
+			return err
+		}
+		triggers[i] = flowapi.NewUsageSet(usages...)
+		if complete && !isSubset(triggers[i], in) {
+			for _, usage := range usages {
+				if !in.Contains(usage) {
+					a.Report(flowapi.FindingUnreachable, "%s can never be triggered, %s is not received", item.UsageString, usage)
+					break
+				}
 			}
+			continue
+		}
+		if len(usages) == 1 {
+			consumed.Add(usages[0])
+		}
+		stmtUsages, err := a.StatementUsages(item.Statement)
+		if err != nil {
+			return fmt.Errorf("invalid mapping %s: %w", item.UsageString, err)
 		}
-	}()
+		out.AddSet(stmtUsages)
+	}
+	for i, item := range config.Map {
+		for j := i + 1; j < len(config.Map); j++ {
+			other := config.Map[j]
+			switch {
+			case isSubset(triggers[i], triggers[j]) && isSubset(triggers[j], triggers[i]):
+				a.Report(flowapi.FindingShadowed, "%s and %s are triggered by the same usages", item.UsageString, other.UsageString)
+			case isSubset(triggers[i], triggers[j]):
+				a.Report(flowapi.FindingOverlap, "%s is also triggered when %s is", item.UsageString, other.UsageString)
+			case isSubset(triggers[j], triggers[i]):
+				a.Report(flowapi.FindingOverlap, "%s is also triggered when %s is", other.UsageString, item.UsageString)
+			}
+		}
+	}
+	for usage := range in {
+		if !consumed.Contains(usage) {
+			out.Add(usage)
+		}
+	}
+	a.Broadcast(out)
+	if !complete {
+		a.SendUnknown()
+	}
+	return nil
+}
+
+func isSubset(a, b flowapi.UsageSet) bool {
+	for usage := range a {
+		if !b.Contains(usage) {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *Bind) Run(ctx context.Context, up flowapi.Stream, down flowapi.Stream) error {
+	in := up.Subscribe(ctx)
+	// Actions run on this loop only: events, interrupts and timers are handled one at a time.
+	actionPool := flowapi.NewActionContextPool(ctx, b.log, func(event *hidapi.Event) {
+		down.Broadcast(flowapi.Event{
+			HID: event,
+		})
+	}, flowapi.WithClock(clock.FromContext(ctx)))
 	for {
 		select {
 		case ev := <-in:
@@ -112,8 +196,14 @@ func (b *Bind) Run(ctx context.Context, up flowapi.Stream, down flowapi.Stream)
 				actionPool.Interrupt(ac)
 			}
 			if !event.IsEmpty() {
-				sendCh <- event
+				down.Broadcast(flowapi.Event{
+					HID: event,
+				})
+			} else {
+				event.Release()
 			}
+		case <-actionPool.Timer():
+			actionPool.RunTimers()
 		case <-ctx.Done():
 			return nil
 		}
@@ -126,7 +216,7 @@ func (b *Bind) shouldInterrupt(ac flowapi.ActionContext) bool {
 	}
 	for _, usage := range ac.HIDEvent().Usages() {
 		// TODO: configure event activation
-		if usage.Activate != nil && !*usage.Activate {
+		if usage.Type == hidapi.UsageEventDeactivate {
 			// ignore deactivation events
 			continue
 		}
@@ -146,9 +236,7 @@ func (b *Bind) triggerMappings(ac flowapi.ActionContext) {
 			m[idx].triggered = true
 			m[idx].finalizer = mapping.handler(ac)
 		case !isTriggered && mapping.triggered:
-			if mapping.finalizer != nil {
-				m[idx].finalizer(ac)
-			}
+			mapping.finalizer.Call(ac)
 			m[idx].triggered = false
 			m[idx].finalizer = nil
 		}
@@ -175,10 +263,10 @@ func (u *usageActivation) Check(ac flowapi.ActionContext) bool {
 	wasActive := len(u.counters) == len(u.usages)
 	for _, usage := range u.usages {
 		usageEvent, ok := ac.HIDEvent().Usage(usage)
-		if !ok || usageEvent.Activate == nil {
+		if !ok || !usageEvent.IsActivation() {
 			continue
 		}
-		if *usageEvent.Activate {
+		if usageEvent.Type == hidapi.UsageEventActivate {
 			u.counters[usage]++
 		} else {
 			u.counters[usage]--
@@ -3,10 +3,13 @@ package nodes
 import (
 	"context"
 	"fmt"
+	"slices"
+	"sync"
 
 	"github.com/neuroplastio/neio-agent/components/actions"
 	"github.com/neuroplastio/neio-agent/flowapi"
 	"github.com/neuroplastio/neio-agent/hidapi"
+	"github.com/neuroplastio/neio-agent/pkg/clock"
 	"go.uber.org/zap"
 )
 
@@ -72,7 +75,7 @@ type muxUnset struct {
 
 func (r *Mux) signalReset(p flowapi.ActionProvider) (flowapi.SignalHandler, error) {
 	return func(ctx context.Context) {
-		r.signals <- muxReset{}
+		r.signal(ctx, muxReset{})
 	}, nil
 }
 
@@ -83,7 +86,7 @@ func (r *Mux) signalSet(p flowapi.ActionProvider) (flowapi.SignalHandler, error)
 		return nil, err
 	}
 	return func(ctx context.Context) {
-		r.signals <- muxSet{route: nodeID}
+		r.signal(ctx, muxSet{route: nodeID})
 	}, nil
 }
 
@@ -94,10 +97,18 @@ func (r *Mux) signalUnset(p flowapi.ActionProvider) (flowapi.SignalHandler, erro
 		return nil, err
 	}
 	return func(ctx context.Context) {
-		r.signals <- muxUnset{route: nodeID}
+		r.signal(ctx, muxUnset{route: nodeID})
 	}, nil
 }
 
+// signal passes the signal to the node loop, giving up when the context is done.
+func (r *Mux) signal(ctx context.Context, signal any) {
+	select {
+	case r.signals <- signal:
+	case <-ctx.Done():
+	}
+}
+
 type Mux struct {
 	id           string
 	defaultRoute string
@@ -106,6 +117,32 @@ type Mux struct {
 	activatedUsages map[hidapi.Usage]string
 	nodeIDs         []string
 	signals         chan any
+
+	// status is a copy of the route state of Run.
+	statusMu sync.Mutex
+	status   MuxStatus
+}
+
+type MuxStatus struct {
+	Route string `json:"route"`
+	// Routes are the routes that were set and not unset, in order.
+	Routes []string `json:"routes"`
+}
+
+func (r *Mux) Status() any {
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+	return MuxStatus{
+		Route:  r.status.Route,
+		Routes: slices.Clone(r.status.Routes),
+	}
+}
+
+func (r *Mux) setStatus(route string, routes []string) {
+	r.statusMu.Lock()
+	defer r.statusMu.Unlock()
+	r.status.Route = route
+	r.status.Routes = append(r.status.Routes[:0], routes...)
 }
 
 func (f MuxType) CreateNode(p flowapi.NodeProvider) (flowapi.Node, error) {
@@ -130,14 +167,21 @@ type muxConfig struct {
 	Fallback string `yaml:"fallback"`
 }
 
-func (r *Mux) Configure(c flowapi.NodeConfigurator) error {
+func (r *Mux) parseConfig(c flowapi.NodeConfigurator) (muxConfig, error) {
 	cfg := muxConfig{
-		Fallback: r.defaultRoute,
+		Fallback: r.nodeIDs[len(r.nodeIDs)-1],
 	}
 	if err := c.Unmarshal(&cfg); err != nil {
-		return fmt.Errorf("failed to unmarshal config: %w", err)
+		return muxConfig{}, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+	if err := r.validateNode(cfg.Fallback); err != nil {
+		return muxConfig{}, err
 	}
-	err := r.validateNode(cfg.Fallback)
+	return cfg, nil
+}
+
+func (r *Mux) Configure(c flowapi.NodeConfigurator) error {
+	cfg, err := r.parseConfig(c)
 	if err != nil {
 		return err
 	}
@@ -145,6 +189,18 @@ func (r *Mux) Configure(c flowapi.NodeConfigurator) error {
 	return nil
 }
 
+func (r *Mux) DescribeGraph(c flowapi.NodeConfigurator) (flowapi.NodeGraphDescription, error) {
+	cfg, err := r.parseConfig(c)
+	if err != nil {
+		return flowapi.NodeGraphDescription{}, err
+	}
+	return flowapi.NodeGraphDescription{
+		Routes: map[string]string{
+			cfg.Fallback: "fallback",
+		},
+	}, nil
+}
+
 func (r *Mux) validateNode(nodeID string) error {
 	found := false
 	for _, id := range r.nodeIDs {
@@ -162,8 +218,10 @@ func (r *Mux) validateNode(nodeID string) error {
 func (r *Mux) Run(ctx context.Context, up flowapi.Stream, down flowapi.Stream) error {
 	routeList := make([]string, 0, len(r.nodeIDs))
 	currentRoute := r.defaultRoute
+	clk := clock.FromContext(ctx)
 	in := up.Subscribe(ctx)
-	defer close(r.signals)
+	deactEvents := make(map[string]*hidapi.Event)
+	r.setStatus(currentRoute, routeList)
 	for {
 		changed := false
 		select {
@@ -198,48 +256,50 @@ func (r *Mux) Run(ctx context.Context, up flowapi.Stream, down flowapi.Stream) e
 			}
 			if changed {
 				r.log.Info("Route changed", zap.String("route", currentRoute))
+				r.setStatus(currentRoute, routeList)
 			}
 		case event := <-in:
 			hidEvent := event.HID
-			deactEvents := make(map[string]*hidapi.Event)
-			for _, usage := range hidEvent.Usages() {
-				if usage.Activate == nil {
-					continue
+			deactivate := func(route string, usage hidapi.Usage) {
+				ev, ok := deactEvents[route]
+				if !ok {
+					ev = hidapi.NewEventAt(clk.Now())
+					deactEvents[route] = ev
 				}
-				// TODO: improve this part / reuse some parts from `bind.go`
-				if *usage.Activate {
-					if prev, ok := r.activatedUsages[usage.Usage]; ok && prev != currentRoute {
-						ev, ok := deactEvents[prev]
-						if !ok {
-							ev = hidapi.NewEvent()
-							deactEvents[prev] = ev
-						}
-						ev.Deactivate(usage.Usage)
+				ev.Deactivate(usage)
+			}
+			// TODO: improve this part / reuse some parts from `bind.go`
+			hidEvent.Filter(func(usage hidapi.UsageEvent) bool {
+				if !usage.IsActivation() {
+					return true
+				}
+				prev, ok := r.activatedUsages[usage.Usage]
+				if usage.Type == hidapi.UsageEventActivate {
+					if ok && prev != currentRoute {
+						deactivate(prev, usage.Usage)
 					}
 					r.activatedUsages[usage.Usage] = currentRoute
+					return true
 				}
-				if !*usage.Activate {
-					if prev, ok := r.activatedUsages[usage.Usage]; ok && prev != currentRoute {
-						ev, ok := deactEvents[prev]
-						if !ok {
-							ev = hidapi.NewEvent()
-							deactEvents[prev] = ev
-						}
-						ev.Deactivate(usage.Usage)
-						hidEvent.Suppress(usage.Usage)
-					}
-					delete(r.activatedUsages, usage.Usage)
+				delete(r.activatedUsages, usage.Usage)
+				if ok && prev != currentRoute {
+					deactivate(prev, usage.Usage)
+					return false
 				}
-			}
+				return true
+			})
 			for route, ev := range deactEvents {
 				down.Publish(route, flowapi.Event{
 					HID: ev,
 				})
 			}
+			clear(deactEvents)
 			if !hidEvent.IsEmpty() {
 				down.Publish(currentRoute, flowapi.Event{
 					HID: hidEvent,
 				})
+			} else {
+				hidEvent.Release()
 			}
 		case <-ctx.Done():
 			return nil
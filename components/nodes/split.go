@@ -3,11 +3,13 @@ package nodes
 import (
 	"context"
 	"fmt"
+	"strings"
 
 	"github.com/goccy/go-yaml"
 	"github.com/neuroplastio/neio-agent/flowapi"
 	"github.com/neuroplastio/neio-agent/hidapi"
 	"github.com/neuroplastio/neio-agent/hidapi/hidusage"
+	"github.com/neuroplastio/neio-agent/pkg/clock"
 	"go.uber.org/zap"
 )
 
@@ -41,42 +43,142 @@ type Split struct {
 	matchItems []matchItem
 }
 
-func (s *Split) Configure(c flowapi.NodeConfigurator) error {
+type splitRoute struct {
+	nodeID   string
+	patterns []string
+}
+
+func parseSplitConfig(c flowapi.NodeConfigurator) ([]splitRoute, error) {
 	var config yaml.MapSlice
 	err := c.Unmarshal(&config)
 	if err != nil {
-		return fmt.Errorf("failed to unmarshal usage page map: %w", err)
+		return nil, fmt.Errorf("failed to unmarshal usage page map: %w", err)
 	}
+	routes := make([]splitRoute, 0, len(config))
 	for _, item := range config {
 		nodeID, ok := item.Key.(string)
 		if !ok {
-			return fmt.Errorf("invalid node ID: %v", item.Key)
+			return nil, fmt.Errorf("invalid node ID: %v", item.Key)
 		}
 		itemsAny, ok := item.Value.([]any)
 		if !ok {
-			return fmt.Errorf("invalid patterns: %v", item.Value)
+			return nil, fmt.Errorf("invalid patterns: %v", item.Value)
 		}
 		patterns := make([]string, 0, len(itemsAny))
 		for _, pattern := range itemsAny {
 			p, ok := pattern.(string)
 			if !ok {
-				return fmt.Errorf("invalid pattern: %v", pattern)
+				return nil, fmt.Errorf("invalid pattern: %v", pattern)
 			}
 			patterns = append(patterns, p)
 		}
-		matcher, err := hidusage.NewMatcher(patterns...)
+		routes = append(routes, splitRoute{
+			nodeID:   nodeID,
+			patterns: patterns,
+		})
+	}
+	return routes, nil
+}
+
+func parseSplitMatchItems(c flowapi.NodeConfigurator) ([]matchItem, error) {
+	routes, err := parseSplitConfig(c)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]matchItem, 0, len(routes))
+	for _, route := range routes {
+		matcher, err := hidusage.NewMatcher(route.patterns...)
 		if err != nil {
-			return fmt.Errorf("invalid matchers %v: %w", patterns, err)
+			return nil, fmt.Errorf("invalid matchers %v: %w", route.patterns, err)
 		}
-		s.matchItems = append(s.matchItems, matchItem{
-			nodeID:  nodeID,
+		items = append(items, matchItem{
+			nodeID:  route.nodeID,
 			matcher: matcher,
 		})
 	}
+	return items, nil
+}
+
+func (s *Split) Configure(c flowapi.NodeConfigurator) error {
+	items, err := parseSplitMatchItems(c)
+	if err != nil {
+		return err
+	}
+	s.matchItems = items
+	return nil
+}
+
+func (s *Split) DescribeGraph(c flowapi.NodeConfigurator) (flowapi.NodeGraphDescription, error) {
+	routes, err := parseSplitConfig(c)
+	if err != nil {
+		return flowapi.NodeGraphDescription{}, err
+	}
+	desc := flowapi.NodeGraphDescription{
+		Routes: make(map[string]string, len(routes)),
+	}
+	for _, route := range routes {
+		desc.Routes[route.nodeID] = strings.Join(route.patterns, ", ")
+	}
+	return desc, nil
+}
+
+// AnalyzeUsages routes usages to the first matching route, and reports usages that match no
+// route and routes that only match usages taken by earlier routes.
+func (s *Split) AnalyzeUsages(a flowapi.UsageAnalysis) error {
+	matchItems, err := parseSplitMatchItems(a)
+	if err != nil {
+		return err
+	}
+	in, complete := a.Upstream()
+	unmatched := flowapi.NewUsageSet()
+	routed := make([]flowapi.UsageSet, len(matchItems))
+	shadowed := make([]bool, len(matchItems))
+	for i := range routed {
+		routed[i] = flowapi.NewUsageSet()
+	}
+	for usage := range in {
+		matched := false
+		for i, item := range matchItems {
+			if !item.matcher(usage.Page(), usage.ID()) {
+				continue
+			}
+			if matched {
+				shadowed[i] = true
+				continue
+			}
+			matched = true
+			routed[i].Add(usage)
+		}
+		if !matched {
+			unmatched.Add(usage)
+		}
+	}
+	for i, item := range matchItems {
+		a.Send(item.nodeID, routed[i])
+		if len(routed[i]) == 0 && shadowed[i] {
+			a.Report(flowapi.FindingShadowed, "route %s only matches usages routed to earlier routes", item.nodeID)
+		}
+	}
+	if len(unmatched) > 0 {
+		a.Report(flowapi.FindingUnmatched, "usages match no route: %s", formatUsages(unmatched))
+	}
+	if !complete {
+		a.SendUnknown()
+	}
 	return nil
 }
 
+func formatUsages(usages flowapi.UsageSet) string {
+	sorted := usages.Sorted()
+	strs := make([]string, len(sorted))
+	for i, usage := range sorted {
+		strs[i] = usage.String()
+	}
+	return strings.Join(strs, ", ")
+}
+
 func (s *Split) Run(ctx context.Context, up flowapi.Stream, down flowapi.Stream) error {
+	clk := clock.FromContext(ctx)
 	in := up.Subscribe(ctx)
 	events := make(map[string]*hidapi.Event)
 	for {
@@ -88,7 +190,7 @@ func (s *Split) Run(ctx context.Context, up flowapi.Stream, down flowapi.Stream)
 					if item.matcher(usage.Usage.Page(), usage.Usage.ID()) {
 						event, ok := events[item.nodeID]
 						if !ok {
-							event = hidapi.NewEvent()
+							event = hidapi.NewEventAt(clk.Now())
 							events[item.nodeID] = event
 						}
 						event.AddUsage(usage)
@@ -102,7 +204,7 @@ func (s *Split) Run(ctx context.Context, up flowapi.Stream, down flowapi.Stream)
 				}
 				event, ok := events[nodeID]
 				if !ok {
-					event = hidapi.NewEvent()
+					event = hidapi.NewEventAt(clk.Now())
 					events[nodeID] = event
 				}
 				event.AddUsage(usage)
@@ -113,6 +215,7 @@ func (s *Split) Run(ctx context.Context, up flowapi.Stream, down flowapi.Stream)
 				})
 			}
 			clear(events)
+			ev.HID.Release()
 		case <-ctx.Done():
 			return nil
 		}
@@ -2,10 +2,11 @@ package flowapi
 
 import (
 	"context"
-	"sync"
+	"slices"
 	"time"
 
 	"github.com/neuroplastio/neio-agent/hidapi"
+	"github.com/neuroplastio/neio-agent/pkg/clock"
 	"go.uber.org/zap"
 )
 
@@ -32,19 +33,28 @@ type ActionContext interface {
 	Context() context.Context
 
 	// HIDEvent returns an event that is being processed at the moment.
-	// It can only be called synchronously. Asynchronous calls have undefined behavior.
+	// It must not be retained after the handler returns.
 	HIDEvent() *hidapi.Event
 
-	// Async branches out action into an asynchronous function.
-	// You should return finalizer function that will be called when the action is finished.
-	// When async action is finished, asyncCtx.Done() channel will be closed.
-	Async(fn func(asyncCtx AsyncActionContext)) ActionFinalizer
+	// Async starts an asynchronous action. fn is called immediately to register timers and handlers
+	// of the action, which are later called on the node loop, one at a time.
+	// The returned finalizer calls the release handler of the action.
+	Async(fn func(async AsyncActionContext)) ActionFinalizer
 }
 
 type ActionFinalizer func(ac ActionContext)
 type ActionHandler func(ac ActionContext) ActionFinalizer
+
+// SignalHandler handles a signal. It must return when the context is done, even if the node is not running.
 type SignalHandler func(ctx context.Context)
 
+// Call calls the finalizer if it is set.
+func (f ActionFinalizer) Call(ac ActionContext) {
+	if f != nil {
+		f(ac)
+	}
+}
+
 type ActionProvider interface {
 	Context() context.Context
 	Args() Arguments
@@ -91,179 +101,247 @@ func (a *actionContext) HIDEvent() *hidapi.Event {
 	return a.event
 }
 
-func (a *actionContext) Async(fn func(asyncCtx AsyncActionContext)) ActionFinalizer {
-	return a.pool.runAsync(a, fn)
-}
-
-func (a *actionContext) clone() *actionContext {
-	return &actionContext{
-		event: a.event.Clone(),
-		pool:  a.pool,
-	}
+func (a *actionContext) Async(fn func(async AsyncActionContext)) ActionFinalizer {
+	return a.pool.startAsync(a, fn)
 }
 
-// TODO: clean up and refactor this mess, fix data races
-
+// AsyncActionContext is a cooperative state machine: instead of blocking, an asynchronous action
+// registers handlers for timers, interrupts and release. Every handler receives a context with its
+// own event, except for the release handler which receives the releasing context.
+// The action stays active until it calls Finish.
 type AsyncActionContext interface {
-	Interrupt() <-chan struct{}
-	Interrupted() bool
-	After(duration time.Duration) <-chan struct{}
-	Finished() <-chan struct{}
-	Do(fn func(ac ActionContext))
-	Action(action ActionHandler) ActionFinalizer
-	Finish(finalizer ActionFinalizer)
-	OnFinish(finalizer ActionFinalizer)
+	// Now returns the current time of the pool clock, or the deadline of the timer being handled.
+	Now() time.Time
+	// After calls fn once the duration elapses, unless the timer is cancelled or the action is finished.
+	After(duration time.Duration, fn func(ac ActionContext)) (cancel func())
+	// OnInterrupt sets the handler for interrupts by events from other actions.
+	OnInterrupt(fn func(ac ActionContext))
+	// OnRelease sets the handler for the finalizer returned by Async.
+	OnRelease(fn func(ac ActionContext))
+	// Finish cancels pending timers and handlers of the action.
+	Finish()
 }
 
 type asyncActionContext struct {
+	pool *ActionContextPool
+	// parent is the context that started the action. It is never interrupted by its own event.
 	parent      *actionContext
-	ac          *actionContext
-	interrupted bool
-	interrupt   chan struct{}
-	finished    chan struct{}
-	done        chan struct{}
-	onFinish    []ActionFinalizer
+	finished    bool
+	released    bool
+	onRelease   func(ac ActionContext)
+	onInterrupt func(ac ActionContext)
+}
+
+type asyncTimer struct {
+	at     time.Time
+	seq    uint64
+	action *asyncActionContext
+	fn     func(ac ActionContext)
+}
+
+type ActionContextPoolOption func(*ActionContextPool)
+
+// WithClock sets the clock used for timing of asynchronous actions and timestamps of their events.
+func WithClock(c clock.Clock) ActionContextPoolOption {
+	return func(p *ActionContextPool) {
+		p.clock = c
+	}
 }
 
-func NewActionContextPool(ctx context.Context, log *zap.Logger, hidChan chan<- *hidapi.Event) *ActionContextPool {
+// NewActionContextPool creates a pool that runs actions of a node. Events produced by asynchronous
+// actions are passed to emit.
+func NewActionContextPool(ctx context.Context, log *zap.Logger, emit func(event *hidapi.Event), opts ...ActionContextPoolOption) *ActionContextPool {
 	pool := &ActionContextPool{
-		ctx:            ctx,
-		log:            log,
-		hidChan:        hidChan,
-		activeContexts: make(map[*asyncActionContext]struct{}),
+		ctx:   ctx,
+		log:   log,
+		clock: clock.Real(),
+		emit:  emit,
+	}
+	for _, opt := range opts {
+		opt(pool)
 	}
+	pool.timer = pool.clock.NewTimer(time.Hour)
+	pool.timer.Stop()
 	return pool
 }
 
+// ActionContextPool runs actions of a node on a single goroutine, the node loop. The loop handles
+// incoming events with New and Interrupt, and calls RunTimers when Timer fires. The pool is not safe
+// for concurrent use, so actions never need synchronization.
 type ActionContextPool struct {
-	log     *zap.Logger
-	ctx     context.Context
-	hidChan chan<- *hidapi.Event
-
-	mu             sync.Mutex
-	activeContexts map[*asyncActionContext]struct{}
+	log   *zap.Logger
+	ctx   context.Context
+	clock clock.Clock
+	emit  func(event *hidapi.Event)
+
+	// active actions in start order
+	active []*asyncActionContext
+	// timers are sorted by deadline
+	timers   []*asyncTimer
+	timerSeq uint64
+	timer    clock.Timer
+	// stepTime is the deadline of the timer being handled.
+	stepTime time.Time
 }
 
 func (a *ActionContextPool) New(event *hidapi.Event) ActionContext {
-	ac := &actionContext{
+	return &actionContext{
 		event: event,
 		pool:  a,
 	}
-	return ac
 }
 
+// Active returns the number of asynchronous actions that have not finished.
+func (a *ActionContextPool) Active() int {
+	return len(a.active)
+}
+
+// Interrupt calls interrupt handlers of all active actions, except for the ones started by ac.
+// Events of the handlers are emitted before the event of ac.
 func (a *ActionContextPool) Interrupt(ac ActionContext) {
-	a.mu.Lock()
-	for async := range a.activeContexts {
-		if async.parent == ac {
+	for _, async := range slices.Clone(a.active) {
+		if async.finished || async.parent == ac || async.onInterrupt == nil {
 			continue
 		}
-		if !async.interrupted {
-			close(async.interrupt)
-		}
+		fn := async.onInterrupt
+		async.onInterrupt = nil
+		a.step(fn)
 	}
-	for async := range a.activeContexts {
-		if async.parent == ac {
+}
+
+// Timer returns a channel that receives when the earliest timer is due, or nil if there are no timers.
+func (a *ActionContextPool) Timer() <-chan time.Time {
+	if len(a.timers) == 0 {
+		return nil
+	}
+	return a.timer.C()
+}
+
+// RunTimers calls handlers of all due timers in deadline order.
+func (a *ActionContextPool) RunTimers() {
+	now := a.clock.Now()
+	for len(a.timers) > 0 && !a.timers[0].at.After(now) {
+		t := a.timers[0]
+		a.timers = a.timers[1:]
+		if t.action.finished {
 			continue
 		}
-		if !async.interrupted {
-			async.interrupted = true
-			select {
-			case <-async.done:
-				a.log.Debug("interrupted async action")
-			case <-a.ctx.Done():
-			}
-		}
+		a.stepTime = t.at
+		a.step(t.fn)
+		a.stepTime = time.Time{}
 	}
-	a.mu.Unlock()
+	a.resetTimer()
 }
 
-func (a *ActionContextPool) runAsync(ac *actionContext, fn func(ac AsyncActionContext)) ActionFinalizer {
-	asyncCtx := &asyncActionContext{
-		parent:    ac,
-		ac:        ac.clone(),
-		finished:  make(chan struct{}),
-		done:      make(chan struct{}),
-		interrupt: make(chan struct{}),
-	}
-	a.mu.Lock()
-	a.activeContexts[asyncCtx] = struct{}{}
-	a.mu.Unlock()
-	go func() {
-		defer func() {
-			close(asyncCtx.done)
-
-		}()
-		fn(asyncCtx)
-	}()
-	return func(ac ActionContext) {
-		a.mu.Lock()
-		delete(a.activeContexts, asyncCtx)
-		a.mu.Unlock()
-		for _, onFinish := range asyncCtx.onFinish {
-			onFinish(ac)
-		}
-		close(asyncCtx.finished)
+func (a *ActionContextPool) now() time.Time {
+	if !a.stepTime.IsZero() {
+		return a.stepTime
 	}
+	return a.clock.Now()
 }
 
-func (a *asyncActionContext) After(duration time.Duration) <-chan struct{} {
-	ch := make(chan struct{})
-	go func() {
-		<-time.After(duration)
-		close(ch)
-	}()
-	return ch
+// step calls fn with a new event and emits it.
+func (a *ActionContextPool) step(fn func(ac ActionContext)) {
+	ac := a.New(hidapi.NewEventAt(a.now()))
+	fn(ac)
+	event := ac.HIDEvent()
+	if event.IsEmpty() {
+		event.Release()
+		return
+	}
+	a.emit(event)
 }
 
-func (a *asyncActionContext) Finished() <-chan struct{} {
-	return a.finished
+func (a *ActionContextPool) startAsync(ac *actionContext, fn func(async AsyncActionContext)) ActionFinalizer {
+	async := &asyncActionContext{
+		pool:   a,
+		parent: ac,
+	}
+	a.active = append(a.active, async)
+	fn(async)
+	return async.release
 }
 
-func (a *asyncActionContext) Interrupted() bool {
-	return a.interrupted
+func (a *ActionContextPool) addTimer(async *asyncActionContext, d time.Duration, fn func(ac ActionContext)) *asyncTimer {
+	a.timerSeq++
+	t := &asyncTimer{
+		at:     a.now().Add(d),
+		seq:    a.timerSeq,
+		action: async,
+		fn:     fn,
+	}
+	idx, _ := slices.BinarySearchFunc(a.timers, t, func(e, t *asyncTimer) int {
+		if c := e.at.Compare(t.at); c != 0 {
+			return c
+		}
+		return int(e.seq - t.seq)
+	})
+	a.timers = slices.Insert(a.timers, idx, t)
+	if idx == 0 {
+		a.resetTimer()
+	}
+	return t
 }
 
-func (a *asyncActionContext) Interrupt() <-chan struct{} {
-	return a.interrupt
+func (a *ActionContextPool) removeTimers(match func(t *asyncTimer) bool) {
+	a.timers = slices.DeleteFunc(a.timers, match)
 }
 
-func (a *asyncActionContext) NewActionContext() ActionContext {
-	return &actionContext{
-		pool:  a.ac.pool,
-		event: hidapi.NewEvent(),
+func (a *ActionContextPool) resetTimer() {
+	a.timer.Stop()
+	if len(a.timers) == 0 {
+		return
 	}
+	a.timer.Reset(max(a.timers[0].at.Sub(a.clock.Now()), 0))
 }
 
-func (a *asyncActionContext) Action(action ActionHandler) ActionFinalizer {
-	var fin ActionFinalizer
-	a.Do(func(ac ActionContext) {
-		fin = action(ac)
-	})
-	return fin
+func (a *asyncActionContext) Now() time.Time {
+	return a.pool.now()
 }
 
-func (a *asyncActionContext) Finish(fin ActionFinalizer) {
-	if fin == nil {
-		return
+func (a *asyncActionContext) After(duration time.Duration, fn func(ac ActionContext)) func() {
+	if a.finished {
+		return func() {}
+	}
+	t := a.pool.addTimer(a, duration, fn)
+	return func() {
+		a.pool.removeTimers(func(e *asyncTimer) bool {
+			return e == t
+		})
 	}
-	a.Do(func(ac ActionContext) {
-		fin(ac)
-	})
 }
 
-func (a *asyncActionContext) Do(fn func(ac ActionContext)) {
-	ac := a.NewActionContext()
-	fn(ac)
-	event := ac.HIDEvent()
-	if !event.IsEmpty() {
-		a.ac.pool.hidChan <- event
+func (a *asyncActionContext) OnInterrupt(fn func(ac ActionContext)) {
+	a.onInterrupt = fn
+}
+
+func (a *asyncActionContext) OnRelease(fn func(ac ActionContext)) {
+	a.onRelease = fn
+}
+
+func (a *asyncActionContext) Finish() {
+	if a.finished {
+		return
 	}
+	a.finished = true
+	a.onInterrupt = nil
+	a.onRelease = nil
+	a.pool.removeTimers(func(t *asyncTimer) bool {
+		return t.action == a
+	})
+	a.pool.active = slices.DeleteFunc(a.pool.active, func(e *asyncActionContext) bool {
+		return e == a
+	})
 }
 
-func (a *asyncActionContext) OnFinish(fin ActionFinalizer) {
-	if fin == nil {
+func (a *asyncActionContext) release(ac ActionContext) {
+	if a.released || a.finished {
 		return
 	}
-	a.onFinish = append(a.onFinish, fin)
+	a.released = true
+	if a.onRelease != nil {
+		fn := a.onRelease
+		a.onRelease = nil
+		fn(ac)
+	}
 }
@@ -0,0 +1,90 @@
+package flowapi
+
+import (
+	"slices"
+
+	"github.com/neuroplastio/neio-agent/flowapi/flowdsl"
+	"github.com/neuroplastio/neio-agent/hidapi"
+)
+
+// UsageSet is a set of usages used by static flow analysis.
+type UsageSet map[hidapi.Usage]struct{}
+
+func NewUsageSet(usages ...hidapi.Usage) UsageSet {
+	s := make(UsageSet, len(usages))
+	s.Add(usages...)
+	return s
+}
+
+func (s UsageSet) Add(usages ...hidapi.Usage) {
+	for _, usage := range usages {
+		s[usage] = struct{}{}
+	}
+}
+
+func (s UsageSet) AddSet(other UsageSet) {
+	for usage := range other {
+		s[usage] = struct{}{}
+	}
+}
+
+func (s UsageSet) Contains(usage hidapi.Usage) bool {
+	_, ok := s[usage]
+	return ok
+}
+
+// Sorted returns usages ordered by usage page and ID.
+func (s UsageSet) Sorted() []hidapi.Usage {
+	usages := make([]hidapi.Usage, 0, len(s))
+	for usage := range s {
+		usages = append(usages, usage)
+	}
+	slices.Sort(usages)
+	return usages
+}
+
+type FindingKind string
+
+const (
+	// FindingUnknown is reported when usages of a node cannot be determined, e.g. when a device
+	// has never been connected and its report descriptor is not stored.
+	FindingUnknown FindingKind = "unknown"
+	// FindingUnencodable is reported for usages sent to an output that cannot encode them.
+	FindingUnencodable FindingKind = "unencodable"
+	// FindingUnreachable is reported for mappings that can never be triggered.
+	FindingUnreachable FindingKind = "unreachable"
+	// FindingUnmatched is reported for usages that are not routed to any downstream node.
+	FindingUnmatched FindingKind = "unmatched"
+	// FindingShadowed is reported for mappings and routes that are hidden by other ones.
+	FindingShadowed FindingKind = "shadowed"
+	// FindingOverlap is reported for mappings that can be triggered by the same usages.
+	FindingOverlap FindingKind = "overlap"
+)
+
+// UsageAnalyzer is implemented by nodes that take part in static flow analysis. AnalyzeUsages is
+// called instead of Configure on nodes that are never run, so it must not start anything.
+// Nodes that do not implement it send all usages they receive to every downstream node.
+type UsageAnalyzer interface {
+	AnalyzeUsages(a UsageAnalysis) error
+}
+
+// UsageAnalysis is the static analysis of usages flowing through a node.
+type UsageAnalysis interface {
+	NodeConfigurator
+
+	// StatementUsages returns usages the statement can send.
+	StatementUsages(stmt flowdsl.Statement) (UsageSet, error)
+
+	Send(nodeID string, usages UsageSet)
+	Broadcast(usages UsageSet)
+	// SendUnknown marks usages sent by the node as incomplete.
+	SendUnknown()
+
+	Report(kind FindingKind, format string, args ...any)
+}
+
+// UsageEmitter is implemented by actions that send usages that are not passed as arguments,
+// e.g. keys of the characters of a string.
+type UsageEmitter interface {
+	EmittedUsages(args Arguments) ([]hidapi.Usage, error)
+}
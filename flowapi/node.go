@@ -48,9 +48,40 @@ type NodeConfigurator interface {
 
 	ActionHandler(stmt flowdsl.Statement) (ActionHandler, error)
 	SignalHandler(stmt flowdsl.Statement) (SignalHandler, error)
+	// Context is cancelled when the configured node is stopped.
+	Context() context.Context
+	// Upstream returns usages the node can receive, found by static analysis of the flow.
+	// complete is false if upstream nodes can send usages that are not known.
+	Upstream() (usages UsageSet, complete bool)
 }
 
 type Node interface {
 	Configure(c NodeConfigurator) error
 	Run(ctx context.Context, up Stream, down Stream) error
 }
+
+// StatusReporter is implemented by nodes that report their runtime state, such as the current route.
+// Status is called concurrently with Run and must return a value that can be encoded as JSON.
+type StatusReporter interface {
+	Status() any
+}
+
+// GraphDescriber is implemented by nodes that describe their configuration in graph exports.
+// DescribeGraph is called instead of Configure on nodes that are never run, so it must only
+// unmarshal the configuration.
+type GraphDescriber interface {
+	DescribeGraph(c NodeConfigurator) (NodeGraphDescription, error)
+}
+
+type NodeGraphDescription struct {
+	// Routes label edges to downstream nodes by node ID, such as usage patterns sent to the node.
+	Routes map[string]string
+	// Bindings are flow statements of the node.
+	Bindings []Binding
+}
+
+// Binding is a flow statement bound to usages, e.g. F12: $layer.switch("nav").
+type Binding struct {
+	Usage     string
+	Statement string
+}
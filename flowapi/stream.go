@@ -19,6 +19,22 @@ type Event struct {
 	HID  *hidapi.Event
 }
 
+// Clone returns a copy of the event with its own HID event.
+func (e Event) Clone() Event {
+	if e.HID != nil {
+		e.HID = e.HID.Clone()
+	}
+	return e
+}
+
+// Release returns HID event to the pool.
+func (e Event) Release() {
+	e.HID.Release()
+}
+
+// Stream connects a node to its upstream or downstream nodes.
+// Broadcasting or publishing an event transfers the ownership of its HID event to the stream,
+// and every subscriber owns the events it receives.
 type Stream interface {
 	Broadcast(event Event)
 	Publish(nodeID string, event Event)
@@ -0,0 +1,170 @@
+package hidapi
+
+import (
+	"slices"
+
+	"github.com/neuroplastio/neio-agent/hidapi/hiddesc"
+	"github.com/neuroplastio/neio-agent/hidapi/hidusage/usagepages"
+)
+
+// augmentArrayCount is the number of usages of array collections that can be active at once.
+const augmentArrayCount = 4
+
+// AugmentDescriptor adds application collections to the descriptor for usages that cannot be encoded
+// in its input reports: keys as an NKRO bitmap, buttons and pointer axes as a mouse, and consumer and
+// system control usages as arrays. Every collection gets a new report ID, and reports of descriptors
+// without report IDs are assigned one. Usages that cannot be added are returned as unsupported.
+func AugmentDescriptor(desc hiddesc.ReportDescriptor, usages []Usage) (hiddesc.ReportDescriptor, []Usage) {
+	layout := NewReportLayout(NewDataItemSet(desc).WithType(hiddesc.MainItemTypeInput))
+	var (
+		keys, buttons, axes, consumer, system []uint16
+		unsupported                           []Usage
+	)
+	for _, usage := range usages {
+		if layout.Contains(usage) || usage.ID() == 0 {
+			continue
+		}
+		id := usage.ID()
+		switch {
+		case usage.Page() == usagepages.KeyboardKeypad:
+			keys = append(keys, id)
+		case usage.Page() == usagepages.Button:
+			buttons = append(buttons, id)
+		case usage.Page() == usagepages.Consumer:
+			consumer = append(consumer, id)
+		case usage.Page() == usagepages.GenericDesktop && id >= 0x30 && id <= 0x38:
+			axes = append(axes, id)
+		case usage.Page() == usagepages.GenericDesktop && id >= 0x81 && id <= 0xb7:
+			system = append(system, id)
+		default:
+			unsupported = append(unsupported, usage)
+		}
+	}
+	if len(keys)+len(buttons)+len(axes)+len(consumer)+len(system) == 0 {
+		return desc, unsupported
+	}
+
+	desc = desc.Clone()
+	reportID := assignReportID(desc)
+	nextID := func() uint8 {
+		reportID++
+		return reportID
+	}
+	if len(keys) > 0 {
+		id := nextID()
+		desc.Collections = append(desc.Collections, applicationCollection(usagepages.GenericDesktop, 0x06,
+			bitmapItems(usagepages.KeyboardKeypad, keys, id)...))
+	}
+	if len(buttons) > 0 || len(axes) > 0 {
+		id := nextID()
+		var items []hiddesc.MainItem
+		if len(buttons) > 0 {
+			items = append(items, bitmapItems(usagepages.Button, buttons, id)...)
+		}
+		if len(axes) > 0 {
+			slices.Sort(axes)
+			items = append(items, inputItem(hiddesc.DataItem{
+				Flags:          hiddesc.DataFlagVariable | hiddesc.DataFlagRelative,
+				UsagePage:      usagepages.GenericDesktop,
+				UsageIDs:       axes,
+				ReportCount:    uint32(len(axes)),
+				ReportSize:     16,
+				ReportID:       id,
+				LogicalMinimum: -32767,
+				LogicalMaximum: 32767,
+			}))
+		}
+		desc.Collections = append(desc.Collections, applicationCollection(usagepages.GenericDesktop, 0x02, items...))
+	}
+	if len(consumer) > 0 {
+		desc.Collections = append(desc.Collections, applicationCollection(usagepages.Consumer, 0x01,
+			arrayItem(usagepages.Consumer, consumer, 16, nextID())))
+	}
+	if len(system) > 0 {
+		desc.Collections = append(desc.Collections, applicationCollection(usagepages.GenericDesktop, 0x80,
+			arrayItem(usagepages.GenericDesktop, system, 8, nextID())))
+	}
+	return desc, unsupported
+}
+
+// assignReportID assigns a report ID to reports of a descriptor without report IDs, modifying its
+// items. It returns the highest report ID of the descriptor.
+func assignReportID(desc hiddesc.ReportDescriptor) uint8 {
+	itemSet := NewDataItemSet(desc)
+	var reportID uint8
+	for _, report := range itemSet.Reports() {
+		reportID = max(reportID, report.ID)
+	}
+	if itemSet.HasReportID() {
+		return reportID
+	}
+	reportID++
+	desc.Walk(func(item hiddesc.MainItem) bool {
+		if item.DataItem != nil {
+			item.DataItem.ReportID = reportID
+		}
+		return true
+	})
+	return reportID
+}
+
+func applicationCollection(page, id uint16, items ...hiddesc.MainItem) hiddesc.Collection {
+	return hiddesc.Collection{
+		Type:      hiddesc.CollectionTypeApplication,
+		UsagePage: page,
+		UsageID:   id,
+		Items:     items,
+	}
+}
+
+func inputItem(item hiddesc.DataItem) hiddesc.MainItem {
+	return dataItem(hiddesc.MainItemTypeInput, item)
+}
+
+func dataItem(typ hiddesc.MainItemType, item hiddesc.DataItem) hiddesc.MainItem {
+	return hiddesc.MainItem{
+		Type:     typ,
+		DataItem: &item,
+	}
+}
+
+// bitmapItems returns a bit per usage from the lowest to the highest usage ID, padded to a byte.
+func bitmapItems(page uint16, ids []uint16, reportID uint8) []hiddesc.MainItem {
+	minimum, maximum := slices.Min(ids), slices.Max(ids)
+	count := uint32(maximum-minimum) + 1
+	items := []hiddesc.MainItem{inputItem(hiddesc.DataItem{
+		Flags:          hiddesc.DataFlagVariable,
+		UsagePage:      page,
+		UsageMinimum:   minimum,
+		UsageMaximum:   maximum,
+		ReportCount:    count,
+		ReportSize:     1,
+		ReportID:       reportID,
+		LogicalMaximum: 1,
+	})}
+	if count%8 != 0 {
+		items = append(items, inputItem(hiddesc.DataItem{
+			Flags:       hiddesc.DataFlagConstant,
+			UsagePage:   page,
+			ReportCount: 1,
+			ReportSize:  8 - count%8,
+			ReportID:    reportID,
+		}))
+	}
+	return items
+}
+
+// arrayItem returns an array of usage IDs from the lowest to the highest usage ID.
+func arrayItem(page uint16, ids []uint16, size uint32, reportID uint8) hiddesc.MainItem {
+	minimum, maximum := slices.Min(ids), slices.Max(ids)
+	return inputItem(hiddesc.DataItem{
+		UsagePage:      page,
+		UsageMinimum:   minimum,
+		UsageMaximum:   maximum,
+		ReportCount:    augmentArrayCount,
+		ReportSize:     size,
+		ReportID:       reportID,
+		LogicalMinimum: int32(minimum),
+		LogicalMaximum: int32(maximum),
+	})
+}
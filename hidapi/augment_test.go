@@ -0,0 +1,74 @@
+package hidapi
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/neuroplastio/neio-agent/hidapi/hiddesc"
+	"go.uber.org/zap"
+)
+
+func TestAugmentDescriptor(t *testing.T) {
+	keyboard := hiddesc.ReportDescriptor{
+		Collections: []hiddesc.Collection{{
+			Type:      hiddesc.CollectionTypeApplication,
+			UsagePage: 0x01,
+			UsageID:   0x06,
+			Items: []hiddesc.MainItem{{
+				Type: hiddesc.MainItemTypeInput,
+				DataItem: &hiddesc.DataItem{
+					UsagePage:      0x07,
+					UsageMinimum:   0x00,
+					UsageMaximum:   0x65,
+					ReportCount:    6,
+					ReportSize:     8,
+					LogicalMaximum: 0x65,
+				},
+			}},
+		}},
+	}
+	vendor := NewUsage(0xff00, 0x01)
+	usages := []Usage{
+		NewUsage(0x07, 0x04),
+		NewUsage(0x07, 0x68),
+		NewUsage(0x09, 0x01),
+		NewUsage(0x01, 0x38),
+		NewUsage(0x0c, 0xcd),
+		NewUsage(0x01, 0x82),
+		vendor,
+	}
+	desc, unsupported := AugmentDescriptor(keyboard, usages)
+	if !slices.Equal(unsupported, []Usage{vendor}) {
+		t.Errorf("unexpected unsupported usages: %v", unsupported)
+	}
+	if keyboard.Collections[0].Items[0].DataItem.ReportID != 0 {
+		t.Error("original descriptor was modified")
+	}
+
+	raw, err := hiddesc.Encode(desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := hiddesc.Decode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := NewDataItemSet(decoded).WithType(hiddesc.MainItemTypeInput)
+	if !items.HasReportID() || len(items.Reports()) != 5 {
+		t.Fatalf("expected 5 reports with IDs, got %d", len(items.Reports()))
+	}
+	layout := NewReportLayout(items)
+	for _, usage := range usages[:len(usages)-1] {
+		if !layout.Contains(usage) {
+			t.Errorf("usage %s is not encoded", usage)
+		}
+	}
+
+	state := NewReportState(zap.NewNop(), items)
+	event := NewEvent()
+	event.Activate(NewUsage(0x0c, 0xcd))
+	reports := state.ApplyEvent(event)
+	if len(reports) != 1 || reports[0][0] != 4 || reports[0][1] != 0xcd {
+		t.Errorf("unexpected consumer report: %x", reports)
+	}
+}
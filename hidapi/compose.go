@@ -0,0 +1,219 @@
+package hidapi
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/neuroplastio/neio-agent/hidapi/hiddesc"
+)
+
+// DescriptorSource is the report descriptor of a device composed into another descriptor.
+type DescriptorSource struct {
+	Name       string
+	Descriptor hiddesc.ReportDescriptor
+}
+
+// ReportIDMapping maps a report ID of a source to the report ID of the composed descriptor.
+// SourceID is 0 for sources without report IDs.
+type ReportIDMapping struct {
+	Source   string
+	SourceID uint8
+	ID       uint8
+}
+
+type ComposeConflictKind string
+
+const (
+	// ConflictReportID is reported for report IDs used by more than one source, they are remapped.
+	ConflictReportID ComposeConflictKind = "reportId"
+	// ConflictUsage is reported for usages in input reports of more than one source, events of these
+	// usages are encoded in one of the reports only.
+	ConflictUsage ComposeConflictKind = "usage"
+)
+
+type ComposeConflict struct {
+	Kind     ComposeConflictKind
+	Sources  []string
+	ReportID uint8
+	Usages   []Usage
+}
+
+// composeConflictUsages is the number of usages listed by String.
+const composeConflictUsages = 5
+
+func (c ComposeConflict) String() string {
+	sources := strings.Join(c.Sources, ", ")
+	if c.Kind == ConflictReportID {
+		return fmt.Sprintf("%s: report ID %d is used by %s", c.Kind, c.ReportID, sources)
+	}
+	usages := make([]string, 0, composeConflictUsages)
+	for _, usage := range c.Usages[:min(len(c.Usages), composeConflictUsages)] {
+		usages = append(usages, usage.String())
+	}
+	if len(c.Usages) > composeConflictUsages {
+		usages = append(usages, fmt.Sprintf("and %d more", len(c.Usages)-composeConflictUsages))
+	}
+	return fmt.Sprintf("%s: usages are encoded by %s: %s", c.Kind, sources, strings.Join(usages, ", "))
+}
+
+// ComposedDescriptor is a descriptor composed of the collections of several sources.
+type ComposedDescriptor struct {
+	Descriptor hiddesc.ReportDescriptor
+	Mappings   []ReportIDMapping
+	Conflicts  []ComposeConflict
+
+	ids     map[string]map[uint8]uint8
+	sources map[uint8]ReportIDMapping
+}
+
+// ReportID returns the report ID of the composed descriptor for a report ID of the source.
+func (c ComposedDescriptor) ReportID(source string, sourceID uint8) (uint8, bool) {
+	id, ok := c.ids[source][sourceID]
+	return id, ok
+}
+
+// Source returns the source of a report ID of the composed descriptor.
+func (c ComposedDescriptor) Source(id uint8) (ReportIDMapping, bool) {
+	mapping, ok := c.sources[id]
+	return mapping, ok
+}
+
+// ComposeDescriptors merges collections of the sources into one descriptor. Report IDs of sources are
+// kept when no other source uses them, otherwise they are remapped to free IDs. Sources without report
+// IDs are assigned one, unless there is a single source.
+func ComposeDescriptors(sources []DescriptorSource) (ComposedDescriptor, error) {
+	composed := ComposedDescriptor{
+		ids:     make(map[string]map[uint8]uint8, len(sources)),
+		sources: make(map[uint8]ReportIDMapping),
+	}
+	if len(sources) == 1 {
+		composed.Descriptor = sources[0].Descriptor.Clone()
+		for _, report := range NewDataItemSet(composed.Descriptor).Reports() {
+			composed.addMapping(ReportIDMapping{Source: sources[0].Name, SourceID: report.ID, ID: report.ID})
+		}
+		return composed, nil
+	}
+
+	reports := make([][]DataItemSetReport, len(sources))
+	users := make(map[uint8][]string)
+	for i, source := range sources {
+		if _, ok := composed.ids[source.Name]; ok {
+			return composed, fmt.Errorf("duplicate source %s", source.Name)
+		}
+		composed.ids[source.Name] = make(map[uint8]uint8)
+		reports[i] = NewDataItemSet(source.Descriptor).Reports()
+		for _, report := range reports[i] {
+			if report.ID != 0 {
+				users[report.ID] = append(users[report.ID], source.Name)
+			}
+		}
+	}
+	// the first source using a report ID keeps it
+	used := make(map[uint8]bool)
+	for i, source := range sources {
+		for _, report := range reports[i] {
+			if report.ID != 0 && !used[report.ID] {
+				used[report.ID] = true
+				composed.addMapping(ReportIDMapping{Source: source.Name, SourceID: report.ID, ID: report.ID})
+			}
+		}
+	}
+	var nextID uint8
+	for i, source := range sources {
+		for _, report := range reports[i] {
+			if _, ok := composed.ReportID(source.Name, report.ID); ok {
+				continue
+			}
+			for nextID++; used[nextID]; nextID++ {
+			}
+			if nextID == 0 {
+				return composed, fmt.Errorf("no free report ID for report %d of %s", report.ID, source.Name)
+			}
+			used[nextID] = true
+			composed.addMapping(ReportIDMapping{Source: source.Name, SourceID: report.ID, ID: nextID})
+		}
+	}
+	for id := 1; id <= 0xff; id++ {
+		if len(users[uint8(id)]) > 1 {
+			composed.Conflicts = append(composed.Conflicts, ComposeConflict{
+				Kind:     ConflictReportID,
+				Sources:  users[uint8(id)],
+				ReportID: uint8(id),
+			})
+		}
+	}
+
+	for _, source := range sources {
+		desc := source.Descriptor.Clone()
+		ids := composed.ids[source.Name]
+		desc.Walk(func(item hiddesc.MainItem) bool {
+			if item.DataItem != nil {
+				item.DataItem.ReportID = ids[item.DataItem.ReportID]
+			}
+			return true
+		})
+		composed.Descriptor.Collections = append(composed.Descriptor.Collections, desc.Collections...)
+	}
+	slices.SortFunc(composed.Mappings, func(a, b ReportIDMapping) int {
+		return int(a.ID) - int(b.ID)
+	})
+	composed.Conflicts = append(composed.Conflicts, usageConflicts(sources)...)
+	return composed, nil
+}
+
+// AppendTemplates adds collections of the templates to the descriptor, see AppendTemplates.
+func (c *ComposedDescriptor) AppendTemplates(templates []DescriptorTemplate) error {
+	desc, err := AppendTemplates(c.Descriptor, templates)
+	if err != nil {
+		return err
+	}
+	c.Descriptor = desc
+	mapping, ok := c.sources[0]
+	if !ok || !NewDataItemSet(desc).HasReportID() {
+		return nil
+	}
+	// the single report of a descriptor without report IDs is assigned report ID 1
+	delete(c.sources, 0)
+	mapping.ID = 1
+	c.ids[mapping.Source][mapping.SourceID] = mapping.ID
+	c.sources[mapping.ID] = mapping
+	c.Mappings = []ReportIDMapping{mapping}
+	return nil
+}
+
+func (c *ComposedDescriptor) addMapping(mapping ReportIDMapping) {
+	if c.ids[mapping.Source] == nil {
+		c.ids[mapping.Source] = make(map[uint8]uint8)
+	}
+	c.ids[mapping.Source][mapping.SourceID] = mapping.ID
+	c.sources[mapping.ID] = mapping
+	c.Mappings = append(c.Mappings, mapping)
+}
+
+// usageConflicts returns usages of input reports shared by pairs of sources.
+func usageConflicts(sources []DescriptorSource) []ComposeConflict {
+	layouts := make([]*ReportLayout, len(sources))
+	for i, source := range sources {
+		layouts[i] = NewReportLayout(NewDataItemSet(source.Descriptor).WithType(hiddesc.MainItemTypeInput))
+	}
+	var conflicts []ComposeConflict
+	for i := range sources {
+		for j := i + 1; j < len(sources); j++ {
+			var usages []Usage
+			for _, usage := range layouts[i].Usages() {
+				if layouts[j].Contains(usage) {
+					usages = append(usages, usage)
+				}
+			}
+			if len(usages) > 0 {
+				conflicts = append(conflicts, ComposeConflict{
+					Kind:    ConflictUsage,
+					Sources: []string{sources[i].Name, sources[j].Name},
+					Usages:  usages,
+				})
+			}
+		}
+	}
+	return conflicts
+}
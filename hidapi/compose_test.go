@@ -0,0 +1,90 @@
+package hidapi
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/neuroplastio/neio-agent/hidapi/hiddesc"
+)
+
+func templateDescriptor(t *testing.T, templates ...DescriptorTemplate) hiddesc.ReportDescriptor {
+	t.Helper()
+	desc, err := AppendTemplates(hiddesc.ReportDescriptor{}, templates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+func TestComposeDescriptors(t *testing.T) {
+	sources := []DescriptorSource{
+		{Name: "a", Descriptor: templateDescriptor(t,
+			DescriptorTemplate{Type: TemplateBootKeyboard, ReportID: 1},
+			DescriptorTemplate{Type: TemplateSystem, ReportID: 2},
+		)},
+		{Name: "b", Descriptor: templateDescriptor(t,
+			DescriptorTemplate{Type: TemplateMouse, ReportID: 1},
+			DescriptorTemplate{Type: TemplateNKROKeyboard, ReportID: 3},
+		)},
+		{Name: "c", Descriptor: templateDescriptor(t, DescriptorTemplate{Type: TemplateConsumer})},
+	}
+	composed, err := ComposeDescriptors(sources)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []ReportIDMapping{
+		{Source: "a", SourceID: 1, ID: 1},
+		{Source: "a", SourceID: 2, ID: 2},
+		{Source: "b", SourceID: 3, ID: 3},
+		{Source: "b", SourceID: 1, ID: 4},
+		{Source: "c", SourceID: 0, ID: 5},
+	}
+	if !slices.Equal(composed.Mappings, expected) {
+		t.Errorf("unexpected mappings: %v", composed.Mappings)
+	}
+	if id, ok := composed.ReportID("b", 1); !ok || id != 4 {
+		t.Errorf("unexpected report ID of b: %d", id)
+	}
+	if mapping, ok := composed.Source(5); !ok || mapping.Source != "c" {
+		t.Errorf("unexpected source of report 5: %v", mapping)
+	}
+
+	// keys of both keyboards, and the horizontal wheel of the mouse with the consumer control
+	if len(composed.Conflicts) != 3 {
+		t.Fatalf("expected 3 conflicts, got %v", composed.Conflicts)
+	}
+	if c := composed.Conflicts[0]; c.Kind != ConflictReportID || c.ReportID != 1 || !slices.Equal(c.Sources, []string{"a", "b"}) {
+		t.Errorf("unexpected report ID conflict: %s", c)
+	}
+	if c := composed.Conflicts[1]; c.Kind != ConflictUsage || !slices.Contains(c.Usages, NewUsage(0x07, 0x04)) {
+		t.Errorf("unexpected usage conflict: %s", c)
+	}
+
+	raw, err := hiddesc.Encode(composed.Descriptor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := hiddesc.Decode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []uint8
+	for _, report := range NewDataItemSet(decoded).Reports() {
+		ids = append(ids, report.ID)
+	}
+	slices.Sort(ids)
+	if !slices.Equal(ids, []uint8{1, 2, 3, 4, 5}) {
+		t.Errorf("unexpected report IDs: %v", ids)
+	}
+
+	single, err := ComposeDescriptors(sources[2:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := single.AppendTemplates([]DescriptorTemplate{{Type: TemplateMouse}}); err != nil {
+		t.Fatal(err)
+	}
+	if mapping, ok := single.Source(1); !ok || mapping.Source != "c" || mapping.SourceID != 0 {
+		t.Errorf("unexpected source of report 1: %v", single.Mappings)
+	}
+}
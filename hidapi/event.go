@@ -2,196 +2,318 @@ package hidapi
 
 import (
 	"fmt"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
 )
 
+// Event is a set of usage changes.
+//
+// Events are pooled and have a single owner. Passing an event to a stream or another node transfers
+// the ownership, and the sender must not use the event afterwards. The last owner returns the event
+// to the pool with Release.
 type Event struct {
-	ts       time.Time
-	mu       sync.Mutex
-	usages   []UsageEvent
-	usageMap map[Usage]int
+	ts     time.Time
+	usages []UsageEvent
+	// hops are only recorded while the flow is traced.
+	hops []Hop
 }
 
+// Hop is a node that passed the event on.
+type Hop struct {
+	Node string
+	At   time.Time
+}
+
+var eventPool = sync.Pool{
+	New: func() any {
+		return &Event{
+			usages: make([]UsageEvent, 0, 16),
+		}
+	},
+}
+
+func NewEvent() *Event {
+	return NewEventAt(time.Now())
+}
+
+// NewEventAt returns an event created at the given time, for callers that use their own clock.
+func NewEventAt(ts time.Time) *Event {
+	event := eventPool.Get().(*Event)
+	event.ts = ts
+	return event
+}
+
+// Clone returns a copy of the event owned by the caller.
 func (h *Event) Clone() *Event {
-	h.mu.Lock()
-	clone := &Event{
-		ts:       h.ts,
-		usageMap: make(map[Usage]int, len(h.usageMap)),
-	}
-	for _, usage := range h.usages {
-		clone.addUsage(usage)
-	}
-	h.mu.Unlock()
+	clone := eventPool.Get().(*Event)
+	clone.ts = h.ts
+	clone.usages = append(clone.usages, h.usages...)
+	clone.hops = append(clone.hops, h.hops...)
 	return clone
 }
 
-func NewEvent() *Event {
-	return &Event{
-		ts:       time.Now(),
-		usageMap: make(map[Usage]int, 16),
+// Release returns the event to the pool. The event must not be used after it is released.
+func (h *Event) Release() {
+	if h == nil {
+		return
 	}
+	h.usages = h.usages[:0]
+	h.hops = h.hops[:0]
+	eventPool.Put(h)
 }
 
+type UsageEventType uint8
+
+const (
+	UsageEventNone UsageEventType = iota
+	UsageEventActivate
+	UsageEventDeactivate
+	UsageEventValue
+	UsageEventDelta
+)
+
 type UsageEvent struct {
-	Usage    Usage
-	Activate *bool
-	Value    *int32
-	Delta    *int32
+	Usage Usage
+	Type  UsageEventType
+	// Value is the absolute value for UsageEventValue and the relative change for UsageEventDelta.
+	Value int32
+}
+
+// IsActivation reports whether the usage is being activated or deactivated.
+func (u UsageEvent) IsActivation() bool {
+	return u.Type == UsageEventActivate || u.Type == UsageEventDeactivate
 }
 
 func (u UsageEvent) String() string {
-	if u.Activate != nil {
-		if *u.Activate {
-			return "+" + u.Usage.String()
+	switch u.Type {
+	case UsageEventActivate:
+		return "+" + u.Usage.String()
+	case UsageEventDeactivate:
+		return "-" + u.Usage.String()
+	case UsageEventDelta:
+		if u.Value > 0 {
+			return fmt.Sprintf("%s+=%d", u.Usage.String(), u.Value)
 		} else {
-			return "-" + u.Usage.String()
+			return fmt.Sprintf("%s-=%d", u.Usage.String(), -u.Value)
 		}
-	} else if u.Delta != nil {
-		if *u.Delta > 0 {
-			return fmt.Sprintf("%s+=%d", u.Usage.String(), *u.Delta)
-		} else {
-			return fmt.Sprintf("%s-=%d", u.Usage.String(), -*u.Delta)
-		}
-	} else if u.Value != nil {
-		return fmt.Sprintf("%s=%d", u.Usage.String(), *u.Value)
+	case UsageEventValue:
+		return fmt.Sprintf("%s=%d", u.Usage.String(), u.Value)
 	}
 	return "(empty)"
 }
 
+// ParseUsageEvent parses the format of UsageEvent.String: +usage, -usage, usage=value, usage+=delta
+// or usage-=delta.
+func ParseUsageEvent(str string) (UsageEvent, error) {
+	var (
+		event UsageEvent
+		name  string
+		value string
+		sign  int32 = 1
+	)
+	switch {
+	case strings.Contains(str, "+="):
+		event.Type = UsageEventDelta
+		name, value, _ = strings.Cut(str, "+=")
+	case strings.Contains(str, "-="):
+		event.Type = UsageEventDelta
+		name, value, _ = strings.Cut(str, "-=")
+		sign = -1
+	case strings.Contains(str, "="):
+		event.Type = UsageEventValue
+		name, value, _ = strings.Cut(str, "=")
+	case strings.HasPrefix(str, "+"):
+		event.Type = UsageEventActivate
+		name = str[1:]
+	case strings.HasPrefix(str, "-"):
+		event.Type = UsageEventDeactivate
+		name = str[1:]
+	default:
+		return event, fmt.Errorf("expected +usage, -usage, usage=value, usage+=delta or usage-=delta")
+	}
+	usage, err := ParseUsage(strings.TrimSpace(name))
+	if err != nil {
+		return event, err
+	}
+	event.Usage = usage
+	if value != "" {
+		v, err := strconv.ParseInt(strings.TrimSpace(value), 10, 32)
+		if err != nil {
+			return event, fmt.Errorf("invalid value: %w", err)
+		}
+		event.Value = sign * int32(v)
+	}
+	return event, nil
+}
+
 func (h *Event) IsEmpty() bool {
-	if h == nil {
-		return true
+	return h == nil || len(h.usages) == 0
+}
+
+// IsRelative reports whether the event only carries relative (delta) usage changes.
+func (h *Event) IsRelative() bool {
+	if h.IsEmpty() {
+		return false
+	}
+	for _, usage := range h.usages {
+		if usage.Type != UsageEventDelta {
+			return false
+		}
+	}
+	return true
+}
+
+// AddDeltas sums relative usage changes of another relative event into this event.
+// It returns false and leaves the event unchanged if either of the events is not relative.
+func (h *Event) AddDeltas(other *Event) bool {
+	if h == other || !h.IsRelative() || !other.IsRelative() {
+		return false
+	}
+	for _, usage := range other.usages {
+		if idx := h.indexOf(usage.Usage); idx >= 0 {
+			usage.Value += h.usages[idx].Value
+		}
+		h.addUsage(usage)
+	}
+	return true
+}
+
+// indexOf returns the position of the usage in the event, or -1. Events rarely carry more than
+// a handful of usages, so a linear scan is cheaper than maintaining an index.
+func (h *Event) indexOf(usage Usage) int {
+	for i := range h.usages {
+		if h.usages[i].Usage == usage {
+			return i
+		}
 	}
-	h.mu.Lock()
-	empty := len(h.usages) == 0
-	h.mu.Unlock()
-	return empty
+	return -1
 }
 
 func (h *Event) addUsage(diff UsageEvent) {
-	if idx, ok := h.usageMap[diff.Usage]; ok {
+	if idx := h.indexOf(diff.Usage); idx >= 0 {
 		h.usages[idx] = diff
 		return
 	}
 	h.usages = append(h.usages, diff)
-	h.usageMap[diff.Usage] = len(h.usages) - 1
 }
 
 func (h *Event) removeUsage(usage Usage) {
-	idx, ok := h.usageMap[usage]
-	if !ok {
+	idx := h.indexOf(usage)
+	if idx < 0 {
 		return
 	}
-	last := len(h.usages) - 1
-	if idx != last {
-		h.usages[idx] = h.usages[last]
-		h.usageMap[h.usages[idx].Usage] = idx
-	}
-	h.usages = h.usages[:last]
-	delete(h.usageMap, usage)
-}
-
-func ptr[T any](v T) *T {
-	return &v
+	h.usages = append(h.usages[:idx], h.usages[idx+1:]...)
 }
 
 func (h *Event) Suppress(usages ...Usage) {
-	h.mu.Lock()
 	for _, usage := range usages {
 		h.removeUsage(usage)
 	}
-	h.mu.Unlock()
+}
+
+// Filter removes usage events for which keep returns false.
+func (h *Event) Filter(keep func(usage UsageEvent) bool) {
+	n := 0
+	for _, usage := range h.usages {
+		if keep(usage) {
+			h.usages[n] = usage
+			n++
+		}
+	}
+	h.usages = h.usages[:n]
 }
 
 func (h *Event) Usage(usage Usage) (UsageEvent, bool) {
-	h.mu.Lock()
-	idx, ok := h.usageMap[usage]
-	if !ok {
-		h.mu.Unlock()
+	idx := h.indexOf(usage)
+	if idx < 0 {
 		return UsageEvent{}, false
 	}
-	usageEvent := h.usages[idx]
-	h.mu.Unlock()
-	return usageEvent, true
+	return h.usages[idx], true
 }
 
 func (h *Event) AddUsage(usages ...UsageEvent) {
-	h.mu.Lock()
 	for _, usage := range usages {
 		h.addUsage(usage)
 	}
-	h.mu.Unlock()
 }
 
 func (h *Event) Activate(usages ...Usage) {
-	h.mu.Lock()
 	for _, usage := range usages {
-		event := UsageEvent{
-			Usage:    usage,
-			Activate: ptr(true),
-		}
-		h.addUsage(event)
+		h.addUsage(UsageEvent{
+			Usage: usage,
+			Type:  UsageEventActivate,
+		})
 	}
-	h.mu.Unlock()
 }
 
 func (h *Event) Deactivate(usages ...Usage) {
-	h.mu.Lock()
 	for _, usage := range usages {
-		diff := UsageEvent{
-			Usage:    usage,
-			Activate: ptr(false),
-		}
-		h.addUsage(diff)
+		h.addUsage(UsageEvent{
+			Usage: usage,
+			Type:  UsageEventDeactivate,
+		})
 	}
-	h.mu.Unlock()
 }
 
 func (h *Event) SetValue(usage Usage, value int32) {
-	h.mu.Lock()
-	event := UsageEvent{
+	h.addUsage(UsageEvent{
 		Usage: usage,
-		Value: ptr(value),
-	}
-	h.addUsage(event)
-	h.mu.Unlock()
+		Type:  UsageEventValue,
+		Value: value,
+	})
 }
 
 func (h *Event) SetDelta(usage Usage, delta int32) {
-	h.mu.Lock()
-	event := UsageEvent{
+	h.addUsage(UsageEvent{
 		Usage: usage,
-		Delta: ptr(delta),
-	}
-	h.addUsage(event)
-	h.mu.Unlock()
+		Type:  UsageEventDelta,
+		Value: delta,
+	})
 }
 
+// Usages returns usage events of the event. The returned slice is owned by the event:
+// it must not be modified or retained, and it is invalidated by any change to the event.
 func (h *Event) Usages() []UsageEvent {
-	h.mu.Lock()
-	usages := make([]UsageEvent, len(h.usages))
-	copy(usages, h.usages)
-	h.mu.Unlock()
-	return usages
+	if h == nil {
+		return nil
+	}
+	return h.usages
 }
 
 func (h *Event) String() string {
-	h.mu.Lock()
-	var parts []string
-	for _, usage := range h.usages {
-		parts = append(parts, usage.String())
+	var sb strings.Builder
+	for i, usage := range h.usages {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(usage.String())
 	}
-	h.mu.Unlock()
-	return strings.Join(parts, ", ")
+	return sb.String()
 }
 
 func (h *Event) Clear() {
-	h.mu.Lock()
 	h.usages = h.usages[:0]
-	clear(h.usageMap)
-	h.mu.Unlock()
+}
+
+// Timestamp returns the time the event was created at.
+func (h *Event) Timestamp() time.Time {
+	return h.ts
+}
+
+// AddHop records that the node passed the event on at the time.
+func (h *Event) AddHop(node string, at time.Time) {
+	h.hops = append(h.hops, Hop{Node: node, At: at})
+}
+
+// Hops returns the nodes that passed the event on, in order. The slice is only valid until
+// the event is modified or released.
+func (h *Event) Hops() []Hop {
+	if h == nil {
+		return nil
+	}
+	return h.hops
 }
 
 func (h *Event) Duration() time.Duration {
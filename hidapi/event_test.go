@@ -0,0 +1,113 @@
+package hidapi
+
+import (
+	"testing"
+
+	"github.com/neuroplastio/neio-agent/hidapi/hiddesc"
+	"go.uber.org/zap"
+)
+
+var (
+	testUsageA = NewUsage(0x07, 0x04)
+	testUsageB = NewUsage(0x07, 0x05)
+	testUsageX = NewUsage(0x01, 0x30)
+	testUsageY = NewUsage(0x01, 0x31)
+)
+
+func TestEventUsages(t *testing.T) {
+	event := NewEvent()
+	defer event.Release()
+
+	event.Activate(testUsageA, testUsageB)
+	event.Deactivate(testUsageA)
+	event.SetDelta(testUsageX, 3)
+	if got := event.String(); got != "-A, +B, dsk.X+=3" {
+		t.Fatalf("unexpected event: %s", got)
+	}
+	usage, ok := event.Usage(testUsageA)
+	if !ok || usage.Type != UsageEventDeactivate || !usage.IsActivation() {
+		t.Fatalf("unexpected usage event: %v", usage)
+	}
+
+	event.Suppress(testUsageA)
+	event.Filter(func(usage UsageEvent) bool {
+		return usage.Type != UsageEventDelta
+	})
+	if got := event.String(); got != "+B" {
+		t.Fatalf("unexpected event: %s", got)
+	}
+}
+
+func TestEventAddDeltas(t *testing.T) {
+	dst := NewEvent()
+	dst.SetDelta(testUsageX, 1)
+	src := NewEvent()
+	src.SetDelta(testUsageX, 2)
+	src.SetDelta(testUsageY, -4)
+
+	if !dst.AddDeltas(src) {
+		t.Fatal("relative events should be merged")
+	}
+	if got := dst.String(); got != "dsk.X+=3, dsk.Y-=4" {
+		t.Fatalf("unexpected event: %s", got)
+	}
+	src.Activate(testUsageA)
+	if dst.AddDeltas(src) {
+		t.Fatal("absolute events should not be merged")
+	}
+	dst.Release()
+	src.Release()
+}
+
+func TestEventClone(t *testing.T) {
+	event := NewEvent()
+	event.Activate(testUsageA)
+	clone := event.Clone()
+	event.Release()
+
+	reused := NewEvent()
+	reused.Activate(testUsageB)
+	if got := clone.String(); got != "+A" {
+		t.Fatalf("clone should not share usages with the original event: %s", got)
+	}
+	reused.Release()
+	clone.Release()
+}
+
+func BenchmarkEvent(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		event := NewEvent()
+		event.Activate(testUsageA)
+		event.Deactivate(testUsageB)
+		event.SetDelta(testUsageX, 1)
+		if _, ok := event.Usage(testUsageB); !ok {
+			b.Fatal("usage not found")
+		}
+		clone := event.Clone()
+		clone.Suppress(testUsageA)
+		clone.Release()
+		event.Release()
+	}
+}
+
+func newTestReportState(tb testing.TB, path string, typ hiddesc.MainItemType, opts ...ReportStateOption) *ReportState {
+	return NewReportState(zap.NewNop(), testDataItems(tb, path, typ), opts...)
+}
+
+// BenchmarkReportPipeline measures a key press and release travelling from an input report to an output report.
+func BenchmarkReportPipeline(b *testing.B) {
+	input := newTestReportState(b, "../testdata/zsa-moonlander/1.desc", hiddesc.MainItemTypeInput)
+	output := newTestReportState(b, "../testdata/zsa-moonlander/1.desc", hiddesc.MainItemTypeInput)
+	reports := [][]byte{
+		{0, 0, 0x04, 0, 0, 0, 0, 0},
+		{0, 0, 0, 0, 0, 0, 0, 0},
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		event := input.ApplyReport(reports[i%2])
+		output.ApplyEvent(event)
+		event.Release()
+	}
+}
@@ -5,37 +5,32 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"slices"
 )
 
 func toUint16(payload []byte) (uint16, error) {
 	if len(payload) > 2 {
 		return 0, fmt.Errorf("uint16 payload too long")
 	}
-	if len(payload) == 0 {
-		return 0, fmt.Errorf("uint16 payload is missing")
-	}
-	if len(payload) == 1 {
-		payload = append(payload, 0)
-	}
-	return binary.LittleEndian.Uint16(payload), nil
+	var buf [2]byte
+	copy(buf[:], payload)
+	return binary.LittleEndian.Uint16(buf[:]), nil
 }
 
+// toUint32 returns the value of an unsigned payload, empty payloads are 0.
 func toUint32(payload []byte) (uint32, error) {
 	if len(payload) > 4 {
 		return 0, fmt.Errorf("uint32 payload too long: %s", hex.Dump(payload))
 	}
-	if len(payload) == 0 {
-		return 0, fmt.Errorf("uint32 payload is missing")
-	}
-	if len(payload) < 4 {
-		// pad payload
-		payload = append(payload, make([]byte, 4-len(payload))...)
-	}
-	return binary.LittleEndian.Uint32(payload), nil
+	var buf [4]byte
+	copy(buf[:], payload)
+	return binary.LittleEndian.Uint32(buf[:]), nil
 }
 
 func toInt32(payload []byte) (int32, error) {
 	switch len(payload) {
+	case 0:
+		return 0, nil
 	case 1:
 		return int32(int8(payload[0])), nil
 	case 2:
@@ -55,20 +50,69 @@ func toInt32(payload []byte) (int32, error) {
 	}
 }
 
-func newDataItem(state *reportDescriptorState, flags DataFlags) *DataItem {
+// toUsage returns the usage ID of a usage payload, and the usage page of extended usages.
+// Extended usages of page 0 are usages of the current page.
+func toUsage(payload []byte) (page uint16, id uint16, err error) {
+	if len(payload) == 4 {
+		val, err := toUint32(payload)
+		if err != nil {
+			return 0, 0, err
+		}
+		return uint16(val >> 16), uint16(val), nil
+	}
+	id, err = toUint16(payload)
+	return 0, id, err
+}
+
+func newDataItem(state *reportDescriptorState, flags DataFlags) (*DataItem, error) {
+	local := state.local
+	if local.delimiter {
+		return nil, errors.New("delimiter set is not closed")
+	}
+	// usages of extended usage ranges and extended usages of a single page are on that page
+	page := state.global.usagePage
+	if local.usageRangePage != 0 {
+		page = local.usageRangePage
+	} else if len(local.usagePages) > 0 && local.usagePages[0] != 0 && !slices.ContainsFunc(local.usagePages, func(p uint16) bool {
+		return p != local.usagePages[0]
+	}) {
+		page = local.usagePages[0]
+	}
+	var pages []uint16
+	for i, p := range local.usagePages {
+		if p == 0 {
+			p = state.global.usagePage
+		}
+		if p != page && pages == nil {
+			pages = make([]uint16, len(local.usagePages))
+			for j := range pages[:i] {
+				pages[j] = page
+			}
+		}
+		if pages != nil {
+			pages[i] = p
+		}
+	}
 	return &DataItem{
 		Flags:        flags,
-		UsagePage:    state.global.usagePage,
-		UsageIDs:     state.local.usage,
-		UsageMinimum: state.local.usageMinimum,
-		UsageMaximum: state.local.usageMaximum,
+		UsagePage:    page,
+		UsageIDs:     local.usage,
+		UsageMinimum: local.usageMinimum,
+		UsageMaximum: local.usageMaximum,
 		ReportCount:  state.global.reportCount,
 		ReportSize:   state.global.reportSize,
 		ReportID:     state.global.reportID,
 
-		DesignatorIndex:   state.local.designatorIndex,
-		DesignatorMinimum: state.local.designatorMinimum,
-		DesignatorMaximum: state.local.designatorMaximum,
+		UsagePages: pages,
+		Alternates: local.alternates,
+
+		DesignatorIndex:   local.designatorIndex,
+		DesignatorMinimum: local.designatorMinimum,
+		DesignatorMaximum: local.designatorMaximum,
+
+		StringIndex:   local.stringIndex,
+		StringMinimum: local.stringMinimum,
+		StringMaximum: local.stringMaximum,
 
 		LogicalMinimum:  state.global.logicalMinimum,
 		LogicalMaximum:  state.global.logicalMaximum,
@@ -76,63 +120,73 @@ func newDataItem(state *reportDescriptorState, flags DataFlags) *DataItem {
 		PhysicalMaximum: state.global.physicalMaximum,
 		UnitExponent:    state.global.unitExponent,
 		Unit:            state.global.unit,
-	}
+	}, nil
 }
 
-func cmdInput(state *reportDescriptorState, payload []byte) error {
+// addDataItem adds a main data item to the open collection and resets the local state.
+func addDataItem(state *reportDescriptorState, typ MainItemType, payload []byte) error {
 	if state.collection == nil {
-		return errors.New("input: no open collection")
+		return errors.New("no open collection")
 	}
-	if len(payload) != 1 {
-		return fmt.Errorf("input: payload length is not 1")
+	flags, err := toUint32(payload)
+	if err != nil {
+		return err
+	}
+	item, err := newDataItem(state, DataFlags(flags))
+	if err != nil {
+		return err
 	}
 	state.collection.Items = append(state.collection.Items, MainItem{
-		Type:     MainItemTypeInput,
-		DataItem: newDataItem(state, DataFlags(payload[0])),
+		Type:     typ,
+		DataItem: item,
 	})
 	state.local = &localState{}
 	return nil
 }
 
-func cmdOutput(state *reportDescriptorState, payload []byte) error {
-	if state.collection == nil {
-		return errors.New("output: no open collection")
+func cmdInput(state *reportDescriptorState, payload []byte) error {
+	if err := addDataItem(state, MainItemTypeInput, payload); err != nil {
+		return fmt.Errorf("input: %w", err)
 	}
-	if len(payload) != 1 {
-		return fmt.Errorf("output: payload length is not 1")
+	return nil
+}
+
+func cmdOutput(state *reportDescriptorState, payload []byte) error {
+	if err := addDataItem(state, MainItemTypeOutput, payload); err != nil {
+		return fmt.Errorf("output: %w", err)
 	}
-	state.collection.Items = append(state.collection.Items, MainItem{
-		Type:     MainItemTypeOutput,
-		DataItem: newDataItem(state, DataFlags(payload[0])),
-	})
-	state.local = &localState{}
 	return nil
 }
 
 func cmdFeature(state *reportDescriptorState, payload []byte) error {
-	if state.collection == nil {
-		return errors.New("feature: no open collection")
-	}
-	if len(payload) != 1 {
-		return fmt.Errorf("feature: payload length is not 1")
+	if err := addDataItem(state, MainItemTypeFeature, payload); err != nil {
+		return fmt.Errorf("feature: %w", err)
 	}
-	state.collection.Items = append(state.collection.Items, MainItem{
-		Type:     MainItemTypeFeature,
-		DataItem: newDataItem(state, DataFlags(payload[0])),
-	})
-	state.local = &localState{}
 	return nil
 }
 
 func cmdCollection(state *reportDescriptorState, payload []byte) error {
-	if len(payload) != 1 {
-		return fmt.Errorf("collection: payload length is not 1")
+	if len(payload) > 1 {
+		return fmt.Errorf("collection: payload length is more than 1")
+	}
+	if state.local.delimiter {
+		return errors.New("collection: delimiter set is not closed")
+	}
+	typ, err := toUint32(payload)
+	if err != nil {
+		return fmt.Errorf("collection: %w", err)
 	}
 	// TODO: validate state
 	c := Collection{
-		Type:      CollectionType(payload[0]),
+		Type:      CollectionType(typ),
 		UsagePage: state.global.usagePage,
-		UsageID:   state.local.usage[0],
+	}
+	// usage is optional for collections other than application collections
+	if len(state.local.usage) > 0 {
+		c.UsageID = state.local.usage[0]
+		if page := state.local.usagePages[0]; page != 0 {
+			c.UsagePage = page
+		}
 	}
 	if state.collection != nil {
 		state.collectionStack = append(state.collectionStack, *state.collection)
@@ -213,21 +267,19 @@ func cmdPhysicalMaximum(state *reportDescriptorState, payload []byte) error {
 }
 
 func cmdUnitExponent(state *reportDescriptorState, payload []byte) error {
-	if len(payload) == 0 {
-		return fmt.Errorf("unit exponent: payload is missing")
-	}
-	val, err := toUint32(payload)
+	val, err := toInt32(payload)
 	if err != nil {
 		return fmt.Errorf("unit exponent: %w", err)
 	}
+	// the exponent is a signed nibble, some devices use a signed byte instead
+	if val >= 0 && val <= 0x0f {
+		val = int32(int8(val<<4) >> 4)
+	}
 	state.global.unitExponent = val
 	return nil
 }
 
 func cmdUnit(state *reportDescriptorState, payload []byte) error {
-	if len(payload) == 0 {
-		return fmt.Errorf("unit: payload is missing")
-	}
 	val, err := toUint32(payload)
 	if err != nil {
 		return fmt.Errorf("unit: %w", err)
@@ -237,9 +289,6 @@ func cmdUnit(state *reportDescriptorState, payload []byte) error {
 }
 
 func cmdReportSize(state *reportDescriptorState, payload []byte) error {
-	if len(payload) == 0 {
-		return fmt.Errorf("report size: payload is missing")
-	}
 	val, err := toUint32(payload)
 	if err != nil {
 		return fmt.Errorf("report size: %w", err)
@@ -249,21 +298,18 @@ func cmdReportSize(state *reportDescriptorState, payload []byte) error {
 }
 
 func cmdReportID(state *reportDescriptorState, payload []byte) error {
-	if len(payload) == 0 {
-		return fmt.Errorf("report id: payload is missing")
-	}
 	val, err := toUint32(payload)
 	if err != nil {
 		return fmt.Errorf("report id: %w", err)
 	}
+	if val == 0 || val > 0xff {
+		return fmt.Errorf("report id: %d is out of range", val)
+	}
 	state.global.reportID = uint8(val)
 	return nil
 }
 
 func cmdReportCount(state *reportDescriptorState, payload []byte) error {
-	if len(payload) == 0 {
-		return fmt.Errorf("report count: payload is missing")
-	}
 	val, err := toUint32(payload)
 	if err != nil {
 		return fmt.Errorf("report count: %w", err)
@@ -273,11 +319,17 @@ func cmdReportCount(state *reportDescriptorState, payload []byte) error {
 }
 
 func cmdPush(state *reportDescriptorState, payload []byte) error {
+	if len(payload) != 0 {
+		return fmt.Errorf("push: payload length is not 0")
+	}
 	state.globalStack = append(state.globalStack, *state.global)
 	return nil
 }
 
 func cmdPop(state *reportDescriptorState, payload []byte) error {
+	if len(payload) != 0 {
+		return fmt.Errorf("pop: payload length is not 0")
+	}
 	if len(state.globalStack) == 0 {
 		return errors.New("pop: stack is empty")
 	}
@@ -287,80 +339,150 @@ func cmdPop(state *reportDescriptorState, payload []byte) error {
 }
 
 func cmdUsage(state *reportDescriptorState, payload []byte) error {
-	val, err := toUint16(payload)
+	page, id, err := toUsage(payload)
 	if err != nil {
 		return fmt.Errorf("usage: %w", err)
 	}
-	state.local.usage = append(state.local.usage, val)
+	local := state.local
+	if local.delimiter {
+		local.delimiterUsages++
+		if local.delimiterUsages > 1 {
+			// the first usage of a delimiter set is the preferred one, the rest are its alternatives
+			if page == 0 {
+				page = state.global.usagePage
+			}
+			alternates := &local.alternates[len(local.alternates)-1]
+			alternates.Usages = append(alternates.Usages, NewUsage(page, id))
+			return nil
+		}
+	}
+	local.usage = append(local.usage, id)
+	local.usagePages = append(local.usagePages, page)
 	return nil
 }
 
 func cmdDelimiter(state *reportDescriptorState, payload []byte) error {
-	return errors.New("not implemented")
+	val, err := toUint32(payload)
+	if err != nil {
+		return fmt.Errorf("delimiter: %w", err)
+	}
+	local := state.local
+	switch val {
+	case 1:
+		if local.delimiter {
+			return errors.New("delimiter: nested delimiter sets")
+		}
+		local.delimiter = true
+		local.delimiterUsages = 0
+		local.alternates = append(local.alternates, AlternateUsages{Index: len(local.usage)})
+	case 0:
+		if !local.delimiter {
+			return errors.New("delimiter: no open delimiter set")
+		}
+		local.delimiter = false
+		if len(local.alternates[len(local.alternates)-1].Usages) == 0 {
+			local.alternates = local.alternates[:len(local.alternates)-1]
+		}
+	default:
+		return fmt.Errorf("delimiter: unknown value %d", val)
+	}
+	return nil
+}
+
+// usageRangePage sets the page of an extended usage minimum or maximum.
+func usageRangePage(local *localState, page uint16) error {
+	if local.delimiter {
+		return errors.New("usage ranges in delimiter sets are not supported")
+	}
+	if page == 0 {
+		return nil
+	}
+	if local.usageRangePage != 0 && local.usageRangePage != page {
+		return errors.New("usage minimum and maximum are on different pages")
+	}
+	local.usageRangePage = page
+	return nil
 }
 
 func cmdUsageMinimum(state *reportDescriptorState, payload []byte) error {
-	val, err := toUint16(payload)
+	page, id, err := toUsage(payload)
 	if err != nil {
 		return fmt.Errorf("usage minimum: %w", err)
 	}
-	state.local.usageMinimum = val
+	if err := usageRangePage(state.local, page); err != nil {
+		return fmt.Errorf("usage minimum: %w", err)
+	}
+	state.local.usageMinimum = id
 	return nil
 }
 
 func cmdUsageMaximum(state *reportDescriptorState, payload []byte) error {
-	val, err := toUint16(payload)
+	page, id, err := toUsage(payload)
 	if err != nil {
 		return fmt.Errorf("usage maximum: %w", err)
 	}
-	state.local.usageMaximum = val
+	if err := usageRangePage(state.local, page); err != nil {
+		return fmt.Errorf("usage maximum: %w", err)
+	}
+	state.local.usageMaximum = id
 	return nil
 }
 
 func cmdDesignatorIndex(state *reportDescriptorState, payload []byte) error {
-	if len(payload) != 1 {
-		return fmt.Errorf("designator index: payload length is not 1")
+	val, err := toUint32(payload)
+	if err != nil {
+		return fmt.Errorf("designator index: %w", err)
 	}
-	state.local.designatorIndex = payload[0]
+	state.local.designatorIndex = val
 	return nil
 }
 
 func cmdDesignatorMinimum(state *reportDescriptorState, payload []byte) error {
-	if len(payload) != 1 {
-		return fmt.Errorf("designator minimum: payload length is not 1")
+	val, err := toUint32(payload)
+	if err != nil {
+		return fmt.Errorf("designator minimum: %w", err)
 	}
-	state.local.designatorMinimum = payload[0]
+	state.local.designatorMinimum = val
 	return nil
 }
 
 func cmdDesignatorMaximum(state *reportDescriptorState, payload []byte) error {
-	if len(payload) != 1 {
-		return fmt.Errorf("designator maximum: payload length is not 1")
+	val, err := toUint32(payload)
+	if err != nil {
+		return fmt.Errorf("designator maximum: %w", err)
 	}
-	state.local.designatorMaximum = payload[0]
+	state.local.designatorMaximum = val
 	return nil
 }
 
 func cmdStringIndex(state *reportDescriptorState, payload []byte) error {
-	if len(payload) != 1 {
-		return fmt.Errorf("string index: payload length is not 1")
+	val, err := toUint32(payload)
+	if err != nil {
+		return fmt.Errorf("string index: %w", err)
 	}
-	state.local.stringIndex = payload[0]
+	state.local.stringIndex = val
 	return nil
 }
 
 func cmdStringMinimum(state *reportDescriptorState, payload []byte) error {
-	if len(payload) != 1 {
-		return fmt.Errorf("string minimum: payload length is not 1")
+	val, err := toUint32(payload)
+	if err != nil {
+		return fmt.Errorf("string minimum: %w", err)
 	}
-	state.local.stringMinimum = payload[0]
+	state.local.stringMinimum = val
 	return nil
 }
 
 func cmdStringMaximum(state *reportDescriptorState, payload []byte) error {
-	if len(payload) != 1 {
-		return fmt.Errorf("string maximum: payload length is not 1")
+	val, err := toUint32(payload)
+	if err != nil {
+		return fmt.Errorf("string maximum: %w", err)
 	}
-	state.local.stringMaximum = payload[0]
+	state.local.stringMaximum = val
+	return nil
+}
+
+// cmdLongItem skips long items, no long item tags are defined.
+func cmdLongItem(state *reportDescriptorState, payload []byte) error {
 	return nil
 }
@@ -54,7 +54,7 @@ type globalState struct {
 	logicalMaximum  int32
 	physicalMinimum int32
 	physicalMaximum int32
-	unitExponent    uint32
+	unitExponent    int32
 	unit            uint32
 	reportID        uint8
 	reportCount     uint32
@@ -62,17 +62,25 @@ type globalState struct {
 }
 
 type localState struct {
-	usage        []uint16
-	usageMinimum uint16
-	usageMaximum uint16
-
-	designatorIndex   uint8
-	designatorMinimum uint8
-	designatorMaximum uint8
-
-	stringIndex   uint8
-	stringMinimum uint8
-	stringMaximum uint8
+	usage []uint16
+	// usagePages are pages of extended usages, 0 for usages of the usage page of the main item
+	usagePages     []uint16
+	usageMinimum   uint16
+	usageMaximum   uint16
+	usageRangePage uint16
+
+	alternates []AlternateUsages
+	// delimiter is set between open and close delimiter items
+	delimiter       bool
+	delimiterUsages int
+
+	designatorIndex   uint32
+	designatorMinimum uint32
+	designatorMaximum uint32
+
+	stringIndex   uint32
+	stringMinimum uint32
+	stringMaximum uint32
 }
 
 type reportDescriptorState struct {
@@ -86,7 +94,7 @@ type reportDescriptorState struct {
 
 	command           Tag
 	commandFn         commandFn
-	commandPayloadLen uint8
+	commandPayloadLen int
 	commandPayload    []byte
 }
 
@@ -134,6 +142,13 @@ func (d *DescriptorDecoder) parseBytes(size int) error {
 		b := d.buf[i]
 
 		switch {
+		case d.state.command == 0 && Tag(b) == TagLongItem:
+			// the payload of long items starts with the data size and the long item tag
+			d.state.command = TagLongItem
+			d.state.commandFn = cmdLongItem
+			d.state.commandPayloadLen = 2
+			d.state.commandPayload = make([]byte, 0, 2)
+			continue
 		case d.state.command == 0:
 			// new command
 			tag := Tag(b)
@@ -156,6 +171,9 @@ func (d *DescriptorDecoder) parseBytes(size int) error {
 		default:
 			// adding payload to command
 			d.state.commandPayload = append(d.state.commandPayload, b)
+			if d.state.command == TagLongItem && len(d.state.commandPayload) == 2 {
+				d.state.commandPayloadLen = 2 + int(d.state.commandPayload[0])
+			}
 		}
 		if len(d.state.commandPayload) == int(d.state.commandPayloadLen) {
 			// command complete, execute and reset command state
@@ -192,6 +210,9 @@ func (d *DescriptorDecoder) Decode() (ReportDescriptor, error) {
 			}
 		}
 		if errors.Is(err, io.EOF) {
+			if d.state.command != 0 {
+				return ReportDescriptor{}, fmt.Errorf("item 0x%02x is truncated", uint8(d.state.command))
+			}
 			return d.state.descriptor(), nil
 		}
 		if err != nil {
@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"encoding/hex"
 	"os"
+	"path/filepath"
+	"reflect"
 	"testing"
 )
 
@@ -52,3 +54,105 @@ func TestWacom(t *testing.T) {
 
 	t.Fatal("")
 }
+
+func TestRoundTrip(t *testing.T) {
+	files, err := filepath.Glob("../../testdata/*/*.desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, "../../testdata/wacom.desc")
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		desc, err := Decode(raw)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		encoded, err := Encode(desc)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		decoded, err := Decode(encoded)
+		if err != nil {
+			t.Errorf("%s: failed to decode encoded descriptor: %v", file, err)
+			continue
+		}
+		// usage pages of items without usages are not encoded, so descriptors are compared by meaning
+		if diffs := Diff(desc, decoded); len(diffs) > 0 {
+			t.Errorf("%s: descriptors differ after round trip: %v\n%s", file, diffs, hex.Dump(encoded))
+		}
+		reencoded, err := Encode(decoded)
+		if err != nil {
+			t.Errorf("%s: %v", file, err)
+			continue
+		}
+		if !bytes.Equal(encoded, reencoded) {
+			t.Errorf("%s: encoding is not canonical:\n%s\n%s", file, hex.Dump(encoded), hex.Dump(reencoded))
+		}
+		if len(encoded) > len(raw) {
+			t.Errorf("%s: encoded descriptor of %d bytes is larger than the original of %d bytes", file, len(encoded), len(raw))
+		}
+	}
+}
+
+// TestDecodeItems checks items of the synthetic fixtures that no captured descriptor uses.
+func TestDecodeItems(t *testing.T) {
+	raw, err := os.ReadFile("../../testdata/descriptors/delimiters-mouse.desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := Decode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items := desc.Collections[0].Items[0].Collection.Items
+	if buttons := items[0].DataItem; buttons.UsagePage != 0x09 || buttons.UsageMinimum != 1 || buttons.UsageMaximum != 5 {
+		t.Errorf("unexpected extended usage range: %+v", buttons)
+	}
+	wheel := items[3].DataItem
+	if !reflect.DeepEqual(wheel.UsageIDs, []uint16{0x38}) || !reflect.DeepEqual(wheel.Alternates, []AlternateUsages{{Index: 0, Usages: []Usage{NewUsage(0x01, 0x37)}}}) {
+		t.Errorf("unexpected wheel usages: %+v", wheel)
+	}
+	pan := items[4].DataItem
+	if !reflect.DeepEqual(pan.Usages(), []Usage{NewUsage(0x0c, 0x238)}) || len(pan.Alternates) != 1 {
+		t.Errorf("unexpected pan usages: %+v", pan)
+	}
+
+	raw, err = os.ReadFile("../../testdata/descriptors/digitizer.desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err = Decode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	items = desc.Collections[0].Items[0].Collection.Items
+	x, y, pressure, invert := items[2].DataItem, items[3].DataItem, items[4].DataItem, items[5].DataItem
+	if x.UsagePage != 0x01 || x.UnitExponent != -3 || y.PhysicalMaximum != 12240 || y.Unit != 0x11 {
+		t.Errorf("unexpected axes: %+v %+v", x, y)
+	}
+	// global items are restored by pop, unit exponent 0xfe is a signed byte
+	if pressure.UsagePage != 0x0d || pressure.PhysicalMaximum != 0 || pressure.Unit != 0 || pressure.UnitExponent != -2 {
+		t.Errorf("unexpected pressure: %+v", pressure)
+	}
+	if invert.StringIndex != 4 || invert.StringMinimum != 1 || invert.StringMaximum != 3 || invert.DesignatorIndex != 2 {
+		t.Errorf("unexpected string and designator indices: %+v", invert)
+	}
+
+	for _, invalid := range [][]byte{
+		{0xa9, 0x01, 0xa9, 0x01},             // nested delimiter sets
+		{0xa1, 0x01, 0xa9, 0x01, 0x81, 0x02}, // main item in a delimiter set
+		{0xa9, 0x00},                         // delimiter close without open
+		{0xb4},                               // pop of an empty stack
+		{0xfe, 0x04, 0xf0, 0x01},             // truncated long item
+		{0x85, 0x00},                         // report ID 0
+	} {
+		if _, err := Decode(invalid); err == nil {
+			t.Errorf("expected an error for % x", invalid)
+		}
+	}
+}
@@ -140,16 +140,47 @@ type DataItem struct {
 	ReportSize   uint32
 	ReportID     uint8
 
-	DesignatorIndex   uint8
-	DesignatorMinimum uint8
-	DesignatorMaximum uint8
+	// UsagePages are pages of UsageIDs declared with extended usages, nil when all of them are
+	// on UsagePage.
+	UsagePages []uint16
+	// Alternates are usages enclosed in delimiters with one of UsageIDs.
+	Alternates []AlternateUsages
+
+	DesignatorIndex   uint32
+	DesignatorMinimum uint32
+	DesignatorMaximum uint32
+
+	StringIndex   uint32
+	StringMinimum uint32
+	StringMaximum uint32
 
 	LogicalMinimum  int32
 	LogicalMaximum  int32
 	PhysicalMinimum int32
 	PhysicalMaximum int32
-	UnitExponent    uint32
-	Unit            uint32
+	// UnitExponent is the base 10 exponent of the unit, in the range -8..7.
+	UnitExponent int32
+	Unit         uint32
+}
+
+// Usages returns UsageIDs with their pages.
+func (d DataItem) Usages() []Usage {
+	usages := make([]Usage, len(d.UsageIDs))
+	for i, id := range d.UsageIDs {
+		page := d.UsagePage
+		if i < len(d.UsagePages) {
+			page = d.UsagePages[i]
+		}
+		usages[i] = NewUsage(page, id)
+	}
+	return usages
+}
+
+// AlternateUsages are alternatives of the usage at Index of UsageIDs for a single control, enclosed
+// in a delimiter set after the preferred usage.
+type AlternateUsages struct {
+	Index  int
+	Usages []Usage
 }
 
 // NewUsage creates a new Usage from a UsagePage and UsageID.
@@ -189,8 +220,10 @@ func (r ReportDescriptor) Clone() ReportDescriptor {
 			}
 		}
 		collections[i] = Collection{
-			Type:  c.Type,
-			Items: items,
+			Type:      c.Type,
+			UsagePage: c.UsagePage,
+			UsageID:   c.UsageID,
+			Items:     items,
 		}
 	}
 	return ReportDescriptor{
@@ -217,8 +250,10 @@ func (c Collection) Clone() Collection {
 		}
 	}
 	return Collection{
-		Type:  c.Type,
-		Items: items,
+		Type:      c.Type,
+		UsagePage: c.UsagePage,
+		UsageID:   c.UsageID,
+		Items:     items,
 	}
 
 }
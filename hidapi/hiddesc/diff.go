@@ -0,0 +1,243 @@
+package hiddesc
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Difference is a difference between two report descriptors. Path locates the item, e.g.
+// "dsk.Mouse[0]/dsk.Pointer[0]/Input[2]", with indexes of items in their collection. Field is empty
+// for items that are only in one of the descriptors, A or B is empty then.
+type Difference struct {
+	Path  string
+	Field string
+	A     string
+	B     string
+}
+
+func (d Difference) String() string {
+	switch {
+	case d.Field != "":
+		return fmt.Sprintf("%s: %s %s -> %s", d.Path, d.Field, d.A, d.B)
+	case d.A == "":
+		return fmt.Sprintf("+ %s: %s", d.Path, d.B)
+	default:
+		return fmt.Sprintf("- %s: %s", d.Path, d.A)
+	}
+}
+
+// Equal reports whether descriptors have the same meaning, see Diff.
+func Equal(a, b ReportDescriptor) bool {
+	return len(Diff(a, b)) == 0
+}
+
+// Diff compares descriptors by meaning: collections, data items, usages, flags and ranges, regardless
+// of how items are encoded. Items are aligned by the longest common sequence of each collection, items
+// between aligned items are compared field by field, or reported as added and removed. Paths of
+// compared items are of the items in b.
+func Diff(a, b ReportDescriptor) []Difference {
+	var d differ
+	d.items("", collectionItems(a.Collections), collectionItems(b.Collections))
+	return d.diffs
+}
+
+func collectionItems(collections []Collection) []MainItem {
+	items := make([]MainItem, len(collections))
+	for i := range collections {
+		items[i] = MainItem{Type: MainItemTypeCollection, Collection: &collections[i]}
+	}
+	return items
+}
+
+type differ struct {
+	diffs []Difference
+}
+
+func (d *differ) add(path, field, a, b string) {
+	d.diffs = append(d.diffs, Difference{Path: path, Field: field, A: a, B: b})
+}
+
+// items compares items of a collection.
+func (d *differ) items(path string, a, b []MainItem) {
+	// lengths of the longest common sequences of suffixes
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if itemsMatch(a[i], b[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	ga, gb := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case itemsMatch(a[i], b[j]):
+			d.gap(path, a, b, ga, i, gb, j)
+			d.item(path, a[i], b[j], j)
+			i++
+			j++
+			ga, gb = i, j
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			i++
+		default:
+			j++
+		}
+	}
+	d.gap(path, a, b, ga, len(a), gb, len(b))
+}
+
+// gap compares items between aligned items, pairing items of the same type in order.
+func (d *differ) gap(path string, a, b []MainItem, ia, ea, ib, eb int) {
+	for ia < ea || ib < eb {
+		switch {
+		case ia < ea && ib < eb && a[ia].Type == b[ib].Type:
+			d.item(path, a[ia], b[ib], ib)
+			ia++
+			ib++
+		case ia < ea && (ib == eb || !slices.ContainsFunc(b[ib:eb], func(item MainItem) bool { return item.Type == a[ia].Type })):
+			d.add(itemPath(path, a[ia], ia), "", describeItem(a[ia]), "")
+			ia++
+		default:
+			d.add(itemPath(path, b[ib], ib), "", "", describeItem(b[ib]))
+			ib++
+		}
+	}
+}
+
+// itemsMatch reports whether items are aligned: equal data items, or collections of the same usage.
+func itemsMatch(a, b MainItem) bool {
+	if a.Type != b.Type {
+		return false
+	}
+	if a.Collection != nil && b.Collection != nil {
+		return a.Collection.Type == b.Collection.Type && a.Collection.UsagePage == b.Collection.UsagePage && a.Collection.UsageID == b.Collection.UsageID
+	}
+	if a.DataItem != nil && b.DataItem != nil {
+		var d differ
+		d.dataItem("", *a.DataItem, *b.DataItem)
+		return len(d.diffs) == 0
+	}
+	return a.Collection == nil && b.Collection == nil && a.DataItem == nil && b.DataItem == nil
+}
+
+// item compares items of the same type, paths are of the item in b.
+func (d *differ) item(path string, a, b MainItem, index int) {
+	path = itemPath(path, b, index)
+	switch {
+	case a.Collection != nil && b.Collection != nil:
+		d.field(path, "type", collectionTypeName(a.Collection.Type), collectionTypeName(b.Collection.Type))
+		d.field(path, "usage", formatUsage(a.Collection.UsagePage, a.Collection.UsageID), formatUsage(b.Collection.UsagePage, b.Collection.UsageID))
+		d.items(path, a.Collection.Items, b.Collection.Items)
+	case a.DataItem != nil && b.DataItem != nil:
+		d.dataItem(path, *a.DataItem, *b.DataItem)
+	}
+}
+
+func (d *differ) field(path, field, a, b string) {
+	if a != b {
+		d.add(path, field, a, b)
+	}
+}
+
+func (d *differ) dataItem(path string, a, b DataItem) {
+	d.field(path, "flags", formatDataFlags(uint32(a.Flags)), formatDataFlags(uint32(b.Flags)))
+	d.field(path, "usages", formatUsages(a), formatUsages(b))
+	d.field(path, "usageRange", formatUsageRange(a), formatUsageRange(b))
+	d.field(path, "reportId", fmt.Sprint(a.ReportID), fmt.Sprint(b.ReportID))
+	d.field(path, "reportSize", fmt.Sprint(a.ReportSize), fmt.Sprint(b.ReportSize))
+	d.field(path, "reportCount", fmt.Sprint(a.ReportCount), fmt.Sprint(b.ReportCount))
+	d.field(path, "logical", formatRange(a.LogicalMinimum, a.LogicalMaximum), formatRange(b.LogicalMinimum, b.LogicalMaximum))
+	d.field(path, "physical", formatRange(a.PhysicalMinimum, a.PhysicalMaximum), formatRange(b.PhysicalMinimum, b.PhysicalMaximum))
+	unitA, _ := formatUnit(a.Unit)
+	unitB, _ := formatUnit(b.Unit)
+	d.field(path, "unit", unitA, unitB)
+	d.field(path, "unitExponent", fmt.Sprint(a.UnitExponent), fmt.Sprint(b.UnitExponent))
+	d.field(path, "designatorIndex", fmt.Sprint(a.DesignatorIndex), fmt.Sprint(b.DesignatorIndex))
+	d.field(path, "designators", formatRange(a.DesignatorMinimum, a.DesignatorMaximum), formatRange(b.DesignatorMinimum, b.DesignatorMaximum))
+	d.field(path, "stringIndex", fmt.Sprint(a.StringIndex), fmt.Sprint(b.StringIndex))
+	d.field(path, "strings", formatRange(a.StringMinimum, a.StringMaximum), formatRange(b.StringMinimum, b.StringMaximum))
+}
+
+func itemPath(path string, item MainItem, index int) string {
+	var name string
+	switch {
+	case item.Collection != nil:
+		name = formatUsage(item.Collection.UsagePage, item.Collection.UsageID)
+	case item.Type == MainItemTypeInput:
+		name = "Input"
+	case item.Type == MainItemTypeOutput:
+		name = "Output"
+	default:
+		name = "Feature"
+	}
+	name = fmt.Sprintf("%s[%d]", name, index)
+	if path == "" {
+		return name
+	}
+	return path + "/" + name
+}
+
+// describeItem summarizes an added or removed item.
+func describeItem(item MainItem) string {
+	if item.Collection != nil {
+		return fmt.Sprintf("%s collection of %d items", collectionTypeName(item.Collection.Type), len(item.Collection.Items))
+	}
+	if item.DataItem == nil {
+		return "empty item"
+	}
+	parts := []string{formatDataFlags(uint32(item.DataItem.Flags))}
+	if usages := formatUsages(*item.DataItem); usages != "" {
+		parts = append(parts, usages)
+	}
+	if usageRange := formatUsageRange(*item.DataItem); usageRange != "" {
+		parts = append(parts, usageRange)
+	}
+	parts = append(parts, fmt.Sprintf("report %d, %dx%d bits", item.DataItem.ReportID, item.DataItem.ReportCount, item.DataItem.ReportSize))
+	return strings.Join(parts, "; ")
+}
+
+func collectionTypeName(typ CollectionType) string {
+	if int(typ) < len(collectionTypeNames) {
+		return collectionTypeNames[typ]
+	}
+	return fmt.Sprintf("0x%02X", uint8(typ))
+}
+
+// formatUsages formats usages with their alternates in brackets.
+func formatUsages(item DataItem) string {
+	alternates := make(map[int][]Usage, len(item.Alternates))
+	for _, a := range item.Alternates {
+		alternates[a.Index] = a.Usages
+	}
+	names := make([]string, 0, len(item.UsageIDs))
+	for i, usage := range item.Usages() {
+		name := formatUsage(usage.Page(), usage.UsageID())
+		if len(alternates[i]) > 0 {
+			alternateNames := make([]string, len(alternates[i]))
+			for j, alternate := range alternates[i] {
+				alternateNames[j] = formatUsage(alternate.Page(), alternate.UsageID())
+			}
+			name += " [" + strings.Join(alternateNames, ", ") + "]"
+		}
+		names = append(names, name)
+	}
+	return strings.Join(names, ", ")
+}
+
+func formatUsageRange(item DataItem) string {
+	if item.UsageMinimum == 0 && item.UsageMaximum == 0 {
+		return ""
+	}
+	return formatUsage(item.UsagePage, item.UsageMinimum) + ".." + formatUsage(item.UsagePage, item.UsageMaximum)
+}
+
+func formatRange[T int32 | uint32](minimum, maximum T) string {
+	return fmt.Sprintf("%d..%d", minimum, maximum)
+}
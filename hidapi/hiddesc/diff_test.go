@@ -0,0 +1,56 @@
+package hiddesc
+
+import (
+	"os"
+	"slices"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	raw, err := os.ReadFile("../../testdata/descriptors/boot-mouse.desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := Decode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := Encode(a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Equal(a, b) {
+		t.Fatalf("round trip is not equal: %v", Diff(a, b))
+	}
+
+	// a firmware update adds a wheel and widens the axes
+	pointer := b.Collections[0].Items[0].Collection
+	axes := *pointer.Items[2].DataItem
+	axes.ReportSize = 16
+	axes.LogicalMinimum, axes.LogicalMaximum = -32767, 32767
+	wheel := axes
+	wheel.UsageIDs = []uint16{0x38}
+	wheel.ReportCount = 1
+	pointer.Items[2].DataItem = &axes
+	pointer.Items = append(pointer.Items, MainItem{Type: MainItemTypeInput, DataItem: &wheel})
+
+	var diffs []string
+	for _, diff := range Diff(a, b) {
+		diffs = append(diffs, diff.String())
+	}
+	expected := []string{
+		"dsk.Mouse[0]/dsk.Pointer[0]/Input[2]: reportSize 8 -> 16",
+		"dsk.Mouse[0]/dsk.Pointer[0]/Input[2]: logical -127..127 -> -32767..32767",
+		"+ dsk.Mouse[0]/dsk.Pointer[0]/Input[3]: Data, Variable, Relative; dsk.Wheel; report 0, 1x16 bits",
+	}
+	if !slices.Equal(diffs, expected) {
+		t.Errorf("unexpected differences:\n%q", diffs)
+	}
+	if diffs := Diff(b, a); len(diffs) != 3 || diffs[2].String() != "- dsk.Mouse[0]/dsk.Pointer[0]/Input[3]: Data, Variable, Relative; dsk.Wheel; report 0, 1x16 bits" {
+		t.Errorf("unexpected reverse differences: %v", diffs)
+	}
+}
@@ -3,256 +3,372 @@ package hiddesc
 import (
 	"bytes"
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
+	"math"
 )
 
-func Encode(desc ReportDescriptor) ([]byte, error) {
+// ErrDescriptorTooLarge is returned when the encoded descriptor exceeds the size limit.
+var ErrDescriptorTooLarge = errors.New("report descriptor is too large")
+
+type DescriptorEncoderOption func(o *descriptorEncoderOptions)
+
+type descriptorEncoderOptions struct {
+	pushPop bool
+	maxSize int
+}
+
+// WithPushPop wraps collections in Push and Pop items when restoring global items after them takes
+// more bytes.
+func WithPushPop() DescriptorEncoderOption {
+	return func(o *descriptorEncoderOptions) {
+		o.pushPop = true
+	}
+}
+
+// WithMaxSize limits the size of the encoded descriptor, 0 is no limit.
+func WithMaxSize(size int) DescriptorEncoderOption {
+	return func(o *descriptorEncoderOptions) {
+		o.maxSize = size
+	}
+}
+
+func Encode(desc ReportDescriptor, opts ...DescriptorEncoderOption) ([]byte, error) {
 	buf := bytes.NewBuffer(nil)
-	err := NewDescriptorEncoder(buf, desc).Encode()
+	err := NewDescriptorEncoder(buf, desc, opts...).Encode()
 	if err != nil {
 		return nil, err
 	}
 	return buf.Bytes(), nil
 }
 
+// Encoder writes the shortest items: global items are only written when they change, local items when
+// they are set, and values in the shortest payloads that keep their sign.
 type Encoder struct {
-	desc   ReportDescriptor
-	w      io.Writer
-	global *globalState
-	local  *localState
+	desc    ReportDescriptor
+	w       io.Writer
+	options descriptorEncoderOptions
+	buf     *bytes.Buffer
+	global  *encoderGlobals
+	local   *localState
 }
 
-func NewDescriptorEncoder(w io.Writer, desc ReportDescriptor) *Encoder {
-	return &Encoder{
-		desc:   desc,
-		w:      w,
-		global: &globalState{},
-		local:  &localState{},
-	}
+// encoderGlobals are the global items in effect. Usage Page, Logical Minimum and Maximum, Report Size
+// and Report Count start unset, so their first use is always written, even with a zero value. Physical
+// extents are written when they are used. Unit, Unit Exponent and Report ID of 0 mean that the item is
+// not used.
+type encoderGlobals struct {
+	globalState
+	// set is a bit set of written global items, indexed by the tag number
+	set uint16
 }
 
-func (e *Encoder) Encode() error {
-	for _, collection := range e.desc.Collections {
-		if err := e.encodeCollection(collection); err != nil {
-			return err
-		}
+// changed reports whether the global item has to be written, and marks it as written.
+func (g *encoderGlobals) changed(tag Tag, equal bool) bool {
+	bit := uint16(1) << (uint8(tag) >> 4)
+	if equal && g.set&bit != 0 {
+		return false
 	}
-	return nil
+	g.set |= bit
+	return true
 }
 
-func (e *Encoder) encodeCollection(collection Collection) error {
-	if err := e.encodeUsagePage(collection.UsagePage); err != nil {
-		return err
+func NewDescriptorEncoder(w io.Writer, desc ReportDescriptor, opts ...DescriptorEncoderOption) *Encoder {
+	var options descriptorEncoderOptions
+	for _, opt := range opts {
+		opt(&options)
 	}
-	if err := e.encodeUsageID(collection.UsageID); err != nil {
-		return err
-	}
-	if err := e.encodeTag8(TagCollection, uint8(collection.Type)); err != nil {
-		return err
+	return &Encoder{
+		desc:    desc,
+		w:       w,
+		options: options,
+		buf:     bytes.NewBuffer(nil),
+		global:  &encoderGlobals{},
+		local:   &localState{},
 	}
-	e.local = &localState{}
-	for _, item := range collection.Items {
-		if err := e.encodeMainItem(item); err != nil {
-			return err
-		}
+}
+
+func (e *Encoder) Encode() error {
+	items := make([]MainItem, len(e.desc.Collections))
+	for i := range e.desc.Collections {
+		items[i] = MainItem{Type: MainItemTypeCollection, Collection: &e.desc.Collections[i]}
 	}
-	if err := e.encodeTag(TagEndCollection); err != nil {
+	if err := e.encodeItems(items); err != nil {
 		return err
 	}
-	e.local = &localState{}
-	return nil
+	if e.options.maxSize > 0 && e.buf.Len() > e.options.maxSize {
+		return fmt.Errorf("%w: %d bytes, the limit is %d bytes", ErrDescriptorTooLarge, e.buf.Len(), e.options.maxSize)
+	}
+	_, err := e.w.Write(e.buf.Bytes())
+	return err
 }
 
-func (e *Encoder) encodeMainItem(item MainItem) error {
-	if item.Collection != nil {
-		return e.encodeCollection(*item.Collection)
+// trial returns an encoder with a copy of the global state, to measure sizes of items.
+func (e *Encoder) trial() *Encoder {
+	global := *e.global
+	return &Encoder{
+		options: e.options,
+		buf:     bytes.NewBuffer(nil),
+		global:  &global,
+		local:   &localState{},
 	}
-	if item.DataItem != nil {
-		if err := e.encodeUsagePage(item.DataItem.UsagePage); err != nil {
-			return err
-		}
-		if err := e.encodeUsages(item.DataItem.UsageIDs); err != nil {
-			return err
-		}
-		if item.DataItem.UsageMinimum != e.local.usageMinimum || item.DataItem.UsageMaximum != e.local.usageMaximum {
-			if err := e.encodeTag16(TagUsageMinimum, item.DataItem.UsageMinimum); err != nil {
-				return err
-			}
-			if err := e.encodeTag16(TagUsageMaximum, item.DataItem.UsageMaximum); err != nil {
-				return err
-			}
-			e.local.usageMinimum = item.DataItem.UsageMinimum
-			e.local.usageMaximum = item.DataItem.UsageMaximum
-		}
-		if item.DataItem.DesignatorIndex != e.local.designatorIndex {
-			if err := e.encodeTag8(TagDesignatorIndex, item.DataItem.DesignatorIndex); err != nil {
-				return err
-			}
-			e.local.designatorIndex = item.DataItem.DesignatorIndex
-		}
-		if item.DataItem.DesignatorMinimum != e.local.designatorMinimum || item.DataItem.DesignatorMaximum != e.local.designatorMaximum {
-			if err := e.encodeTag8(TagDesignatorMinimum, item.DataItem.DesignatorMinimum); err != nil {
-				return err
-			}
-			if err := e.encodeTag8(TagDesignatorMaximum, item.DataItem.DesignatorMaximum); err != nil {
-				return err
-			}
-			e.local.designatorMinimum = item.DataItem.DesignatorMinimum
-			e.local.designatorMaximum = item.DataItem.DesignatorMaximum
-		}
+}
 
-		if item.DataItem.LogicalMinimum != e.global.logicalMinimum || item.DataItem.LogicalMaximum != e.global.logicalMaximum {
-			if err := e.encodeTagi32(TagLogicalMinimum, item.DataItem.LogicalMinimum); err != nil {
-				return err
-			}
-			if err := e.encodeTagi32(TagLogicalMaximum, item.DataItem.LogicalMaximum); err != nil {
-				return err
-			}
-			e.global.logicalMinimum = item.DataItem.LogicalMinimum
-			e.global.logicalMaximum = item.DataItem.LogicalMaximum
-		}
-		if item.DataItem.PhysicalMinimum != e.global.physicalMinimum || item.DataItem.PhysicalMaximum != e.global.physicalMaximum {
-			if err := e.encodeTagi32(TagPhysicalMinimum, item.DataItem.PhysicalMinimum); err != nil {
-				return err
-			}
-			if err := e.encodeTagi32(TagPhysicalMaximum, item.DataItem.PhysicalMaximum); err != nil {
-				return err
-			}
-			e.global.physicalMinimum = item.DataItem.PhysicalMinimum
-			e.global.physicalMaximum = item.DataItem.PhysicalMaximum
-		}
-		if item.DataItem.UnitExponent != e.global.unitExponent {
-			if err := e.encodeTag32(TagUnitExponent, item.DataItem.UnitExponent); err != nil {
+func (e *Encoder) encodeItems(items []MainItem) error {
+	for i, item := range items {
+		if item.Collection == nil {
+			if err := e.encodeMainItem(item); err != nil {
 				return err
 			}
-			e.global.unitExponent = item.DataItem.UnitExponent
+			continue
 		}
-		if item.DataItem.Unit != e.global.unit {
-			if err := e.encodeTag32(TagUnit, item.DataItem.Unit); err != nil {
-				return err
-			}
-			e.global.unit = item.DataItem.Unit
+		pushPop, err := e.shouldPushPop(*item.Collection, firstDataItem(items[i+1:]))
+		if err != nil {
+			return err
 		}
-		if item.DataItem.ReportID != e.global.reportID {
-			if err := e.encodeTag8(TagReportID, item.DataItem.ReportID); err != nil {
+		if !pushPop {
+			if err := e.encodeCollection(*item.Collection); err != nil {
 				return err
 			}
-			e.global.reportID = item.DataItem.ReportID
+			continue
 		}
-		if item.DataItem.ReportCount != e.global.reportCount {
-			if err := e.encodeTag32(TagReportCount, item.DataItem.ReportCount); err != nil {
-				return err
-			}
-			e.global.reportCount = item.DataItem.ReportCount
+		global := *e.global
+		e.encodeTag(TagPush)
+		if err := e.encodeCollection(*item.Collection); err != nil {
+			return err
 		}
-		if item.DataItem.ReportSize != e.global.reportSize {
-			if err := e.encodeTag32(TagReportSize, item.DataItem.ReportSize); err != nil {
-				return err
-			}
-			e.global.reportSize = item.DataItem.ReportSize
+		e.encodeTag(TagPop)
+		*e.global = global
+	}
+	return nil
+}
+
+// shouldPushPop reports whether restoring global items of the next data item after the collection
+// takes more bytes than Push and Pop items.
+func (e *Encoder) shouldPushPop(collection Collection, next *DataItem) (bool, error) {
+	if !e.options.pushPop || next == nil {
+		return false, nil
+	}
+	after := e.trial()
+	if err := after.encodeCollection(collection); err != nil {
+		return false, err
+	}
+	restored, err := after.trial().dataItemSize(*next)
+	if err != nil {
+		return false, err
+	}
+	kept, err := e.trial().dataItemSize(*next)
+	if err != nil {
+		return false, err
+	}
+	return restored-kept > 2, nil
+}
+
+func (e *Encoder) dataItemSize(item DataItem) (int, error) {
+	if err := e.encodeMainItem(MainItem{Type: MainItemTypeInput, DataItem: &item}); err != nil {
+		return 0, err
+	}
+	return e.buf.Len(), nil
+}
+
+// firstDataItem returns the first data item of the items, including items of collections.
+func firstDataItem(items []MainItem) *DataItem {
+	for _, item := range items {
+		if item.DataItem != nil {
+			return item.DataItem
 		}
-		switch item.Type {
-		case MainItemTypeInput:
-			if err := e.encodeTag32(TagInput, uint32(item.DataItem.Flags)); err != nil {
-				return err
-			}
-		case MainItemTypeOutput:
-			if err := e.encodeTag32(TagOutput, uint32(item.DataItem.Flags)); err != nil {
-				return err
-			}
-		case MainItemTypeFeature:
-			if err := e.encodeTag32(TagFeature, uint32(item.DataItem.Flags)); err != nil {
-				return err
+		if item.Collection != nil {
+			if dataItem := firstDataItem(item.Collection.Items); dataItem != nil {
+				return dataItem
 			}
 		}
-		e.local = &localState{}
 	}
 	return nil
 }
 
-func (e *Encoder) encodeUsagePage(usagePage uint16) error {
-	if usagePage == e.global.usagePage {
-		return nil
+func (e *Encoder) encodeCollection(collection Collection) error {
+	// the usage page of a collection without a usage is not encoded
+	if collection.UsageID != 0 {
+		e.encodeUsagePage(collection.UsagePage)
+		e.encodeUnsigned(TagUsage, uint32(collection.UsageID))
 	}
-	if err := e.encodeTag16(TagUsagePage, usagePage); err != nil {
+	if collection.Type == CollectionTypePhysical {
+		// an empty payload is 0
+		e.encodeTag(TagCollection)
+	} else {
+		e.encodeUnsigned(TagCollection, uint32(collection.Type))
+	}
+	e.local = &localState{}
+	if err := e.encodeItems(collection.Items); err != nil {
 		return err
 	}
-	e.global.usagePage = usagePage
+	e.encodeTag(TagEndCollection)
+	e.local = &localState{}
 	return nil
 }
 
-func (e *Encoder) encodeUsages(usageIDs []uint16) error {
-	for _, usageID := range usageIDs {
-		if err := e.encodeUsageID(usageID); err != nil {
-			return err
+func (e *Encoder) encodeMainItem(item MainItem) error {
+	if item.Collection != nil {
+		return e.encodeCollection(*item.Collection)
+	}
+	if item.DataItem == nil {
+		return nil
+	}
+	data := item.DataItem
+	// the usage page of an item without usages is not encoded
+	if len(data.UsageIDs) > 0 || data.UsageMinimum != 0 || data.UsageMaximum != 0 {
+		e.encodeUsagePage(data.UsagePage)
+	}
+	e.encodeUsages(data)
+	// local items are reset by main items, so they are only encoded when they are set, ranges as pairs
+	e.encodeLocalRange(TagUsageMinimum, TagUsageMaximum, uint32(data.UsageMinimum), uint32(data.UsageMaximum))
+	e.encodeLocal(TagDesignatorIndex, data.DesignatorIndex)
+	e.encodeLocalRange(TagDesignatorMinimum, TagDesignatorMaximum, data.DesignatorMinimum, data.DesignatorMaximum)
+	e.encodeLocal(TagStringIndex, data.StringIndex)
+	e.encodeLocalRange(TagStringMinimum, TagStringMaximum, data.StringMinimum, data.StringMaximum)
+
+	e.encodeGlobalSigned(TagLogicalMinimum, &e.global.logicalMinimum, data.LogicalMinimum)
+	e.encodeGlobalSigned(TagLogicalMaximum, &e.global.logicalMaximum, data.LogicalMaximum)
+	// physical extents of 0 are the logical extents, so they are only written once they are used
+	if data.PhysicalMinimum != 0 || data.PhysicalMaximum != 0 || e.global.physicalMinimum != 0 || e.global.physicalMaximum != 0 {
+		e.encodeGlobalSigned(TagPhysicalMinimum, &e.global.physicalMinimum, data.PhysicalMinimum)
+		e.encodeGlobalSigned(TagPhysicalMaximum, &e.global.physicalMaximum, data.PhysicalMaximum)
+	}
+	if data.UnitExponent != e.global.unitExponent {
+		// the exponent is encoded as a signed nibble
+		if data.UnitExponent < -8 || data.UnitExponent > 7 {
+			return fmt.Errorf("unit exponent %d is out of range", data.UnitExponent)
 		}
+		e.encodeUnsigned(TagUnitExponent, uint32(data.UnitExponent)&0x0f)
+		e.global.unitExponent = data.UnitExponent
+	}
+	if data.Unit != e.global.unit {
+		e.encodeUnsigned(TagUnit, data.Unit)
+		e.global.unit = data.Unit
 	}
+	if data.ReportID != e.global.reportID {
+		e.encodeUnsigned(TagReportID, uint32(data.ReportID))
+		e.global.reportID = data.ReportID
+	}
+	e.encodeGlobal(TagReportCount, &e.global.reportCount, data.ReportCount)
+	e.encodeGlobal(TagReportSize, &e.global.reportSize, data.ReportSize)
+	switch item.Type {
+	case MainItemTypeInput:
+		e.encodeUnsigned(TagInput, uint32(data.Flags))
+	case MainItemTypeOutput:
+		e.encodeUnsigned(TagOutput, uint32(data.Flags))
+	case MainItemTypeFeature:
+		e.encodeUnsigned(TagFeature, uint32(data.Flags))
+	}
+	e.local = &localState{}
 	return nil
 }
 
-func (e *Encoder) encodeUsageID(usageID uint16) error {
-	if usageID == 0 {
-		return nil
+func (e *Encoder) encodeUsagePage(usagePage uint16) {
+	if !e.global.changed(TagUsagePage, usagePage == e.global.usagePage) {
+		return
 	}
-	if len(e.local.usage) > 0 && e.local.usage[len(e.local.usage)-1] == usageID {
-		return nil
+	e.encodeUnsigned(TagUsagePage, uint32(usagePage))
+	e.global.usagePage = usagePage
+}
+
+func (e *Encoder) encodeUsages(item *DataItem) {
+	alternates := make(map[int][]Usage, len(item.Alternates))
+	for _, a := range item.Alternates {
+		alternates[a.Index] = a.Usages
 	}
-	if err := e.encodeTag16(TagUsage, usageID); err != nil {
-		return err
+	for i, usage := range item.Usages() {
+		usages, delimited := alternates[i]
+		if delimited {
+			e.encodeUnsigned(TagDelimiter, 1)
+		}
+		for _, usage := range append([]Usage{usage}, usages...) {
+			e.encodeUsage(item.UsagePage, usage)
+		}
+		if delimited {
+			e.encodeUnsigned(TagDelimiter, 0)
+		}
 	}
-	e.local.usage = []uint16{usageID}
-	return nil
 }
 
-func (e *Encoder) encodeTag(tag Tag) error {
-	_, err := e.w.Write([]byte{byte(tag.WithItemSize(TagItemSize0))})
-	return err
+// encodeUsage encodes usages of other pages than the usage page as extended usages.
+func (e *Encoder) encodeUsage(usagePage uint16, usage Usage) {
+	if usage.Page() == usagePage {
+		e.encodeUnsigned(TagUsage, uint32(usage.UsageID()))
+		return
+	}
+	e.encodeData(TagUsage, binary.LittleEndian.AppendUint32(nil, uint32(usage)))
 }
 
-func (e *Encoder) encodeTag8(tag Tag, value uint8) error {
-	_, err := e.w.Write([]byte{byte(tag.WithItemSize(TagItemSize8)), value})
-	return err
+// encodeLocal encodes a local item that is set.
+func (e *Encoder) encodeLocal(tag Tag, value uint32) {
+	if value != 0 {
+		e.encodeUnsigned(tag, value)
+	}
 }
 
-func (e *Encoder) encodeTag16(tag Tag, value uint16) error {
-	// check if value fits into one byte
-	if value < 0x100 {
-		return e.encodeTag8(tag, uint8(value))
+// encodeLocalRange encodes minimum and maximum local items as a pair when either is set.
+func (e *Encoder) encodeLocalRange(minimumTag, maximumTag Tag, minimum, maximum uint32) {
+	if minimum != 0 || maximum != 0 {
+		e.encodeUnsigned(minimumTag, minimum)
+		e.encodeUnsigned(maximumTag, maximum)
 	}
-	_, err := e.w.Write([]byte{byte(tag.WithItemSize(TagItemSize16)), byte(value), byte(value >> 8)})
-	return err
 }
 
-func (e *Encoder) encodeTagi32(tag Tag, value int32) error {
-	data := make([]byte, 4)
-	binary.LittleEndian.PutUint32(data, uint32(value))
-	size := TagItemSize32
-	pad := byte(0)
-	if value < 0 {
-		pad = 0xff
+// encodeGlobal encodes a global item that changed or was not written yet.
+func (e *Encoder) encodeGlobal(tag Tag, current *uint32, value uint32) {
+	if !e.global.changed(tag, *current == value) {
+		return
 	}
+	e.encodeUnsigned(tag, value)
+	*current = value
+}
+
+func (e *Encoder) encodeGlobalSigned(tag Tag, current *int32, value int32) {
+	if !e.global.changed(tag, *current == value) {
+		return
+	}
+	e.encodeSigned(tag, value)
+	*current = value
+}
+
+func (e *Encoder) encodeTag(tag Tag) {
+	e.buf.WriteByte(byte(tag.WithItemSize(TagItemSize0)))
+}
+
+func (e *Encoder) encodeData(tag Tag, data []byte) {
+	size := TagItemSize8
+	switch len(data) {
+	case 2:
+		size = TagItemSize16
+	case 4:
+		size = TagItemSize32
+	}
+	e.buf.WriteByte(byte(tag.WithItemSize(size)))
+	e.buf.Write(data)
+}
+
+// encodeUnsigned encodes the value in the shortest payload, zero in a byte.
+func (e *Encoder) encodeUnsigned(tag Tag, value uint32) {
+	data := binary.LittleEndian.AppendUint32(nil, value)
 	switch {
-	case data[1] == pad && data[2] == pad && data[3] == pad:
-		size = TagItemSize8
+	case value <= math.MaxUint8:
 		data = data[:1]
-	case data[2] == pad && data[3] == pad:
-		size = TagItemSize16
+	case value <= math.MaxUint16:
 		data = data[:2]
 	}
-	data = append([]byte{byte(tag.WithItemSize(size))}, data...)
-	_, err := e.w.Write(data)
-	return err
+	e.encodeData(tag, data)
 }
 
-func (e *Encoder) encodeTag32(tag Tag, value uint32) error {
-	// check if value fits into one byte
-	if value < 0x100 {
-		return e.encodeTag8(tag, uint8(value))
-	}
-	// check if value fits into two bytes
-	if value < 0x10000 {
-		return e.encodeTag16(tag, uint16(value))
+// encodeSigned encodes the value in the shortest payload with the sign bit of the value.
+func (e *Encoder) encodeSigned(tag Tag, value int32) {
+	data := binary.LittleEndian.AppendUint32(nil, uint32(value))
+	switch {
+	case value >= math.MinInt8 && value <= math.MaxInt8:
+		data = data[:1]
+	case value >= math.MinInt16 && value <= math.MaxInt16:
+		data = data[:2]
 	}
-	_, err := e.w.Write([]byte{byte(tag.WithItemSize(TagItemSize32)), byte(value), byte(value >> 8), byte(value >> 16), byte(value >> 24)})
-	return err
+	e.encodeData(tag, data)
 }
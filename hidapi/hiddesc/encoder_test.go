@@ -0,0 +1,141 @@
+package hiddesc
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestEncodeBootKeyboard(t *testing.T) {
+	// HID 1.11 appendix B.1
+	raw, err := os.ReadFile("../../testdata/descriptors/boot-keyboard.desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := Decode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := Encode(desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the items of the appendix, with global items of each main item in encoder order and without the
+	// repeated Logical Minimum (0) of the key array
+	expected := []byte{
+		0x05, 0x01, 0x09, 0x06, 0xa1, 0x01,
+		// modifiers
+		0x05, 0x07, 0x19, 0xe0, 0x29, 0xe7, 0x15, 0x00, 0x25, 0x01, 0x95, 0x08, 0x75, 0x01, 0x81, 0x02,
+		// reserved byte
+		0x95, 0x01, 0x75, 0x08, 0x81, 0x01,
+		// LEDs
+		0x05, 0x08, 0x19, 0x01, 0x29, 0x05, 0x95, 0x05, 0x75, 0x01, 0x91, 0x02,
+		// LED padding
+		0x95, 0x01, 0x75, 0x03, 0x91, 0x01,
+		// key array
+		0x05, 0x07, 0x19, 0x00, 0x29, 0x65, 0x25, 0x65, 0x95, 0x06, 0x75, 0x08, 0x81, 0x00,
+		0xc0,
+	}
+	if !bytes.Equal(encoded, expected) {
+		t.Errorf("unexpected encoding:\n% x\n% x", encoded, expected)
+	}
+	decoded, err := Decode(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(desc, decoded) {
+		t.Errorf("descriptors differ after round trip: %v", Diff(desc, decoded))
+	}
+}
+
+func TestEncodeCompact(t *testing.T) {
+	desc := ReportDescriptor{Collections: []Collection{{
+		Type:      CollectionTypeApplication,
+		UsagePage: 0x01,
+		UsageID:   0x05,
+		Items: []MainItem{
+			{Type: MainItemTypeInput, DataItem: &DataItem{
+				Flags: DataFlagVariable, UsagePage: 0x01, UsageIDs: []uint16{0x30},
+				LogicalMinimum: -1, LogicalMaximum: 255, ReportSize: 16, ReportCount: 1,
+			}},
+			{Type: MainItemTypeInput, DataItem: &DataItem{
+				Flags: DataFlagVariable, UsagePage: 0x01, UsageIDs: []uint16{0x31},
+				LogicalMinimum: -1, LogicalMaximum: 32768, ReportSize: 32, ReportCount: 1,
+			}},
+			// padding keeps the usage page
+			{Type: MainItemTypeInput, DataItem: &DataItem{
+				Flags: DataFlagConstant, UsagePage: 0x09, LogicalMinimum: -1, LogicalMaximum: 32768, ReportSize: 32, ReportCount: 1,
+			}},
+		},
+	}}}
+	raw, err := Encode(desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		0x05, 0x01, 0x09, 0x05, 0xa1, 0x01,
+		0x09, 0x30, 0x15, 0xff, 0x26, 0xff, 0x00, 0x95, 0x01, 0x75, 0x10, 0x81, 0x02,
+		0x09, 0x31, 0x27, 0x00, 0x80, 0x00, 0x00, 0x75, 0x20, 0x81, 0x02,
+		0x81, 0x01,
+		0xc0,
+	}
+	if !bytes.Equal(raw, expected) {
+		t.Errorf("unexpected encoding:\n% x\n% x", raw, expected)
+	}
+
+	if _, err := Encode(desc, WithMaxSize(len(expected)-1)); !errors.Is(err, ErrDescriptorTooLarge) {
+		t.Errorf("expected the size limit to be exceeded, got %v", err)
+	}
+	if _, err := Encode(desc, WithMaxSize(len(expected))); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestEncodePushPop(t *testing.T) {
+	axis := func(id uint16) *DataItem {
+		return &DataItem{
+			Flags: DataFlagVariable, UsagePage: 0x01, UsageIDs: []uint16{id},
+			LogicalMaximum: 4095, ReportSize: 12, ReportCount: 1,
+		}
+	}
+	// the pen collection changes most global items, the axis after it restores them
+	pen := axis(0x30)
+	pen.UsagePage, pen.UsageIDs = 0x0d, []uint16{0x30}
+	pen.LogicalMinimum, pen.LogicalMaximum, pen.PhysicalMaximum = -100, 100000, 1000
+	pen.Unit, pen.UnitExponent, pen.ReportSize = 0x11, -2, 32
+	desc := ReportDescriptor{Collections: []Collection{{
+		Type:      CollectionTypeApplication,
+		UsagePage: 0x01,
+		UsageID:   0x04,
+		Items: []MainItem{
+			{Type: MainItemTypeInput, DataItem: axis(0x30)},
+			{Type: MainItemTypeCollection, Collection: &Collection{
+				Type:      CollectionTypePhysical,
+				UsagePage: 0x0d,
+				UsageID:   0x20,
+				Items:     []MainItem{{Type: MainItemTypeInput, DataItem: pen}},
+			}},
+			{Type: MainItemTypeInput, DataItem: axis(0x31)},
+		},
+	}}}
+	plain, err := Encode(desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pushPop, err := Encode(desc, WithPushPop())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pushPop) >= len(plain) || !bytes.Contains(pushPop, []byte{TagPush}) || !bytes.Contains(pushPop, []byte{TagPop}) {
+		t.Errorf("push and pop are not used:\n% x\n% x", plain, pushPop)
+	}
+	decoded, err := Decode(pushPop)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diffs := Diff(desc, decoded); len(diffs) > 0 {
+		t.Errorf("descriptors differ: %v", diffs)
+	}
+}
@@ -0,0 +1,110 @@
+package hiddesc
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// TagLongItem is the prefix of long items, followed by the data size and the long item tag.
+const TagLongItem = 0xFE
+
+// Item is a raw item of a report descriptor. Data of long items starts with the data size and the
+// long item tag.
+type Item struct {
+	Tag  Tag
+	Data []byte
+}
+
+// IsLong reports whether the item is a long item.
+func (i Item) IsLong() bool {
+	return i.Tag == TagLongItem
+}
+
+// Unsigned returns the data of a short item as an unsigned value.
+func (i Item) Unsigned() uint32 {
+	var buf [4]byte
+	copy(buf[:], i.Data)
+	return binary.LittleEndian.Uint32(buf[:])
+}
+
+// Signed returns the data of a short item as a signed value.
+func (i Item) Signed() int32 {
+	switch len(i.Data) {
+	case 1:
+		return int32(int8(i.Data[0]))
+	case 2:
+		return int32(int16(binary.LittleEndian.Uint16(i.Data)))
+	default:
+		return int32(i.Unsigned())
+	}
+}
+
+// ReadItems splits a raw report descriptor into items.
+func ReadItems(data []byte) ([]Item, error) {
+	var items []Item
+	for i := 0; i < len(data); {
+		tag := Tag(data[i])
+		size := 0
+		if tag == TagLongItem {
+			if i+2 >= len(data) {
+				return nil, fmt.Errorf("long item at offset %d is truncated", i)
+			}
+			size = 2 + int(data[i+1])
+		} else {
+			size = []int{0, 1, 2, 4}[tag.PayloadSize()]
+		}
+		if i+1+size > len(data) {
+			return nil, fmt.Errorf("item 0x%02x at offset %d is truncated", uint8(tag), i)
+		}
+		items = append(items, Item{Tag: tag, Data: data[i+1 : i+1+size]})
+		i += 1 + size
+	}
+	return items, nil
+}
+
+// WriteItems joins items into a raw report descriptor.
+func WriteItems(items []Item) []byte {
+	var data []byte
+	for _, item := range items {
+		data = append(data, byte(item.Tag))
+		data = append(data, item.Data...)
+	}
+	return data
+}
+
+// shortItem returns a short item with the shortest data that holds the value, at least a byte.
+func shortItem(tag Tag, value uint32) Item {
+	data := binary.LittleEndian.AppendUint32(nil, value)
+	switch {
+	case value <= 0xff:
+		data = data[:1]
+	case value <= 0xffff:
+		data = data[:2]
+	}
+	return Item{Tag: tag.WithItemSize(itemSize(len(data))), Data: data}
+}
+
+// signedItem returns a short item with the shortest data that holds the signed value.
+func signedItem(tag Tag, value int32) Item {
+	data := binary.LittleEndian.AppendUint32(nil, uint32(value))
+	switch {
+	case value >= -0x80 && value <= 0x7f:
+		data = data[:1]
+	case value >= -0x8000 && value <= 0x7fff:
+		data = data[:2]
+	}
+	return Item{Tag: tag.WithItemSize(itemSize(len(data))), Data: data}
+}
+
+func itemSize(n int) TagItemSize {
+	switch n {
+	case 0:
+		return TagItemSize0
+	case 1:
+		return TagItemSize8
+	case 2:
+		return TagItemSize16
+	default:
+		return TagItemSize32
+	}
+}
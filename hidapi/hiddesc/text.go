@@ -0,0 +1,575 @@
+package hiddesc
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/neuroplastio/neio-agent/hidapi/hidusage"
+	"github.com/neuroplastio/neio-agent/hidapi/hidusage/usagepages"
+)
+
+// The text format has an item per line, e.g. "Logical Maximum (255)", indented by collection. Usages
+// are formatted with hidusage.Format, usages without a page are looked up in the current usage page,
+// and a usage of another page than the current usage page is an extended usage. Text after ";" is a
+// comment. Items that are not known are written as "Item (<tag>, <hex data>)".
+
+type textValue uint8
+
+const (
+	textValueNone textValue = iota
+	textValueUnsigned
+	textValueSigned
+	textValueFlags
+	textValueCollection
+	textValueUsagePage
+	textValueUsage
+	textValueUnit
+	textValueUnitExponent
+	textValueDelimiter
+)
+
+type textItem struct {
+	tag   Tag
+	name  string
+	value textValue
+}
+
+var textItems = []textItem{
+	{TagInput, "Input", textValueFlags},
+	{TagOutput, "Output", textValueFlags},
+	{TagFeature, "Feature", textValueFlags},
+	{TagCollection, "Collection", textValueCollection},
+	{TagEndCollection, "End Collection", textValueNone},
+	{TagUsagePage, "Usage Page", textValueUsagePage},
+	{TagLogicalMinimum, "Logical Minimum", textValueSigned},
+	{TagLogicalMaximum, "Logical Maximum", textValueSigned},
+	{TagPhysicalMinimum, "Physical Minimum", textValueSigned},
+	{TagPhysicalMaximum, "Physical Maximum", textValueSigned},
+	{TagUnitExponent, "Unit Exponent", textValueUnitExponent},
+	{TagUnit, "Unit", textValueUnit},
+	{TagReportSize, "Report Size", textValueUnsigned},
+	{TagReportID, "Report ID", textValueUnsigned},
+	{TagReportCount, "Report Count", textValueUnsigned},
+	{TagPush, "Push", textValueNone},
+	{TagPop, "Pop", textValueNone},
+	{TagUsage, "Usage", textValueUsage},
+	{TagUsageMinimum, "Usage Minimum", textValueUsage},
+	{TagUsageMaximum, "Usage Maximum", textValueUsage},
+	{TagDesignatorIndex, "Designator Index", textValueUnsigned},
+	{TagDesignatorMinimum, "Designator Minimum", textValueUnsigned},
+	{TagDesignatorMaximum, "Designator Maximum", textValueUnsigned},
+	{TagStringIndex, "String Index", textValueUnsigned},
+	{TagStringMinimum, "String Minimum", textValueUnsigned},
+	{TagStringMaximum, "String Maximum", textValueUnsigned},
+	{TagDelimiter, "Delimiter", textValueDelimiter},
+}
+
+// textRawItem is the name of items written as a tag and hex data.
+const textRawItem = "Item"
+
+var (
+	textItemsByTag  = make(map[Tag]textItem, len(textItems))
+	textItemsByName = make(map[string]textItem, len(textItems))
+)
+
+// dataFlagNames are names of cleared and set data flags, in order of the bits.
+var dataFlagNames = [][2]string{
+	{"Data", "Constant"},
+	{"Array", "Variable"},
+	{"Absolute", "Relative"},
+	{"No Wrap", "Wrap"},
+	{"Linear", "Non Linear"},
+	{"Preferred State", "No Preferred"},
+	{"No Null Position", "Null State"},
+	{"Non Volatile", "Volatile"},
+	{"Bit Field", "Buffered Bytes"},
+}
+
+var collectionTypeNames = []string{
+	"Physical", "Application", "Logical", "Report", "Named Array", "Usage Switch", "Usage Modifier",
+}
+
+var (
+	unitSystemNames = []string{"None", "SI Linear", "SI Rotation", "English Linear", "English Rotation"}
+	unitNames       = []string{"Length", "Mass", "Time", "Temperature", "Current", "Luminous Intensity"}
+	// unitSymbols are symbols of units of each system, for comments.
+	unitSymbols = [][]string{
+		{},
+		{"cm", "g", "s", "K", "A", "cd"},
+		{"rad", "g", "s", "K", "A", "cd"},
+		{"in", "slug", "s", "°F", "A", "cd"},
+		{"deg", "slug", "s", "°F", "A", "cd"},
+	}
+)
+
+func init() {
+	for _, item := range textItems {
+		textItemsByTag[item.tag] = item
+		textItemsByName[normalizeTextName(item.name)] = item
+	}
+}
+
+// normalizeTextName makes names case-insensitive and ignores spaces, so "NullState" matches "Null State".
+func normalizeTextName(name string) string {
+	return strings.ToLower(strings.ReplaceAll(name, " ", ""))
+}
+
+// textCommentColumn is the column of comments following items.
+const textCommentColumn = 40
+
+// FormatText writes items of a raw report descriptor in the text format.
+func FormatText(w io.Writer, data []byte) error {
+	items, err := ReadItems(data)
+	if err != nil {
+		return err
+	}
+	f := textFormatter{}
+	for _, item := range items {
+		text, comment := f.format(item)
+		if item.Tag.TagPrefix() == TagEndCollection && f.depth > 0 {
+			f.depth--
+		}
+		line := strings.Repeat("  ", f.depth) + text
+		if comment != "" {
+			line = fmt.Sprintf("%-*s ; %s", textCommentColumn, line, comment)
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+		if item.Tag.TagPrefix() == TagCollection {
+			f.depth++
+		}
+	}
+	return nil
+}
+
+// IsText reports whether data is a descriptor in the text format. Raw descriptors contain control
+// characters.
+func IsText(data []byte) bool {
+	for _, b := range data {
+		if b < 0x20 && b != '\t' && b != '\n' && b != '\r' {
+			return false
+		}
+	}
+	return utf8.Valid(data)
+}
+
+// EncodeText encodes the descriptor in the text format.
+func EncodeText(desc ReportDescriptor) ([]byte, error) {
+	data, err := Encode(desc)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := FormatText(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeText decodes a descriptor from the text format.
+func DecodeText(text []byte) (ReportDescriptor, error) {
+	data, err := ParseText(text)
+	if err != nil {
+		return ReportDescriptor{}, err
+	}
+	return Decode(data)
+}
+
+type textFormatter struct {
+	depth     int
+	usagePage uint16
+	stack     []uint16
+}
+
+func (f *textFormatter) format(item Item) (string, string) {
+	info, ok := textItemsByTag[item.Tag.TagPrefix()]
+	if !ok || item.IsLong() {
+		return formatRawItem(item), ""
+	}
+	switch info.value {
+	case textValueNone:
+		switch info.tag {
+		case TagPush:
+			f.stack = append(f.stack, f.usagePage)
+		case TagPop:
+			if len(f.stack) > 0 {
+				f.usagePage = f.stack[len(f.stack)-1]
+				f.stack = f.stack[:len(f.stack)-1]
+			}
+		}
+		if len(item.Data) > 0 {
+			return formatRawItem(item), ""
+		}
+		return info.name, ""
+	case textValueSigned:
+		return fmt.Sprintf("%s (%d)", info.name, item.Signed()), ""
+	case textValueFlags:
+		return fmt.Sprintf("%s (%s)", info.name, formatDataFlags(item.Unsigned())), ""
+	case textValueCollection:
+		value := item.Unsigned()
+		if int(value) < len(collectionTypeNames) {
+			return fmt.Sprintf("%s (%s)", info.name, collectionTypeNames[value]), ""
+		}
+		return fmt.Sprintf("%s (0x%02X)", info.name, value), ""
+	case textValueUsagePage:
+		f.usagePage = uint16(item.Unsigned())
+		page, ok := usagepages.GetPageInfoByCode(f.usagePage)
+		if !ok {
+			return fmt.Sprintf("%s (0x%04x)", info.name, f.usagePage), ""
+		}
+		return fmt.Sprintf("%s (%s)", info.name, page.Alias), page.Name
+	case textValueUsage:
+		page, id := f.usagePage, item.Unsigned()
+		if len(item.Data) == 4 {
+			page, id = uint16(id>>16), id&0xffff
+		}
+		return fmt.Sprintf("%s (%s)", info.name, formatUsage(page, uint16(id))), ""
+	case textValueUnit:
+		text, comment := formatUnit(item.Unsigned())
+		return fmt.Sprintf("%s (%s)", info.name, text), comment
+	case textValueUnitExponent:
+		value := item.Unsigned()
+		if len(item.Data) != 1 || value > 0xf {
+			return fmt.Sprintf("%s (0x%X)", info.name, value), ""
+		}
+		return fmt.Sprintf("%s (%d)", info.name, signedNibble(value)), ""
+	case textValueDelimiter:
+		switch item.Unsigned() {
+		case 0:
+			return info.name + " (Close)", ""
+		case 1:
+			return info.name + " (Open)", ""
+		}
+	}
+	return fmt.Sprintf("%s (%d)", info.name, item.Unsigned()), ""
+}
+
+func formatRawItem(item Item) string {
+	if len(item.Data) == 0 {
+		return fmt.Sprintf("%s (0x%02X)", textRawItem, uint8(item.Tag))
+	}
+	return fmt.Sprintf("%s (0x%02X, %X)", textRawItem, uint8(item.Tag), item.Data)
+}
+
+func formatDataFlags(flags uint32) string {
+	var names []string
+	for bit, pair := range dataFlagNames {
+		set := flags&(1<<bit) != 0
+		// the first three flags are always written
+		if bit < 3 || set {
+			names = append(names, pair[boolIndex(set)])
+		}
+	}
+	if rest := flags >> len(dataFlagNames) << len(dataFlagNames); rest != 0 {
+		names = append(names, fmt.Sprintf("0x%X", rest))
+	}
+	return strings.Join(names, ", ")
+}
+
+func formatUsage(page, id uint16) string {
+	// key names only cover 8-bit usage IDs
+	if page == usagepages.KeyboardKeypad && id > 0xff {
+		return fmt.Sprintf("kb.0x%02x", id)
+	}
+	return hidusage.Format(page, id)
+}
+
+func formatUnit(value uint32) (string, string) {
+	system := value & 0xf
+	if int(system) >= len(unitSystemNames) || value>>28 != 0 {
+		return fmt.Sprintf("0x%X", value), ""
+	}
+	var names, symbols []string
+	for i, name := range unitNames {
+		exponent := signedNibble(value >> (4 * (i + 1)) & 0xf)
+		if exponent == 0 {
+			continue
+		}
+		symbol := unitSymbols[system]
+		if exponent != 1 {
+			name = fmt.Sprintf("%s^%d", name, exponent)
+		}
+		names = append(names, name)
+		if len(symbol) > 0 {
+			if exponent != 1 {
+				symbols = append(symbols, fmt.Sprintf("%s^%d", symbol[i], exponent))
+			} else {
+				symbols = append(symbols, symbol[i])
+			}
+		}
+	}
+	text := unitSystemNames[system]
+	if len(names) > 0 {
+		text += ": " + strings.Join(names, ", ")
+	}
+	return text, strings.Join(symbols, " ")
+}
+
+func signedNibble(value uint32) int {
+	if value >= 8 {
+		return int(value) - 16
+	}
+	return int(value)
+}
+
+func boolIndex(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ParseText compiles the text format into a raw report descriptor.
+func ParseText(text []byte) ([]byte, error) {
+	p := textParser{}
+	var items []Item
+	scanner := bufio.NewScanner(bytes.NewReader(text))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if i := strings.Index(line, ";"); i >= 0 {
+			line = line[:i]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		item, err := p.parse(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNum, err)
+		}
+		items = append(items, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return WriteItems(items), nil
+}
+
+type textParser struct {
+	usagePage uint16
+	stack     []uint16
+}
+
+func (p *textParser) parse(line string) (Item, error) {
+	name, value := line, ""
+	if i := strings.Index(line, "("); i >= 0 {
+		if !strings.HasSuffix(line, ")") {
+			return Item{}, fmt.Errorf("missing closing parenthesis: %s", line)
+		}
+		name, value = strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:len(line)-1])
+	}
+	if normalizeTextName(name) == normalizeTextName(textRawItem) {
+		return parseRawItem(value)
+	}
+	info, ok := textItemsByName[normalizeTextName(name)]
+	if !ok {
+		return Item{}, fmt.Errorf("unknown item: %s", name)
+	}
+	if info.value == textValueNone {
+		if value != "" {
+			return Item{}, fmt.Errorf("%s has no value", info.name)
+		}
+		switch info.tag {
+		case TagPush:
+			p.stack = append(p.stack, p.usagePage)
+		case TagPop:
+			if len(p.stack) == 0 {
+				return Item{}, fmt.Errorf("pop without push")
+			}
+			p.usagePage = p.stack[len(p.stack)-1]
+			p.stack = p.stack[:len(p.stack)-1]
+		}
+		return Item{Tag: info.tag}, nil
+	}
+	if value == "" {
+		return Item{}, fmt.Errorf("%s requires a value", info.name)
+	}
+	switch info.value {
+	case textValueSigned:
+		v, err := strconv.ParseInt(value, 0, 32)
+		if err != nil {
+			return Item{}, fmt.Errorf("invalid %s: %s", info.name, value)
+		}
+		return signedItem(info.tag, int32(v)), nil
+	case textValueFlags:
+		flags, err := parseDataFlags(value)
+		if err != nil {
+			return Item{}, err
+		}
+		return shortItem(info.tag, flags), nil
+	case textValueCollection:
+		for i, typeName := range collectionTypeNames {
+			if normalizeTextName(typeName) == normalizeTextName(value) {
+				return shortItem(info.tag, uint32(i)), nil
+			}
+		}
+	case textValueUsagePage:
+		page, err := hidusage.ParsePage(value)
+		if err != nil {
+			return Item{}, err
+		}
+		p.usagePage = page.Code
+		return shortItem(info.tag, uint32(page.Code)), nil
+	case textValueUsage:
+		page, id, err := parseUsage(p.usagePage, value)
+		if err != nil {
+			return Item{}, err
+		}
+		if page != p.usagePage {
+			// extended usage
+			return Item{Tag: info.tag.WithItemSize(TagItemSize32), Data: []byte{byte(id), byte(id >> 8), byte(page), byte(page >> 8)}}, nil
+		}
+		return shortItem(info.tag, uint32(id)), nil
+	case textValueUnit:
+		unit, err := parseUnit(value)
+		if err != nil {
+			return Item{}, err
+		}
+		return shortItem(info.tag, unit), nil
+	case textValueUnitExponent:
+		if strings.HasPrefix(value, "0x") {
+			break
+		}
+		v, err := strconv.ParseInt(value, 10, 8)
+		if err != nil || v < -8 || v > 7 {
+			return Item{}, fmt.Errorf("unit exponent must be between -8 and 7: %s", value)
+		}
+		return shortItem(info.tag, uint32(v)&0xf), nil
+	case textValueDelimiter:
+		switch normalizeTextName(value) {
+		case "close":
+			return shortItem(info.tag, 0), nil
+		case "open":
+			return shortItem(info.tag, 1), nil
+		}
+	}
+	v, err := strconv.ParseUint(value, 0, 32)
+	if err != nil {
+		return Item{}, fmt.Errorf("invalid %s: %s", info.name, value)
+	}
+	return shortItem(info.tag, uint32(v)), nil
+}
+
+func parseRawItem(value string) (Item, error) {
+	tagStr, dataStr, _ := strings.Cut(value, ",")
+	tag, err := strconv.ParseUint(strings.TrimSpace(tagStr), 0, 8)
+	if err != nil {
+		return Item{}, fmt.Errorf("invalid item tag: %s", tagStr)
+	}
+	data, err := hex.DecodeString(strings.ReplaceAll(strings.TrimSpace(dataStr), " ", ""))
+	if err != nil {
+		return Item{}, fmt.Errorf("invalid item data: %s", dataStr)
+	}
+	item := Item{Tag: Tag(tag), Data: data}
+	if item.IsLong() {
+		if len(data) < 2 || int(data[0])+2 != len(data) {
+			return Item{}, fmt.Errorf("long item data size does not match: %s", dataStr)
+		}
+	} else if []int{0, 1, 2, 4}[item.Tag.PayloadSize()] != len(data) {
+		return Item{}, fmt.Errorf("item 0x%02X requires %d data bytes", tag, []int{0, 1, 2, 4}[item.Tag.PayloadSize()])
+	}
+	return item, nil
+}
+
+func parseDataFlags(value string) (uint32, error) {
+	var flags uint32
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		if v, err := strconv.ParseUint(name, 0, 32); err == nil {
+			flags |= uint32(v)
+			continue
+		}
+		found := false
+		for bit, pair := range dataFlagNames {
+			for set, flagName := range pair {
+				if normalizeTextName(flagName) == normalizeTextName(name) {
+					flags |= uint32(set) << bit
+					found = true
+				}
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown data flag: %s", name)
+		}
+	}
+	return flags, nil
+}
+
+// parseUsage parses a usage, usages without a page are looked up in the current usage page first.
+func parseUsage(currentPage uint16, value string) (uint16, uint16, error) {
+	pageStr, idStr, ok := strings.Cut(value, ".")
+	if !ok {
+		if page, ok := usagepages.GetPageInfoByCode(currentPage); ok && currentPage != usagepages.KeyboardKeypad {
+			if usage, ok := page.Usages.ByAlias(value); ok {
+				return currentPage, usage.ID, nil
+			}
+		}
+		_, usage, err := hidusage.Parse(value)
+		return usagepages.KeyboardKeypad, usage.ID, err
+	}
+	page, err := hidusage.ParsePage(pageStr)
+	if err != nil {
+		return 0, 0, err
+	}
+	if strings.HasPrefix(idStr, "0x") {
+		id, err := strconv.ParseUint(idStr[2:], 16, 16)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid usage: %s", value)
+		}
+		return page.Code, uint16(id), nil
+	}
+	if page.Usages == nil {
+		return 0, 0, fmt.Errorf("unknown usage: %s", value)
+	}
+	_, usage, err := hidusage.Parse(value)
+	return page.Code, usage.ID, err
+}
+
+func parseUnit(value string) (uint32, error) {
+	if v, err := strconv.ParseUint(value, 0, 32); err == nil {
+		return uint32(v), nil
+	}
+	systemStr, unitsStr, _ := strings.Cut(value, ":")
+	system := -1
+	for i, name := range unitSystemNames {
+		if normalizeTextName(name) == normalizeTextName(systemStr) {
+			system = i
+		}
+	}
+	if system < 0 {
+		return 0, fmt.Errorf("unknown unit system: %s", systemStr)
+	}
+	unit := uint32(system)
+	if strings.TrimSpace(unitsStr) == "" {
+		return unit, nil
+	}
+	for _, part := range strings.Split(unitsStr, ",") {
+		name, exponentStr, hasExponent := strings.Cut(strings.TrimSpace(part), "^")
+		exponent := int64(1)
+		if hasExponent {
+			var err error
+			exponent, err = strconv.ParseInt(exponentStr, 10, 8)
+			if err != nil || exponent < -8 || exponent > 7 {
+				return 0, fmt.Errorf("unit exponent must be between -8 and 7: %s", part)
+			}
+		}
+		found := false
+		for i, unitName := range unitNames {
+			if normalizeTextName(unitName) == normalizeTextName(name) {
+				unit |= (uint32(exponent) & 0xf) << (4 * (i + 1))
+				found = true
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("unknown unit: %s", name)
+		}
+	}
+	return unit, nil
+}
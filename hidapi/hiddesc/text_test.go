@@ -0,0 +1,132 @@
+package hiddesc
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTextRoundTrip(t *testing.T) {
+	files, err := filepath.Glob("../../testdata/*/*.desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	files = append(files, "../../testdata/wacom.desc")
+	for _, file := range files {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var text bytes.Buffer
+		if err := FormatText(&text, raw); err != nil {
+			t.Fatalf("%s: %v", file, err)
+		}
+		if IsText(raw) || !IsText(text.Bytes()) {
+			t.Errorf("%s: text and raw descriptors are not told apart", file)
+		}
+		compiled, err := ParseText(text.Bytes())
+		if err != nil {
+			t.Fatalf("%s: %v\n%s", file, err, text.String())
+		}
+		var recompiled bytes.Buffer
+		if err := FormatText(&recompiled, compiled); err != nil {
+			t.Fatal(err)
+		}
+		if text.String() != recompiled.String() {
+			t.Errorf("%s: text changed after compiling:\n%s\n%s", file, text.String(), recompiled.String())
+		}
+		expected, err := Decode(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := Decode(compiled)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(expected, actual) {
+			t.Errorf("%s: decoded descriptors differ", file)
+		}
+	}
+}
+
+func TestParseText(t *testing.T) {
+	text := `
+Usage Page (dsk)           ; Generic Desktop Page
+Usage (Mouse)
+Collection (Application)
+  Usage (dsk.Pointer)
+  Collection (Physical)
+    Report ID (2)
+    Usage Page (btn)
+    Usage Minimum (btn.1)
+    Usage Maximum (btn.5)
+    Logical Minimum (0)
+    Logical Maximum (1)
+    Report Count (5)
+    Report Size (1)
+    Input (Data, Variable, Absolute)
+    Report Count (1)
+    Report Size (3)
+    Input (Constant)
+    Usage Page (dsk)
+    Usage (dsk.X)
+    Usage (dsk.Y)
+    Logical Minimum (-32767)
+    Logical Maximum (32767)
+    Physical Maximum (0)
+    Unit (SI Linear: Length, Time^-1)
+    Unit Exponent (-2)
+    Report Count (2)
+    Report Size (16)
+    Input (Data, Variable, Relative)
+  End Collection
+End Collection
+`
+	desc, err := DecodeText([]byte(text))
+	if err != nil {
+		t.Fatal(err)
+	}
+	pointer := desc.Collections[0].Items[0].Collection
+	if pointer == nil || pointer.UsageID != 0x01 || len(pointer.Items) != 3 {
+		t.Fatalf("unexpected pointer collection: %+v", desc.Collections[0])
+	}
+	buttons := pointer.Items[0].DataItem
+	if buttons.UsagePage != 0x09 || buttons.UsageMinimum != 1 || buttons.UsageMaximum != 5 || buttons.ReportID != 2 {
+		t.Errorf("unexpected buttons: %+v", buttons)
+	}
+	axes := pointer.Items[2].DataItem
+	if !axes.Flags.IsRelative() || axes.LogicalMinimum != -32767 || axes.Unit != 0xf011 || axes.UnitExponent != -2 {
+		t.Errorf("unexpected axes: %+v", axes)
+	}
+	formatted, err := EncodeText(desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(formatted), "Unit (SI Linear: Length, Time^-1)") {
+		t.Errorf("unit is not formatted:\n%s", formatted)
+	}
+
+	extended, err := ParseText([]byte("Usage Page (dsk)\nUsage (con.AcPan)\nUsage (X)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(extended, []byte{0x05, 0x01, 0x0b, 0x38, 0x02, 0x0c, 0x00, 0x09, 0x30}) {
+		t.Errorf("unexpected extended usage: %x", extended)
+	}
+
+	for _, invalid := range []string{
+		"Usage Page (nope)",
+		"Input (Data, Sideways)",
+		"Report Count",
+		"Unit Exponent (9)",
+		"Frobnicate (1)",
+		"Item (0x06, 01)",
+	} {
+		if _, err := ParseText([]byte(invalid)); err == nil {
+			t.Errorf("expected an error for %q", invalid)
+		}
+	}
+}
@@ -0,0 +1,431 @@
+package hidapi
+
+import (
+	"encoding/binary"
+	"slices"
+
+	"github.com/neuroplastio/neio-agent/hidapi/hiddesc"
+)
+
+// ReportLayout is a DataItemSet compiled into flat tables. Every field has a precomputed bit offset
+// in the report, and usages are resolved to fields through arrays indexed by usage ID.
+type ReportLayout struct {
+	hasReportID bool
+	reports     []reportLayout
+	// reportIndex maps report IDs to positions in reports, -1 if the report is not defined.
+	reportIndex [256]int16
+	fields      []layoutField
+
+	sets   usageIndex
+	values usageIndex
+}
+
+type reportLayout struct {
+	id uint8
+	// size is the length of the report in bytes, including report ID.
+	size   int
+	fields []int
+}
+
+type fieldKind uint8
+
+const (
+	// fieldConstant is not mapped to any usage (padding, vendor arrays, etc.)
+	fieldConstant fieldKind = iota
+	// fieldFlags has one bit per usage from the usage list.
+	fieldFlags
+	// fieldRange has one bit per usage from the usage minimum.
+	fieldRange
+	// fieldSelector is an array of active usage IDs.
+	fieldSelector
+	// fieldValues has one value per usage from the usage list.
+	fieldValues
+)
+
+type layoutField struct {
+	kind   fieldKind
+	report int
+	// offset is the bit offset of the field in the report, including report ID.
+	offset   int
+	size     int
+	count    int
+	relative bool
+	signed   bool
+
+	page    uint16
+	minimum uint16
+	maximum uint16
+	usages  []Usage
+}
+
+// usageSlot points to the position of a usage in a field.
+type usageSlot struct {
+	field int32
+	index int32
+}
+
+func NewReportLayout(dataItems DataItemSet) *ReportLayout {
+	l := &ReportLayout{
+		hasReportID: dataItems.HasReportID(),
+	}
+	for i := range l.reportIndex {
+		l.reportIndex[i] = -1
+	}
+	idBits := 0
+	if l.hasReportID {
+		idBits = 8
+	}
+	var setRanges []usageRangeSlot
+	for _, rd := range dataItems.Reports() {
+		report := reportLayout{
+			id: rd.ID,
+		}
+		reportIdx := len(l.reports)
+		offset := idBits
+		for _, item := range rd.DataItems {
+			field := newLayoutField(item)
+			field.report = reportIdx
+			field.offset = offset
+			offset += field.size * field.count
+
+			fieldIdx := int32(len(l.fields))
+			report.fields = append(report.fields, len(l.fields))
+			l.fields = append(l.fields, field)
+
+			switch field.kind {
+			case fieldFlags:
+				for i, usage := range field.usages {
+					if i < field.count {
+						l.sets.add(usage, usageSlot{field: fieldIdx, index: int32(i)})
+					}
+				}
+			case fieldValues:
+				for i, usage := range field.usages {
+					if i < field.count {
+						l.values.add(usage, usageSlot{field: fieldIdx, index: int32(i)})
+					}
+				}
+			case fieldRange, fieldSelector:
+				setRanges = append(setRanges, usageRangeSlot{
+					page:    field.page,
+					minimum: field.minimum,
+					maximum: field.maximum,
+					field:   fieldIdx,
+					size:    field.size,
+				})
+			}
+		}
+		report.size = (offset + 7) / 8
+		l.reportIndex[rd.ID] = int16(reportIdx)
+		l.reports = append(l.reports, report)
+	}
+	// Bitfields are preferred over selectors for overlapping ranges
+	slices.SortStableFunc(setRanges, func(a, b usageRangeSlot) int {
+		if a.size != b.size {
+			return a.size - b.size
+		}
+		return int(a.minimum) - int(b.minimum)
+	})
+	l.sets.ranges = setRanges
+	l.sets.build()
+	l.values.build()
+	return l
+}
+
+func newLayoutField(item hiddesc.DataItem) layoutField {
+	field := layoutField{
+		kind:     fieldConstant,
+		size:     int(item.ReportSize),
+		count:    int(item.ReportCount),
+		relative: item.Flags.IsRelative(),
+		signed:   item.LogicalMinimum < 0,
+		page:     item.UsagePage,
+		minimum:  item.UsageMinimum,
+		maximum:  item.UsageMaximum,
+	}
+	for _, usage := range item.Usages() {
+		field.usages = append(field.usages, Usage(usage))
+	}
+	variable := item.Flags.IsVariable()
+	switch {
+	case item.Flags.IsConstant():
+	case item.UsageMaximum != 0 && !variable && field.size <= 16:
+		field.kind = fieldSelector
+	case item.UsageMaximum != 0 && variable && field.size == 1:
+		field.kind = fieldRange
+	case len(item.UsageIDs) > 0 && variable && field.size == 1:
+		field.kind = fieldFlags
+	case len(item.UsageIDs) > 0 && variable && field.size <= 32:
+		field.kind = fieldValues
+	}
+	return field
+}
+
+// Contains reports whether events of the usage can be encoded in the reports.
+func (l *ReportLayout) Contains(usage Usage) bool {
+	if _, ok := l.sets.lookup(usage); ok {
+		return true
+	}
+	_, ok := l.values.lookup(usage)
+	return ok
+}
+
+// Usages returns all usages that can be encoded in the reports, in order of the fields.
+func (l *ReportLayout) Usages() []Usage {
+	var usages []Usage
+	seen := make(map[Usage]struct{})
+	add := func(usage Usage) {
+		if _, ok := seen[usage]; ok || usage.ID() == 0 {
+			return
+		}
+		seen[usage] = struct{}{}
+		usages = append(usages, usage)
+	}
+	for _, field := range l.fields {
+		switch field.kind {
+		case fieldFlags, fieldValues:
+			for i, usage := range field.usages {
+				if i < field.count {
+					add(usage)
+				}
+			}
+		case fieldRange, fieldSelector:
+			for id := int(field.minimum); id <= int(field.maximum); id++ {
+				add(NewUsage(field.page, uint16(id)))
+			}
+		}
+	}
+	return usages
+}
+
+func (l *ReportLayout) report(reportID uint8) (*reportLayout, bool) {
+	idx := l.reportIndex[reportID]
+	if idx < 0 {
+		return nil, false
+	}
+	return &l.reports[idx], true
+}
+
+// usageIndex resolves usages to slots. Explicit usages are stored in dense per-page arrays,
+// and usage ranges are checked in order after them.
+type usageIndex struct {
+	entries []usageEntry
+	pages   []usagePageIndex
+	ranges  []usageRangeSlot
+}
+
+type usageEntry struct {
+	usage Usage
+	slot  usageSlot
+}
+
+type usagePageIndex struct {
+	page  uint16
+	first uint16
+	// slots are offset by one, so that zero value means that usage is not mapped.
+	slots []usageSlot
+}
+
+type usageRangeSlot struct {
+	page    uint16
+	minimum uint16
+	maximum uint16
+	field   int32
+	// index is the position of the usage minimum in the field.
+	index int32
+	size  int
+}
+
+// maxDensePageSpan limits the size of dense arrays for sparse usage pages.
+const maxDensePageSpan = 4096
+
+func (u *usageIndex) add(usage Usage, slot usageSlot) {
+	u.entries = append(u.entries, usageEntry{usage: usage, slot: slot})
+}
+
+func (u *usageIndex) build() {
+	type span struct{ first, last uint16 }
+	spans := make(map[uint16]span)
+	var pages []uint16
+	for _, entry := range u.entries {
+		page, id := entry.usage.Page(), entry.usage.ID()
+		s, ok := spans[page]
+		if !ok {
+			pages = append(pages, page)
+			s = span{first: id, last: id}
+		}
+		s.first = min(s.first, id)
+		s.last = max(s.last, id)
+		spans[page] = s
+	}
+	for _, page := range pages {
+		s := spans[page]
+		if int(s.last-s.first) >= maxDensePageSpan {
+			continue
+		}
+		u.pages = append(u.pages, usagePageIndex{
+			page:  page,
+			first: s.first,
+			slots: make([]usageSlot, int(s.last-s.first)+1),
+		})
+	}
+	var sparse []usageRangeSlot
+	for _, entry := range u.entries {
+		idx := u.pageIndex(entry.usage.Page())
+		if idx < 0 {
+			// sparse page, fall back to a single usage range
+			sparse = append(sparse, usageRangeSlot{
+				page:    entry.usage.Page(),
+				minimum: entry.usage.ID(),
+				maximum: entry.usage.ID(),
+				field:   entry.slot.field,
+				index:   entry.slot.index,
+			})
+			continue
+		}
+		page := &u.pages[idx]
+		slot := &page.slots[entry.usage.ID()-page.first]
+		if slot.field != 0 {
+			// the first data item wins for overlapping usages
+			continue
+		}
+		*slot = usageSlot{field: entry.slot.field + 1, index: entry.slot.index}
+	}
+	u.ranges = append(sparse, u.ranges...)
+	u.entries = nil
+}
+
+func (u *usageIndex) pageIndex(page uint16) int {
+	for i := range u.pages {
+		if u.pages[i].page == page {
+			return i
+		}
+	}
+	return -1
+}
+
+func (u *usageIndex) lookup(usage Usage) (usageSlot, bool) {
+	page, id := usage.Page(), usage.ID()
+	if idx := u.pageIndex(page); idx >= 0 {
+		p := &u.pages[idx]
+		if id >= p.first && int(id-p.first) < len(p.slots) {
+			slot := p.slots[id-p.first]
+			if slot.field != 0 {
+				slot.field--
+				return slot, true
+			}
+		}
+	}
+	for _, r := range u.ranges {
+		if r.page == page && id >= r.minimum && id <= r.maximum {
+			return usageSlot{field: r.field, index: r.index + int32(id-r.minimum)}, true
+		}
+	}
+	return usageSlot{}, false
+}
+
+// getBits reads size bits (up to 32) starting at the bit offset. HID reports are little-endian,
+// with the least significant bit first.
+func getBits(buf []byte, offset, size int) uint32 {
+	if size == 1 {
+		return uint32(buf[offset/8]>>(offset%8)) & 1
+	}
+	if offset%8 == 0 {
+		b := buf[offset/8:]
+		switch size {
+		case 8:
+			return uint32(b[0])
+		case 16:
+			return uint32(binary.LittleEndian.Uint16(b))
+		case 32:
+			return binary.LittleEndian.Uint32(b)
+		}
+	}
+	var value uint32
+	for i := 0; i < size; {
+		bit := offset + i
+		shift := bit % 8
+		n := min(8-shift, size-i)
+		value |= (uint32(buf[bit/8]) >> shift) & (1<<n - 1) << i
+		i += n
+	}
+	return value
+}
+
+func setBits(buf []byte, offset, size int, value uint32) {
+	if offset%8 == 0 {
+		b := buf[offset/8:]
+		switch size {
+		case 8:
+			b[0] = uint8(value)
+			return
+		case 16:
+			binary.LittleEndian.PutUint16(b, uint16(value))
+			return
+		case 32:
+			binary.LittleEndian.PutUint32(b, value)
+			return
+		}
+	}
+	for i := 0; i < size; {
+		bit := offset + i
+		shift := bit % 8
+		n := min(8-shift, size-i)
+		mask := uint8(1<<n-1) << shift
+		buf[bit/8] = buf[bit/8]&^mask | uint8(value>>i)<<shift&mask
+		i += n
+	}
+}
+
+// bitsEqual reports whether size bits starting at the bit offset are equal in both buffers.
+func bitsEqual(a, b []byte, offset, size int) bool {
+	for i := 0; i < size; {
+		bit := offset + i
+		if bit%8 == 0 && size-i >= 8 {
+			n := (size - i) / 8
+			if string(a[bit/8:bit/8+n]) != string(b[bit/8:bit/8+n]) {
+				return false
+			}
+			i += n * 8
+			continue
+		}
+		n := min(8-bit%8, size-i)
+		if getBits(a, bit, n) != getBits(b, bit, n) {
+			return false
+		}
+		i += n
+	}
+	return true
+}
+
+func clearBits(buf []byte, offset, size int) {
+	for i := 0; i < size; {
+		bit := offset + i
+		if bit%8 == 0 && size-i >= 8 {
+			n := (size - i) / 8
+			clear(buf[bit/8 : bit/8+n])
+			i += n * 8
+			continue
+		}
+		n := min(8-bit%8, size-i)
+		setBits(buf, bit, n, 0)
+		i += n
+	}
+}
+
+func (f *layoutField) value(buf []byte, index int) int32 {
+	raw := getBits(buf, f.offset+index*f.size, f.size)
+	if f.signed && f.size < 32 {
+		shift := 32 - f.size
+		return int32(raw<<shift) >> shift
+	}
+	return int32(raw)
+}
+
+func (f *layoutField) setValue(buf []byte, index int, value int32) {
+	setBits(buf, f.offset+index*f.size, f.size, uint32(value))
+}
+
+func (f *layoutField) bitSize() int {
+	return f.size * f.count
+}
@@ -0,0 +1,53 @@
+package hidapi
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/neuroplastio/neio-agent/hidapi/hiddesc"
+)
+
+func TestBitsUnaligned(t *testing.T) {
+	buf := make([]byte, 8)
+	cases := []struct {
+		offset, size int
+		value        uint32
+	}{
+		{0, 1, 1},
+		{1, 3, 5},
+		{4, 12, 0xabc},
+		{16, 16, 0xbeef},
+		{33, 7, 0x55},
+		{40, 24, 0x123456},
+	}
+	for _, c := range cases {
+		setBits(buf, c.offset, c.size, c.value)
+	}
+	for _, c := range cases {
+		if got := getBits(buf, c.offset, c.size); got != c.value {
+			t.Fatalf("offset %d size %d: expected %x, got %x", c.offset, c.size, c.value, got)
+		}
+	}
+	clearBits(buf, 4, 12)
+	if got := getBits(buf, 0, 16); got != 0xb {
+		t.Fatalf("expected cleared bits, got %x", got)
+	}
+	field := layoutField{offset: 33, size: 7, count: 1, signed: true}
+	field.setValue(buf, 0, -3)
+	if got := field.value(buf, 0); got != -3 {
+		t.Fatalf("expected -3, got %d", got)
+	}
+}
+
+func TestReportLayoutUsages(t *testing.T) {
+	layout := NewReportLayout(testDataItems(t, "../testdata/logitech-x-pro-superlight/1.desc", hiddesc.MainItemTypeInput))
+	usages := layout.Usages()
+	for _, usage := range []Usage{NewUsage(0x09, 0x01), NewUsage(0x01, 0x30)} {
+		if !layout.Contains(usage) || !slices.Contains(usages, usage) {
+			t.Errorf("expected usage %s in layout", usage)
+		}
+	}
+	if key := NewUsage(0x07, 0x04); layout.Contains(key) || slices.Contains(usages, key) {
+		t.Errorf("unexpected usage %s in layout", key)
+	}
+}
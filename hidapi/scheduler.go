@@ -0,0 +1,273 @@
+package hidapi
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/neuroplastio/neio-agent/pkg/clock"
+	"go.uber.org/zap"
+)
+
+var defaultSchedulerOptions = schedulerOptions{
+	minInterval: time.Millisecond,
+	queueSize:   1024,
+	clock:       clock.Real(),
+}
+
+type schedulerOptions struct {
+	minInterval   time.Duration
+	usageInterval time.Duration
+	queueSize     int
+	clock         clock.Clock
+	onWait        func(wait time.Duration)
+}
+
+type SchedulerOption func(*schedulerOptions)
+
+// WithMinInterval sets the minimum interval between reports that activate or deactivate usages.
+func WithMinInterval(d time.Duration) SchedulerOption {
+	return func(o *schedulerOptions) {
+		o.minInterval = d
+	}
+}
+
+// WithUsageInterval sets the minimum interval between state changes of the same usage.
+// Zero disables per-usage pacing.
+func WithUsageInterval(d time.Duration) SchedulerOption {
+	return func(o *schedulerOptions) {
+		o.usageInterval = d
+	}
+}
+
+// WithSchedulerQueueSize sets the number of pending report batches. Batches are dropped when the queue is full,
+// unless they deactivate usages.
+func WithSchedulerQueueSize(size int) SchedulerOption {
+	return func(o *schedulerOptions) {
+		o.queueSize = size
+	}
+}
+
+// WithSchedulerClock sets the clock used for pacing.
+func WithSchedulerClock(c clock.Clock) SchedulerOption {
+	return func(o *schedulerOptions) {
+		o.clock = c
+	}
+}
+
+// WithWaitObserver sets a function called with the delay of every report batch held back by pacing.
+func WithWaitObserver(fn func(wait time.Duration)) SchedulerOption {
+	return func(o *schedulerOptions) {
+		o.onWait = fn
+	}
+}
+
+// OutputScheduler queues encoded reports of an output device and writes them in order, pacing reports
+// that activate or deactivate usages. Hosts may miss key presses that change faster than they poll,
+// so macros need pacing, but producers must never be blocked by it.
+type OutputScheduler struct {
+	log  *zap.Logger
+	opts schedulerOptions
+
+	mu sync.Mutex
+	// queue is a ring of batches, reused to avoid allocations.
+	queue  []scheduledBatch
+	head   int
+	count  int
+	signal chan struct{}
+
+	// lastActivation and lastUsage are only accessed by Run.
+	lastActivation time.Time
+	lastUsage      map[Usage]time.Time
+}
+
+type scheduledBatch struct {
+	reports [][]byte
+	// usages are activated or deactivated by the batch.
+	usages []Usage
+}
+
+func NewOutputScheduler(log *zap.Logger, opts ...SchedulerOption) *OutputScheduler {
+	options := defaultSchedulerOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return &OutputScheduler{
+		log:       log,
+		opts:      options,
+		queue:     make([]scheduledBatch, max(options.queueSize, 1)),
+		signal:    make(chan struct{}, 1),
+		lastUsage: make(map[Usage]time.Time),
+	}
+}
+
+// Schedule copies reports produced by ReportState.ApplyEvent for the event and queues them for writing.
+// It never blocks and returns false if the queue is full and the reports were dropped. Reports of events
+// that deactivate usages are never dropped, the queue grows instead, so that keys are not left pressed
+// on the host.
+func (s *OutputScheduler) Schedule(reports [][]byte, event *Event) bool {
+	if len(reports) == 0 {
+		return true
+	}
+	s.mu.Lock()
+	if s.count == len(s.queue) {
+		if !hasDeactivation(event) {
+			s.mu.Unlock()
+			s.log.Warn("Output queue is full, dropping reports", zap.String("event", event.String()))
+			return false
+		}
+		s.grow()
+	}
+	batch := &s.queue[(s.head+s.count)%len(s.queue)]
+	batch.reports = batch.reports[:0]
+	for _, report := range reports {
+		n := len(batch.reports)
+		if n < cap(batch.reports) {
+			batch.reports = batch.reports[:n+1]
+			batch.reports[n] = append(batch.reports[n][:0], report...)
+		} else {
+			batch.reports = append(batch.reports, append([]byte(nil), report...))
+		}
+	}
+	batch.usages = batch.usages[:0]
+	for _, usage := range event.Usages() {
+		if usage.IsActivation() {
+			batch.usages = append(batch.usages, usage.Usage)
+		}
+	}
+	s.count++
+	s.mu.Unlock()
+
+	select {
+	case s.signal <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+func hasDeactivation(event *Event) bool {
+	for _, usage := range event.Usages() {
+		if usage.Type == UsageEventDeactivate {
+			return true
+		}
+	}
+	return false
+}
+
+// grow doubles the queue, keeping the order of queued batches.
+func (s *OutputScheduler) grow() {
+	queue := make([]scheduledBatch, len(s.queue)*2)
+	for i := 0; i < s.count; i++ {
+		queue[i] = s.queue[(s.head+i)%len(s.queue)]
+	}
+	s.queue = queue
+	s.head = 0
+}
+
+// Reset drops queued reports and the pacing state, for a device that was disconnected. It must not be
+// called while Run is running.
+func (s *OutputScheduler) Reset() {
+	s.mu.Lock()
+	for i := range s.queue {
+		s.queue[i].reports = s.queue[i].reports[:0]
+		s.queue[i].usages = s.queue[i].usages[:0]
+	}
+	s.head = 0
+	s.count = 0
+	s.mu.Unlock()
+	select {
+	case <-s.signal:
+	default:
+	}
+	s.lastActivation = time.Time{}
+	clear(s.lastUsage)
+}
+
+// Len returns the number of queued report batches.
+func (s *OutputScheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.count
+}
+
+// Run writes queued reports until the context is cancelled. Reports that are still queued
+// are kept for the next call, unless the scheduler is reset.
+func (s *OutputScheduler) Run(ctx context.Context, write func(report []byte) error) {
+	timer := s.opts.clock.NewTimer(time.Hour)
+	timer.Stop()
+	defer timer.Stop()
+	for {
+		s.mu.Lock()
+		if s.count == 0 {
+			s.mu.Unlock()
+			select {
+			case <-s.signal:
+				continue
+			case <-ctx.Done():
+				return
+			}
+		}
+		// The head batch is not reused by Schedule until count is decremented.
+		batch := &s.queue[s.head]
+		s.mu.Unlock()
+
+		if wait := s.readyAt(batch).Sub(s.opts.clock.Now()); wait > 0 {
+			if s.opts.onWait != nil {
+				s.opts.onWait(wait)
+			}
+			timer.Reset(wait)
+			select {
+			case <-timer.C():
+			case <-ctx.Done():
+				return
+			}
+		}
+		for _, report := range batch.reports {
+			if err := write(report); err != nil {
+				s.log.Error("Failed to write output report", zap.Error(err))
+			}
+		}
+		if len(batch.usages) > 0 {
+			now := s.opts.clock.Now()
+			s.lastActivation = now
+			if s.opts.usageInterval > 0 {
+				s.pruneUsages(now)
+				for _, usage := range batch.usages {
+					s.lastUsage[usage] = now
+				}
+			}
+		}
+
+		s.mu.Lock()
+		s.head = (s.head + 1) % len(s.queue)
+		s.count--
+		s.mu.Unlock()
+	}
+}
+
+// pruneUsages forgets usages that changed longer than the usage interval ago, they no longer delay reports.
+func (s *OutputScheduler) pruneUsages(now time.Time) {
+	for usage, last := range s.lastUsage {
+		if now.Sub(last) >= s.opts.usageInterval {
+			delete(s.lastUsage, usage)
+		}
+	}
+}
+
+// readyAt returns the earliest time the batch can be written.
+func (s *OutputScheduler) readyAt(batch *scheduledBatch) time.Time {
+	if len(batch.usages) == 0 {
+		return time.Time{}
+	}
+	at := s.lastActivation.Add(s.opts.minInterval)
+	if s.opts.usageInterval > 0 {
+		for _, usage := range batch.usages {
+			if last, ok := s.lastUsage[usage]; ok {
+				if usageAt := last.Add(s.opts.usageInterval); usageAt.After(at) {
+					at = usageAt
+				}
+			}
+		}
+	}
+	return at
+}
@@ -0,0 +1,131 @@
+package hidapi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestOutputSchedulerPacing(t *testing.T) {
+	const (
+		minInterval   = 2 * time.Millisecond
+		usageInterval = 10 * time.Millisecond
+	)
+	s := NewOutputScheduler(zap.NewNop(), WithMinInterval(minInterval), WithUsageInterval(usageInterval))
+	a, b := NewUsage(0x07, 0x04), NewUsage(0x07, 0x05)
+
+	type write struct {
+		at     time.Time
+		report byte
+	}
+	writes := make(chan write, 16)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go s.Run(ctx, func(report []byte) error {
+		writes <- write{at: time.Now(), report: report[0]}
+		return nil
+	})
+
+	schedule := func(report byte, build func(e *Event)) {
+		event := NewEvent()
+		defer event.Release()
+		build(event)
+		buf := []byte{report}
+		start := time.Now()
+		if !s.Schedule([][]byte{buf}, event) {
+			t.Fatalf("report %d was dropped", report)
+		}
+		if time.Since(start) > time.Millisecond {
+			t.Fatalf("Schedule blocked for %s", time.Since(start))
+		}
+		// reports must be copied
+		buf[0] = 0xff
+	}
+	schedule(1, func(e *Event) { e.Activate(a) })
+	schedule(2, func(e *Event) { e.Activate(b) })
+	schedule(3, func(e *Event) { e.SetDelta(NewUsage(0x01, 0x30), 1) })
+	schedule(4, func(e *Event) { e.Deactivate(a) })
+
+	var got []write
+	for len(got) < 4 {
+		select {
+		case w := <-writes:
+			got = append(got, w)
+		case <-time.After(time.Second):
+			t.Fatalf("timed out, got %d reports", len(got))
+		}
+	}
+	for i, w := range got {
+		if w.report != byte(i+1) {
+			t.Fatalf("report %d: expected %d, got %d", i, i+1, w.report)
+		}
+	}
+	if d := got[1].at.Sub(got[0].at); d < minInterval {
+		t.Errorf("activations of different usages were %s apart, expected at least %s", d, minInterval)
+	}
+	if d := got[3].at.Sub(got[0].at); d < usageInterval {
+		t.Errorf("activations of the same usage were %s apart, expected at least %s", d, usageInterval)
+	}
+}
+
+func TestOutputSchedulerFullQueue(t *testing.T) {
+	s := NewOutputScheduler(zap.NewNop(), WithSchedulerQueueSize(2), WithUsageInterval(time.Millisecond))
+	a, b := NewUsage(0x07, 0x04), NewUsage(0x07, 0x05)
+	schedule := func(report byte, build func(e *Event)) bool {
+		event := NewEvent()
+		defer event.Release()
+		build(event)
+		return s.Schedule([][]byte{{report}}, event)
+	}
+	if !schedule(1, func(e *Event) { e.Activate(a) }) || !schedule(2, func(e *Event) { e.Activate(b) }) {
+		t.Fatal("reports were dropped")
+	}
+	if schedule(3, func(e *Event) { e.SetDelta(NewUsage(0x01, 0x30), 1) }) {
+		t.Fatal("reports should be dropped when the queue is full")
+	}
+	// releases are never dropped
+	if !schedule(4, func(e *Event) { e.Deactivate(a) }) || !schedule(5, func(e *Event) { e.Deactivate(b) }) {
+		t.Fatal("releases were dropped")
+	}
+	if s.Len() != 4 {
+		t.Fatalf("expected 4 queued batches, got %d", s.Len())
+	}
+
+	var written []byte
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(ctx, func(report []byte) error {
+			written = append(written, report[0])
+			if len(written) == 4 {
+				cancel()
+			}
+			return nil
+		})
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("timed out")
+	}
+	if string(written) != string([]byte{1, 2, 4, 5}) {
+		t.Fatalf("unexpected reports: %v", written)
+	}
+	if len(s.lastUsage) == 0 {
+		t.Fatal("expected paced usages")
+	}
+	s.pruneUsages(time.Now().Add(time.Millisecond))
+	if len(s.lastUsage) != 0 {
+		t.Fatalf("expected pruned usages, got %d", len(s.lastUsage))
+	}
+
+	// stale reports of a disconnected device are dropped
+	schedule(6, func(e *Event) { e.Activate(a) })
+	s.Reset()
+	if s.Len() != 0 || len(s.lastUsage) != 0 {
+		t.Fatalf("expected an empty scheduler, got %d batches and %d usages", s.Len(), len(s.lastUsage))
+	}
+}
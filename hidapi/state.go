@@ -4,348 +4,325 @@ import (
 	"fmt"
 	"slices"
 	"sync"
-	"time"
 
-	"github.com/neuroplastio/neio-agent/hidapi/hiddesc"
 	"github.com/neuroplastio/neio-agent/pkg/bits"
+	"github.com/neuroplastio/neio-agent/pkg/clock"
 	"go.uber.org/zap"
 )
 
 type ReportState struct {
-	log       *zap.Logger
-	decoder   *ReportDecoder
-	dataItems DataItemSet
+	log    *zap.Logger
+	layout *ReportLayout
+	clock  clock.Clock
 
-	usageSets   map[uint8]map[int]UsageSet
-	usageValues map[uint8]map[int]UsageValues
+	mu sync.Mutex
+	// reports hold the current state of every report, indexed as layout reports.
+	reports [][]byte
+	// out and encoded are reused for reports returned by ApplyEvent.
+	out     [][]byte
+	dirty   []bool
+	encoded [][]byte
 
-	usageSetRanges   map[uint16][]usageRange
-	usageSetMap      map[uint16]map[uint16]itemAddress
-	usageValuesIndex map[Usage]itemAddress
-
-	mu                    sync.RWMutex
-	reports               map[uint8]Report
-	usageActivations      map[uint8]map[Usage]int
-	lastActivation        time.Time
-	activationMinInterval time.Duration
+	usageActivations map[Usage]int
 }
 
-type usageRange struct {
-	start uint16
-	end   uint16
-
-	addr     itemAddress
-	dataItem hiddesc.DataItem
-}
+type ReportStateOption func(*ReportState)
 
-func (u usageRange) Contains(usageID uint16) bool {
-	return usageID >= u.start && usageID <= u.end
-}
-
-type itemAddress struct {
-	reportID uint8
-	itemIdx  int
+// WithReportClock sets the clock used to timestamp events returned by ApplyReport.
+func WithReportClock(c clock.Clock) ReportStateOption {
+	return func(r *ReportState) {
+		r.clock = c
+	}
 }
 
-func NewReportState(log *zap.Logger, dataItems DataItemSet) *ReportState {
+func NewReportState(log *zap.Logger, dataItems DataItemSet, opts ...ReportStateOption) *ReportState {
+	layout := NewReportLayout(dataItems)
 	rte := &ReportState{
-		log:       log,
-		decoder:   NewReportDecoder(dataItems),
-		dataItems: dataItems,
+		log:    log,
+		layout: layout,
+		clock:  clock.Real(),
 
-		usageSets:        make(map[uint8]map[int]UsageSet),
-		usageValues:      make(map[uint8]map[int]UsageValues),
-		usageSetRanges:   make(map[uint16][]usageRange),
-		usageSetMap:      make(map[uint16]map[uint16]itemAddress),
-		usageValuesIndex: make(map[Usage]itemAddress),
+		reports: make([][]byte, len(layout.reports)),
+		out:     make([][]byte, len(layout.reports)),
+		dirty:   make([]bool, len(layout.reports)),
+		encoded: make([][]byte, 0, len(layout.reports)),
 
-		reports:               make(map[uint8]Report),
-		usageActivations:      make(map[uint8]map[Usage]int),
-		activationMinInterval: 500 * time.Microsecond,
+		usageActivations: make(map[Usage]int),
 	}
-	rte.initializeStates()
-	return rte
-}
-
-func (r *ReportState) initializeStates() {
-	for _, rd := range r.dataItems.Reports() {
-		report := Report{
-			ID:     rd.ID,
-			Fields: make([]bits.Bits, len(rd.DataItems)),
+	for i, report := range layout.reports {
+		rte.reports[i] = make([]byte, report.size)
+		rte.out[i] = make([]byte, report.size)
+		if layout.hasReportID {
+			rte.reports[i][0] = report.id
 		}
-		for i, item := range rd.DataItems {
-			report.Fields[i] = bits.NewZeros(int(item.ReportCount * item.ReportSize))
-		}
-		r.mu.Lock()
-		r.reports[rd.ID] = report
-		r.mu.Unlock()
-		r.usageSets[rd.ID] = NewUsageSets(rd.DataItems)
-		r.usageValues[rd.ID] = NewUsageValuesItems(rd.DataItems)
-
-		for idx, usageSet := range r.usageSets[rd.ID] {
-			if unordered, ok := usageSet.(UnorderedUsageSet); ok {
-				var usageIDs []string
-				for _, usageID := range unordered.UsageIDs() {
-					usageIDs = append(usageIDs, NewUsage(unordered.UsagePage(), usageID).String())
-				}
-				if _, ok := r.usageSetMap[usageSet.UsagePage()]; !ok {
-					r.usageSetMap[usageSet.UsagePage()] = make(map[uint16]itemAddress)
-				}
-				for _, usageID := range unordered.UsageIDs() {
-					r.usageSetMap[usageSet.UsagePage()][usageID] = itemAddress{reportID: rd.ID, itemIdx: idx}
-				}
-				continue
-			}
-			if ordered, ok := usageSet.(OrderedUsageSet); ok {
-				rang := usageRange{
-					start:    ordered.UsageMinimum(),
-					end:      ordered.UsageMaximum(),
-					addr:     itemAddress{reportID: rd.ID, itemIdx: idx},
-					dataItem: rd.DataItems[idx],
-				}
-				r.usageSetRanges[usageSet.UsagePage()] = append(r.usageSetRanges[usageSet.UsagePage()], rang)
-				continue
-			}
-			r.log.Error("Unknown Usage Set type")
-		}
-		for idx, usageValue := range r.usageValues[rd.ID] {
-			// TODO: handle overlapping usages
-			for _, usage := range usageValue.Usages() {
-				r.usageValuesIndex[usage] = itemAddress{
-					reportID: rd.ID,
-					itemIdx:  idx,
-				}
-			}
-		}
-		r.usageActivations[rd.ID] = make(map[Usage]int)
-
 	}
-	for usagePage, items := range r.usageSetRanges {
-		slices.SortFunc(items, func(a, b usageRange) int {
-			if a.dataItem.ReportSize < b.dataItem.ReportSize {
-				return -1
-			}
-			if a.dataItem.ReportSize > b.dataItem.ReportSize {
-				return 1
-			}
-			if a.start < b.start {
-				return -1
-			}
-			if a.start > b.start {
-				return 1
-			}
-			return 0
-		})
-		r.usageSetRanges[usagePage] = items
+	for _, opt := range opts {
+		opt(rte)
 	}
+	return rte
 }
 
 func (r *ReportState) InitReports(reportGetter func(reportID uint8) ([]byte, error)) ([]*Event, error) {
 	var events []*Event
-	for _, rd := range r.dataItems.Reports() {
-		if len(rd.DataItems) == 0 {
+	for _, report := range r.layout.reports {
+		if len(report.fields) == 0 {
 			continue
 		}
-		reportData, err := reportGetter(rd.ID)
+		reportData, err := reportGetter(report.id)
 		if err != nil {
-			r.log.Warn("failed to get report", zap.Uint8("reportId", rd.ID), zap.Error(err))
+			r.log.Warn("failed to get report", zap.Uint8("reportId", report.id), zap.Error(err))
 			continue
 		}
 		event := r.ApplyReport(reportData)
 		if !event.IsEmpty() {
-			r.log.Debug("Applying report", zap.Any("report", bits.New(reportData, 0).String()), zap.Any("reportId", rd.ID))
+			r.log.Debug("Applying report", zap.Any("report", bits.New(reportData, 0).String()), zap.Any("reportId", report.id))
 			events = append(events, event)
+		} else {
+			event.Release()
 		}
 	}
 	return events, nil
 }
 
+// ApplyReport updates the state with the report data and returns an event with usage changes.
 func (r *ReportState) ApplyReport(reportData []byte) *Event {
-	report, ok := r.decoder.Decode(reportData)
-	if !ok {
-		r.log.Error("failed to decode report")
-		return nil
+	reportID := uint8(0)
+	if r.layout.hasReportID {
+		if len(reportData) == 0 {
+			r.log.Error("failed to decode report: empty report")
+			return nil
+		}
+		reportID = reportData[0]
 	}
-	dataItems := r.dataItems.Report(report.ID)
-	if len(report.Fields) != len(dataItems) {
-		r.log.Error("report field count mismatch")
-		return NewEvent()
+	report, ok := r.layout.report(reportID)
+	if !ok || len(reportData) < report.size {
+		r.log.Error("failed to decode report", zap.Uint8("reportId", reportID), zap.Int("size", len(reportData)))
+		return nil
 	}
-	r.mu.RLock()
-	lastReport := r.reports[report.ID]
-	r.mu.RUnlock()
+	data := reportData[:report.size]
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	last := r.reports[r.layout.reportIndex[reportID]]
 
-	event := NewEvent()
-	for i, item := range dataItems {
-		reportField := report.Fields[i]
-		lastReportField := lastReport.Fields[i]
-		usageSet, ok := r.usageSets[report.ID][i]
-		if ok {
-			if reportField.Equal(lastReportField) {
+	event := NewEventAt(r.clock.Now())
+	for _, fieldIdx := range report.fields {
+		field := &r.layout.fields[fieldIdx]
+		switch field.kind {
+		case fieldFlags, fieldRange, fieldSelector:
+			if bitsEqual(last, data, field.offset, field.bitSize()) {
 				continue
 			}
-			activated, deactivated := UsageSetDiff(usageSet, lastReport.Fields[i], report.Fields[i])
-			event.Activate(activated...)
-			event.Deactivate(deactivated...)
-			continue
-		}
-		values, ok := r.usageValues[report.ID][i]
-		if ok {
-			usages := values.Usages()
-			for _, usage := range usages {
-				if item.Flags.IsRelative() {
-					if reportField.Equal(lastReportField) {
-						continue
-					}
-					t0 := values.GetValue(lastReport.Fields[i], usage)
-					t1 := values.GetValue(report.Fields[i], usage)
+			diffUsageSet(event, field, last, data)
+		case fieldValues:
+			for i, usage := range field.usages {
+				if i >= field.count {
+					break
+				}
+				if field.relative {
+					t0 := field.value(last, i)
+					t1 := field.value(data, i)
 					if t0 == t1 {
 						continue
 					}
 					event.SetDelta(usage, t1-t0)
 				} else {
-					event.SetValue(usage, values.GetValue(report.Fields[i], usage))
+					event.SetValue(usage, field.value(data, i))
 				}
 			}
-			continue
 		}
 	}
 
-	r.mu.Lock()
-	r.reports[report.ID] = r.stripRelativeValues(report)
-	r.mu.Unlock()
-
+	copy(last, data)
+	r.stripRelativeValues(report, last)
 	return event
 }
 
-func (r *ReportState) getUsageSet(usage Usage) (usageRange, bool) {
-	for _, rang := range r.usageSetRanges[usage.Page()] {
-		if rang.Contains(usage.ID()) {
-			return rang, true
+// diffUsageSet adds activations and deactivations of the usage set field between two reports.
+func diffUsageSet(event *Event, field *layoutField, t0, t1 []byte) {
+	switch field.kind {
+	case fieldFlags, fieldRange:
+		for i := 0; i < field.count; i++ {
+			bit := field.offset + i
+			was, is := getBits(t0, bit, 1) != 0, getBits(t1, bit, 1) != 0
+			if was == is {
+				continue
+			}
+			var usage Usage
+			if field.kind == fieldFlags {
+				if i >= len(field.usages) {
+					break
+				}
+				usage = field.usages[i]
+			} else {
+				usage = NewUsage(field.page, field.minimum+uint16(i))
+			}
+			if is {
+				event.Activate(usage)
+			} else {
+				event.Deactivate(usage)
+			}
+		}
+	case fieldSelector:
+		for i := 0; i < field.count; i++ {
+			id := getBits(t1, field.offset+i*field.size, field.size)
+			if id != 0 && !selectorHas(field, t0, id) {
+				event.Activate(NewUsage(field.page, uint16(id)))
+			}
+		}
+		for i := 0; i < field.count; i++ {
+			id := getBits(t0, field.offset+i*field.size, field.size)
+			if id != 0 && !selectorHas(field, t1, id) {
+				event.Deactivate(NewUsage(field.page, uint16(id)))
+			}
+		}
+	}
+}
+
+func selectorHas(field *layoutField, buf []byte, id uint32) bool {
+	for i := 0; i < field.count; i++ {
+		if getBits(buf, field.offset+i*field.size, field.size) == id {
+			return true
+		}
+	}
+	return false
+}
+
+func selectorSet(field *layoutField, buf []byte, id uint32) {
+	for i := 0; i < field.count; i++ {
+		offset := field.offset + i*field.size
+		switch getBits(buf, offset, field.size) {
+		case id:
+			return
+		case 0:
+			setBits(buf, offset, field.size, id)
+			return
+		}
+	}
+}
+
+// selectorClear removes the usage ID from the selector and shifts the remaining IDs left.
+func selectorClear(field *layoutField, buf []byte, id uint32) {
+	cleared := false
+	for i := 0; i < field.count; i++ {
+		offset := field.offset + i*field.size
+		val := getBits(buf, offset, field.size)
+		if val == 0 {
+			return
+		}
+		if cleared {
+			setBits(buf, offset-field.size, field.size, val)
+			setBits(buf, offset, field.size, 0)
+			continue
+		}
+		if val == id {
+			setBits(buf, offset, field.size, 0)
+			cleared = true
 		}
 	}
-	return usageRange{}, false
 }
 
 func (r *ReportState) GetReport(reportID uint8) ([]byte, error) {
-	r.mu.RLock()
-	report, ok := r.reports[reportID]
-	r.mu.RUnlock()
-	if !ok {
+	idx := r.layout.reportIndex[reportID]
+	if idx < 0 {
 		return nil, fmt.Errorf("report ID %d not found", reportID)
 	}
-	return EncodeReport(report).Bytes(), nil
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return slices.Clone(r.reports[idx]), nil
 }
 
+// ApplyEvent updates the state with usage changes and returns encoded reports that were affected.
+// Returned reports are reused by the next call. Activations are not paced, see OutputScheduler.
 func (r *ReportState) ApplyEvent(e *Event) [][]byte {
-	reports := make([]Report, 0, 1)
-	reportMap := make(map[uint8]int)
-	getReport := func(reportID uint8) Report {
-		_, ok := reportMap[reportID]
-		if !ok {
-			r.mu.RLock()
-			reports = append(reports, r.reports[reportID])
-			r.mu.RUnlock()
-			reportMap[reportID] = len(reports) - 1
-		}
-		return reports[reportMap[reportID]]
-	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
 	for _, usageEvent := range e.Usages() {
 		usage := usageEvent.Usage
 		var (
-			addr itemAddress
+			slot usageSlot
+			ok   bool
 		)
 		switch {
-		case usageEvent.Activate != nil:
-			if a, ok := r.usageSetMap[usage.Page()][usage.ID()]; ok {
-				addr = a
-				break
-			}
-			if rang, ok := r.getUsageSet(usage); ok {
-				addr = rang.addr
-				break
-			}
+		case usageEvent.IsActivation():
+			slot, ok = r.layout.sets.lookup(usage)
+		case usageEvent.Type == UsageEventDelta || usageEvent.Type == UsageEventValue:
+			slot, ok = r.layout.values.lookup(usage)
+		default:
+			r.log.Warn("Usage event has no action")
+			continue
+		}
+		if !ok {
 			r.log.Warn("Usage has no matching report",
 				zap.String("usage", usage.String()),
 			)
 			continue
-		case usageEvent.Delta != nil || usageEvent.Value != nil:
-			a, ok := r.usageValuesIndex[usage]
-			if !ok {
-				r.log.Warn("Usage has no matching report",
-					zap.String("usage", usage.String()),
-				)
-				continue
-			}
-			addr = a
-		default:
-			r.log.Warn("Usage event has no action")
-			continue
 		}
-		report := getReport(addr.reportID)
-		dataItem := r.dataItems.Report(addr.reportID)[addr.itemIdx]
-		switch {
-		case usageEvent.Activate != nil && *usageEvent.Activate:
-			if dataItem.Flags.IsRelative() {
-				r.usageSets[addr.reportID][addr.itemIdx].SetUsage(report.Fields[addr.itemIdx], usage)
-			} else {
-				r.usageActivations[addr.reportID][usage]++
-				count := r.usageActivations[addr.reportID][usage]
-				if count == 1 {
-					r.usageSets[addr.reportID][addr.itemIdx].SetUsage(report.Fields[addr.itemIdx], usage)
-					// TODO: configurable minInterval with 1ms by default
-					// TODO: non-blocking rate limiting
-					sinceLast := time.Since(r.lastActivation)
-					if sinceLast < r.activationMinInterval {
-						r.log.Warn("Activation rate limit", zap.Duration("sinceLast", sinceLast))
-						time.Sleep(r.activationMinInterval - sinceLast)
-					}
-					r.lastActivation = time.Now()
-				}
+		field := &r.layout.fields[slot.field]
+		report := r.reports[field.report]
+		r.dirty[field.report] = true
+		switch usageEvent.Type {
+		case UsageEventActivate:
+			if field.relative {
+				r.setUsage(field, report, slot, usage)
+				break
 			}
-		case usageEvent.Activate != nil && !*usageEvent.Activate:
-			if dataItem.Flags.IsRelative() {
-				r.usageSets[addr.reportID][addr.itemIdx].ClearUsage(report.Fields[addr.itemIdx], usage)
-			} else {
-				r.usageActivations[addr.reportID][usage]--
-				count := r.usageActivations[addr.reportID][usage]
-				if count <= 0 {
-					r.usageSets[addr.reportID][addr.itemIdx].ClearUsage(report.Fields[addr.itemIdx], usage)
-					delete(r.usageActivations[addr.reportID], usage)
-					// TODO: configurable minInterval with 1ms by default
-					// TODO: non-blocking rate limiting
-					sinceLast := time.Since(r.lastActivation)
-					if sinceLast < r.activationMinInterval {
-						r.log.Warn("Activation rate limit", zap.Duration("sinceLast", sinceLast))
-						time.Sleep(r.activationMinInterval - sinceLast)
-					}
-					r.lastActivation = time.Now()
-				}
+			r.usageActivations[usage]++
+			if r.usageActivations[usage] == 1 {
+				r.setUsage(field, report, slot, usage)
 			}
-		case usageEvent.Delta != nil:
-			current := r.usageValues[addr.reportID][addr.itemIdx].GetValue(report.Fields[addr.itemIdx], usage)
-			r.usageValues[addr.reportID][addr.itemIdx].SetValue(report.Fields[addr.itemIdx], usage, current+*usageEvent.Delta)
-		case usageEvent.Value != nil:
-			r.usageValues[addr.reportID][addr.itemIdx].SetValue(report.Fields[addr.itemIdx], usage, *usageEvent.Value)
+		case UsageEventDeactivate:
+			if field.relative {
+				r.clearUsage(field, report, slot, usage)
+				break
+			}
+			r.usageActivations[usage]--
+			if r.usageActivations[usage] <= 0 {
+				r.clearUsage(field, report, slot, usage)
+				delete(r.usageActivations, usage)
+			}
+		case UsageEventDelta:
+			current := field.value(report, int(slot.index))
+			field.setValue(report, int(slot.index), current+usageEvent.Value)
+		case UsageEventValue:
+			field.setValue(report, int(slot.index), usageEvent.Value)
 		}
 	}
 
-	encoded := make([][]byte, len(reports))
-	for i, report := range reports {
-		r.mu.Lock()
-		r.reports[report.ID] = r.stripRelativeValues(report.Clone())
-		r.mu.Unlock()
-		encoded[i] = EncodeReport(report).Bytes()
+	r.encoded = r.encoded[:0]
+	for i, dirty := range r.dirty {
+		if !dirty {
+			continue
+		}
+		r.dirty[i] = false
+		copy(r.out[i], r.reports[i])
+		r.stripRelativeValues(&r.layout.reports[i], r.reports[i])
+		r.encoded = append(r.encoded, r.out[i])
+	}
+	return r.encoded
+}
+
+func (r *ReportState) setUsage(field *layoutField, report []byte, slot usageSlot, usage Usage) {
+	if field.kind == fieldSelector {
+		selectorSet(field, report, uint32(usage.ID()))
+		return
 	}
+	setBits(report, field.offset+int(slot.index), 1, 1)
+}
 
-	return encoded
+func (r *ReportState) clearUsage(field *layoutField, report []byte, slot usageSlot, usage Usage) {
+	if field.kind == fieldSelector {
+		selectorClear(field, report, uint32(usage.ID()))
+		return
+	}
+	setBits(report, field.offset+int(slot.index), 1, 0)
 }
 
-func (r *ReportState) stripRelativeValues(report Report) Report {
-	for i, item := range r.dataItems.Report(report.ID) {
-		if item.Flags.IsRelative() {
-			report.Fields[i].ClearAll()
+func (r *ReportState) stripRelativeValues(report *reportLayout, data []byte) {
+	for _, fieldIdx := range report.fields {
+		field := &r.layout.fields[fieldIdx]
+		if field.relative {
+			clearBits(data, field.offset, field.bitSize())
 		}
 	}
-	return report
 }
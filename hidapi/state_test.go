@@ -0,0 +1,139 @@
+package hidapi
+
+import (
+	"bytes"
+	"os"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/neuroplastio/neio-agent/hidapi/hiddesc"
+	"github.com/neuroplastio/neio-agent/pkg/clock"
+)
+
+func testDataItems(tb testing.TB, path string, typ hiddesc.MainItemType) DataItemSet {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	desc, err := hiddesc.NewDescriptorDecoder(bytes.NewReader(data)).Decode()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return NewDataItemSet(desc).WithType(typ)
+}
+
+type reportStateStep struct {
+	report []byte
+	// event is the event returned by ApplyReport.
+	event string
+	// out is the report produced by applying the event to another report state.
+	out []byte
+}
+
+type reportStateCase struct {
+	name  string
+	path  string
+	steps []reportStateStep
+}
+
+var reportStateCases = []reportStateCase{
+	{
+		name: "keyboard",
+		path: "../testdata/zsa-moonlander/1.desc",
+		steps: []reportStateStep{
+			{
+				report: []byte{0x02, 0, 0x04, 0, 0, 0, 0, 0},
+				event:  "+LeftShift, +A",
+				out:    []byte{0x02, 0, 0x04, 0, 0, 0, 0, 0},
+			},
+			{
+				report: []byte{0x02, 0, 0x04, 0x05, 0, 0, 0, 0},
+				event:  "+B",
+				out:    []byte{0x02, 0, 0x04, 0x05, 0, 0, 0, 0},
+			},
+			{
+				report: []byte{0, 0, 0x05, 0, 0, 0, 0, 0},
+				event:  "-LeftShift, -A",
+				out:    []byte{0, 0, 0x05, 0, 0, 0, 0, 0},
+			},
+			{
+				report: []byte{0, 0, 0, 0, 0, 0, 0, 0},
+				event:  "-B",
+				out:    []byte{0, 0, 0, 0, 0, 0, 0, 0},
+			},
+		},
+	},
+	{
+		name: "mouse",
+		path: "../testdata/logitech-x-pro-superlight/1.desc",
+		steps: []reportStateStep{
+			{
+				report: []byte{0x01, 0, 0x05, 0, 0xfb, 0xff, 0x01, 0, 0, 0, 0, 0, 0},
+				event:  "+btn.1, dsk.X+=5, dsk.Y-=5, dsk.Wheel+=1",
+				out:    []byte{0x01, 0, 0x05, 0, 0xfb, 0xff, 0x01, 0, 0, 0, 0, 0, 0},
+			},
+			{
+				report: []byte{0x01, 0, 0x02, 0, 0x03, 0, 0, 0xff, 0, 0, 0, 0, 0},
+				event:  "dsk.X+=2, dsk.Y+=3, con.AcPan-=1",
+				out:    []byte{0x01, 0, 0x02, 0, 0x03, 0, 0, 0xff, 0, 0, 0, 0, 0},
+			},
+			{
+				report: []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+				event:  "-btn.1",
+				out:    []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0},
+			},
+		},
+	},
+}
+
+func TestReportState(t *testing.T) {
+	for _, c := range reportStateCases {
+		t.Run(c.name, func(t *testing.T) {
+			state := newTestReportState(t, c.path, hiddesc.MainItemTypeInput)
+			stateOut := newTestReportState(t, c.path, hiddesc.MainItemTypeInput)
+			for i, step := range c.steps {
+				event := state.ApplyReport(step.report)
+				if event.String() != step.event {
+					t.Fatalf("step %d: expected event %q, got %q", i, step.event, event)
+				}
+				reports := stateOut.ApplyEvent(event)
+				if !slices.EqualFunc(reports, [][]byte{step.out}, bytes.Equal) {
+					t.Fatalf("step %d: expected reports %x, got %x", i, [][]byte{step.out}, reports)
+				}
+				event.Release()
+			}
+		})
+	}
+}
+
+func TestReportStateClock(t *testing.T) {
+	c := reportStateCases[0]
+	clk := clock.NewVirtual(time.Unix(0, 0))
+	state := newTestReportState(t, c.path, hiddesc.MainItemTypeInput, WithReportClock(clk))
+	for i, step := range c.steps {
+		clk.Advance(10 * time.Millisecond)
+		event := state.ApplyReport(step.report)
+		if !event.Timestamp().Equal(clk.Now()) {
+			t.Fatalf("report %d: expected timestamp %v, got %v", i, clk.Now(), event.Timestamp())
+		}
+		event.Release()
+	}
+}
+
+// BenchmarkReportState measures the reports of every case travelling from an input report state to an output
+// report state.
+func BenchmarkReportState(b *testing.B) {
+	for _, c := range reportStateCases {
+		input := newTestReportState(b, c.path, hiddesc.MainItemTypeInput)
+		output := newTestReportState(b, c.path, hiddesc.MainItemTypeInput)
+		b.Run(c.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				event := input.ApplyReport(c.steps[i%len(c.steps)].report)
+				output.ApplyEvent(event)
+				event.Release()
+			}
+		})
+	}
+}
@@ -0,0 +1,379 @@
+package hidapi
+
+import (
+	"fmt"
+
+	"github.com/neuroplastio/neio-agent/hidapi/hiddesc"
+	"github.com/neuroplastio/neio-agent/hidapi/hidusage/usagepages"
+)
+
+type TemplateType string
+
+const (
+	// TemplateBootKeyboard is a keyboard compatible with the boot protocol: modifiers and an array of keys.
+	TemplateBootKeyboard TemplateType = "bootKeyboard"
+	// TemplateNKROKeyboard is a keyboard with a bit for every key.
+	TemplateNKROKeyboard TemplateType = "nkroKeyboard"
+	// TemplateMouse is a mouse with 16-bit relative axes, wheel and horizontal wheel.
+	TemplateMouse TemplateType = "mouse"
+	// TemplateConsumer is a consumer control with an array of consumer usages.
+	TemplateConsumer TemplateType = "consumer"
+	// TemplateSystem is a system control with an array of system control usages.
+	TemplateSystem TemplateType = "system"
+	// TemplateGamepad is a gamepad with buttons, 16-bit absolute axes and a hat switch.
+	TemplateGamepad TemplateType = "gamepad"
+	// TemplateAbsolutePointer is a pointer with buttons and absolute X and Y axes, e.g. for remote desktops.
+	TemplateAbsolutePointer TemplateType = "absolutePointer"
+)
+
+const (
+	templateMaxButtons = 32
+	templateMaxCount   = 32
+	// templateWheelMultiplier is the resolution multiplier of high-resolution wheels, as used by Windows and Linux.
+	templateWheelMultiplier = 120
+)
+
+// gamepadAxes are usages of gamepad axes in the order they are added.
+var gamepadAxes = []uint16{0x30, 0x31, 0x32, 0x35, 0x33, 0x34}
+
+// DescriptorTemplate is a parameterized application collection. Zero parameters use the defaults of the type.
+type DescriptorTemplate struct {
+	Type TemplateType
+	// ReportID is the report ID of the collection. Zero assigns a free one.
+	ReportID uint8
+
+	// Buttons is the number of buttons of mice, gamepads and pointers.
+	Buttons int
+	// Count is the number of keys of boot keyboards, and usages of consumer and system controls, active at once.
+	Count int
+	// Axes is the number of gamepad axes: X, Y, Z, Rz, Rx and Ry.
+	Axes int
+	// LEDs adds an output report with keyboard LEDs.
+	LEDs bool
+	// HiResWheel adds resolution multipliers to wheels of mice. Hosts that set the multiplier expect
+	// wheel values in 1/120 of a detent.
+	HiResWheel bool
+	// NoHat removes the hat switch of gamepads.
+	NoHat bool
+	// Maximum is the logical maximum of absolute pointer axes.
+	Maximum int32
+}
+
+// Collection returns the application collection of the template with the report ID.
+func (t DescriptorTemplate) Collection(reportID uint8) (hiddesc.Collection, error) {
+	switch t.Type {
+	case TemplateBootKeyboard:
+		count, err := t.count(6)
+		if err != nil {
+			return hiddesc.Collection{}, err
+		}
+		items := keyboardModifierItems(reportID)
+		items = append(items, keyboardLEDItems(t.LEDs, reportID)...)
+		items = append(items, inputItem(hiddesc.DataItem{
+			UsagePage:      usagepages.KeyboardKeypad,
+			UsageMinimum:   0x00,
+			UsageMaximum:   0xdd,
+			ReportCount:    uint32(count),
+			ReportSize:     8,
+			ReportID:       reportID,
+			LogicalMaximum: 0xdd,
+		}))
+		return applicationCollection(usagepages.GenericDesktop, 0x06, items...), nil
+	case TemplateNKROKeyboard:
+		items := keyboardModifierItems(reportID)
+		items = append(items, keyboardLEDItems(t.LEDs, reportID)...)
+		items = append(items, bitmapItems(usagepages.KeyboardKeypad, []uint16{0x04, 0xdd}, reportID)...)
+		return applicationCollection(usagepages.GenericDesktop, 0x06, items...), nil
+	case TemplateMouse:
+		buttons, err := t.buttons(5)
+		if err != nil {
+			return hiddesc.Collection{}, err
+		}
+		items := bitmapItems(usagepages.Button, buttonIDs(buttons), reportID)
+		items = append(items, relativeItem(usagepages.GenericDesktop, []uint16{0x30, 0x31}, reportID))
+		if t.HiResWheel {
+			items = append(items,
+				wheelCollection(usagepages.GenericDesktop, 0x38, reportID),
+				wheelCollection(usagepages.Consumer, 0x238, reportID),
+				dataItem(hiddesc.MainItemTypeFeature, hiddesc.DataItem{
+					Flags:       hiddesc.DataFlagConstant,
+					UsagePage:   usagepages.GenericDesktop,
+					ReportCount: 1,
+					ReportSize:  4,
+					ReportID:    reportID,
+				}),
+			)
+		} else {
+			items = append(items,
+				relativeItem(usagepages.GenericDesktop, []uint16{0x38}, reportID),
+				relativeItem(usagepages.Consumer, []uint16{0x238}, reportID),
+			)
+		}
+		return applicationCollection(usagepages.GenericDesktop, 0x02, pointerCollection(items...)), nil
+	case TemplateConsumer:
+		count, err := t.count(4)
+		if err != nil {
+			return hiddesc.Collection{}, err
+		}
+		item := arrayItem(usagepages.Consumer, []uint16{0x001, 0x3ff}, 16, reportID)
+		item.DataItem.ReportCount = uint32(count)
+		return applicationCollection(usagepages.Consumer, 0x01, item), nil
+	case TemplateSystem:
+		count, err := t.count(1)
+		if err != nil {
+			return hiddesc.Collection{}, err
+		}
+		item := arrayItem(usagepages.GenericDesktop, []uint16{0x81, 0xb7}, 8, reportID)
+		item.DataItem.ReportCount = uint32(count)
+		return applicationCollection(usagepages.GenericDesktop, 0x80, item), nil
+	case TemplateGamepad:
+		buttons, err := t.buttons(16)
+		if err != nil {
+			return hiddesc.Collection{}, err
+		}
+		axes := t.Axes
+		if axes == 0 {
+			axes = 4
+		}
+		if axes < 0 || axes > len(gamepadAxes) {
+			return hiddesc.Collection{}, fmt.Errorf("axes must be between 1 and %d", len(gamepadAxes))
+		}
+		items := bitmapItems(usagepages.Button, buttonIDs(buttons), reportID)
+		items = append(items, inputItem(hiddesc.DataItem{
+			Flags:          hiddesc.DataFlagVariable,
+			UsagePage:      usagepages.GenericDesktop,
+			UsageIDs:       gamepadAxes[:axes],
+			ReportCount:    uint32(axes),
+			ReportSize:     16,
+			ReportID:       reportID,
+			LogicalMinimum: -32767,
+			LogicalMaximum: 32767,
+		}))
+		if !t.NoHat {
+			items = append(items,
+				inputItem(hiddesc.DataItem{
+					Flags:           hiddesc.DataFlagVariable | hiddesc.DataFlagNullState,
+					UsagePage:       usagepages.GenericDesktop,
+					UsageIDs:        []uint16{0x39},
+					ReportCount:     1,
+					ReportSize:      4,
+					ReportID:        reportID,
+					LogicalMaximum:  7,
+					PhysicalMaximum: 315,
+					// English rotation, degrees
+					Unit: 0x14,
+				}),
+				inputItem(hiddesc.DataItem{
+					Flags:       hiddesc.DataFlagConstant,
+					UsagePage:   usagepages.GenericDesktop,
+					ReportCount: 1,
+					ReportSize:  4,
+					ReportID:    reportID,
+				}),
+			)
+		}
+		return applicationCollection(usagepages.GenericDesktop, 0x05, items...), nil
+	case TemplateAbsolutePointer:
+		buttons, err := t.buttons(3)
+		if err != nil {
+			return hiddesc.Collection{}, err
+		}
+		maximum := t.Maximum
+		if maximum == 0 {
+			maximum = 32767
+		}
+		if maximum < 0 {
+			return hiddesc.Collection{}, fmt.Errorf("maximum must be positive")
+		}
+		items := bitmapItems(usagepages.Button, buttonIDs(buttons), reportID)
+		items = append(items,
+			inputItem(hiddesc.DataItem{
+				Flags:          hiddesc.DataFlagVariable,
+				UsagePage:      usagepages.GenericDesktop,
+				UsageIDs:       []uint16{0x30, 0x31},
+				ReportCount:    2,
+				ReportSize:     16,
+				ReportID:       reportID,
+				LogicalMaximum: maximum,
+			}),
+			relativeItem(usagepages.GenericDesktop, []uint16{0x38}, reportID),
+		)
+		return applicationCollection(usagepages.GenericDesktop, 0x02, pointerCollection(items...)), nil
+	default:
+		return hiddesc.Collection{}, fmt.Errorf("unknown template type %q", t.Type)
+	}
+}
+
+func (t DescriptorTemplate) buttons(defaultButtons int) (int, error) {
+	if t.Buttons == 0 {
+		return defaultButtons, nil
+	}
+	if t.Buttons < 0 || t.Buttons > templateMaxButtons {
+		return 0, fmt.Errorf("buttons must be between 1 and %d", templateMaxButtons)
+	}
+	return t.Buttons, nil
+}
+
+func (t DescriptorTemplate) count(defaultCount int) (int, error) {
+	if t.Count == 0 {
+		return defaultCount, nil
+	}
+	if t.Count < 0 || t.Count > templateMaxCount {
+		return 0, fmt.Errorf("count must be between 1 and %d", templateMaxCount)
+	}
+	return t.Count, nil
+}
+
+// AppendTemplates adds collections of the templates to the descriptor. A single template without a
+// report ID makes a descriptor without report IDs, otherwise reports of desc are assigned a report ID
+// if they have none, and templates get free report IDs.
+func AppendTemplates(desc hiddesc.ReportDescriptor, templates []DescriptorTemplate) (hiddesc.ReportDescriptor, error) {
+	if len(templates) == 0 {
+		return desc, nil
+	}
+	desc = desc.Clone()
+	if len(desc.Collections) == 0 && len(templates) == 1 && templates[0].ReportID == 0 {
+		collection, err := templates[0].Collection(0)
+		if err != nil {
+			return desc, fmt.Errorf("invalid %s template: %w", templates[0].Type, err)
+		}
+		desc.Collections = append(desc.Collections, collection)
+		return desc, nil
+	}
+
+	assignReportID(desc)
+	used := make(map[uint8]bool)
+	for _, report := range NewDataItemSet(desc).Reports() {
+		used[report.ID] = true
+	}
+	for _, template := range templates {
+		if template.ReportID == 0 {
+			continue
+		}
+		if used[template.ReportID] {
+			return desc, fmt.Errorf("report ID %d of %s template is already used", template.ReportID, template.Type)
+		}
+		used[template.ReportID] = true
+	}
+	var nextID uint8
+	for _, template := range templates {
+		reportID := template.ReportID
+		if reportID == 0 {
+			for nextID++; used[nextID]; nextID++ {
+				if nextID == 0xff {
+					return desc, fmt.Errorf("no free report ID for %s template", template.Type)
+				}
+			}
+			reportID = nextID
+			used[reportID] = true
+		}
+		collection, err := template.Collection(reportID)
+		if err != nil {
+			return desc, fmt.Errorf("invalid %s template: %w", template.Type, err)
+		}
+		desc.Collections = append(desc.Collections, collection)
+	}
+	return desc, nil
+}
+
+func keyboardModifierItems(reportID uint8) []hiddesc.MainItem {
+	return []hiddesc.MainItem{
+		inputItem(hiddesc.DataItem{
+			Flags:          hiddesc.DataFlagVariable,
+			UsagePage:      usagepages.KeyboardKeypad,
+			UsageMinimum:   0xe0,
+			UsageMaximum:   0xe7,
+			ReportCount:    8,
+			ReportSize:     1,
+			ReportID:       reportID,
+			LogicalMaximum: 1,
+		}),
+		inputItem(hiddesc.DataItem{
+			Flags:       hiddesc.DataFlagConstant,
+			UsagePage:   usagepages.KeyboardKeypad,
+			ReportCount: 1,
+			ReportSize:  8,
+			ReportID:    reportID,
+		}),
+	}
+}
+
+// keyboardLEDItems returns an output report with Num Lock, Caps Lock, Scroll Lock, Compose and Kana LEDs.
+func keyboardLEDItems(leds bool, reportID uint8) []hiddesc.MainItem {
+	if !leds {
+		return nil
+	}
+	return []hiddesc.MainItem{
+		dataItem(hiddesc.MainItemTypeOutput, hiddesc.DataItem{
+			Flags:          hiddesc.DataFlagVariable,
+			UsagePage:      usagepages.Led,
+			UsageMinimum:   0x01,
+			UsageMaximum:   0x05,
+			ReportCount:    5,
+			ReportSize:     1,
+			ReportID:       reportID,
+			LogicalMaximum: 1,
+		}),
+		dataItem(hiddesc.MainItemTypeOutput, hiddesc.DataItem{
+			Flags:       hiddesc.DataFlagConstant,
+			UsagePage:   usagepages.Led,
+			ReportCount: 1,
+			ReportSize:  3,
+			ReportID:    reportID,
+		}),
+	}
+}
+
+func buttonIDs(buttons int) []uint16 {
+	return []uint16{1, uint16(buttons)}
+}
+
+func relativeItem(page uint16, ids []uint16, reportID uint8) hiddesc.MainItem {
+	return inputItem(hiddesc.DataItem{
+		Flags:          hiddesc.DataFlagVariable | hiddesc.DataFlagRelative,
+		UsagePage:      page,
+		UsageIDs:       ids,
+		ReportCount:    uint32(len(ids)),
+		ReportSize:     16,
+		ReportID:       reportID,
+		LogicalMinimum: -32767,
+		LogicalMaximum: 32767,
+	})
+}
+
+func pointerCollection(items ...hiddesc.MainItem) hiddesc.MainItem {
+	return hiddesc.MainItem{
+		Type: hiddesc.MainItemTypeCollection,
+		Collection: &hiddesc.Collection{
+			Type:      hiddesc.CollectionTypePhysical,
+			UsagePage: usagepages.GenericDesktop,
+			UsageID:   0x01,
+			Items:     items,
+		},
+	}
+}
+
+// wheelCollection returns a logical collection of a wheel and its 2-bit resolution multiplier feature.
+func wheelCollection(page, id uint16, reportID uint8) hiddesc.MainItem {
+	return hiddesc.MainItem{
+		Type: hiddesc.MainItemTypeCollection,
+		Collection: &hiddesc.Collection{
+			Type:      hiddesc.CollectionTypeLogical,
+			UsagePage: usagepages.GenericDesktop,
+			Items: []hiddesc.MainItem{
+				dataItem(hiddesc.MainItemTypeFeature, hiddesc.DataItem{
+					Flags:           hiddesc.DataFlagVariable,
+					UsagePage:       usagepages.GenericDesktop,
+					UsageIDs:        []uint16{0x48},
+					ReportCount:     1,
+					ReportSize:      2,
+					ReportID:        reportID,
+					LogicalMaximum:  1,
+					PhysicalMinimum: 1,
+					PhysicalMaximum: templateWheelMultiplier,
+				}),
+				relativeItem(page, []uint16{id}, reportID),
+			},
+		},
+	}
+}
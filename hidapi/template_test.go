@@ -0,0 +1,85 @@
+package hidapi
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/neuroplastio/neio-agent/hidapi/hiddesc"
+)
+
+func TestAppendTemplates(t *testing.T) {
+	templates := []DescriptorTemplate{
+		{Type: TemplateNKROKeyboard, LEDs: true},
+		{Type: TemplateMouse, Buttons: 16, HiResWheel: true},
+		{Type: TemplateConsumer, ReportID: 1},
+		{Type: TemplateSystem},
+		{Type: TemplateGamepad, Axes: 6},
+		{Type: TemplateAbsolutePointer},
+	}
+	desc, err := AppendTemplates(hiddesc.ReportDescriptor{}, templates)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := hiddesc.Encode(desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := hiddesc.Decode(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reencoded, err := hiddesc.Encode(decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(raw, reencoded) {
+		t.Errorf("descriptor changed after decoding:\n%x\n%x", raw, reencoded)
+	}
+
+	items := NewDataItemSet(decoded)
+	inputs := items.WithType(hiddesc.MainItemTypeInput)
+	if len(inputs.Reports()) != len(templates) {
+		t.Fatalf("expected %d input reports, got %d", len(templates), len(inputs.Reports()))
+	}
+	if inputs.Report(1)[0].UsagePage != 0x0c {
+		t.Errorf("consumer template did not get report ID 1")
+	}
+	layout := NewReportLayout(inputs)
+	for _, usage := range []Usage{
+		NewUsage(0x07, 0x04),
+		NewUsage(0x07, 0xe1),
+		NewUsage(0x09, 0x10),
+		NewUsage(0x01, 0x38),
+		NewUsage(0x0c, 0x238),
+		NewUsage(0x0c, 0xe9),
+		NewUsage(0x01, 0x82),
+		NewUsage(0x01, 0x34),
+		NewUsage(0x01, 0x39),
+	} {
+		if !layout.Contains(usage) {
+			t.Errorf("usage %s is not encoded", usage)
+		}
+	}
+	outputs := items.WithType(hiddesc.MainItemTypeOutput)
+	if len(outputs.Reports()) != 1 {
+		t.Error("expected a LED output report")
+	}
+	features := items.WithType(hiddesc.MainItemTypeFeature)
+	if len(features.Reports()) != 1 {
+		t.Error("expected a resolution multiplier feature report")
+	}
+
+	boot, err := AppendTemplates(hiddesc.ReportDescriptor{}, []DescriptorTemplate{{Type: TemplateBootKeyboard}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if NewDataItemSet(boot).HasReportID() {
+		t.Error("single template should not have a report ID")
+	}
+	if _, err := AppendTemplates(boot, []DescriptorTemplate{{Type: TemplateMouse, Buttons: 64}}); err == nil {
+		t.Error("expected an error for too many buttons")
+	}
+	if _, err := AppendTemplates(hiddesc.ReportDescriptor{}, []DescriptorTemplate{{Type: TemplateMouse, ReportID: 2}, {Type: TemplateSystem, ReportID: 2}}); err == nil {
+		t.Error("expected an error for duplicate report IDs")
+	}
+}
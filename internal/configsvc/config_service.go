@@ -122,6 +122,15 @@ func RegisterWriteable[T any](s *Service, path string, def T, fn func(config T,
 	return config, nil
 }
 
+// Load reads a configuration file without watching it.
+func Load[T any](path string, def T) (T, error) {
+	config, err := readConfig(path, def)
+	if err != nil {
+		return def, fmt.Errorf("failed to read config: %w", err)
+	}
+	return config, nil
+}
+
 func writeConfig[T any](path string, config T) error {
 	jsonB, err := json.Marshal(config)
 	if err != nil {
@@ -0,0 +1,90 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+
+	"github.com/neuroplastio/neio-agent/internal/flowsvc"
+)
+
+type Client struct {
+	rpc *rpc.Client
+}
+
+// Dial connects to the control socket of a running agent.
+func Dial(path string) (*Client, error) {
+	client, err := jsonrpc.Dial("unix", path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to agent at %s: %w", path, err)
+	}
+	return &Client{rpc: client}, nil
+}
+
+func (c *Client) Close() error {
+	return c.rpc.Close()
+}
+
+func (c *Client) Status() (Status, error) {
+	var status Status
+	if err := c.rpc.Call("Agent.Status", Empty{}, &status); err != nil {
+		return Status{}, err
+	}
+	return status, nil
+}
+
+// Node returns the status of a single node.
+func (c *Client) Node(id string) (Node, error) {
+	status, err := c.Status()
+	if err != nil {
+		return Node{}, err
+	}
+	if status.FlowError != "" {
+		return Node{}, fmt.Errorf("flow is not running: %s", status.FlowError)
+	}
+	for _, node := range status.Nodes {
+		if node.ID == id {
+			return node, nil
+		}
+	}
+	return Node{}, fmt.Errorf("node %s not found", id)
+}
+
+func (c *Client) Signal(signal string) error {
+	return c.rpc.Call("Agent.Signal", SignalArgs{Signal: signal}, &Empty{})
+}
+
+func (c *Client) Inject(node string, events ...string) error {
+	return c.rpc.Call("Agent.Inject", InjectArgs{Node: node, Events: events}, &Empty{})
+}
+
+func (c *Client) Reload() error {
+	return c.rpc.Call("Agent.Reload", Empty{}, &Empty{})
+}
+
+// Trace calls fn with records of events traced by the running agent until the context is cancelled.
+func (c *Client) Trace(ctx context.Context, args TraceArgs, fn func(record flowsvc.TraceRecord) error) error {
+	var session TraceSession
+	if err := c.rpc.Call("Agent.TraceStart", args, &session); err != nil {
+		return err
+	}
+	defer c.rpc.Call("Agent.TraceStop", session, &Empty{})
+	for {
+		var records TraceRecords
+		call := c.rpc.Go("Agent.TraceNext", session, &records, nil)
+		select {
+		case <-call.Done:
+		case <-ctx.Done():
+			return nil
+		}
+		if call.Error != nil {
+			return call.Error
+		}
+		for _, record := range records.Records {
+			if err := fn(record); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package control
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/neuroplastio/neio-agent/hidapi"
+	"github.com/neuroplastio/neio-agent/internal/flowsvc"
+	"go.uber.org/zap"
+)
+
+type fakeAgent struct {
+	signals []string
+	injects []string
+}
+
+func (f *fakeAgent) Devices() ([]Device, error) {
+	return []Device{{Addr: "virtual@kb", Name: "Keyboard", Kind: "input", Connected: true}}, nil
+}
+
+func (f *fakeAgent) Nodes() ([]flowsvc.NodeStatus, error) {
+	return []flowsvc.NodeStatus{
+		{ID: "layer", Type: "mux", State: flowsvc.NodeStateRunning, Details: map[string]string{"route": "nav"}},
+	}, nil
+}
+
+func (f *fakeAgent) Signal(ctx context.Context, expr string) error {
+	if expr == "$missing.set()" {
+		return errors.New("node missing not found")
+	}
+	f.signals = append(f.signals, expr)
+	return nil
+}
+
+func (f *fakeAgent) Inject(nodeID string, usages []hidapi.UsageEvent) error {
+	for _, usage := range usages {
+		f.injects = append(f.injects, nodeID+":"+usage.String())
+	}
+	return nil
+}
+
+func (f *fakeAgent) Reload() error {
+	return nil
+}
+
+func (f *fakeAgent) Trace(ctx context.Context, filter flowsvc.TraceFilter) <-chan flowsvc.TraceRecord {
+	ch := make(chan flowsvc.TraceRecord, 2)
+	a := hidapi.NewUsage(0x07, 0x04)
+	ch <- flowsvc.TraceRecord{From: "kb", To: filter.Node, Usages: []hidapi.UsageEvent{{Usage: a, Type: hidapi.UsageEventActivate}}}
+	ch <- flowsvc.TraceRecord{From: "kb", To: filter.Node, Usages: []hidapi.UsageEvent{{Usage: a, Type: hidapi.UsageEventDeactivate}}}
+	go func() {
+		<-ctx.Done()
+		close(ch)
+	}()
+	return ch
+}
+
+func TestControl(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	agent := &fakeAgent{}
+	server, err := NewServer(zap.NewNop(), agent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "agent.sock")
+	done := make(chan error, 1)
+	go func() {
+		done <- server.Serve(ctx, path)
+	}()
+
+	var client *Client
+	for start := time.Now(); time.Since(start) < time.Second; time.Sleep(5 * time.Millisecond) {
+		if client, err = Dial(path); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0o600 {
+		t.Errorf("expected socket permissions 0600, got %v", info.Mode().Perm())
+	}
+	if entries, err := os.ReadDir(filepath.Dir(path)); err != nil || len(entries) != 1 {
+		t.Errorf("expected only the socket in its directory, got %v, %v", entries, err)
+	}
+
+	node, err := client.Node("layer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var details struct {
+		Route string `json:"route"`
+	}
+	if err := node.DecodeDetails(&details); err != nil || details.Route != "nav" {
+		t.Errorf("unexpected details: %s, %v", node.Details, err)
+	}
+	if err := client.Signal(`$layer.set("nav")`); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Signal("$missing.set()"); err == nil || err.Error() != "node missing not found" {
+		t.Errorf("expected signal error, got %v", err)
+	}
+	if err := client.Inject("kb", "+LeftShift", "A=1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.Inject("kb", "LeftShift"); err == nil {
+		t.Error("expected invalid event error")
+	}
+	if !slices.Equal(agent.signals, []string{`$layer.set("nav")`}) {
+		t.Errorf("unexpected signals: %v", agent.signals)
+	}
+	if !slices.Equal(agent.injects, []string{"kb:+LeftShift", "kb:A=1"}) {
+		t.Errorf("unexpected injects: %v", agent.injects)
+	}
+
+	traceCtx, traceCancel := context.WithTimeout(ctx, time.Second)
+	defer traceCancel()
+	var traced []string
+	err = client.Trace(traceCtx, TraceArgs{Node: "binds", Edge: "up"}, func(record flowsvc.TraceRecord) error {
+		traced = append(traced, record.To+":"+record.Usages[0].String())
+		if len(traced) == 2 {
+			traceCancel()
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(traced, []string{"binds:+A", "binds:-A"}) {
+		t.Errorf("unexpected trace records: %v", traced)
+	}
+	if err := client.Trace(ctx, TraceArgs{Edge: "sideways"}, nil); err == nil {
+		t.Error("expected invalid edge error")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected the socket to be removed, got %v", err)
+	}
+}
@@ -0,0 +1,234 @@
+// Package control serves the local control API of the agent over a Unix socket. The API uses
+// JSON-RPC 1.0 as implemented by net/rpc/jsonrpc, with methods of the "Agent" service.
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/neuroplastio/neio-agent/hidapi"
+	"github.com/neuroplastio/neio-agent/internal/flowsvc"
+	"go.uber.org/zap"
+)
+
+// Agent is the part of the agent exposed by the control API.
+type Agent interface {
+	Devices() ([]Device, error)
+	Nodes() ([]flowsvc.NodeStatus, error)
+	Signal(ctx context.Context, expr string) error
+	Inject(nodeID string, usages []hidapi.UsageEvent) error
+	Reload() error
+	// Trace returns records of events of the running flow until the context is cancelled.
+	Trace(ctx context.Context, filter flowsvc.TraceFilter) <-chan flowsvc.TraceRecord
+}
+
+type Device struct {
+	Addr      string `json:"addr"`
+	Name      string `json:"name"`
+	Kind      string `json:"kind"`
+	Connected bool   `json:"connected"`
+}
+
+type Node struct {
+	ID       string          `json:"id"`
+	Type     string          `json:"type"`
+	State    string          `json:"state"`
+	Error    string          `json:"error,omitempty"`
+	Bypassed bool            `json:"bypassed,omitempty"`
+	Details  json.RawMessage `json:"details,omitempty"`
+}
+
+// DecodeDetails decodes the state reported by the node.
+func (n Node) DecodeDetails(v any) error {
+	if len(n.Details) == 0 {
+		return fmt.Errorf("node %s has no details", n.ID)
+	}
+	return json.Unmarshal(n.Details, v)
+}
+
+type Status struct {
+	Devices []Device `json:"devices"`
+	Nodes   []Node   `json:"nodes"`
+	// FlowError is set if the flow is not running.
+	FlowError string `json:"flowError,omitempty"`
+}
+
+type Empty struct{}
+
+type SignalArgs struct {
+	// Signal is a flow statement, e.g. $layer.set("nav").
+	Signal string `json:"signal"`
+}
+
+type InjectArgs struct {
+	Node string `json:"node"`
+	// Events are usage events in the format of hidapi.UsageEvent.String, e.g. +A.
+	Events []string `json:"events"`
+}
+
+// API implements methods of the "Agent" service.
+type API struct {
+	agent  Agent
+	traces traceSessions
+}
+
+const signalTimeout = 5 * time.Second
+
+func (a *API) Status(_ Empty, reply *Status) error {
+	devices, err := a.agent.Devices()
+	if err != nil {
+		return err
+	}
+	reply.Devices = devices
+	nodes, err := a.agent.Nodes()
+	if err != nil {
+		reply.FlowError = err.Error()
+		return nil
+	}
+	for _, node := range nodes {
+		n := Node{
+			ID:       node.ID,
+			Type:     node.Type,
+			State:    string(node.State),
+			Error:    node.Error,
+			Bypassed: node.Bypassed,
+		}
+		if node.Details != nil {
+			n.Details, err = json.Marshal(node.Details)
+			if err != nil {
+				return fmt.Errorf("failed to encode status of node %s: %w", node.ID, err)
+			}
+		}
+		reply.Nodes = append(reply.Nodes, n)
+	}
+	return nil
+}
+
+func (a *API) Signal(args SignalArgs, _ *Empty) error {
+	ctx, cancel := context.WithTimeout(context.Background(), signalTimeout)
+	defer cancel()
+	return a.agent.Signal(ctx, args.Signal)
+}
+
+func (a *API) Inject(args InjectArgs, _ *Empty) error {
+	usages := make([]hidapi.UsageEvent, 0, len(args.Events))
+	for _, str := range args.Events {
+		usage, err := hidapi.ParseUsageEvent(str)
+		if err != nil {
+			return fmt.Errorf("invalid event %q: %w", str, err)
+		}
+		usages = append(usages, usage)
+	}
+	return a.agent.Inject(args.Node, usages)
+}
+
+func (a *API) Reload(_ Empty, _ *Empty) error {
+	return a.agent.Reload()
+}
+
+type Server struct {
+	log *zap.Logger
+	rpc *rpc.Server
+	api *API
+}
+
+// listenPrivate listens on a socket that is only accessible by the current user. The socket is created in
+// a directory only accessible by the current user and moved to the path once its permissions are set, so
+// that other users cannot connect to it in between.
+func listenPrivate(path string) (*net.UnixListener, error) {
+	dir, err := os.MkdirTemp(filepath.Dir(path), ".control-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create control socket directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	tmpPath := filepath.Join(dir, "agent.sock")
+	listener, err := net.ListenUnix("unix", &net.UnixAddr{Name: tmpPath, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on control socket: %w", err)
+	}
+	// the socket file is moved, it is removed by Serve
+	listener.SetUnlinkOnClose(false)
+	if err := os.Chmod(tmpPath, 0o600); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set control socket permissions: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to move control socket: %w", err)
+	}
+	return listener, nil
+}
+
+func NewServer(log *zap.Logger, agent Agent) (*Server, error) {
+	server := rpc.NewServer()
+	api := &API{agent: agent}
+	if err := server.RegisterName("Agent", api); err != nil {
+		return nil, fmt.Errorf("failed to register control API: %w", err)
+	}
+	return &Server{
+		log: log,
+		rpc: server,
+		api: api,
+	}, nil
+}
+
+// Serve listens on the socket until the context is cancelled. A stale socket file is replaced,
+// and the socket is only accessible by the user running the agent.
+func (s *Server) Serve(ctx context.Context, path string) error {
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	listener, err := listenPrivate(path)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	defer listener.Close()
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		conns = make(map[net.Conn]struct{})
+	)
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+		s.api.traces.stopAll()
+		mu.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+	}()
+	s.log.Info("Control API listening", zap.String("socket", path))
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				wg.Wait()
+				return nil
+			}
+			return fmt.Errorf("failed to accept control connection: %w", err)
+		}
+		mu.Lock()
+		conns[conn] = struct{}{}
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.rpc.ServeCodec(jsonrpc.NewServerCodec(conn))
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+		}()
+	}
+}
@@ -0,0 +1,150 @@
+package control
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/neuroplastio/neio-agent/internal/flowsvc"
+)
+
+// TraceArgs selects traced events, see flowsvc.TraceFilter.
+type TraceArgs struct {
+	Node string `json:"node"`
+	// Edge is up, down or any.
+	Edge string `json:"edge"`
+}
+
+type TraceSession struct {
+	ID uint64 `json:"id"`
+}
+
+type TraceRecords struct {
+	Records []flowsvc.TraceRecord `json:"records"`
+}
+
+const (
+	// traceWait is the time TraceNext waits for records.
+	traceWait = time.Second
+	// traceIdle is the time a trace session is kept without TraceNext calls, for clients that are gone.
+	traceIdle = 10 * time.Second
+	// traceBuffer is the number of records buffered for a session.
+	traceBuffer = 1024
+)
+
+// traceSessions are traces of the running flow, polled by clients with TraceNext.
+type traceSessions struct {
+	mu       sync.Mutex
+	next     uint64
+	sessions map[uint64]*traceSession
+}
+
+type traceSession struct {
+	records <-chan flowsvc.TraceRecord
+	cancel  context.CancelFunc
+	idle    *time.Timer
+}
+
+func (t *traceSessions) start(agent Agent, filter flowsvc.TraceFilter) uint64 {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.sessions == nil {
+		t.sessions = make(map[uint64]*traceSession)
+	}
+	t.next++
+	id := t.next
+	t.sessions[id] = &traceSession{
+		records: agent.Trace(ctx, filter),
+		cancel:  cancel,
+		idle: time.AfterFunc(traceIdle, func() {
+			t.stop(id)
+		}),
+	}
+	return id
+}
+
+func (t *traceSessions) get(id uint64) (*traceSession, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	session, ok := t.sessions[id]
+	if !ok {
+		return nil, fmt.Errorf("trace session %d not found", id)
+	}
+	return session, nil
+}
+
+func (t *traceSessions) stop(id uint64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if session, ok := t.sessions[id]; ok {
+		session.idle.Stop()
+		session.cancel()
+		delete(t.sessions, id)
+	}
+}
+
+func (t *traceSessions) stopAll() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, session := range t.sessions {
+		session.idle.Stop()
+		session.cancel()
+		delete(t.sessions, id)
+	}
+}
+
+// next waits for records of the session and returns the records that are buffered.
+func (s *traceSession) next(reply *TraceRecords) error {
+	s.idle.Reset(traceIdle)
+	defer s.idle.Reset(traceIdle)
+	timer := time.NewTimer(traceWait)
+	defer timer.Stop()
+	select {
+	case record, ok := <-s.records:
+		if !ok {
+			return fmt.Errorf("trace session ended")
+		}
+		reply.Records = append(reply.Records, record)
+	case <-timer.C:
+		return nil
+	}
+	for len(reply.Records) < traceBuffer {
+		select {
+		case record, ok := <-s.records:
+			if !ok {
+				return nil
+			}
+			reply.Records = append(reply.Records, record)
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+// TraceStart starts tracing events of the running flow. Records are polled with TraceNext, and
+// sessions that are not polled are stopped.
+func (a *API) TraceStart(args TraceArgs, reply *TraceSession) error {
+	edge, err := flowsvc.ParseTraceEdge(args.Edge)
+	if err != nil {
+		return err
+	}
+	reply.ID = a.traces.start(a.agent, flowsvc.TraceFilter{Node: args.Node, Edge: edge})
+	return nil
+}
+
+// TraceNext returns records traced since the previous call, waiting up to a second for the first one.
+func (a *API) TraceNext(args TraceSession, reply *TraceRecords) error {
+	session, err := a.traces.get(args.ID)
+	if err != nil {
+		return err
+	}
+	return session.next(reply)
+}
+
+func (a *API) TraceStop(args TraceSession, _ *Empty) error {
+	a.traces.stop(args.ID)
+	return nil
+}
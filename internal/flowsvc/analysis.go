@@ -0,0 +1,265 @@
+package flowsvc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/neuroplastio/neio-agent/flowapi"
+	"github.com/neuroplastio/neio-agent/flowapi/flowdsl"
+	"github.com/neuroplastio/neio-agent/hidapi"
+	"github.com/neuroplastio/neio-agent/internal/configsvc"
+	"go.uber.org/zap"
+)
+
+// Finding is a problem of a flow config found by static analysis.
+type Finding struct {
+	Node    string              `json:"node"`
+	Kind    flowapi.FindingKind `json:"kind"`
+	Message string              `json:"message"`
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.Node, f.Kind, f.Message)
+}
+
+// Analyze propagates usages from input nodes through the graph to output nodes and returns
+// findings reported by the nodes. Nodes are created, but they are neither configured nor run.
+func Analyze(log *zap.Logger, registry *Registry, cfg FlowConfig) ([]Finding, error) {
+	analysis, err := analyzeFlow(log, registry, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return analysis.findings, nil
+}
+
+type flowAnalysis struct {
+	findings []Finding
+	// received are usages received by nodes, unknown is set for nodes that can receive other usages.
+	received map[string]flowapi.UsageSet
+	unknown  map[string]bool
+}
+
+// upstream returns usages received by the node.
+func (f *flowAnalysis) upstream(nodeID string) (flowapi.UsageSet, bool) {
+	usages := f.received[nodeID]
+	if usages == nil {
+		usages = flowapi.NewUsageSet()
+	}
+	return usages, !f.unknown[nodeID]
+}
+
+func analyzeFlow(log *zap.Logger, registry *Registry, cfg FlowConfig) (*flowAnalysis, error) {
+	b := NewGraphBuilder(log, registry, nil)
+	configs := make(map[string]NodeConfig, len(cfg.Nodes))
+	for _, node := range cfg.Nodes {
+		b = b.AddNode(node.Type, node.ID, node.To)
+		configs[node.ID] = node
+	}
+	if err := b.Validate(); err != nil {
+		return nil, fmt.Errorf("failed to validate graph: %w", err)
+	}
+	nodes := make(map[string]flowapi.Node, len(cfg.Nodes))
+	for _, node := range cfg.Nodes {
+		n, err := b.createNode(node.ID)
+		if err != nil {
+			return nil, err
+		}
+		nodes[node.ID] = n
+	}
+
+	analysis := &flowAnalysis{
+		received: make(map[string]flowapi.UsageSet, len(cfg.Nodes)),
+		unknown:  make(map[string]bool, len(cfg.Nodes)),
+	}
+	for _, id := range b.topologicalOrder() {
+		upstream, complete := analysis.upstream(id)
+		a := &usageAnalysis{
+			nodeConfigurator: nodeConfigurator{
+				ctx:      context.Background(),
+				config:   configs[id].Config,
+				registry: b.registry,
+			},
+			nodeID:      id,
+			upstream:    upstream,
+			complete:    complete,
+			downstreams: b.edgesDown[id],
+			sent:        make(map[string]flowapi.UsageSet),
+		}
+		if analyzer, ok := nodes[id].(flowapi.UsageAnalyzer); ok {
+			if err := analyzer.AnalyzeUsages(a); err != nil {
+				return nil, fmt.Errorf("failed to analyze node %s: %w", id, err)
+			}
+		} else {
+			a.Broadcast(a.upstream)
+			if !a.complete {
+				a.SendUnknown()
+			}
+		}
+		analysis.findings = append(analysis.findings, a.findings...)
+		for _, to := range a.downstreams {
+			if analysis.received[to] == nil {
+				analysis.received[to] = flowapi.NewUsageSet()
+			}
+			analysis.received[to].AddSet(a.sent[to])
+			if a.unknown {
+				analysis.unknown[to] = true
+			}
+		}
+	}
+	return analysis, nil
+}
+
+// Analyze reads the flow config and analyzes it with report descriptors of known devices.
+func (s *Service) Analyze() ([]Finding, error) {
+	cfg, err := configsvc.Load(s.flowPath, FlowConfig{})
+	if err != nil {
+		return nil, err
+	}
+	return Analyze(s.log.Named("analysis"), s.registry, cfg)
+}
+
+// topologicalOrder returns node IDs ordered so that upstream nodes come first. The graph
+// must be validated.
+func (g GraphBuilder) topologicalOrder() []string {
+	pending := make(map[string]int, len(g.nodeIDs))
+	for _, id := range g.nodeIDs {
+		pending[id] = len(g.edgesUp[id])
+	}
+	order := g.entryNodeIDs()
+	for i := 0; i < len(order); i++ {
+		for _, to := range g.edgesDown[order[i]] {
+			pending[to]--
+			if pending[to] == 0 {
+				order = append(order, to)
+			}
+		}
+	}
+	return order
+}
+
+type usageAnalysis struct {
+	nodeConfigurator
+
+	nodeID      string
+	upstream    flowapi.UsageSet
+	complete    bool
+	downstreams []string
+
+	sent     map[string]flowapi.UsageSet
+	unknown  bool
+	findings []Finding
+}
+
+func (a *usageAnalysis) Upstream() (flowapi.UsageSet, bool) {
+	return a.upstream, a.complete
+}
+
+func (a *usageAnalysis) StatementUsages(stmt flowdsl.Statement) (flowapi.UsageSet, error) {
+	usages := flowapi.NewUsageSet()
+	if err := a.registry.statementUsages(stmt, usages); err != nil {
+		return nil, err
+	}
+	return usages, nil
+}
+
+func (a *usageAnalysis) Send(nodeID string, usages flowapi.UsageSet) {
+	if a.sent[nodeID] == nil {
+		a.sent[nodeID] = flowapi.NewUsageSet()
+	}
+	a.sent[nodeID].AddSet(usages)
+}
+
+func (a *usageAnalysis) Broadcast(usages flowapi.UsageSet) {
+	for _, id := range a.downstreams {
+		a.Send(id, usages)
+	}
+}
+
+func (a *usageAnalysis) SendUnknown() {
+	a.unknown = true
+}
+
+func (a *usageAnalysis) Report(kind flowapi.FindingKind, format string, args ...any) {
+	a.findings = append(a.findings, Finding{
+		Node:    a.nodeID,
+		Kind:    kind,
+		Message: fmt.Sprintf(format, args...),
+	})
+}
+
+// statementUsages adds usages the action statement can send, including usages of actions passed
+// as arguments.
+func (g *GraphRegistry) statementUsages(stmt flowdsl.Statement, usages flowapi.UsageSet) error {
+	switch {
+	case stmt.Usage != nil:
+		return addUsageStatement(*stmt.Usage, usages)
+	case stmt.Expr == nil:
+		return fmt.Errorf("invalid action statement: %v", stmt)
+	}
+	ident, err := g.parseIdentifier(stmt.Expr.Identifier)
+	if err != nil {
+		return err
+	}
+	var decl flowdsl.Declaration
+	if ident.NodeID == nil {
+		reg, err := g.registry.getActionRegistration(ident.Name)
+		if err != nil {
+			return fmt.Errorf("failed to get action %q: %w", ident.Name, err)
+		}
+		decl = reg.declaration
+		if emitter, ok := reg.action.(flowapi.UsageEmitter); ok {
+			args, err := flowapi.NewArguments(decl.Parameters, stmt.Expr.Arguments)
+			if err != nil {
+				return fmt.Errorf("failed to create action %q: %w", ident.Name, err)
+			}
+			emitted, err := emitter.EmittedUsages(args)
+			if err != nil {
+				return fmt.Errorf("invalid action %q: %w", ident.Name, err)
+			}
+			usages.Add(emitted...)
+		}
+	} else {
+		if _, ok := g.actions[*ident.NodeID][ident.Name]; !ok {
+			return fmt.Errorf("action %q not found in node %q", ident.Name, *ident.NodeID)
+		}
+		decl = g.declarations[*ident.NodeID][ident.Name]
+	}
+	for i, arg := range stmt.Expr.Arguments {
+		if i >= len(decl.Parameters) {
+			break
+		}
+		switch decl.Parameters[i].Type {
+		case "Action":
+			if arg.Usage == nil && arg.Expr == nil {
+				continue
+			}
+			if err := g.statementUsages(flowdsl.Statement{Usage: arg.Usage, Expr: arg.Expr}, usages); err != nil {
+				return err
+			}
+		case "Usage":
+			if arg.Usage != nil {
+				if err := addUsageStatement(*arg.Usage, usages); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func addUsageStatement(stmt flowdsl.UsageStatement, usages flowapi.UsageSet) error {
+	if stmt.Usage != "" {
+		usage, err := hidapi.ParseUsage(stmt.Usage)
+		if err != nil {
+			return err
+		}
+		usages.Add(usage)
+		return nil
+	}
+	parsed, err := hidapi.ParseUsages(stmt.Usages)
+	if err != nil {
+		return err
+	}
+	usages.Add(parsed...)
+	return nil
+}
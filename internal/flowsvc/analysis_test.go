@@ -0,0 +1,152 @@
+package flowsvc_test
+
+import (
+	"context"
+	"slices"
+	"testing"
+
+	"github.com/goccy/go-yaml"
+	"github.com/neuroplastio/neio-agent/components/actions"
+	"github.com/neuroplastio/neio-agent/components/nodes"
+	"github.com/neuroplastio/neio-agent/flowapi"
+	"github.com/neuroplastio/neio-agent/hidapi"
+	"github.com/neuroplastio/neio-agent/internal/flowsvc"
+	"go.uber.org/zap"
+)
+
+// deviceType is a device with usages listed in its config, standing in for input and output nodes.
+type deviceType struct {
+	input bool
+}
+
+func (d deviceType) Descriptor() flowapi.NodeTypeDescriptor {
+	if d.input {
+		return flowapi.NodeTypeDescriptor{UpstreamType: flowapi.NodeLinkTypeNone, DownstreamType: flowapi.NodeLinkTypeMany}
+	}
+	return flowapi.NodeTypeDescriptor{UpstreamType: flowapi.NodeLinkTypeMany, DownstreamType: flowapi.NodeLinkTypeNone}
+}
+
+func (d deviceType) CreateNode(p flowapi.NodeProvider) (flowapi.Node, error) {
+	return device{input: d.input}, nil
+}
+
+type device struct {
+	input bool
+}
+
+func (d device) Configure(c flowapi.NodeConfigurator) error {
+	return nil
+}
+
+func (d device) Run(ctx context.Context, up flowapi.Stream, down flowapi.Stream) error {
+	return nil
+}
+
+func (d device) AnalyzeUsages(a flowapi.UsageAnalysis) error {
+	var cfg struct {
+		Usages  []string `yaml:"usages"`
+		Unknown bool     `yaml:"unknown"`
+	}
+	if err := a.Unmarshal(&cfg); err != nil {
+		return err
+	}
+	usages, err := hidapi.ParseUsages(cfg.Usages)
+	if err != nil {
+		return err
+	}
+	if d.input {
+		if cfg.Unknown {
+			a.SendUnknown()
+		}
+		a.Broadcast(flowapi.NewUsageSet(usages...))
+		return nil
+	}
+	in, _ := a.Upstream()
+	encodable := flowapi.NewUsageSet(usages...)
+	for _, usage := range in.Sorted() {
+		if !encodable.Contains(usage) {
+			a.Report(flowapi.FindingUnencodable, "%s", usage)
+		}
+	}
+	return nil
+}
+
+const analysisFlow = `
+nodes:
+  - id: kb
+    to: [keys]
+    input:
+      usages: [A, B, C, F12, LeftShift, btn.1, con.VolumeIncrement]
+
+  - id: keys
+    to: [binds, extra, out]
+    split:
+      binds: [kb.*]
+      extra: [kb.A]
+      out: [btn.*]
+
+  - id: extra
+    to: [out]
+    bind:
+      map:
+        X: Y
+
+  - id: binds
+    to: [out]
+    bind:
+      map:
+        A: sendString("Hi")
+        A+B: C
+        D: E
+        F12: signal($binds.toggleBypass())
+
+  - id: out
+    output:
+      usages: [B, C, H, I, LeftShift]
+`
+
+func TestAnalyze(t *testing.T) {
+	var cfg flowsvc.FlowConfig
+	if err := yaml.Unmarshal([]byte(analysisFlow), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	log := zap.NewNop()
+	registry := flowsvc.NewRegistry()
+	nodes.Register(log, registry)
+	registry.MustRegisterNodeType("input", deviceType{input: true})
+	registry.MustRegisterNodeType("output", deviceType{})
+	actions.Register(registry)
+
+	findings, err := flowsvc.Analyze(log, registry, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []string
+	for _, finding := range findings {
+		actual = append(actual, finding.String())
+	}
+	expected := []string{
+		"keys: shadowed: route extra only matches usages routed to earlier routes",
+		"keys: unmatched: usages match no route: con.VolumeIncrement",
+		"binds: unreachable: D can never be triggered, D is not received",
+		"binds: overlap: A is also triggered when A+B is",
+		"extra: unreachable: X can never be triggered, X is not received",
+		"out: unencodable: btn.1",
+	}
+	slices.Sort(actual)
+	slices.Sort(expected)
+	if !slices.Equal(actual, expected) {
+		t.Errorf("unexpected findings:\n%v\nexpected:\n%v", actual, expected)
+	}
+
+	cfg.Nodes[0].Config = []byte(`{usages: [A, B, C, F12, LeftShift, btn.1], unknown: true}`)
+	findings, err = flowsvc.Analyze(log, registry, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, finding := range findings {
+		if finding.Kind == flowapi.FindingUnreachable {
+			t.Errorf("unexpected finding with unknown usages: %s", finding)
+		}
+	}
+}
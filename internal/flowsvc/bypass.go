@@ -0,0 +1,199 @@
+package flowsvc
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/neuroplastio/neio-agent/flowapi"
+	"github.com/neuroplastio/neio-agent/flowapi/flowdsl"
+	"github.com/neuroplastio/neio-agent/hidapi"
+)
+
+// BuiltinSignals are signals of every node. Node types cannot declare signals or actions with
+// the same names.
+var BuiltinSignals = []flowapi.SignalDescriptor{
+	{
+		DisplayName: "Bypass",
+		Description: `Bypasses the node: events are forwarded unchanged to the downstream node, and events from the downstream node to the upstream nodes.
+Only nodes with a single downstream node can be bypassed. Usages held downstream of the node are released when the node is bypassed or enabled, and the node receives releases of the usages it holds when it is bypassed.`,
+		Signature: "bypass()",
+	},
+	{
+		DisplayName: "Enable",
+		Description: "Stops bypassing the node",
+		Signature:   "enable()",
+	},
+	{
+		DisplayName: "Toggle Bypass",
+		Description: "Bypasses the node if it is enabled, and enables it if it is bypassed",
+		Signature:   "toggleBypass()",
+	},
+}
+
+var builtinDeclarations = func() map[string]flowdsl.Declaration {
+	decls := make(map[string]flowdsl.Declaration, len(BuiltinSignals))
+	for _, signal := range BuiltinSignals {
+		decl, err := flowdsl.ParseDeclaration(signal.Signature)
+		if err != nil {
+			panic(fmt.Sprintf("invalid builtin signal %s: %v", signal.Signature, err))
+		}
+		decls[decl.Identifier] = decl
+	}
+	return decls
+}()
+
+// registerBuiltinSignals registers the builtin signals of the node. They control the runner,
+// so they keep working when the node is replaced.
+func (r *GraphRegistry) registerBuiltinSignals(nodeID string, runner *nodeRunner) {
+	creators := map[string]func(){
+		"bypass": func() {
+			runner.setBypassed(true)
+		},
+		"enable": func() {
+			runner.setBypassed(false)
+		},
+		"toggleBypass": func() {
+			runner.setBypassed(!runner.bypass.bypassed.Load())
+		},
+	}
+	if r.signals[nodeID] == nil {
+		r.signals[nodeID] = make(map[string]flowapi.SignalCreator)
+	}
+	if r.declarations[nodeID] == nil {
+		r.declarations[nodeID] = make(map[string]flowdsl.Declaration)
+	}
+	for name, fn := range creators {
+		fn := fn
+		r.signals[nodeID][name] = func(p flowapi.ActionProvider) (flowapi.SignalHandler, error) {
+			if runner.bypass.downstreams != 1 {
+				return nil, fmt.Errorf("node %s has %d downstream nodes, only nodes with a single downstream node can be bypassed", nodeID, runner.bypass.downstreams)
+			}
+			runner.bypass.used.Store(true)
+			return func(ctx context.Context) {
+				fn()
+			}, nil
+		}
+		r.declarations[nodeID][name] = builtinDeclarations[name]
+	}
+}
+
+// nodeBypass passes events sent to a bypassed node on to the nodes behind it, so that bypassing a node
+// costs nothing until its bypass signals are used. From then on, usages held downstream of the node are
+// tracked, so that they are released when the bypass state changes, and so are usages the node received,
+// so that the node is released when it is bypassed and does not receive releases of usages it never saw.
+type nodeBypass struct {
+	downstreams int
+	up          flowapi.Stream
+	down        flowapi.Stream
+	// in publishes events to the node as if they were sent by its upstream nodes
+	in FlowPublisher
+
+	used     atomic.Bool
+	bypassed atomic.Bool
+
+	mu       sync.Mutex
+	held     map[hidapi.Usage]struct{}
+	received map[hidapi.Usage]struct{}
+}
+
+func newNodeBypass(downstreams int) *nodeBypass {
+	return &nodeBypass{
+		downstreams: downstreams,
+		held:        make(map[hidapi.Usage]struct{}),
+		received:    make(map[hidapi.Usage]struct{}),
+	}
+}
+
+// receive records usages activated and deactivated by an event sent to the node in the direction, and drops
+// releases of usages the node did not receive. It reports false if the node is bypassed.
+func (b *nodeBypass) receive(direction FlowEventType, event flowapi.Event) bool {
+	if !b.used.Load() {
+		return !b.bypassed.Load()
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.bypassed.Load() {
+		return false
+	}
+	if direction != FlowEventDownstream || event.Type != flowapi.HIDEventTypeInput || event.HID == nil {
+		return true
+	}
+	event.HID.Filter(func(usage hidapi.UsageEvent) bool {
+		switch usage.Type {
+		case hidapi.UsageEventActivate:
+			b.received[usage.Usage] = struct{}{}
+		case hidapi.UsageEventDeactivate:
+			if _, ok := b.received[usage.Usage]; !ok {
+				return false
+			}
+			delete(b.received, usage.Usage)
+		}
+		return true
+	})
+	return true
+}
+
+// forward passes an event sent to the node in the direction to the nodes behind it.
+func (b *nodeBypass) forward(direction FlowEventType, event flowapi.Event) {
+	if direction == FlowEventUpstream {
+		b.up.Broadcast(event)
+		return
+	}
+	b.down.Broadcast(event)
+}
+
+// track records usages activated and deactivated by an event sent downstream of the node.
+func (b *nodeBypass) track(event flowapi.Event) {
+	if !b.used.Load() || event.Type != flowapi.HIDEventTypeInput || event.HID == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, usage := range event.HID.Usages() {
+		switch usage.Type {
+		case hidapi.UsageEventActivate:
+			b.held[usage.Usage] = struct{}{}
+		case hidapi.UsageEventDeactivate:
+			delete(b.held, usage.Usage)
+		}
+	}
+}
+
+// set changes the bypass state and releases usages held downstream of the node, and usages received
+// by the node. It reports whether the state changed.
+func (b *nodeBypass) set(ctx context.Context, bypassed bool, now time.Time) bool {
+	b.mu.Lock()
+	if b.bypassed.Swap(bypassed) == bypassed {
+		b.mu.Unlock()
+		return false
+	}
+	held := drainUsages(b.held)
+	received := drainUsages(b.received)
+	b.mu.Unlock()
+	if len(held) > 0 {
+		event := hidapi.NewEventAt(now)
+		event.Deactivate(held...)
+		b.down.Broadcast(flowapi.Event{Type: flowapi.HIDEventTypeInput, HID: event})
+	}
+	if len(received) > 0 {
+		event := hidapi.NewEventAt(now)
+		event.Deactivate(received...)
+		b.in(ctx, flowapi.Event{Type: flowapi.HIDEventTypeInput, HID: event})
+	}
+	return true
+}
+
+// drainUsages returns the sorted usages of the set and clears it.
+func drainUsages(set map[hidapi.Usage]struct{}) []hidapi.Usage {
+	usages := make([]hidapi.Usage, 0, len(set))
+	for usage := range set {
+		usages = append(usages, usage)
+	}
+	clear(set)
+	slices.Sort(usages)
+	return usages
+}
@@ -0,0 +1,133 @@
+package flowsvc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/neuroplastio/neio-agent/flowapi"
+	"github.com/neuroplastio/neio-agent/flowapi/flowdsl"
+	"github.com/neuroplastio/neio-agent/hidapi"
+	"github.com/neuroplastio/neio-agent/internal/configsvc"
+	"github.com/neuroplastio/neio-agent/pkg/clock"
+)
+
+type NodeState string
+
+const (
+	NodeStateStarting NodeState = ""
+	NodeStateRunning  NodeState = "running"
+	NodeStateStopped  NodeState = "stopped"
+	NodeStateFailed   NodeState = "failed"
+)
+
+// NodeStatus is the health of a node and the state reported by nodes implementing flowapi.StatusReporter.
+type NodeStatus struct {
+	ID    string    `json:"id"`
+	Type  string    `json:"type"`
+	State NodeState `json:"state"`
+	Error string    `json:"error,omitempty"`
+	// Bypassed is set if events are passed through the node unchanged.
+	Bypassed bool `json:"bypassed,omitempty"`
+	// Details are reported by the node.
+	Details any `json:"details,omitempty"`
+}
+
+var ErrFlowNotRunning = errors.New("flow is not running")
+
+// Status returns statuses of all nodes in the order of the flow config.
+func (g *Graph) Status() []NodeStatus {
+	statuses := make([]NodeStatus, 0, len(g.nodeIDs))
+	for _, id := range g.nodeIDs {
+		node, state, err := g.runners[id].status()
+		status := NodeStatus{
+			ID:       id,
+			Type:     g.registry.nodeTypes[id],
+			State:    state,
+			Bypassed: g.runners[id].bypass.bypassed.Load(),
+		}
+		if err != nil {
+			status.Error = err.Error()
+		}
+		if reporter, ok := node.(flowapi.StatusReporter); ok {
+			status.Details = reporter.Status()
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
+
+// Signal calls a node signal written as a flow statement, e.g. $layer.set("nav").
+func (g *Graph) Signal(ctx context.Context, expr string) error {
+	stmt, err := flowdsl.ParseStatement(expr)
+	if err != nil {
+		return fmt.Errorf("failed to parse signal: %w", err)
+	}
+	handler, err := g.registry.SignalHandler(g.baseCtx, stmt)
+	if err != nil {
+		return err
+	}
+	handler(ctx)
+	if err := ctx.Err(); err != nil {
+		return fmt.Errorf("signal was not handled: %w", err)
+	}
+	return nil
+}
+
+// Inject sends an event with the usages to the downstream nodes of the node, as if the node
+// produced it.
+func (g *Graph) Inject(nodeID string, typ flowapi.HIDEventType, usages []hidapi.UsageEvent) error {
+	down, ok := g.down[nodeID]
+	if !ok {
+		return fmt.Errorf("node %s not found", nodeID)
+	}
+	event := hidapi.NewEventAt(clock.FromContext(g.baseCtx).Now())
+	event.AddUsage(usages...)
+	down.Broadcast(flowapi.Event{
+		Type: typ,
+		HID:  event,
+	})
+	return nil
+}
+
+func (s *Service) runningGraph() (*Graph, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.graph == nil {
+		return nil, ErrFlowNotRunning
+	}
+	return s.graph, nil
+}
+
+func (s *Service) Status() ([]NodeStatus, error) {
+	graph, err := s.runningGraph()
+	if err != nil {
+		return nil, err
+	}
+	return graph.Status(), nil
+}
+
+func (s *Service) Signal(ctx context.Context, expr string) error {
+	graph, err := s.runningGraph()
+	if err != nil {
+		return err
+	}
+	return graph.Signal(ctx, expr)
+}
+
+func (s *Service) Inject(nodeID string, typ flowapi.HIDEventType, usages []hidapi.UsageEvent) error {
+	graph, err := s.runningGraph()
+	if err != nil {
+		return err
+	}
+	return graph.Inject(nodeID, typ, usages)
+}
+
+// Reload reads the flow config and applies it like a change of the file would.
+func (s *Service) Reload() error {
+	cfg, err := configsvc.Load(s.flowPath, FlowConfig{})
+	if err != nil {
+		return err
+	}
+	return s.applyConfig(cfg)
+}
@@ -0,0 +1,253 @@
+package flowsvc
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/neuroplastio/neio-agent/flowapi"
+	"github.com/neuroplastio/neio-agent/flowapi/flowdsl"
+	"go.uber.org/zap"
+)
+
+// GraphExport is the node graph of a flow config.
+type GraphExport struct {
+	Nodes []GraphNode `json:"nodes"`
+	Edges []GraphEdge `json:"edges"`
+}
+
+type GraphNode struct {
+	ID       string         `json:"id"`
+	Type     string         `json:"type"`
+	Bindings []GraphBinding `json:"bindings,omitempty"`
+}
+
+type GraphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Label describes events routed to the downstream node, e.g. split patterns or the mux fallback.
+	Label string `json:"label,omitempty"`
+}
+
+type GraphBinding struct {
+	Usage      string           `json:"usage"`
+	Statement  string           `json:"statement"`
+	References []GraphReference `json:"references,omitempty"`
+}
+
+type ReferenceKind string
+
+const (
+	ReferenceKindAction ReferenceKind = "action"
+	ReferenceKindSignal ReferenceKind = "signal"
+)
+
+// GraphReference is an action or a signal of a node used by a binding.
+type GraphReference struct {
+	Node string        `json:"node"`
+	Name string        `json:"name"`
+	Kind ReferenceKind `json:"kind"`
+}
+
+func (r GraphReference) String() string {
+	return fmt.Sprintf("$%s.%s", r.Node, r.Name)
+}
+
+// ExportGraph validates the flow config and describes its node graph. Nodes are created to
+// register their actions and signals, but they are neither configured nor run.
+func ExportGraph(log *zap.Logger, registry *Registry, cfg FlowConfig) (GraphExport, error) {
+	b := NewGraphBuilder(log, registry, nil)
+	for _, node := range cfg.Nodes {
+		b = b.AddNode(node.Type, node.ID, node.To)
+	}
+	if err := b.Validate(); err != nil {
+		return GraphExport{}, fmt.Errorf("failed to validate graph: %w", err)
+	}
+	nodes := make(map[string]flowapi.Node, len(cfg.Nodes))
+	for _, node := range cfg.Nodes {
+		n, err := b.createNode(node.ID)
+		if err != nil {
+			return GraphExport{}, err
+		}
+		nodes[node.ID] = n
+	}
+
+	var export GraphExport
+	for _, node := range cfg.Nodes {
+		var desc flowapi.NodeGraphDescription
+		if describer, ok := nodes[node.ID].(flowapi.GraphDescriber); ok {
+			var err error
+			desc, err = describer.DescribeGraph(nodeConfigurator{
+				ctx:      context.Background(),
+				config:   node.Config,
+				registry: b.registry,
+			})
+			if err != nil {
+				return GraphExport{}, fmt.Errorf("failed to describe node %s: %w", node.ID, err)
+			}
+		}
+		for _, to := range node.To {
+			export.Edges = append(export.Edges, GraphEdge{
+				From:  node.ID,
+				To:    to,
+				Label: desc.Routes[to],
+			})
+		}
+		n := GraphNode{
+			ID:   node.ID,
+			Type: node.Type,
+		}
+		for _, binding := range desc.Bindings {
+			stmt, err := flowdsl.ParseStatement(binding.Statement)
+			if err != nil {
+				return GraphExport{}, fmt.Errorf("invalid statement of node %s: %s: %w", node.ID, binding.Statement, err)
+			}
+			refs, err := b.registry.references(stmt, ReferenceKindAction, nil)
+			if err != nil {
+				return GraphExport{}, fmt.Errorf("invalid binding of node %s: %s: %w", node.ID, binding.Usage, err)
+			}
+			n.Bindings = append(n.Bindings, GraphBinding{
+				Usage:      binding.Usage,
+				Statement:  binding.Statement,
+				References: refs,
+			})
+		}
+		slices.SortFunc(n.Bindings, func(a, b GraphBinding) int {
+			return strings.Compare(a.Usage, b.Usage)
+		})
+		export.Nodes = append(export.Nodes, n)
+	}
+	return export, nil
+}
+
+// references appends node actions and signals used by the statement, including statements
+// passed as Action and Signal arguments.
+func (g *GraphRegistry) references(stmt flowdsl.Statement, kind ReferenceKind, refs []GraphReference) ([]GraphReference, error) {
+	if stmt.Expr == nil {
+		return refs, nil
+	}
+	ident, err := g.parseIdentifier(stmt.Expr.Identifier)
+	if err != nil {
+		return nil, err
+	}
+	var decl flowdsl.Declaration
+	switch {
+	case ident.NodeID == nil && kind == ReferenceKindSignal:
+		return nil, fmt.Errorf("invalid signal identifier")
+	case ident.NodeID == nil:
+		reg, err := g.registry.getActionRegistration(ident.Name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get action %q: %w", ident.Name, err)
+		}
+		decl = reg.declaration
+	default:
+		var ok bool
+		if kind == ReferenceKindAction {
+			_, ok = g.actions[*ident.NodeID][ident.Name]
+		} else {
+			_, ok = g.signals[*ident.NodeID][ident.Name]
+			if !ok {
+				decl, ok = builtinDeclarations[ident.Name]
+			}
+		}
+		if !ok {
+			return nil, fmt.Errorf("%s %q not found in node %q", kind, ident.Name, *ident.NodeID)
+		}
+		if d, ok := g.declarations[*ident.NodeID][ident.Name]; ok {
+			decl = d
+		}
+		refs = append(refs, GraphReference{
+			Node: *ident.NodeID,
+			Name: ident.Name,
+			Kind: kind,
+		})
+	}
+	for i, arg := range stmt.Expr.Arguments {
+		if i >= len(decl.Parameters) || arg.Expr == nil {
+			continue
+		}
+		argStmt := flowdsl.Statement{Expr: arg.Expr}
+		switch decl.Parameters[i].Type {
+		case "Action":
+			refs, err = g.references(argStmt, ReferenceKindAction, refs)
+		case "Signal":
+			refs, err = g.references(argStmt, ReferenceKindSignal, refs)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return refs, nil
+}
+
+// WriteDOT writes the graph in the Graphviz DOT language. Bindings are drawn as dashed edges to
+// the nodes they reference.
+func WriteDOT(w io.Writer, g GraphExport) error {
+	var sb strings.Builder
+	sb.WriteString("digraph flow {\n")
+	sb.WriteString("\trankdir=LR;\n")
+	sb.WriteString("\tnode [shape=box];\n")
+	for _, node := range g.Nodes {
+		fmt.Fprintf(&sb, "\t%s [label=%s];\n", dotQuote(node.ID), dotQuote(node.ID+"\n"+node.Type))
+	}
+	for _, edge := range g.Edges {
+		fmt.Fprintf(&sb, "\t%s -> %s", dotQuote(edge.From), dotQuote(edge.To))
+		if edge.Label != "" {
+			fmt.Fprintf(&sb, " [label=%s]", dotQuote(edge.Label))
+		}
+		sb.WriteString(";\n")
+	}
+	for _, node := range g.Nodes {
+		for _, binding := range node.Bindings {
+			for _, ref := range binding.References {
+				label := fmt.Sprintf("%s: %s %s", binding.Usage, ref.Kind, ref.Name)
+				fmt.Fprintf(&sb, "\t%s -> %s [style=dashed, label=%s];\n", dotQuote(node.ID), dotQuote(ref.Node), dotQuote(label))
+			}
+		}
+	}
+	sb.WriteString("}\n")
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func dotQuote(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + r.Replace(s) + `"`
+}
+
+// WriteMermaid writes the graph as a Mermaid flowchart. Bindings are drawn as dotted edges to
+// the nodes they reference.
+func WriteMermaid(w io.Writer, g GraphExport) error {
+	ids := make(map[string]string, len(g.Nodes))
+	for i, node := range g.Nodes {
+		ids[node.ID] = fmt.Sprintf("n%d", i)
+	}
+	var sb strings.Builder
+	sb.WriteString("flowchart LR\n")
+	for _, node := range g.Nodes {
+		fmt.Fprintf(&sb, "\t%s[%s]\n", ids[node.ID], mermaidQuote(node.ID+"<br>"+node.Type))
+	}
+	for _, edge := range g.Edges {
+		if edge.Label != "" {
+			fmt.Fprintf(&sb, "\t%s -->|%s| %s\n", ids[edge.From], mermaidQuote(edge.Label), ids[edge.To])
+		} else {
+			fmt.Fprintf(&sb, "\t%s --> %s\n", ids[edge.From], ids[edge.To])
+		}
+	}
+	for _, node := range g.Nodes {
+		for _, binding := range node.Bindings {
+			for _, ref := range binding.References {
+				label := fmt.Sprintf("%s: %s %s", binding.Usage, ref.Kind, ref.Name)
+				fmt.Fprintf(&sb, "\t%s -.->|%s| %s\n", ids[node.ID], mermaidQuote(label), ids[ref.Node])
+			}
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+func mermaidQuote(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, "#quot;") + `"`
+}
@@ -0,0 +1,109 @@
+package flowsvc_test
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/neuroplastio/neio-agent/components/actions"
+	"github.com/neuroplastio/neio-agent/components/nodes"
+	"github.com/neuroplastio/neio-agent/internal/flowsvc"
+	"github.com/neuroplastio/neio-agent/internal/hidsvc"
+	"go.uber.org/zap"
+)
+
+const exportFlow = `
+nodes:
+  - id: kb
+    to: [keys]
+    input:
+      addr: linux/3297:1969.0
+
+  - id: keys
+    to: [binds, out]
+    split:
+      binds: [kb.*]
+      out: [btn.*, dsk.*]
+
+  - id: binds
+    to: [layer]
+    bind:
+      map:
+        F12: signal($mods.toggleBypass())
+        CapsLock: tapHold(Escape, $layer.switch("nav"))
+
+  - id: layer
+    to: [nav, mods]
+    mux: {}
+
+  - id: nav
+    to: [out]
+    bind:
+      map:
+        H: Left
+
+  - id: mods
+    to: [out]
+    bind:
+      map:
+        A: B
+
+  - id: out
+    output:
+      addr: linux/uhid:neio-kb
+`
+
+func TestExportGraph(t *testing.T) {
+	var cfg flowsvc.FlowConfig
+	if err := yaml.Unmarshal([]byte(exportFlow), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	log := zap.NewNop()
+	registry := flowsvc.NewRegistry()
+	nodes.Register(log, registry)
+	hidsvc.New(nil, log, time.Now).RegisterNodes(registry)
+	actions.Register(registry)
+
+	graph, err := flowsvc.ExportGraph(log, registry, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Nodes) != 7 || len(graph.Edges) != 8 {
+		t.Fatalf("unexpected graph: %d nodes, %d edges", len(graph.Nodes), len(graph.Edges))
+	}
+	labels := make(map[string]string)
+	for _, edge := range graph.Edges {
+		labels[edge.From+"->"+edge.To] = edge.Label
+	}
+	if labels["keys->out"] != "btn.*, dsk.*" || labels["layer->mods"] != "fallback" || labels["layer->nav"] != "" {
+		t.Errorf("unexpected edge labels: %v", labels)
+	}
+	binds := graph.Nodes[2]
+	if len(binds.Bindings) != 2 {
+		t.Fatalf("unexpected bindings: %v", binds.Bindings)
+	}
+	refs := make(map[string]flowsvc.ReferenceKind)
+	for _, binding := range binds.Bindings {
+		for _, ref := range binding.References {
+			refs[binding.Usage+" "+ref.String()] = ref.Kind
+		}
+	}
+	if len(refs) != 2 || refs["F12 $mods.toggleBypass"] != flowsvc.ReferenceKindSignal || refs["CapsLock $layer.switch"] != flowsvc.ReferenceKindAction {
+		t.Errorf("unexpected references: %v", refs)
+	}
+
+	var buf bytes.Buffer
+	if err := flowsvc.WriteDOT(&buf, graph); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `"binds" -> "mods" [style=dashed, label="F12: signal toggleBypass"];`) {
+		t.Errorf("missing reference edge in DOT:\n%s", buf.String())
+	}
+
+	cfg.Nodes[2].Config = []byte(`map: {F12: signal($layer.missing())}`)
+	if _, err := flowsvc.ExportGraph(log, registry, cfg); err == nil {
+		t.Error("expected unknown signal error")
+	}
+}
@@ -2,13 +2,15 @@ package flowsvc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"sync"
-	"time"
 
 	"github.com/neuroplastio/neio-agent/flowapi"
 	"github.com/neuroplastio/neio-agent/internal/configsvc"
 	"github.com/neuroplastio/neio-agent/pkg/bus"
+	"github.com/neuroplastio/neio-agent/pkg/clock"
+	"github.com/neuroplastio/neio-agent/pkg/metrics"
 	"go.uber.org/zap"
 )
 
@@ -17,6 +19,8 @@ type Service struct {
 	log      *zap.Logger
 	flowPath string
 
+	// applyMu serializes changes of the config, mu guards the running graph.
+	applyMu      sync.Mutex
 	mu           sync.Mutex
 	ctx          context.Context
 	graphCtx     context.Context
@@ -27,6 +31,7 @@ type Service struct {
 	graphRunning chan struct{}
 
 	registry *Registry
+	tracer   *Tracer
 }
 
 type (
@@ -44,6 +49,17 @@ type (
 		nodeIDs    []string
 		subscriber FlowSubscriber
 		publishers map[string]FlowPublisher
+		direction  FlowEventType
+		tracer     *Tracer
+		clock      clock.Clock
+
+		sent     *metrics.Counter
+		received map[string]*metrics.Counter
+
+		// bypass are bypass states of the nodes events are published to, held tracks usages held
+		// downstream of the node
+		bypass map[string]*nodeBypass
+		held   *nodeBypass
 	}
 )
 
@@ -52,10 +68,37 @@ const (
 	FlowEventUpstream
 )
 
-func newFlowStream(ctx context.Context, nodeID string, subscriber FlowSubscriber, publishers map[string]FlowPublisher) flowStream {
+func (t FlowEventType) String() string {
+	if t == FlowEventUpstream {
+		return "up"
+	}
+	return "down"
+}
+
+// flowEventLane puts events that only carry relative changes (pointer motion, wheel) on the bulk lane,
+// so that they never delay activations and releases.
+func flowEventLane(event flowapi.Event) bus.Lane {
+	if event.HID.IsRelative() {
+		return bus.LaneBulk
+	}
+	return bus.LanePriority
+}
+
+// mergeFlowEvents sums the deltas of relative events waiting for the same node.
+func mergeFlowEvents(dst, src flowapi.Event) (flowapi.Event, bool) {
+	if dst.Type != src.Type || !dst.HID.AddDeltas(src.HID) {
+		return dst, false
+	}
+	src.Release()
+	return dst, true
+}
+
+func newFlowStream(ctx context.Context, nodeID string, subscriber FlowSubscriber, publishers map[string]FlowPublisher, direction FlowEventType, tracer *Tracer) flowStream {
 	nodeIDs := make([]string, 0, len(publishers))
+	received := make(map[string]*metrics.Counter, len(publishers))
 	for nodeID := range publishers {
 		nodeIDs = append(nodeIDs, nodeID)
+		received[nodeID] = eventsInMetric.With(nodeID)
 	}
 	return flowStream{
 		ctx:        ctx,
@@ -63,20 +106,40 @@ func newFlowStream(ctx context.Context, nodeID string, subscriber FlowSubscriber
 		nodeIDs:    nodeIDs,
 		subscriber: subscriber,
 		publishers: publishers,
+		direction:  direction,
+		tracer:     tracer,
+		clock:      clock.FromContext(ctx),
+		sent:       eventsOutMetric.With(nodeID),
+		received:   received,
 	}
 }
 
 func (f flowStream) Publish(toNodeID string, msg flowapi.Event) {
-	// TODO: configurable timeout
-	ctx, cancel := context.WithTimeout(f.ctx, 100*time.Microsecond)
-	f.publishers[toNodeID](ctx, msg)
-	cancel()
+	if f.held != nil {
+		f.held.track(msg)
+	}
+	if bypass := f.bypass[toNodeID]; bypass != nil && !bypass.receive(f.direction, msg) {
+		bypass.forward(f.direction, msg)
+		return
+	}
+	if f.tracer.Enabled() {
+		f.tracer.publish(f.clock.Now(), f.nodeID, toNodeID, f.direction, msg)
+	}
+	f.sent.Inc()
+	f.received[toNodeID].Inc()
+	f.publishers[toNodeID](f.ctx, msg)
 }
 
 func (f flowStream) Broadcast(msg flowapi.Event) {
-	for _, nodeID := range f.nodeIDs {
-		f.Publish(nodeID, msg)
+	if len(f.nodeIDs) == 0 {
+		msg.Release()
+		return
+	}
+	last := len(f.nodeIDs) - 1
+	for _, nodeID := range f.nodeIDs[:last] {
+		f.Publish(nodeID, msg.Clone())
 	}
+	f.Publish(f.nodeIDs[last], msg)
 }
 
 func (f flowStream) Subscribe(ctx context.Context) <-chan flowapi.Event {
@@ -93,11 +156,29 @@ func New(
 		config:   config,
 		log:      log,
 		flowPath: flowPath,
-		bus:      bus.NewBus[FlowEventKey, flowapi.Event](log),
+		bus:      NewFlowBus(log),
 		registry: registry,
+		tracer:   NewTracer(),
 	}
 }
 
+// Tracer returns the tracer of the flow graph.
+func (s *Service) Tracer() *Tracer {
+	return s.tracer
+}
+
+// NewFlowBus creates the bus that carries events between nodes of a graph.
+func NewFlowBus(log *zap.Logger) *FlowBus {
+	b := bus.NewBus[FlowEventKey, flowapi.Event](log,
+		bus.WithLanes(flowEventLane, mergeFlowEvents),
+		bus.WithOwnership(flowapi.Event.Clone, flowapi.Event.Release),
+	)
+	b.OnDropped(func(key FlowEventKey) {
+		eventsDroppedMetric.With(key.NodeID, key.Type.String()).Inc()
+	})
+	return b
+}
+
 func (s *Service) Start(ctx context.Context) error {
 	s.ctx = ctx
 	select {
@@ -118,7 +199,9 @@ func (s *Service) Start(ctx context.Context) error {
 		return nil
 	case <-s.bus.Ready():
 	}
+	s.applyMu.Lock()
 	err = s.startGraph(cfg)
+	s.applyMu.Unlock()
 	if err != nil {
 		return fmt.Errorf("failed to compile flow: %w", err)
 	}
@@ -132,21 +215,34 @@ func (s *Service) onConfigChange(cfg FlowConfig, err error) {
 		s.log.Error("failed to parse config", zap.Error(err))
 		return
 	}
+	if err := s.applyConfig(cfg); err != nil {
+		s.log.Error("invalid graph configuration", zap.Error(err))
+	}
+}
+
+// applyConfig restarts the graph if its nodes or edges changed, otherwise it reconfigures nodes.
+func (s *Service) applyConfig(cfg FlowConfig) error {
+	s.applyMu.Lock()
+	defer s.applyMu.Unlock()
 	treeHash := cfg.treeHash()
 	if treeHash != s.graphHash {
 		s.log.Info("Configuration updated", zap.Uint64("hash", treeHash), zap.Uint64("old", s.graphHash))
-		err = s.restartGraph(cfg)
-		if err != nil {
-			s.log.Error("invalid graph configuration", zap.Error(err))
-		}
-		return
+		return s.restartGraph(cfg)
 	}
+	graph, err := s.runningGraph()
+	if err != nil {
+		return err
+	}
+	graph.setFlow(cfg)
+	var errs []error
 	for _, node := range cfg.Nodes {
-		err = s.graph.Configure(node.ID, node.Config)
+		err := graph.Configure(node.ID, node.Config)
 		if err != nil {
 			s.log.Error("failed to configure node", zap.String("node", node.ID), zap.Error(err))
+			errs = append(errs, fmt.Errorf("failed to configure node %s: %w", node.ID, err))
 		}
 	}
+	return errors.Join(errs...)
 }
 
 func (s *Service) restartGraph(cfg FlowConfig) error {
@@ -154,23 +250,14 @@ func (s *Service) restartGraph(cfg FlowConfig) error {
 	if err != nil {
 		return fmt.Errorf("failed to build graph: %w", err)
 	}
-	s.graphCancel()
-	<-s.graphRunning
-	s.graphHash = cfg.treeHash()
-	s.graphRunning = make(chan struct{})
-	s.graph = graph
-	s.graphCtx = graphCtx
-	s.graphCancel = graphCancel
-	go func() {
-		s.graph.Run()
-		s.log.Info("flow stopped")
-		s.graphCancel()
-		s.graph = nil
-		s.graphCtx = nil
-		s.graphCancel = nil
-		close(s.graphRunning)
-	}()
-
+	s.mu.Lock()
+	cancel, running := s.graphCancel, s.graphRunning
+	s.mu.Unlock()
+	if cancel != nil {
+		cancel()
+	}
+	<-running
+	s.runGraph(cfg, graph, graphCtx, graphCancel)
 	return nil
 }
 
@@ -179,45 +266,63 @@ func (s *Service) startGraph(cfg FlowConfig) error {
 	if err != nil {
 		return fmt.Errorf("failed to build graph: %w", err)
 	}
+	s.runGraph(cfg, graph, graphCtx, graphCancel)
+	return nil
+}
+
+func (s *Service) runGraph(cfg FlowConfig, graph *Graph, graphCtx context.Context, graphCancel context.CancelFunc) {
+	s.mu.Lock()
 	s.graphHash = cfg.treeHash()
 	s.graphRunning = make(chan struct{})
 	s.graph = graph
 	s.graphCtx = graphCtx
 	s.graphCancel = graphCancel
+	running := s.graphRunning
+	s.mu.Unlock()
 	go func() {
-		s.graph.Run()
+		graph.Run()
 		s.log.Info("flow stopped")
-		s.graphCancel()
+		graphCancel()
+		s.mu.Lock()
 		s.graph = nil
 		s.graphCtx = nil
 		s.graphCancel = nil
-		close(s.graphRunning)
+		s.mu.Unlock()
+		close(running)
 	}()
-
-	return nil
 }
 
 func (s *Service) buildGraph(cfg FlowConfig) (*Graph, context.Context, context.CancelFunc, error) {
-	b := NewGraphBuilder(s.log, s.registry, s.bus)
+	graphCtx, graphCancel := context.WithCancel(s.ctx)
+	graph, err := BuildGraph(graphCtx, s.log, s.registry, s.bus, cfg, s.tracer)
+	if err != nil {
+		graphCancel()
+		return nil, nil, nil, err
+	}
+	return graph, graphCtx, graphCancel, nil
+}
+
+// BuildGraph validates, builds and configures the graph of the flow config. Nodes run with ctx,
+// which must be cancelled to stop the graph. The bus must be started. The tracer may be nil.
+func BuildGraph(ctx context.Context, log *zap.Logger, registry *Registry, flowBus *FlowBus, cfg FlowConfig, tracer *Tracer) (*Graph, error) {
+	b := NewGraphBuilder(log, registry, flowBus).WithTracer(tracer)
 
 	for _, node := range cfg.Nodes {
 		b = b.AddNode(node.Type, node.ID, node.To)
 	}
 	if err := b.Validate(); err != nil {
-		return nil, nil, nil, fmt.Errorf("failed to validate graph: %w", err)
+		return nil, fmt.Errorf("failed to validate graph: %w", err)
 	}
-	graphCtx, graphCancel := context.WithCancel(s.ctx)
-	graph, err := b.Build(graphCtx)
+	graph, err := b.Build(ctx)
 	if err != nil {
-		graphCancel()
-		return nil, nil, nil, fmt.Errorf("failed to build graph: %w", err)
+		return nil, fmt.Errorf("failed to build graph: %w", err)
 	}
+	graph.setFlow(cfg)
 	for _, node := range cfg.Nodes {
 		err := graph.Configure(node.ID, node.Config)
 		if err != nil {
-			graphCancel()
-			return nil, nil, nil, fmt.Errorf("failed to configure node %s: %w", node.ID, err)
+			return nil, fmt.Errorf("failed to configure node %s: %w", node.ID, err)
 		}
 	}
-	return graph, graphCtx, graphCancel, nil
+	return graph, nil
 }
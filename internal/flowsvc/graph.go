@@ -6,11 +6,13 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/goccy/go-yaml"
 	"github.com/neuroplastio/neio-agent/flowapi"
 	"github.com/neuroplastio/neio-agent/flowapi/flowdsl"
 	"github.com/neuroplastio/neio-agent/hidapi"
+	"github.com/neuroplastio/neio-agent/pkg/clock"
 	"go.uber.org/zap"
 )
 
@@ -18,6 +20,7 @@ type GraphBuilder struct {
 	log      *zap.Logger
 	registry *GraphRegistry
 	bus      *FlowBus
+	tracer   *Tracer
 
 	idMap   map[string]struct{}
 	nodeIDs []string
@@ -52,6 +55,12 @@ func NewGraphBuilder(log *zap.Logger, reg *Registry, bus *FlowBus) GraphBuilder
 	}
 }
 
+// WithTracer passes events published between nodes of the graph to the tracer.
+func (g GraphBuilder) WithTracer(tracer *Tracer) GraphBuilder {
+	g.tracer = tracer
+	return g
+}
+
 func (g GraphBuilder) AddNode(typ string, id string, to []string) GraphBuilder {
 	if _, ok := g.idMap[id]; ok {
 		g.errors = append(g.errors, fmt.Errorf("multiple instances of node with id %q", id))
@@ -162,9 +171,15 @@ func (g GraphBuilder) validateCycles(id string, visited map[string]struct{}) err
 func (g GraphBuilder) Build(ctx context.Context) (*Graph, error) {
 	up := make(map[string]flowapi.Stream, len(g.nodeIDs))
 	down := make(map[string]flowapi.Stream, len(g.nodeIDs))
+	bypass := make(map[string]*nodeBypass, len(g.nodeIDs))
+	for _, id := range g.nodeIDs {
+		bypass[id] = newNodeBypass(len(g.edgesDown[id]))
+	}
 	for _, id := range g.nodeIDs {
-		up[id] = g.createStream(ctx, id, g.edgesUp[id], true)
-		down[id] = g.createStream(ctx, id, g.edgesDown[id], false)
+		up[id] = g.createStream(ctx, id, g.edgesUp[id], true, bypass)
+		down[id] = g.createStream(ctx, id, g.edgesDown[id], false, bypass)
+		bypass[id].up, bypass[id].down = up[id], down[id]
+		bypass[id].in = g.bus.CreatePublisher(FlowEventKey{NodeID: id, Type: FlowEventDownstream})
 	}
 	graph := &Graph{
 		log:      g.log,
@@ -174,6 +189,7 @@ func (g GraphBuilder) Build(ctx context.Context) (*Graph, error) {
 		makeNode: g.createNode,
 		up:       up,
 		down:     down,
+		bypass:   bypass,
 		configs:  make(map[string]json.RawMessage),
 	}
 	err := graph.initRunners()
@@ -306,15 +322,39 @@ type Graph struct {
 	nodeIDs  []string
 	up       map[string]flowapi.Stream
 	down     map[string]flowapi.Stream
+	bypass   map[string]*nodeBypass
 
 	configs map[string]json.RawMessage
 	runners map[string]*nodeRunner
+
+	// analysis is the static analysis of the flow, run when a node asks for its upstream usages.
+	analysis func() (*flowAnalysis, error)
+}
+
+// setFlow sets the flow config the graph is configured with.
+func (g *Graph) setFlow(cfg FlowConfig) {
+	g.analysis = sync.OnceValues(func() (*flowAnalysis, error) {
+		return analyzeFlow(g.log, g.registry.registry, cfg)
+	})
+}
+
+func (g *Graph) upstream(nodeID string) (flowapi.UsageSet, bool) {
+	if g.analysis == nil {
+		return flowapi.NewUsageSet(), false
+	}
+	analysis, err := g.analysis()
+	if err != nil {
+		g.log.Warn("Failed to analyze flow", zap.Error(err))
+		return flowapi.NewUsageSet(), false
+	}
+	return analysis.upstream(nodeID)
 }
 
 type nodeConfigurator struct {
 	ctx      context.Context
 	config   json.RawMessage
 	registry *GraphRegistry
+	upstream func() (flowapi.UsageSet, bool)
 }
 
 func (r nodeConfigurator) Unmarshal(to any) error {
@@ -333,6 +373,13 @@ func (r nodeConfigurator) Context() context.Context {
 	return r.ctx
 }
 
+func (r nodeConfigurator) Upstream() (flowapi.UsageSet, bool) {
+	if r.upstream == nil {
+		return flowapi.NewUsageSet(), false
+	}
+	return r.upstream()
+}
+
 func (g *Graph) Configure(nodeID string, config json.RawMessage) error {
 	runner, ok := g.runners[nodeID]
 	if !ok {
@@ -342,6 +389,9 @@ func (g *Graph) Configure(nodeID string, config json.RawMessage) error {
 		ctx:      runner.ctx,
 		config:   config,
 		registry: g.registry,
+		upstream: func() (flowapi.UsageSet, bool) {
+			return g.upstream(nodeID)
+		},
 	}
 	oldConfig, ok := g.configs[nodeID]
 	if ok {
@@ -368,9 +418,9 @@ func (g *Graph) Configure(nodeID string, config json.RawMessage) error {
 	return runner.node.Configure(configurator)
 }
 
-func (g *GraphBuilder) createStream(ctx context.Context, nodeID string, nodes []string, reverse bool) flowapi.Stream {
+func (g *GraphBuilder) createStream(ctx context.Context, nodeID string, nodes []string, reverse bool, bypass map[string]*nodeBypass) flowapi.Stream {
 	if len(nodes) == 0 {
-		return newFlowStream(ctx, nodeID, nil, nil)
+		return newFlowStream(ctx, nodeID, nil, nil, FlowEventDownstream, nil)
 	}
 	t1 := FlowEventUpstream
 	t2 := FlowEventDownstream
@@ -395,7 +445,15 @@ func (g *GraphBuilder) createStream(ctx context.Context, nodeID string, nodes []
 	for _, key := range pubKeys {
 		pub[key.NodeID] = g.bus.CreatePublisher(key)
 	}
-	return newFlowStream(ctx, nodeID, sub, pub)
+	stream := newFlowStream(ctx, nodeID, sub, pub, t2, g.tracer)
+	stream.bypass = make(map[string]*nodeBypass, len(nodes))
+	for _, id := range nodes {
+		stream.bypass[id] = bypass[id]
+	}
+	if !reverse {
+		stream.held = bypass[nodeID]
+	}
+	return stream
 }
 
 func (g *Graph) initRunners() error {
@@ -405,7 +463,8 @@ func (g *Graph) initRunners() error {
 		if err != nil {
 			return fmt.Errorf("failed to create node %s: %w", id, err)
 		}
-		runner := newNodeRunner(g.baseCtx, g.log, node, g.up[id], g.down[id])
+		runner := newNodeRunner(g.baseCtx, g.log, node, g.up[id], g.down[id], g.bypass[id])
+		g.registry.registerBuiltinSignals(id, runner)
 		g.runners[id] = runner
 	}
 	return nil
@@ -570,18 +629,31 @@ type nodeRunner struct {
 	ctx     context.Context
 	cancel  context.CancelFunc
 	running chan struct{}
+
+	mu    sync.Mutex
+	state NodeState
+	err   error
+
+	bypass *nodeBypass
 }
 
-func newNodeRunner(ctx context.Context, log *zap.Logger, node flowapi.Node, up flowapi.Stream, down flowapi.Stream) *nodeRunner {
+func newNodeRunner(ctx context.Context, log *zap.Logger, node flowapi.Node, up flowapi.Stream, down flowapi.Stream, bypass *nodeBypass) *nodeRunner {
 	runnerCtx, cancel := context.WithCancel(ctx)
 	return &nodeRunner{
 		log:        log,
 		node:       node,
+		upstream:   up,
+		downstream: down,
 		baseCtx:    ctx,
 		ctx:        runnerCtx,
 		cancel:     cancel,
-		upstream:   up,
-		downstream: down,
+		bypass:     bypass,
+	}
+}
+
+func (n *nodeRunner) setBypassed(bypassed bool) {
+	if n.bypass.set(n.baseCtx, bypassed, clock.FromContext(n.baseCtx).Now()) {
+		n.log.Info("Node bypass changed", zap.Bool("bypassed", bypassed))
 	}
 }
 
@@ -590,24 +662,44 @@ func (n *nodeRunner) start() {
 	go func() {
 		defer func() {
 			n.cancel()
-			close(n.running)
 			if r := recover(); r != nil {
 				n.log.Error("Node panic", zap.Any("panic", r))
+				n.setState(NodeStateFailed, fmt.Errorf("panic: %v", r))
 			}
+			close(n.running)
 		}()
 		n.log.Debug("Starting node")
+		n.setState(NodeStateRunning, nil)
 		err := n.node.Run(n.ctx, n.upstream, n.downstream)
 		if err != nil {
 			n.log.Error("Node failed", zap.Error(err))
+			n.setState(NodeStateFailed, err)
+			return
 		}
+		n.setState(NodeStateStopped, nil)
 	}()
 }
 
+func (n *nodeRunner) setState(state NodeState, err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.state = state
+	n.err = err
+}
+
+func (n *nodeRunner) status() (flowapi.Node, NodeState, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.node, n.state, n.err
+}
+
 func (n *nodeRunner) replaceNode(newCtx context.Context, newCancel context.CancelFunc, node flowapi.Node) {
 	n.log.Debug("Replacing node")
 	n.cancel()
 	<-n.running
+	n.mu.Lock()
 	n.node = node
+	n.mu.Unlock()
 	n.ctx, n.cancel = newCtx, newCancel
 	n.running = make(chan struct{})
 	n.start()
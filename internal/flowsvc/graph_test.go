@@ -0,0 +1,103 @@
+package flowsvc_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/neuroplastio/neio-agent/flowapi"
+	"github.com/neuroplastio/neio-agent/hidapi"
+	"github.com/neuroplastio/neio-agent/internal/flowsvc"
+	"go.uber.org/zap"
+)
+
+// funcNodeType is a node type running the function, standing in for input and output nodes.
+type funcNodeType struct {
+	desc flowapi.NodeTypeDescriptor
+	run  func(ctx context.Context, up flowapi.Stream, down flowapi.Stream) error
+}
+
+func (f funcNodeType) Descriptor() flowapi.NodeTypeDescriptor {
+	return f.desc
+}
+
+func (f funcNodeType) CreateNode(p flowapi.NodeProvider) (flowapi.Node, error) {
+	return funcNode(f), nil
+}
+
+type funcNode funcNodeType
+
+func (f funcNode) Configure(c flowapi.NodeConfigurator) error {
+	return nil
+}
+
+func (f funcNode) Run(ctx context.Context, up flowapi.Stream, down flowapi.Stream) error {
+	return f.run(ctx, up, down)
+}
+
+func TestGraphBackpressure(t *testing.T) {
+	// more presses and releases than fit in the bus queue and the mailbox of the slow node
+	const count = 8192
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	log := zap.NewNop()
+	usage := hidapi.NewUsage(0x07, 0x04)
+
+	registry := flowsvc.NewRegistry()
+	registry.MustRegisterNodeType("source", funcNodeType{
+		desc: flowapi.NodeTypeDescriptor{UpstreamType: flowapi.NodeLinkTypeNone, DownstreamType: flowapi.NodeLinkTypeMany},
+		run: func(ctx context.Context, up flowapi.Stream, down flowapi.Stream) error {
+			for i := 0; i < count; i++ {
+				event := hidapi.NewEvent()
+				if i%2 == 0 {
+					event.Activate(usage)
+				} else {
+					event.Deactivate(usage)
+				}
+				down.Broadcast(flowapi.Event{Type: flowapi.HIDEventTypeInput, HID: event})
+			}
+			<-ctx.Done()
+			return nil
+		},
+	})
+	received := make(chan int)
+	registry.MustRegisterNodeType("sink", funcNodeType{
+		desc: flowapi.NodeTypeDescriptor{UpstreamType: flowapi.NodeLinkTypeMany, DownstreamType: flowapi.NodeLinkTypeNone},
+		run: func(ctx context.Context, up flowapi.Stream, down flowapi.Stream) error {
+			in := up.Subscribe(ctx)
+			// fall behind the source
+			time.Sleep(100 * time.Millisecond)
+			n := 0
+			timeout := time.After(5 * time.Second)
+			for n < count {
+				select {
+				case event := <-in:
+					n += len(event.HID.Usages())
+					event.Release()
+				case <-timeout:
+					received <- n
+					return nil
+				}
+			}
+			received <- n
+			return nil
+		},
+	})
+
+	flowBus := flowsvc.NewFlowBus(log)
+	if err := flowBus.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	<-flowBus.Ready()
+	graph, err := flowsvc.NewGraphBuilder(log, registry, flowBus).
+		AddNode("source", "in", []string{"out"}).
+		AddNode("sink", "out", nil).
+		Build(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go graph.Run()
+	if n := <-received; n != count {
+		t.Fatalf("expected %d presses and releases, received %d", count, n)
+	}
+}
@@ -0,0 +1,9 @@
+package flowsvc
+
+import "github.com/neuroplastio/neio-agent/pkg/metrics"
+
+var (
+	eventsInMetric      = metrics.NewCounter("neio_flow_events_in_total", "Events published to a node.", "node")
+	eventsOutMetric     = metrics.NewCounter("neio_flow_events_out_total", "Events published by a node.", "node")
+	eventsDroppedMetric = metrics.NewCounter("neio_flow_events_dropped_total", "Events dropped before they were received by a node.", "node", "direction")
+)
@@ -55,6 +55,9 @@ func (a *Registry) RegisterNodeType(typ string, node flowapi.NodeType) error {
 		if _, ok := registration.declarations[decl.Identifier]; ok {
 			return fmt.Errorf("identifier %s already registered for node %s", decl.Identifier, typ)
 		}
+		if _, ok := builtinDeclarations[decl.Identifier]; ok {
+			return fmt.Errorf("identifier %s of node %s is reserved for a builtin signal", decl.Identifier, typ)
+		}
 		registration.actions[decl.Identifier] = decl
 		registration.declarations[decl.Identifier] = decl
 	}
@@ -66,6 +69,9 @@ func (a *Registry) RegisterNodeType(typ string, node flowapi.NodeType) error {
 		if _, ok := registration.declarations[decl.Identifier]; ok {
 			return fmt.Errorf("identifier %s already registered for node %s", decl.Identifier, typ)
 		}
+		if _, ok := builtinDeclarations[decl.Identifier]; ok {
+			return fmt.Errorf("identifier %s of node %s is reserved for a builtin signal", decl.Identifier, typ)
+		}
 		registration.signals[decl.Identifier] = decl
 		registration.declarations[decl.Identifier] = decl
 	}
@@ -0,0 +1,265 @@
+package flowsvc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/neuroplastio/neio-agent/flowapi"
+	"github.com/neuroplastio/neio-agent/hidapi"
+)
+
+// TraceEdge selects events between a node and its upstream or downstream nodes, in both directions.
+type TraceEdge uint8
+
+const (
+	TraceEdgeAny TraceEdge = iota
+	TraceEdgeUp
+	TraceEdgeDown
+)
+
+func ParseTraceEdge(str string) (TraceEdge, error) {
+	switch str {
+	case "", "any":
+		return TraceEdgeAny, nil
+	case "up":
+		return TraceEdgeUp, nil
+	case "down":
+		return TraceEdgeDown, nil
+	}
+	return TraceEdgeAny, fmt.Errorf("unknown edge %q, expected up, down or any", str)
+}
+
+type TraceFilter struct {
+	// Node is the traced node. All events are traced if it is empty.
+	Node string
+	Edge TraceEdge
+}
+
+func (f TraceFilter) match(from, to string, direction FlowEventType) bool {
+	if f.Node == "" {
+		return true
+	}
+	// up is the node that is upstream on the edge
+	up, down := from, to
+	if direction == FlowEventUpstream {
+		up, down = to, from
+	}
+	switch f.Edge {
+	case TraceEdgeUp:
+		return down == f.Node
+	case TraceEdgeDown:
+		return up == f.Node
+	default:
+		return up == f.Node || down == f.Node
+	}
+}
+
+// TraceRecord is a copy of an event published from one node to another.
+type TraceRecord struct {
+	Time      time.Time
+	From      string
+	To        string
+	Direction FlowEventType
+	Type      flowapi.HIDEventType
+	Usages    []hidapi.UsageEvent
+	// Created is the time the HID event was created at.
+	Created time.Time
+	// Hops are the nodes that passed the event on, the last one is From.
+	Hops []hidapi.Hop
+}
+
+// Latencies returns the time each hop took since the previous one, starting from the creation
+// of the event.
+func (r TraceRecord) Latencies() []time.Duration {
+	latencies := make([]time.Duration, len(r.Hops))
+	prev := r.Created
+	for i, hop := range r.Hops {
+		latencies[i] = hop.At.Sub(prev)
+		prev = hop.At
+	}
+	return latencies
+}
+
+func (r TraceRecord) String() string {
+	var sb strings.Builder
+	arrow := "->"
+	if r.Direction == FlowEventUpstream {
+		arrow = "<-"
+	}
+	fmt.Fprintf(&sb, "%s %s %s %s [%s] ", r.Time.Format("15:04:05.000000"), r.From, arrow, r.To, r.TypeName())
+	for i, usage := range r.Usages {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		sb.WriteString(usage.String())
+	}
+	sb.WriteString("  path:")
+	for i, latency := range r.Latencies() {
+		fmt.Fprintf(&sb, " %s(+%s)", r.Hops[i].Node, latency)
+	}
+	return sb.String()
+}
+
+func (r TraceRecord) TypeName() string {
+	switch r.Type {
+	case flowapi.HIDEventTypeInput:
+		return "input"
+	case flowapi.HIDEventTypeOutput:
+		return "output"
+	case flowapi.HIDEventTypeFeature:
+		return "feature"
+	}
+	return fmt.Sprintf("type %d", r.Type)
+}
+
+// Tracer copies events published between nodes to subscribers. Hops are only recorded while
+// there are subscribers.
+type Tracer struct {
+	enabled atomic.Bool
+
+	mu          sync.Mutex
+	subscribers []*traceSubscriber
+}
+
+type traceSubscriber struct {
+	filter  TraceFilter
+	ch      chan TraceRecord
+	dropped atomic.Uint64
+}
+
+func NewTracer() *Tracer {
+	return &Tracer{}
+}
+
+// Subscribe returns records matching the filter until the context is cancelled. Records are dropped
+// when the subscriber falls behind by more than the buffer size.
+func (t *Tracer) Subscribe(ctx context.Context, filter TraceFilter, buffer int) <-chan TraceRecord {
+	sub := &traceSubscriber{
+		filter: filter,
+		ch:     make(chan TraceRecord, buffer),
+	}
+	t.mu.Lock()
+	t.subscribers = append(t.subscribers, sub)
+	t.enabled.Store(true)
+	t.mu.Unlock()
+	go func() {
+		<-ctx.Done()
+		t.mu.Lock()
+		t.subscribers = slices.DeleteFunc(t.subscribers, func(s *traceSubscriber) bool {
+			return s == sub
+		})
+		t.enabled.Store(len(t.subscribers) > 0)
+		close(sub.ch)
+		t.mu.Unlock()
+	}()
+	return sub.ch
+}
+
+func (t *Tracer) Enabled() bool {
+	return t != nil && t.enabled.Load()
+}
+
+// publish records the hop and passes a copy of the event to matching subscribers.
+func (t *Tracer) publish(now time.Time, from, to string, direction FlowEventType, event flowapi.Event) {
+	if event.HID == nil {
+		return
+	}
+	event.HID.AddHop(from, now)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, sub := range t.subscribers {
+		if !sub.filter.match(from, to, direction) {
+			continue
+		}
+		record := TraceRecord{
+			Time:      now,
+			From:      from,
+			To:        to,
+			Direction: direction,
+			Type:      event.Type,
+			Usages:    slices.Clone(event.HID.Usages()),
+			Created:   event.HID.Timestamp(),
+			Hops:      slices.Clone(event.HID.Hops()),
+		}
+		select {
+		case sub.ch <- record:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+type perfettoEvent struct {
+	Name  string         `json:"name"`
+	Cat   string         `json:"cat,omitempty"`
+	Phase string         `json:"ph"`
+	Ts    int64          `json:"ts"`
+	Dur   int64          `json:"dur,omitempty"`
+	Pid   int            `json:"pid"`
+	Tid   int            `json:"tid"`
+	Args  map[string]any `json:"args,omitempty"`
+}
+
+// WritePerfetto writes records in the Chrome trace event format, which can be opened in Perfetto.
+// Each record is a slice on the track of the node that published it, starting when the event
+// was received by the node.
+func WritePerfetto(w io.Writer, records []TraceRecord) error {
+	var start time.Time
+	for _, r := range records {
+		if !r.Created.IsZero() && (start.IsZero() || r.Created.Before(start)) {
+			start = r.Created
+		}
+	}
+	micros := func(t time.Time) int64 {
+		return t.Sub(start).Microseconds()
+	}
+	tids := make(map[string]int)
+	events := make([]perfettoEvent, 0, len(records))
+	for _, r := range records {
+		if len(r.Hops) == 0 {
+			continue
+		}
+		tid, ok := tids[r.From]
+		if !ok {
+			tid = len(tids) + 1
+			tids[r.From] = tid
+			events = append(events, perfettoEvent{
+				Name:  "thread_name",
+				Phase: "M",
+				Pid:   1,
+				Tid:   tid,
+				Args:  map[string]any{"name": r.From},
+			})
+		}
+		usages := make([]string, len(r.Usages))
+		for i, usage := range r.Usages {
+			usages[i] = usage.String()
+		}
+		latencies := r.Latencies()
+		latency := latencies[len(latencies)-1]
+		events = append(events, perfettoEvent{
+			Name:  strings.Join(usages, ", "),
+			Cat:   r.TypeName(),
+			Phase: "X",
+			Ts:    micros(r.Time.Add(-latency)),
+			Dur:   max(latency.Microseconds(), 1),
+			Pid:   1,
+			Tid:   tid,
+			Args:  map[string]any{"to": r.To},
+		})
+	}
+	err := json.NewEncoder(w).Encode(struct {
+		TraceEvents []perfettoEvent `json:"traceEvents"`
+	}{events})
+	if err != nil {
+		return fmt.Errorf("failed to write trace: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package flowsvc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/neuroplastio/neio-agent/flowapi"
+	"github.com/neuroplastio/neio-agent/hidapi"
+	"github.com/neuroplastio/neio-agent/pkg/clock"
+)
+
+func TestTracer(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	start := time.Unix(1700000000, 0)
+	clk := clock.NewVirtual(start)
+	ctx = clock.WithContext(ctx, clk)
+
+	tracer := NewTracer()
+	records := tracer.Subscribe(ctx, TraceFilter{Node: "mods", Edge: TraceEdgeUp}, 10)
+
+	var received []flowapi.Event
+	collect := func(ctx context.Context, event flowapi.Event) {
+		received = append(received, event)
+	}
+	kb := newFlowStream(ctx, "kb", nil, map[string]FlowPublisher{"mods": collect}, FlowEventDownstream, tracer)
+	mods := newFlowStream(ctx, "mods", nil, map[string]FlowPublisher{"out": collect}, FlowEventDownstream, tracer)
+
+	hid := hidapi.NewEventAt(start)
+	hid.Activate(hidapi.NewUsage(0x07, 0x04))
+	clk.Advance(time.Millisecond)
+	kb.Publish("mods", flowapi.Event{HID: hid})
+	clk.Advance(2 * time.Millisecond)
+	mods.Publish("out", received[0])
+
+	if hops := received[1].HID.Hops(); len(hops) != 2 || hops[0].Node != "kb" || hops[1].Node != "mods" {
+		t.Fatalf("unexpected hops: %v", hops)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record for the up edge of mods, got %d", len(records))
+	}
+	record := <-records
+	if record.From != "kb" || record.To != "mods" {
+		t.Errorf("unexpected edge: %s -> %s", record.From, record.To)
+	}
+	if latencies := record.Latencies(); len(latencies) != 1 || latencies[0] != time.Millisecond {
+		t.Errorf("unexpected latencies: %v", latencies)
+	}
+
+	var buf bytes.Buffer
+	if err := WritePerfetto(&buf, []TraceRecord{record}); err != nil {
+		t.Fatal(err)
+	}
+	var trace struct {
+		TraceEvents []perfettoEvent `json:"traceEvents"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &trace); err != nil {
+		t.Fatal(err)
+	}
+	if len(trace.TraceEvents) != 2 || trace.TraceEvents[1].Ts != 0 || trace.TraceEvents[1].Dur != 1000 {
+		t.Errorf("unexpected trace: %s", buf.String())
+	}
+
+	cancel()
+	if _, ok := <-records; ok {
+		t.Error("expected records to be closed")
+	}
+	if tracer.Enabled() {
+		t.Error("expected tracer to be disabled without subscribers")
+	}
+}
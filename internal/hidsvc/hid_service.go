@@ -1,6 +1,7 @@
 package hidsvc
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -11,6 +12,7 @@ import (
 	"github.com/dgraph-io/badger"
 	"github.com/goccy/go-yaml"
 	"github.com/neuroplastio/neio-agent/flowapi"
+	"github.com/neuroplastio/neio-agent/hidapi/hiddesc"
 	"github.com/neuroplastio/neio-agent/pkg/bus"
 	"github.com/puzpuzpuz/xsync/v3"
 	"go.uber.org/zap"
@@ -162,10 +164,9 @@ func (s *Service) Start(ctx context.Context) error {
 }
 
 func (s *Service) consumeEvents(ctx context.Context) {
+	// subscribe before backends are started, so that their first events are not missed
+	ch := s.backendBus.Subscribe(ctx)
 	go func() {
-		ctx, cancel := context.WithCancel(ctx)
-		defer cancel()
-		ch := s.backendBus.Subscribe(ctx)
 		for {
 			select {
 			case <-ctx.Done():
@@ -221,6 +222,7 @@ func (s *Service) onBackendInputsChanged(ctx context.Context, backendID string,
 func (s *Service) onInputDisconnected(ctx context.Context, backendID, id string) {
 	addr := Address{Backend: backendID, ID: id}
 	s.connectedInputs.Delete(addr)
+	disconnectsMetric.With(addr.String(), "input").Inc()
 	s.log.Debug("input disconnected", zap.String("backend", backendID), zap.String("id", id))
 	s.inputBus.Publish(ctx, InputBusKey{
 		Type: InputDisconnected,
@@ -236,6 +238,7 @@ func (s *Service) onInputConnected(ctx context.Context, backendID string, bdev B
 	}
 	s.log.Debug("input connected", zap.String("backend", backendID), zap.String("id", dev.Address.ID), zap.String("name", dev.Name), zap.Time("firstSeenAt", dev.FirstSeenAt))
 	s.connectedInputs.Store(dev.Address, struct{}{})
+	connectsMetric.With(dev.Address.String(), "input").Inc()
 	s.inputBus.Publish(ctx, InputBusKey{
 		Type: InputConnected,
 		Addr: dev.Address,
@@ -254,6 +257,7 @@ func (s *Service) onBackendOutputsChanged(ctx context.Context, backendID string,
 func (s *Service) onOutputDisconnected(ctx context.Context, backendID, id string) {
 	addr := Address{Backend: backendID, ID: id}
 	s.connectedOutputs.Delete(addr)
+	disconnectsMetric.With(addr.String(), "output").Inc()
 	s.log.Debug("output disconnected", zap.String("backend", backendID), zap.String("id", id))
 	s.outputBus.Publish(ctx, OutputBusKey{
 		Type: OutputDisconnected,
@@ -269,6 +273,7 @@ func (s *Service) onOutputConnected(ctx context.Context, backendID string, bdev
 	}
 	s.log.Debug("output connected", zap.String("backend", backendID), zap.String("id", dev.Address.ID), zap.String("name", dev.Name), zap.Time("firstSeenAt", dev.FirstSeenAt))
 	s.connectedOutputs.Store(dev.Address, nil)
+	connectsMetric.With(dev.Address.String(), "output").Inc()
 	s.outputBus.Publish(ctx, OutputBusKey{
 		Type: OutputConnected,
 		Addr: dev.Address,
@@ -299,17 +304,49 @@ func (s *Service) initializeInputDevice(backendID string, bdev BackendDevice) (H
 	if err != nil {
 		return HidInputDevice{}, fmt.Errorf("failed to get report descriptor: %w", err)
 	}
-	var dev HidInputDevice
 	now := s.now()
-	err = s.db.Update(func(txn *badger.Txn) error {
-		addr := Address{Backend: backendID, ID: bdev.ID}
+	return s.storeInputDevice(Address{Backend: backendID, ID: bdev.ID}, desc, func(dev *HidInputDevice, found bool) {
+		if !found {
+			dev.Name = bdev.Name
+		}
+		dev.BackendDevice = bdev
+		if dev.FirstSeenAt.IsZero() {
+			dev.FirstSeenAt = now
+		}
+		dev.LastSeenAt = now
+	})
+}
+
+// ImportInputDevice stores the report descriptor of an input device that was not necessarily connected
+// before, so that outputs can be composed of it. Times of imported devices that were never seen are zero.
+func (s *Service) ImportInputDevice(addr Address, name string, desc []byte) (HidInputDevice, error) {
+	if _, err := hiddesc.Decode(desc); err != nil {
+		return HidInputDevice{}, fmt.Errorf("failed to decode report descriptor: %w", err)
+	}
+	return s.storeInputDevice(addr, desc, func(dev *HidInputDevice, found bool) {
+		if name != "" {
+			dev.Name = name
+		} else if !found {
+			dev.Name = addr.ID
+		}
+		if dev.BackendDevice.ID == "" {
+			dev.BackendDevice = BackendDevice{ID: addr.ID, Name: dev.Name}
+		}
+	})
+}
+
+// storeInputDevice saves the input device, updated by the update function, and its report descriptor.
+func (s *Service) storeInputDevice(addr Address, desc []byte, update func(dev *HidInputDevice, found bool)) (HidInputDevice, error) {
+	var (
+		dev      HidInputDevice
+		previous []byte
+	)
+	err := s.db.Update(func(txn *badger.Txn) error {
 		key := s.inputDeviceKey(addr)
 		item, err := txn.Get(key)
 		switch {
 		case errors.Is(err, badger.ErrKeyNotFound):
-			dev = HidInputDevice{
-				Name: bdev.Name,
-			}
+			update(&dev, false)
 		case err != nil:
 			return err
 		default:
@@ -319,13 +356,9 @@ func (s *Service) initializeInputDevice(backendID string, bdev BackendDevice) (H
 			if err != nil {
 				return fmt.Errorf("failed to unmarshal device: %w", err)
 			}
+			update(&dev, true)
 		}
 		dev.Address = addr
-		dev.BackendDevice = bdev
-		if dev.FirstSeenAt.IsZero() {
-			dev.FirstSeenAt = now
-		}
-		dev.LastSeenAt = now
 		b, err := json.Marshal(dev)
 		if err != nil {
 			return fmt.Errorf("failed to marshal device: %w", err)
@@ -335,6 +368,12 @@ func (s *Service) initializeInputDevice(backendID string, bdev BackendDevice) (H
 			return fmt.Errorf("failed to save device: %w", err)
 		}
 		descKey := s.reportDescriptorKey(addr)
+		if item, err := txn.Get(descKey); err == nil {
+			previous, err = item.ValueCopy(nil)
+			if err != nil {
+				return fmt.Errorf("failed to read descriptor: %w", err)
+			}
+		}
 		err = txn.Set(descKey, desc)
 		if err != nil {
 			return fmt.Errorf("failed to save descriptor: %w", err)
@@ -344,9 +383,33 @@ func (s *Service) initializeInputDevice(backendID string, bdev BackendDevice) (H
 	if err != nil {
 		return HidInputDevice{}, fmt.Errorf("failed to fetch device: %w", err)
 	}
+	if previous != nil && !bytes.Equal(previous, desc) {
+		s.logDescriptorChange(dev.Address, previous, desc)
+	}
 	return dev, nil
 }
 
+// logDescriptorChange warns about report descriptors changed since the device was connected before,
+// e.g. by a firmware update. Outputs composed of the stored descriptor are outdated.
+func (s *Service) logDescriptorChange(addr Address, previous, current []byte) {
+	log := s.log.With(zap.String("addr", addr.String()))
+	previousDesc, err := hiddesc.Decode(previous)
+	if err != nil {
+		log.Warn("report descriptor changed, stored descriptor is invalid", zap.Error(err))
+		return
+	}
+	currentDesc, err := hiddesc.Decode(current)
+	if err != nil {
+		log.Warn("report descriptor changed, new descriptor is invalid", zap.Error(err))
+		return
+	}
+	diffs := hiddesc.Diff(previousDesc, currentDesc)
+	if len(diffs) == 0 {
+		return
+	}
+	log.Warn("report descriptor changed, outputs composed of it are outdated until the flow is reloaded", zap.Stringers("differences", diffs))
+}
+
 func (s *Service) initializeOutputDevice(backendID string, bdev BackendDevice) (HidOutputDevice, error) {
 	var dev HidOutputDevice
 	now := s.now()
@@ -434,6 +497,14 @@ type Backend interface {
 	OpenOutputDevice(id string, handler OutputDeviceHandler, descriptor []byte) (OutputDevice, error)
 }
 
+// DescriptorSizeLimiter is implemented by backends that limit the size of report descriptors of
+// output devices.
+type DescriptorSizeLimiter interface {
+	// MaxDescriptorSize returns the size limit of the report descriptor of the output device, 0 if
+	// there is none.
+	MaxDescriptorSize(id string) int
+}
+
 type Address struct {
 	Backend string `yaml:"backend" json:"backend"`
 	ID      string `yaml:"id" json:"id"`
@@ -539,6 +610,31 @@ func (s *Service) ListInputDevices() ([]HidInputDevice, error) {
 	return devices, nil
 }
 
+func (s *Service) ListOutputDevices() ([]HidOutputDevice, error) {
+	var devices []HidOutputDevice
+	err := s.db.View(func(txn *badger.Txn) error {
+		iter := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+		prefix := []byte("hid/outputs/")
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			item := iter.Item()
+			var dev HidOutputDevice
+			err := item.Value(func(val []byte) error {
+				return json.Unmarshal(val, &dev)
+			})
+			if err != nil {
+				return err
+			}
+			devices = append(devices, dev)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list devices: %w", err)
+	}
+	return devices, nil
+}
+
 func (s *Service) GetInputDevice(addr Address) (HidInputDevice, error) {
 	var dev HidInputDevice
 	err := s.db.View(func(txn *badger.Txn) error {
@@ -612,6 +708,16 @@ func (s *Service) OpenOutputDevice(addr Address, handler OutputDeviceHandler, de
 	return dev, nil
 }
 
+// MaxDescriptorSize returns the size limit of the report descriptor of the output device, 0 if there
+// is none.
+func (s *Service) MaxDescriptorSize(addr Address) int {
+	limiter, ok := s.options.backends[addr.Backend].(DescriptorSizeLimiter)
+	if !ok {
+		return 0
+	}
+	return limiter.MaxDescriptorSize(addr.ID)
+}
+
 func (s *Service) IsInputConnected(addr Address) bool {
 	if _, ok := s.connectedInputs.Load(addr); ok {
 		return true
@@ -455,6 +455,13 @@ type GetReportRequest struct {
 
 const uhidReportSize = 4096
 
+// uhidMaxDescriptorSize is HID_MAX_DESCRIPTOR_SIZE of the kernel.
+const uhidMaxDescriptorSize = 4096
+
+func (b *Backend) MaxDescriptorSize(id string) int {
+	return uhidMaxDescriptorSize
+}
+
 type GetReportReply struct {
 	EventType uhid.EventType
 	RequestID uint32
@@ -0,0 +1,14 @@
+package hidsvc
+
+import "github.com/neuroplastio/neio-agent/pkg/metrics"
+
+var (
+	reportsReadMetric    = metrics.NewCounter("neio_hid_reports_read_total", "Reports read from a device.", "addr")
+	reportsWrittenMetric = metrics.NewCounter("neio_hid_reports_written_total", "Reports written to a device.", "addr")
+	connectsMetric       = metrics.NewCounter("neio_hid_connects_total", "Device connections.", "addr", "kind")
+	disconnectsMetric    = metrics.NewCounter("neio_hid_disconnects_total", "Device disconnections.", "addr", "kind")
+
+	outputLatencyMetric = metrics.NewHistogram("neio_output_latency_seconds", "Time from the creation of an input event until its reports are queued by an output node.", metrics.DefaultBuckets, "node")
+	pacingWaitMetric    = metrics.NewHistogram("neio_output_pacing_wait_seconds", "Time output reports were delayed by pacing.", metrics.DefaultBuckets, "node")
+	outputDroppedMetric = metrics.NewCounter("neio_output_dropped_total", "Report batches dropped because the output queue was full.", "node")
+)
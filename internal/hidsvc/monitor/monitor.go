@@ -0,0 +1,271 @@
+// Package monitor prints usage events decoded from raw reports of an input device.
+package monitor
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/neuroplastio/neio-agent/hidapi"
+	"github.com/neuroplastio/neio-agent/hidapi/hiddesc"
+	"github.com/neuroplastio/neio-agent/internal/hidsvc"
+	"github.com/neuroplastio/neio-agent/pkg/clock"
+	"go.uber.org/zap"
+)
+
+type Format string
+
+const (
+	FormatText Format = "text"
+	FormatJSON Format = "json"
+)
+
+func ParseFormat(str string) (Format, error) {
+	switch Format(str) {
+	case FormatText, FormatJSON:
+		return Format(str), nil
+	}
+	return "", fmt.Errorf("unknown format %q, expected text or json", str)
+}
+
+var defaultOptions = options{
+	format:        FormatText,
+	statsInterval: time.Second,
+	clock:         clock.Real(),
+}
+
+type options struct {
+	format        Format
+	statsInterval time.Duration
+	clock         clock.Clock
+}
+
+type Option func(*options)
+
+func WithFormat(format Format) Option {
+	return func(o *options) {
+		o.format = format
+	}
+}
+
+// WithStatsInterval sets how often the report rate and jitter are printed. Zero only prints them
+// when the monitor stops.
+func WithStatsInterval(d time.Duration) Option {
+	return func(o *options) {
+		o.statsInterval = d
+	}
+}
+
+func WithClock(c clock.Clock) Option {
+	return func(o *options) {
+		o.clock = c
+	}
+}
+
+// Monitor reads reports of a device that is not acquired, so that the device keeps working.
+type Monitor struct {
+	log     *zap.Logger
+	dev     hidsvc.InputDevice
+	out     io.Writer
+	options options
+
+	state *hidapi.ReportState
+	start time.Time
+	last  time.Time
+
+	window Stats
+	total  Stats
+}
+
+// New decodes the report descriptor of the device and reads its current input reports, so that
+// the first printed events are changes from the current state.
+func New(log *zap.Logger, dev hidsvc.InputDevice, out io.Writer, opts ...Option) (*Monitor, error) {
+	options := defaultOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	descRaw, err := dev.GetReportDescriptor()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get report descriptor: %w", err)
+	}
+	desc, err := hiddesc.Decode(descRaw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode report descriptor: %w", err)
+	}
+	state := hidapi.NewReportState(log, hidapi.NewDataItemSet(desc).WithType(hiddesc.MainItemTypeInput), hidapi.WithReportClock(options.clock))
+	events, err := state.InitReports(dev.GetInputReport)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read input reports: %w", err)
+	}
+	for _, event := range events {
+		event.Release()
+	}
+	return &Monitor{
+		log:     log,
+		dev:     dev,
+		out:     out,
+		options: options,
+		state:   state,
+	}, nil
+}
+
+// Stats describe timing of reports.
+type Stats struct {
+	Reports int `json:"reports"`
+	// Rate is the number of reports per second.
+	Rate float64 `json:"rate"`
+	// Interval is the mean time between reports and Jitter is its standard deviation.
+	Interval time.Duration `json:"interval"`
+	Jitter   time.Duration `json:"jitter"`
+
+	start time.Time
+	end   time.Time
+	// mean and m2 accumulate intervals in seconds with Welford's algorithm.
+	mean      float64
+	m2        float64
+	intervals int
+}
+
+func (s *Stats) add(at time.Time, interval time.Duration, hasInterval bool) {
+	if s.Reports == 0 {
+		s.start = at
+	}
+	s.Reports++
+	s.end = at
+	if hasInterval {
+		s.intervals++
+		x := interval.Seconds()
+		delta := x - s.mean
+		s.mean += delta / float64(s.intervals)
+		s.m2 += delta * (x - s.mean)
+	}
+	if elapsed := s.end.Sub(s.start); elapsed > 0 {
+		s.Rate = float64(s.intervals) / elapsed.Seconds()
+	}
+	s.Interval = time.Duration(s.mean * float64(time.Second))
+	if s.intervals > 1 {
+		s.Jitter = time.Duration(math.Sqrt(s.m2/float64(s.intervals-1)) * float64(time.Second))
+	}
+}
+
+func (s Stats) String() string {
+	return fmt.Sprintf("%d reports, %.1f reports/s, interval %s ± %s", s.Reports, s.Rate, s.Interval, s.Jitter)
+}
+
+type reportLine struct {
+	Time     time.Time     `json:"time"`
+	Elapsed  time.Duration `json:"elapsed"`
+	Interval time.Duration `json:"interval"`
+	Data     string        `json:"data"`
+	Events   []string      `json:"events"`
+}
+
+type statsLine struct {
+	Stats Stats `json:"stats"`
+	Final bool  `json:"final,omitempty"`
+}
+
+// Run prints reports until the context is cancelled or the device fails. The device is closed
+// when the context is cancelled.
+func (m *Monitor) Run(ctx context.Context) error {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			m.dev.Close()
+		case <-done:
+		}
+	}()
+	buf := make([]byte, 4096)
+	for {
+		n, err := m.dev.Read(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return m.printStats(m.total, true)
+			}
+			return fmt.Errorf("failed to read report: %w", err)
+		}
+		if err := m.handleReport(buf[:n]); err != nil {
+			return err
+		}
+	}
+}
+
+func (m *Monitor) handleReport(report []byte) error {
+	now := m.options.clock.Now()
+	var interval time.Duration
+	hasInterval := !m.last.IsZero()
+	if hasInterval {
+		interval = now.Sub(m.last)
+	} else {
+		m.start = now
+	}
+	m.last = now
+	m.window.add(now, interval, hasInterval && m.window.Reports > 0)
+	m.total.add(now, interval, hasInterval)
+
+	event := m.state.ApplyReport(report)
+	defer event.Release()
+	line := reportLine{
+		Time:     now,
+		Elapsed:  now.Sub(m.start),
+		Interval: interval,
+		Data:     hex.EncodeToString(report),
+		Events:   make([]string, 0, len(event.Usages())),
+	}
+	for _, usage := range event.Usages() {
+		line.Events = append(line.Events, usage.String())
+	}
+	if err := m.printReport(line); err != nil {
+		return err
+	}
+	if m.options.statsInterval > 0 && m.window.end.Sub(m.window.start) >= m.options.statsInterval {
+		if err := m.printStats(m.window, false); err != nil {
+			return err
+		}
+		m.window = Stats{}
+	}
+	return nil
+}
+
+func (m *Monitor) printReport(line reportLine) error {
+	var err error
+	switch m.options.format {
+	case FormatJSON:
+		err = json.NewEncoder(m.out).Encode(line)
+	default:
+		events := "(no changes)"
+		if len(line.Events) > 0 {
+			events = strings.Join(line.Events, ", ")
+		}
+		_, err = fmt.Fprintf(m.out, "%12s  %-32s  %s\n", line.Elapsed.Round(time.Microsecond), line.Data, events)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to print report: %w", err)
+	}
+	return nil
+}
+
+func (m *Monitor) printStats(stats Stats, final bool) error {
+	var err error
+	switch m.options.format {
+	case FormatJSON:
+		err = json.NewEncoder(m.out).Encode(statsLine{Stats: stats, Final: final})
+	default:
+		prefix := "--"
+		if final {
+			prefix = "== total:"
+		}
+		_, err = fmt.Fprintf(m.out, "%s %s\n", prefix, stats)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to print stats: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/neuroplastio/neio-agent/internal/hidsvc/virtual"
+	"github.com/neuroplastio/neio-agent/pkg/clock"
+	"go.uber.org/zap"
+)
+
+// lineWriter passes every written line to a channel.
+type lineWriter struct {
+	mu    sync.Mutex
+	buf   bytes.Buffer
+	lines chan string
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf.Write(p)
+	for {
+		line, err := w.buf.ReadString('\n')
+		if err != nil {
+			w.buf.WriteString(line)
+			return len(p), nil
+		}
+		w.lines <- strings.TrimSuffix(line, "\n")
+	}
+}
+
+func TestMonitorJSON(t *testing.T) {
+	backend := virtual.NewBackend(zap.NewNop())
+	input, err := backend.AddInputFile("kb", "Keyboard", "../../../testdata/zsa-moonlander/1.desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, err := backend.OpenInputDevice("kb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	vclock := clock.NewVirtual(time.Unix(0, 0))
+	out := &lineWriter{lines: make(chan string, 16)}
+	m, err := New(zap.NewNop(), dev, out,
+		WithFormat(FormatJSON),
+		WithStatsInterval(20*time.Millisecond),
+		WithClock(vclock),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- m.Run(ctx)
+	}()
+
+	reports := [][]byte{
+		{0x02, 0, 0x04, 0, 0, 0, 0, 0},
+		{0x02, 0, 0, 0, 0, 0, 0, 0},
+		{0, 0, 0, 0, 0, 0, 0, 0},
+	}
+	intervals := []time.Duration{0, 8 * time.Millisecond, 12 * time.Millisecond}
+	var lines []reportLine
+	for i, report := range reports {
+		vclock.Advance(intervals[i])
+		if err := input.Inject(report); err != nil {
+			t.Fatal(err)
+		}
+		line := reportLine{}
+		if err := json.Unmarshal([]byte(<-out.lines), &line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	if got := strings.Join(lines[0].Events, ", "); got != "+LeftShift, +A" {
+		t.Errorf("unexpected events of the first report: %s", got)
+	}
+	if got := strings.Join(lines[2].Events, ", "); got != "-LeftShift" {
+		t.Errorf("unexpected events of the last report: %s", got)
+	}
+	if lines[2].Elapsed != 20*time.Millisecond || lines[2].Interval != 12*time.Millisecond {
+		t.Errorf("unexpected timing %s, %s", lines[2].Elapsed, lines[2].Interval)
+	}
+
+	stats := statsLine{}
+	if err := json.Unmarshal([]byte(<-out.lines), &stats); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Stats.Reports != 3 || stats.Stats.Rate != 100 || stats.Stats.Interval != 10*time.Millisecond {
+		t.Errorf("unexpected stats %+v", stats.Stats)
+	}
+	if jitter := stats.Stats.Jitter; jitter < 2828*time.Microsecond || jitter > 2829*time.Microsecond {
+		t.Errorf("unexpected jitter %s", jitter)
+	}
+
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	final := statsLine{}
+	if err := json.Unmarshal([]byte(<-out.lines), &final); err != nil || !final.Final {
+		t.Fatalf("expected final stats: %v", err)
+	}
+}
@@ -2,11 +2,13 @@ package hidsvc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 
 	"github.com/neuroplastio/neio-agent/flowapi"
 	"github.com/neuroplastio/neio-agent/hidapi"
 	"github.com/neuroplastio/neio-agent/hidapi/hiddesc"
+	"github.com/neuroplastio/neio-agent/pkg/clock"
 	"go.uber.org/zap"
 )
 
@@ -54,6 +56,27 @@ func (g *InputNode) Configure(c flowapi.NodeConfigurator) error {
 	return nil
 }
 
+// AnalyzeUsages sends usages of the input reports of the stored report descriptor of the device.
+func (g *InputNode) AnalyzeUsages(a flowapi.UsageAnalysis) error {
+	cfg := inputConfig{}
+	if err := a.Unmarshal(&cfg); err != nil {
+		return fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+	descRaw, err := g.hid.GetReportDescriptor(cfg.Addr)
+	if err != nil {
+		a.Report(flowapi.FindingUnknown, "report descriptor of %s is not known, connect the device or import its descriptor to analyze its usages", cfg.Addr)
+		a.SendUnknown()
+		return nil
+	}
+	desc, err := hiddesc.Decode(descRaw)
+	if err != nil {
+		return fmt.Errorf("failed to decode HID report descriptor: %w", err)
+	}
+	layout := hidapi.NewReportLayout(hidapi.NewDataItemSet(desc).WithType(hiddesc.MainItemTypeInput))
+	a.Broadcast(flowapi.NewUsageSet(layout.Usages()...))
+	return nil
+}
+
 func (g *InputNode) handleDevice(ctx context.Context, down flowapi.Stream) {
 	defer close(g.done)
 	dev, err := g.hid.OpenInputDevice(g.addr)
@@ -74,21 +97,22 @@ func (g *InputNode) handleDevice(ctx context.Context, down flowapi.Stream) {
 		return
 	}
 	itemSet := hidapi.NewDataItemSet(desc)
-	inputState := hidapi.NewReportState(g.log.Named("input"), itemSet.WithType(hiddesc.MainItemTypeInput))
+	reportClock := hidapi.WithReportClock(clock.FromContext(ctx))
+	inputState := hidapi.NewReportState(g.log.Named("input"), itemSet.WithType(hiddesc.MainItemTypeInput), reportClock)
 	inputEvents, err := inputState.InitReports(dev.GetInputReport)
 	if err != nil {
 		dev.Close()
 		g.log.Error("Failed to initialize input reports", zap.Error(err))
 		return
 	}
-	featureState := hidapi.NewReportState(g.log.Named("feature"), itemSet.WithType(hiddesc.MainItemTypeFeature))
+	featureState := hidapi.NewReportState(g.log.Named("feature"), itemSet.WithType(hiddesc.MainItemTypeFeature), reportClock)
 	featureEvents, err := featureState.InitReports(dev.GetFeatureReport)
 	if err != nil {
 		dev.Close()
 		g.log.Error("Failed to initialize feature reports", zap.Error(err))
 		return
 	}
-	outputState := hidapi.NewReportState(g.log.Named("output"), itemSet.WithType(hiddesc.MainItemTypeOutput))
+	outputState := hidapi.NewReportState(g.log.Named("output"), itemSet.WithType(hiddesc.MainItemTypeOutput), reportClock)
 
 	release, err := dev.Acquire()
 	if err != nil {
@@ -97,6 +121,8 @@ func (g *InputNode) handleDevice(ctx context.Context, down flowapi.Stream) {
 		return
 	}
 	g.log.Info("Input device acquired", zap.String("addr", g.addr.String()))
+	reportsRead := reportsReadMetric.With(g.addr.String())
+	reportsWritten := reportsWrittenMetric.With(g.addr.String())
 	downEvents := down.Subscribe(ctx)
 
 	for _, event := range inputEvents {
@@ -118,6 +144,9 @@ func (g *InputNode) handleDevice(ctx context.Context, down flowapi.Stream) {
 		buf := make([]byte, 2048) // TODO: calculate from the descriptor (only for standard input devices)
 		for {
 			n, err := dev.Read(buf)
+			if errors.Is(err, context.Canceled) {
+				return
+			}
 			if err != nil {
 				g.log.Error("Failed to read from device, releasing", zap.Error(err))
 				return
@@ -126,12 +155,15 @@ func (g *InputNode) handleDevice(ctx context.Context, down flowapi.Stream) {
 				return
 			}
 			if n > 0 {
+				reportsRead.Inc()
 				event := inputState.ApplyReport(buf[:n])
 				if !event.IsEmpty() {
 					down.Broadcast(flowapi.Event{
 						Type: flowapi.HIDEventTypeInput,
 						HID:  event,
 					})
+				} else {
+					event.Release()
 				}
 			}
 		}
@@ -148,7 +180,9 @@ func (g *InputNode) handleDevice(ctx context.Context, down flowapi.Stream) {
 						_, err := dev.Write(report)
 						if err != nil {
 							g.log.Error("Failed to write output report", zap.Error(err))
+							continue
 						}
+						reportsWritten.Inc()
 					}
 				case flowapi.HIDEventTypeFeature:
 					reports := featureState.ApplyEvent(event.HID)
@@ -156,11 +190,14 @@ func (g *InputNode) handleDevice(ctx context.Context, down flowapi.Stream) {
 						_, err := dev.SetFeatureReport(report)
 						if err != nil {
 							g.log.Error("Failed to write feature report", zap.Error(err))
+							continue
 						}
+						reportsWritten.Inc()
 					}
 				default:
 					g.log.Error("Unknown HID event type", zap.Any("type", event.Type))
 				}
+				event.Release()
 			case <-ctx.Done():
 				return
 			}
@@ -4,10 +4,16 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/neuroplastio/neio-agent/flowapi"
 	"github.com/neuroplastio/neio-agent/hidapi"
 	"github.com/neuroplastio/neio-agent/hidapi/hiddesc"
+	"github.com/neuroplastio/neio-agent/pkg/clock"
 	"go.uber.org/zap"
 )
 
@@ -27,19 +33,65 @@ func (o OutputNodeType) Descriptor() flowapi.NodeTypeDescriptor {
 
 func (o OutputNodeType) CreateNode(p flowapi.NodeProvider) (flowapi.Node, error) {
 	return &OutputNode{
-		id:  p.Info().ID,
-		log: o.log.With(zap.String("nodeId", p.Info().ID)),
-		hid: o.hid,
+		id:   p.Info().ID,
+		log:  o.log.With(zap.String("nodeId", p.Info().ID)),
+		hid:  o.hid,
+		held: make(map[hidapi.Usage]struct{}),
 	}, nil
 }
 
 type outputConfig struct {
 	Addr       Address                `yaml:"addr"`
 	Descriptor outputDescriptorConfig `yaml:"descriptor"`
+	Pacing     outputPacingConfig     `yaml:"pacing"`
+}
+
+type outputPacingConfig struct {
+	// Interval is the minimum interval between reports that activate or deactivate usages.
+	Interval time.Duration `yaml:"interval"`
+	// UsageInterval is the minimum interval between state changes of the same usage.
+	UsageInterval time.Duration `yaml:"usageInterval"`
 }
 
 type outputDescriptorConfig struct {
 	Inputs []Address `yaml:"inputs"`
+	// File is a report descriptor in the text or raw format, composed after the inputs.
+	File string `yaml:"file"`
+	// Templates are collections added after collections of the inputs.
+	Templates []outputTemplateConfig `yaml:"templates"`
+	// Augment is "auto" to add collections for usages sent to the output that the descriptor
+	// of the inputs cannot encode.
+	Augment string `yaml:"augment"`
+	// PushPop wraps collections in Push and Pop items when it makes the descriptor smaller.
+	PushPop bool `yaml:"pushPop"`
+}
+
+const augmentAuto = "auto"
+
+type outputTemplateConfig struct {
+	Type       hidapi.TemplateType `yaml:"type"`
+	ReportID   uint8               `yaml:"reportId"`
+	Buttons    int                 `yaml:"buttons"`
+	Count      int                 `yaml:"count"`
+	Axes       int                 `yaml:"axes"`
+	LEDs       bool                `yaml:"leds"`
+	HiResWheel bool                `yaml:"hiResWheel"`
+	NoHat      bool                `yaml:"noHat"`
+	Maximum    int32               `yaml:"maximum"`
+}
+
+func (c outputTemplateConfig) template() hidapi.DescriptorTemplate {
+	return hidapi.DescriptorTemplate{
+		Type:       c.Type,
+		ReportID:   c.ReportID,
+		Buttons:    c.Buttons,
+		Count:      c.Count,
+		Axes:       c.Axes,
+		LEDs:       c.LEDs,
+		HiResWheel: c.HiResWheel,
+		NoHat:      c.NoHat,
+		Maximum:    c.Maximum,
+	}
 }
 
 type OutputNode struct {
@@ -50,10 +102,58 @@ type OutputNode struct {
 	addr    Address
 	desc    hiddesc.ReportDescriptor
 	descRaw []byte
+	// composed maps report IDs of the descriptor to report IDs of the inputs.
+	composed hidapi.ComposedDescriptor
 
 	inputState   *hidapi.ReportState
 	outputState  *hidapi.ReportState
 	featureState *hidapi.ReportState
+
+	scheduler *hidapi.OutputScheduler
+	clock     clock.Clock
+
+	// held are the usages activated by input events, for the status.
+	heldMu sync.Mutex
+	held   map[hidapi.Usage]struct{}
+}
+
+type OutputStatus struct {
+	Addr      Address  `json:"addr"`
+	Connected bool     `json:"connected"`
+	Held      []string `json:"held"`
+	Queued    int      `json:"queued"`
+}
+
+func (o *OutputNode) Status() any {
+	o.heldMu.Lock()
+	held := make([]string, 0, len(o.held))
+	for usage := range o.held {
+		held = append(held, usage.String())
+	}
+	o.heldMu.Unlock()
+	slices.Sort(held)
+	status := OutputStatus{
+		Addr:      o.addr,
+		Connected: o.hid.IsOutputConnected(o.addr),
+		Held:      held,
+	}
+	if o.scheduler != nil {
+		status.Queued = o.scheduler.Len()
+	}
+	return status
+}
+
+func (o *OutputNode) updateHeld(event *hidapi.Event) {
+	o.heldMu.Lock()
+	defer o.heldMu.Unlock()
+	for _, usage := range event.Usages() {
+		switch usage.Type {
+		case hidapi.UsageEventActivate:
+			o.held[usage.Usage] = struct{}{}
+		case hidapi.UsageEventDeactivate:
+			delete(o.held, usage.Usage)
+		}
+	}
 }
 
 func (o *OutputNode) Configure(c flowapi.NodeConfigurator) error {
@@ -62,20 +162,52 @@ func (o *OutputNode) Configure(c flowapi.NodeConfigurator) error {
 		return fmt.Errorf("failed to unmarshal config: %w", err)
 	}
 	o.addr = cfg.Addr
-	desc, err := o.buildDescriptor(cfg.Descriptor)
+	composed, err := o.buildDescriptor(cfg.Descriptor)
 	if err != nil {
 		return fmt.Errorf("failed to build HID report descriptor: %w", err)
 	}
+	o.composed = composed
+	desc := composed.Descriptor
+	if cfg.Descriptor.Augment == augmentAuto {
+		usages, complete := c.Upstream()
+		if !complete {
+			o.log.Warn("Usages sent to the output are not fully known, descriptor may miss some of them")
+		}
+		var unsupported []hidapi.Usage
+		desc, unsupported = hidapi.AugmentDescriptor(desc, usages.Sorted())
+		if len(unsupported) > 0 {
+			o.log.Warn("Descriptor cannot be augmented with usages", zap.Stringers("usages", unsupported))
+		}
+	}
 	o.desc = desc
-	o.descRaw, err = hiddesc.Encode(desc)
+	encodeOpts := []hiddesc.DescriptorEncoderOption{hiddesc.WithMaxSize(o.hid.MaxDescriptorSize(o.addr))}
+	if cfg.Descriptor.PushPop {
+		encodeOpts = append(encodeOpts, hiddesc.WithPushPop())
+	}
+	o.descRaw, err = hiddesc.Encode(desc, encodeOpts...)
 	if err != nil {
 		return fmt.Errorf("failed to encode HID report descriptor: %w", err)
 	}
 
+	o.clock = clock.FromContext(c.Context())
 	itemSet := hidapi.NewDataItemSet(o.desc)
-	o.inputState = hidapi.NewReportState(o.log.Named("input"), itemSet.WithType(hiddesc.MainItemTypeInput))
-	o.outputState = hidapi.NewReportState(o.log.Named("output"), itemSet.WithType(hiddesc.MainItemTypeOutput))
-	o.featureState = hidapi.NewReportState(o.log.Named("feature"), itemSet.WithType(hiddesc.MainItemTypeFeature))
+	reportClock := hidapi.WithReportClock(o.clock)
+	o.inputState = hidapi.NewReportState(o.log.Named("input"), itemSet.WithType(hiddesc.MainItemTypeInput), reportClock)
+	o.outputState = hidapi.NewReportState(o.log.Named("output"), itemSet.WithType(hiddesc.MainItemTypeOutput), reportClock)
+	o.featureState = hidapi.NewReportState(o.log.Named("feature"), itemSet.WithType(hiddesc.MainItemTypeFeature), reportClock)
+
+	pacingWait := pacingWaitMetric.With(o.id)
+	opts := []hidapi.SchedulerOption{
+		hidapi.WithSchedulerClock(o.clock),
+		hidapi.WithWaitObserver(pacingWait.ObserveDuration),
+	}
+	if cfg.Pacing.Interval > 0 {
+		opts = append(opts, hidapi.WithMinInterval(cfg.Pacing.Interval))
+	}
+	if cfg.Pacing.UsageInterval > 0 {
+		opts = append(opts, hidapi.WithUsageInterval(cfg.Pacing.UsageInterval))
+	}
+	o.scheduler = hidapi.NewOutputScheduler(o.log.Named("scheduler"), opts...)
 
 	return nil
 }
@@ -114,7 +246,7 @@ func (o *outDevHandler) SetFeatureReport(reportID uint8, data []byte) error {
 	return nil
 }
 
-func (o *OutputNode) handleDevice(ctx context.Context, up flowapi.Stream, inputCh chan [][]byte) {
+func (o *OutputNode) handleDevice(ctx context.Context, up flowapi.Stream) {
 	handler := &outDevHandler{
 		inputState:   o.inputState,
 		outputState:  o.outputState,
@@ -126,6 +258,8 @@ func (o *OutputNode) handleDevice(ctx context.Context, up flowapi.Stream, inputC
 		return
 	}
 	defer dev.Close()
+	reportsRead := reportsReadMetric.With(o.addr.String())
+	reportsWritten := reportsWrittenMetric.With(o.addr.String())
 
 	go func() {
 		// Output report reader
@@ -139,75 +273,119 @@ func (o *OutputNode) handleDevice(ctx context.Context, up flowapi.Stream, inputC
 				o.log.Error("Failed to read output report", zap.Error(err))
 				return
 			}
-			o.log.Debug("Read output report", zap.Any("size", n), zap.Any("reportID", buf[0]))
+			if source, ok := o.composed.Source(buf[0]); ok {
+				o.log.Debug("Read output report", zap.Int("size", n), zap.Uint8("reportID", buf[0]),
+					zap.String("source", source.Source), zap.Uint8("sourceReportID", source.SourceID))
+			} else {
+				o.log.Debug("Read output report", zap.Int("size", n), zap.Uint8("reportID", buf[0]))
+			}
 			if ctx.Err() != nil {
 				return
 			}
+			reportsRead.Inc()
 			event := o.outputState.ApplyReport(buf[:n])
 			if !event.IsEmpty() {
 				up.Broadcast(flowapi.Event{
 					Type: flowapi.HIDEventTypeOutput,
 					HID:  event,
 				})
+			} else {
+				event.Release()
 			}
 		}
 	}()
 
-	// Input And Feature Reports
-	for {
-		select {
-		case reports := <-inputCh:
-			for _, report := range reports {
-				_, err := dev.Write(report)
-				if err != nil {
-					o.log.Error("Failed to write output report", zap.Error(err))
-				}
-			}
-		case <-ctx.Done():
-			return
+	// Input Reports
+	// reports queued while the device was disconnected are stale, the host reads the current state
+	o.scheduler.Reset()
+	o.scheduler.Run(ctx, func(report []byte) error {
+		if _, err := dev.Write(report); err != nil {
+			return err
 		}
+		reportsWritten.Inc()
+		return nil
+	})
+	o.scheduler.Reset()
+}
+
+// AnalyzeUsages reports received usages that cannot be encoded in the input reports of the
+// descriptor of the output device.
+func (o *OutputNode) AnalyzeUsages(a flowapi.UsageAnalysis) error {
+	cfg := outputConfig{}
+	if err := a.Unmarshal(&cfg); err != nil {
+		return fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+	composed, err := o.buildDescriptor(cfg.Descriptor)
+	if err != nil {
+		a.Report(flowapi.FindingUnknown, "report descriptor is not known: %v", err)
+		return nil
+	}
+	desc := composed.Descriptor
+	in, _ := a.Upstream()
+	if cfg.Descriptor.Augment == augmentAuto {
+		desc, _ = hidapi.AugmentDescriptor(desc, in.Sorted())
+	}
+	layout := hidapi.NewReportLayout(hidapi.NewDataItemSet(desc).WithType(hiddesc.MainItemTypeInput))
+	var unencodable []string
+	for _, usage := range in.Sorted() {
+		if !layout.Contains(usage) {
+			unencodable = append(unencodable, usage.String())
+		}
+	}
+	if len(unencodable) > 0 {
+		a.Report(flowapi.FindingUnencodable, "usages cannot be encoded by %s: %s", cfg.Addr, strings.Join(unencodable, ", "))
 	}
+	return nil
 }
 
-func (o *OutputNode) buildDescriptor(cfg outputDescriptorConfig) (hiddesc.ReportDescriptor, error) {
-	desc := hiddesc.ReportDescriptor{}
-	if len(cfg.Inputs) == 0 {
-		return desc, fmt.Errorf("no input devices specified")
+// buildDescriptor composes descriptors of the inputs and adds templates. Report IDs of the inputs are
+// mapped to report IDs of the output descriptor.
+func (o *OutputNode) buildDescriptor(cfg outputDescriptorConfig) (hidapi.ComposedDescriptor, error) {
+	composed := hidapi.ComposedDescriptor{}
+	if cfg.Augment != "" && cfg.Augment != augmentAuto {
+		return composed, fmt.Errorf("unknown augment mode %q", cfg.Augment)
 	}
-	idMap := make(map[uint8]uint8)
+	if len(cfg.Inputs) == 0 && cfg.File == "" && len(cfg.Templates) == 0 {
+		return composed, fmt.Errorf("no input devices, file or templates specified")
+	}
+	sources := make([]hidapi.DescriptorSource, 0, len(cfg.Inputs))
 	for _, addr := range cfg.Inputs {
 		inputDescRaw, err := o.hid.GetReportDescriptor(addr)
 		if err != nil {
-			return desc, fmt.Errorf("failed to get report descriptor for input device %s: %w", addr, err)
+			return composed, fmt.Errorf("failed to get report descriptor for input device %s, connect it or import its descriptor: %w", addr, err)
 		}
 		inputDesc, err := hiddesc.Decode(inputDescRaw)
 		if err != nil {
 			o.log.Error("Failed to decode HID report descriptor", zap.Error(err))
 			continue
 		}
-		inputSet := hidapi.NewDataItemSet(inputDesc)
-		for _, rd := range inputSet.Reports() {
-			id := rd.ID
-			for {
-				if _, ok := idMap[id]; !ok {
-					idMap[rd.ID] = id
-					break
-				}
-				id++
-			}
+		sources = append(sources, hidapi.DescriptorSource{Name: addr.String(), Descriptor: inputDesc})
+	}
+	if len(cfg.Inputs) > 0 && len(sources) == 0 {
+		return composed, fmt.Errorf("no input devices connected")
+	}
+	if cfg.File != "" {
+		fileDesc, err := readDescriptorFile(cfg.File)
+		if err != nil {
+			return composed, err
 		}
-		inputDesc.Walk(func(item hiddesc.MainItem) bool {
-			if item.DataItem != nil {
-				item.DataItem.ReportID = idMap[item.DataItem.ReportID]
-			}
-			return true
-		})
-		desc.Collections = append(desc.Collections, inputDesc.Collections...)
+		sources = append(sources, hidapi.DescriptorSource{Name: cfg.File, Descriptor: fileDesc})
 	}
-	if len(desc.Collections) == 0 {
-		return desc, fmt.Errorf("no input devices connected")
+	composed, err := hidapi.ComposeDescriptors(sources)
+	if err != nil {
+		return composed, fmt.Errorf("failed to compose report descriptors: %w", err)
 	}
-	return desc, nil
+	for _, conflict := range composed.Conflicts {
+		o.log.Debug("Report descriptor conflict", zap.Stringer("conflict", conflict))
+	}
+	templates := make([]hidapi.DescriptorTemplate, 0, len(cfg.Templates))
+	for _, template := range cfg.Templates {
+		templates = append(templates, template.template())
+	}
+	if err := composed.AppendTemplates(templates); err != nil {
+		return composed, fmt.Errorf("failed to add templates: %w", err)
+	}
+	return composed, nil
 }
 
 func (o *OutputNode) Run(ctx context.Context, up flowapi.Stream, _ flowapi.Stream) error {
@@ -218,16 +396,29 @@ func (o *OutputNode) Run(ctx context.Context, up flowapi.Stream, _ flowapi.Strea
 		Type: OutputDisconnected,
 		Addr: o.addr,
 	})
-	var deviceCtx context.Context
 	var cancel context.CancelFunc
+	var deviceDone chan struct{}
+	connect := func() {
+		if deviceDone != nil {
+			// the scheduler is reset by the previous connection when it is done
+			<-deviceDone
+		}
+		deviceCtx, deviceCancel := context.WithCancel(ctx)
+		cancel = deviceCancel
+		done := make(chan struct{})
+		deviceDone = done
+		go func() {
+			defer close(done)
+			o.handleDevice(deviceCtx, up)
+		}()
+	}
 	events := up.Subscribe(ctx)
-	reportsCh := make(chan [][]byte, 1)
-	defer close(reportsCh)
+	latency := outputLatencyMetric.With(o.id)
+	dropped := outputDroppedMetric.With(o.id)
 
 	isConnected := o.hid.IsOutputConnected(o.addr)
 	if isConnected {
-		deviceCtx, cancel = context.WithCancel(ctx)
-		go o.handleDevice(deviceCtx, up, reportsCh)
+		connect()
 	}
 	go func() {
 		for {
@@ -235,17 +426,15 @@ func (o *OutputNode) Run(ctx context.Context, up flowapi.Stream, _ flowapi.Strea
 			case event := <-events:
 				switch event.Type {
 				case flowapi.HIDEventTypeInput:
-					reports := o.inputState.ApplyEvent(event.HID)
-					if len(reports) > 0 {
-						select {
-						case reportsCh <- reports:
-						default:
-							o.log.Warn("Dropped input reports", zap.Any("event", event.HID.String()))
-						}
+					latency.ObserveDuration(o.clock.Since(event.HID.Timestamp()))
+					o.updateHeld(event.HID)
+					if !o.scheduler.Schedule(o.inputState.ApplyEvent(event.HID), event.HID) {
+						dropped.Inc()
 					}
 				case flowapi.HIDEventTypeFeature:
 					o.featureState.ApplyEvent(event.HID)
 				}
+				event.Release()
 			case <-ctx.Done():
 				return
 			}
@@ -260,15 +449,13 @@ func (o *OutputNode) Run(ctx context.Context, up flowapi.Stream, _ flowapi.Strea
 					break
 				}
 				o.log.Info("Output device connected", zap.String("addr", o.addr.String()))
-				deviceCtx, cancel = context.WithCancel(ctx)
-				go o.handleDevice(deviceCtx, up, reportsCh)
+				connect()
 			case OutputDisconnected:
 				if cancel == nil {
 					break
 				}
 				o.log.Info("Output device disconnected", zap.String("addr", o.addr.String()))
 				cancel()
-				deviceCtx = nil
 				cancel = nil
 			}
 		case <-ctx.Done():
@@ -279,3 +466,21 @@ func (o *OutputNode) Run(ctx context.Context, up flowapi.Stream, _ flowapi.Strea
 		}
 	}
 }
+
+// readDescriptorFile reads a report descriptor in the text or raw format.
+func readDescriptorFile(path string) (hiddesc.ReportDescriptor, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return hiddesc.ReportDescriptor{}, fmt.Errorf("failed to read report descriptor file: %w", err)
+	}
+	var desc hiddesc.ReportDescriptor
+	if hiddesc.IsText(data) {
+		desc, err = hiddesc.DecodeText(data)
+	} else {
+		desc, err = hiddesc.Decode(data)
+	}
+	if err != nil {
+		return desc, fmt.Errorf("failed to decode report descriptor file %s: %w", path, err)
+	}
+	return desc, nil
+}
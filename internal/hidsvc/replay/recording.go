@@ -0,0 +1,222 @@
+// Package replay records raw reports of HID input devices and plays them back as virtual devices.
+package replay
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/neuroplastio/neio-agent/internal/hidsvc"
+)
+
+// Recordings are stored in .nrec files:
+//
+//	magic "NREC", version byte
+//	header: address, name, report descriptor and start time
+//	records: time since the previous record in microseconds, report
+//
+// Strings and byte slices are prefixed with their uvarint length, the start time is a varint
+// of Unix nanoseconds and the time deltas are uvarints.
+const (
+	magic   = "NREC"
+	version = 1
+
+	maxStringSize     = 1 << 10
+	maxReportSize     = 1 << 16
+	maxDescriptorSize = 1 << 16
+)
+
+type Header struct {
+	Addr       hidsvc.Address
+	Name       string
+	Descriptor []byte
+	Start      time.Time
+}
+
+// Record is a raw input report with its time relative to the start of the recording.
+type Record struct {
+	Time time.Duration
+	Data []byte
+}
+
+// Recording is a fully loaded recording.
+type Recording struct {
+	Header
+	Records []Record
+}
+
+// Duration returns the time of the last record.
+func (r *Recording) Duration() time.Duration {
+	if len(r.Records) == 0 {
+		return 0
+	}
+	return r.Records[len(r.Records)-1].Time
+}
+
+type Writer struct {
+	w    *bufio.Writer
+	buf  []byte
+	last time.Duration
+	hdr  Header
+}
+
+// NewWriter writes the header and returns a writer for records. Flush must be called when done.
+func NewWriter(w io.Writer, hdr Header) (*Writer, error) {
+	rw := &Writer{
+		w:   bufio.NewWriter(w),
+		buf: make([]byte, 0, 64),
+		hdr: hdr,
+	}
+	rw.buf = append(rw.buf, magic...)
+	rw.buf = append(rw.buf, version)
+	rw.buf = appendBytes(rw.buf, []byte(hdr.Addr.String()))
+	rw.buf = appendBytes(rw.buf, []byte(hdr.Name))
+	rw.buf = appendBytes(rw.buf, hdr.Descriptor)
+	rw.buf = binary.AppendVarint(rw.buf, hdr.Start.UnixNano())
+	if _, err := rw.w.Write(rw.buf); err != nil {
+		return nil, fmt.Errorf("failed to write header: %w", err)
+	}
+	return rw, nil
+}
+
+// Write appends a report received at the time. Times before the previous record are clamped to it.
+func (w *Writer) Write(at time.Time, report []byte) error {
+	offset := max(at.Sub(w.hdr.Start), w.last)
+	delta := offset.Microseconds() - w.last.Microseconds()
+	w.last = offset
+	w.buf = binary.AppendUvarint(w.buf[:0], uint64(delta))
+	w.buf = appendBytes(w.buf, report)
+	if _, err := w.w.Write(w.buf); err != nil {
+		return fmt.Errorf("failed to write record: %w", err)
+	}
+	return nil
+}
+
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+type Reader struct {
+	r    *bufio.Reader
+	hdr  Header
+	last time.Duration
+}
+
+func NewReader(r io.Reader) (*Reader, error) {
+	rr := &Reader{
+		r: bufio.NewReader(r),
+	}
+	head := make([]byte, len(magic)+1)
+	if _, err := io.ReadFull(rr.r, head); err != nil {
+		return nil, fmt.Errorf("failed to read header: %w", err)
+	}
+	if string(head[:len(magic)]) != magic {
+		return nil, fmt.Errorf("not a recording")
+	}
+	if head[len(magic)] != version {
+		return nil, fmt.Errorf("unsupported recording version %d", head[len(magic)])
+	}
+	addr, err := rr.readBytes(maxStringSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read address: %w", err)
+	}
+	rr.hdr.Addr, err = hidsvc.ParseAddress(string(addr))
+	if err != nil {
+		return nil, err
+	}
+	name, err := rr.readBytes(maxStringSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read name: %w", err)
+	}
+	rr.hdr.Name = string(name)
+	rr.hdr.Descriptor, err = rr.readBytes(maxDescriptorSize)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report descriptor: %w", err)
+	}
+	start, err := binary.ReadVarint(rr.r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read start time: %w", err)
+	}
+	rr.hdr.Start = time.Unix(0, start)
+	return rr, nil
+}
+
+func (r *Reader) Header() Header {
+	return r.hdr
+}
+
+// Next returns the next record, or io.EOF at the end of the recording.
+func (r *Reader) Next() (Record, error) {
+	delta, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			return Record{}, io.EOF
+		}
+		return Record{}, fmt.Errorf("failed to read record: %w", err)
+	}
+	data, err := r.readBytes(maxReportSize)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return Record{}, fmt.Errorf("failed to read record: %w", err)
+	}
+	r.last += time.Duration(delta) * time.Microsecond
+	return Record{Time: r.last, Data: data}, nil
+}
+
+func (r *Reader) readBytes(limit int) ([]byte, error) {
+	n, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(limit) {
+		return nil, fmt.Errorf("length %d exceeds %d", n, limit)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// Load reads a whole recording.
+func Load(r io.Reader) (*Recording, error) {
+	rr, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	rec := &Recording{Header: rr.Header()}
+	for {
+		record, err := rr.Next()
+		if errors.Is(err, io.EOF) {
+			return rec, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		rec.Records = append(rec.Records, record)
+	}
+}
+
+func LoadFile(path string) (*Recording, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open recording: %w", err)
+	}
+	defer f.Close()
+	rec, err := Load(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read recording %s: %w", path, err)
+	}
+	return rec, nil
+}
+
+func appendBytes(buf, data []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(data)))
+	return append(buf, data...)
+}
@@ -0,0 +1,68 @@
+package replay
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/neuroplastio/neio-agent/internal/hidsvc"
+)
+
+func TestRecordingRoundTrip(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	hdr := Header{
+		Addr:       hidsvc.Address{Backend: "linux", ID: "3297:1969:0"},
+		Name:       "Moonlander",
+		Descriptor: []byte{0x05, 0x01, 0x09, 0x06},
+		Start:      start,
+	}
+	records := []Record{
+		{Time: 0, Data: []byte{0, 0, 0x04, 0, 0, 0, 0, 0}},
+		{Time: 12345 * time.Microsecond, Data: []byte{0, 0, 0, 0, 0, 0, 0, 0}},
+		{Time: 2 * time.Second, Data: []byte{0x02, 0, 0x05, 0, 0, 0, 0, 0}},
+	}
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, hdr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, record := range records {
+		if err := w.Write(start.Add(record.Time), record.Data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	rec, err := Load(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Addr != hdr.Addr || rec.Name != hdr.Name || !bytes.Equal(rec.Descriptor, hdr.Descriptor) || !rec.Start.Equal(start) {
+		t.Fatalf("unexpected header %+v", rec.Header)
+	}
+	if !slices.EqualFunc(rec.Records, records, func(a, b Record) bool {
+		return a.Time == b.Time && bytes.Equal(a.Data, b.Data)
+	}) {
+		t.Fatalf("unexpected records %v", rec.Records)
+	}
+
+	r, err := NewReader(bytes.NewReader(data[:len(data)-3]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for {
+		_, err = r.Next()
+		if err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected unexpected EOF for a truncated recording, got %v", err)
+	}
+}
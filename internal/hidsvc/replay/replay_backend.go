@@ -0,0 +1,198 @@
+package replay
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+
+	"github.com/neuroplastio/neio-agent/internal/hidsvc"
+	"github.com/neuroplastio/neio-agent/internal/hidsvc/virtual"
+	"github.com/neuroplastio/neio-agent/pkg/clock"
+	"go.uber.org/zap"
+)
+
+var defaultBackendOptions = backendOptions{
+	clock: clock.Real(),
+}
+
+type backendOptions struct {
+	delegate hidsvc.Backend
+	clock    clock.Clock
+}
+
+type Option func(*backendOptions)
+
+// WithDelegate overlays recordings on top of another backend. Devices of the delegate that have
+// a recording are hidden, all other devices are served by the delegate.
+func WithDelegate(backend hidsvc.Backend) Option {
+	return func(o *backendOptions) {
+		o.delegate = backend
+	}
+}
+
+// WithClock sets the clock used to pace recorded reports.
+func WithClock(c clock.Clock) Option {
+	return func(o *backendOptions) {
+		o.clock = c
+	}
+}
+
+// Backend presents recordings as input devices at their original addresses. Playback of a recording
+// starts when its device is acquired, and reports are sent with their original timing.
+type Backend struct {
+	log     *zap.Logger
+	options backendOptions
+
+	virtual    *virtual.Backend
+	recordings map[string]*Recording
+
+	ready chan struct{}
+}
+
+// NewBackend creates a backend for recordings of devices of the same backend.
+func NewBackend(log *zap.Logger, recordings []*Recording, opts ...Option) (*Backend, error) {
+	options := defaultBackendOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	b := &Backend{
+		log:        log,
+		options:    options,
+		virtual:    virtual.NewBackend(log, virtual.WithClock(options.clock)),
+		recordings: make(map[string]*Recording, len(recordings)),
+		ready:      make(chan struct{}),
+	}
+	for _, rec := range recordings {
+		if rec.Addr.Backend != recordings[0].Addr.Backend {
+			return nil, fmt.Errorf("recordings of different backends: %s, %s", recordings[0].Addr, rec.Addr)
+		}
+		if _, err := b.virtual.AddInput(rec.Addr.ID, rec.Name, rec.Descriptor); err != nil {
+			return nil, fmt.Errorf("failed to add recorded device %s: %w", rec.Addr, err)
+		}
+		b.recordings[rec.Addr.ID] = rec
+	}
+	return b, nil
+}
+
+func (b *Backend) Ready() <-chan struct{} {
+	return b.ready
+}
+
+func (b *Backend) Start(ctx context.Context, publisher hidsvc.BackendPublisher) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		b.virtual.Start(ctx, publisher)
+	}()
+	readyChans := []<-chan struct{}{b.virtual.Ready()}
+	if b.options.delegate != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer cancel()
+			if err := b.options.delegate.Start(ctx, b.filter(publisher)); err != nil {
+				b.log.Error("Delegate backend failed", zap.Error(err))
+			}
+		}()
+		readyChans = append(readyChans, b.options.delegate.Ready())
+	}
+	for _, ready := range readyChans {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ready:
+		}
+	}
+	for id, rec := range b.recordings {
+		input, _ := b.virtual.Input(id)
+		wg.Add(1)
+		go func(rec *Recording) {
+			defer wg.Done()
+			b.play(ctx, input, rec)
+		}(rec)
+	}
+	select {
+	case <-b.ready:
+	default:
+		close(b.ready)
+	}
+	b.log.Info("Replay backend started", zap.Int("recordings", len(b.recordings)))
+	<-ctx.Done()
+	return nil
+}
+
+// filter hides devices of the delegate that are replaced by recordings.
+func (b *Backend) filter(publisher hidsvc.BackendPublisher) hidsvc.BackendPublisher {
+	keep := func(id string) bool {
+		_, ok := b.recordings[id]
+		return !ok
+	}
+	return func(ctx context.Context, event hidsvc.BackendEvent) {
+		if changed := event.InputsChanged; changed != nil {
+			event.InputsChanged = &hidsvc.BackendEventInputsChanged{
+				Connected: slices.DeleteFunc(slices.Clone(changed.Connected), func(dev hidsvc.BackendDevice) bool {
+					return !keep(dev.ID)
+				}),
+				Disconnected: slices.DeleteFunc(slices.Clone(changed.Disconnected), func(id string) bool {
+					return !keep(id)
+				}),
+			}
+		}
+		publisher(ctx, event)
+	}
+}
+
+// play injects recorded reports once the device is acquired.
+func (b *Backend) play(ctx context.Context, input *virtual.Input, rec *Recording) {
+	if err := input.WaitAcquired(ctx); err != nil {
+		return
+	}
+	log := b.log.With(zap.String("addr", rec.Addr.String()))
+	log.Info("Replaying recording", zap.Int("reports", len(rec.Records)), zap.Duration("duration", rec.Duration()))
+	start := b.options.clock.Now()
+	for _, record := range rec.Records {
+		if wait := start.Add(record.Time).Sub(b.options.clock.Now()); wait > 0 {
+			select {
+			case <-b.options.clock.After(wait):
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := input.Inject(record.Data); err != nil {
+			log.Error("Failed to replay report", zap.Error(err))
+			return
+		}
+	}
+	log.Info("Recording finished")
+}
+
+func (b *Backend) OpenInputDevice(id string) (hidsvc.InputDevice, error) {
+	if _, ok := b.recordings[id]; ok {
+		return b.virtual.OpenInputDevice(id)
+	}
+	if b.options.delegate == nil {
+		return nil, fmt.Errorf("device not found: %s", id)
+	}
+	return b.options.delegate.OpenInputDevice(id)
+}
+
+func (b *Backend) OpenOutputDevice(id string, handler hidsvc.OutputDeviceHandler, descriptor []byte) (hidsvc.OutputDevice, error) {
+	if b.options.delegate == nil {
+		return nil, fmt.Errorf("device not found: %s", id)
+	}
+	return b.options.delegate.OpenOutputDevice(id, handler, descriptor)
+}
+
+func (b *Backend) MaxDescriptorSize(id string) int {
+	limiter, ok := b.options.delegate.(hidsvc.DescriptorSizeLimiter)
+	if !ok {
+		return 0
+	}
+	return limiter.MaxDescriptorSize(id)
+}
@@ -0,0 +1,98 @@
+package replay
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/neuroplastio/neio-agent/internal/hidsvc"
+	"github.com/neuroplastio/neio-agent/internal/hidsvc/virtual"
+	"go.uber.org/zap"
+)
+
+func TestBackendReplay(t *testing.T) {
+	delegate := virtual.NewBackend(zap.NewNop())
+	if _, err := delegate.AddInput("kb", "Real keyboard", []byte{0x05, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := delegate.AddInput("mouse", "Real mouse", []byte{0x05, 0x01}); err != nil {
+		t.Fatal(err)
+	}
+	rec := &Recording{
+		Header: Header{
+			Addr: hidsvc.Address{Backend: "virtual", ID: "kb"},
+			Name: "Recorded keyboard",
+			// boot keyboard input report: modifiers, reserved, 6 keys
+			Descriptor: []byte{
+				0x05, 0x01, 0x09, 0x06, 0xa1, 0x01,
+				0x05, 0x07, 0x19, 0xe0, 0x29, 0xe7, 0x15, 0x00, 0x25, 0x01, 0x75, 0x01, 0x95, 0x08, 0x81, 0x02,
+				0x95, 0x01, 0x75, 0x08, 0x81, 0x01,
+				0x95, 0x06, 0x75, 0x08, 0x15, 0x00, 0x25, 0x65, 0x05, 0x07, 0x19, 0x00, 0x29, 0x65, 0x81, 0x00,
+				0xc0,
+			},
+		},
+		Records: []Record{
+			{Time: 0, Data: []byte{0, 0, 0x04, 0, 0, 0, 0, 0}},
+			{Time: 20 * time.Millisecond, Data: []byte{0, 0, 0, 0, 0, 0, 0, 0}},
+		},
+	}
+	backend, err := NewBackend(zap.NewNop(), []*Recording{rec}, WithDelegate(delegate))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := badger.DefaultOptions(t.TempDir())
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	svc := hidsvc.New(db, zap.NewNop(), time.Now, hidsvc.WithBackend("virtual", backend))
+	go svc.Start(ctx)
+	<-svc.Ready()
+
+	kb := hidsvc.Address{Backend: "virtual", ID: "kb"}
+	mouse := hidsvc.Address{Backend: "virtual", ID: "mouse"}
+	deadline := time.Now().Add(5 * time.Second)
+	for !svc.IsInputConnected(kb) || !svc.IsInputConnected(mouse) {
+		if time.Now().After(deadline) {
+			t.Fatal("devices are not connected")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if info, err := svc.GetInputDevice(kb); err != nil || info.Name != "Recorded keyboard" {
+		t.Fatalf("expected the recorded device to replace the real one, got %+v: %v", info, err)
+	}
+
+	dev, err := svc.OpenInputDevice(kb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+	release, err := dev.Acquire()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer release()
+	buf := make([]byte, 64)
+	var first time.Time
+	for i, record := range rec.Records {
+		n, err := dev.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(buf[:n]) != string(record.Data) {
+			t.Fatalf("report %d: expected %x, got %x", i, record.Data, buf[:n])
+		}
+		if i == 0 {
+			first = time.Now()
+		}
+	}
+	if elapsed := time.Since(first); elapsed < 15*time.Millisecond {
+		t.Fatalf("reports were not paced: %s", elapsed)
+	}
+}
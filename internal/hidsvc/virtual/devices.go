@@ -0,0 +1,532 @@
+package virtual
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/neuroplastio/neio-agent/hidapi"
+	"github.com/neuroplastio/neio-agent/hidapi/hiddesc"
+	"github.com/neuroplastio/neio-agent/internal/hidsvc"
+	"go.uber.org/zap"
+)
+
+// Input is a virtual input device. Injected reports are delivered to every open handle, like hidraw does.
+type Input struct {
+	b          *Backend
+	log        *zap.Logger
+	id         string
+	name       string
+	descriptor []byte
+
+	mu        sync.Mutex
+	connected bool
+	acquired  int
+	handles   []*inputHandle
+	changed   chan struct{}
+
+	// inputState and featureState answer GetInputReport and GetFeatureReport requests.
+	inputState   *hidapi.ReportState
+	featureState *hidapi.ReportState
+
+	// outputReports are written to the device by the agent, e.g. keyboard LEDs.
+	outputReports chan []byte
+}
+
+func newInput(b *Backend, id, name string, descriptor []byte) (*Input, error) {
+	desc, err := hiddesc.Decode(descriptor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode report descriptor: %w", err)
+	}
+	log := b.log.With(zap.String("input", id))
+	itemSet := hidapi.NewDataItemSet(desc)
+	return &Input{
+		b:             b,
+		log:           log,
+		id:            id,
+		name:          name,
+		descriptor:    slices.Clone(descriptor),
+		changed:       make(chan struct{}),
+		inputState:    hidapi.NewReportState(log, itemSet.WithType(hiddesc.MainItemTypeInput), hidapi.WithReportClock(b.options.clock)),
+		featureState:  hidapi.NewReportState(log, itemSet.WithType(hiddesc.MainItemTypeFeature), hidapi.WithReportClock(b.options.clock)),
+		outputReports: make(chan []byte, b.options.bufferSize),
+	}, nil
+}
+
+func (i *Input) ID() string {
+	return i.id
+}
+
+func (i *Input) backendDevice() hidsvc.BackendDevice {
+	return hidsvc.BackendDevice{
+		ID:   i.id,
+		Name: i.name,
+	}
+}
+
+func (i *Input) IsConnected() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.connected
+}
+
+// Connect simulates plugging the device in.
+func (i *Input) Connect() {
+	i.mu.Lock()
+	if i.connected {
+		i.mu.Unlock()
+		return
+	}
+	i.connected = true
+	i.notifyLocked()
+	i.mu.Unlock()
+	i.b.publish(hidsvc.BackendEvent{
+		InputsChanged: &hidsvc.BackendEventInputsChanged{
+			Connected: []hidsvc.BackendDevice{i.backendDevice()},
+		},
+	})
+}
+
+// Disconnect simulates unplugging the device. Open handles fail with hidsvc.ErrDeviceNotConnected.
+func (i *Input) Disconnect() {
+	i.mu.Lock()
+	if !i.connected {
+		i.mu.Unlock()
+		return
+	}
+	i.connected = false
+	handles := i.handles
+	i.handles = nil
+	i.acquired = 0
+	i.notifyLocked()
+	i.mu.Unlock()
+	for _, h := range handles {
+		h.close(hidsvc.ErrDeviceNotConnected)
+	}
+	i.b.publish(hidsvc.BackendEvent{
+		InputsChanged: &hidsvc.BackendEventInputsChanged{
+			Disconnected: []string{i.id},
+		},
+	})
+}
+
+// Inject sends a raw input report to open handles of the device. The report is also returned
+// by GetInputReport afterwards.
+func (i *Input) Inject(report []byte) error {
+	if len(report) == 0 {
+		return fmt.Errorf("empty report")
+	}
+	i.mu.Lock()
+	if !i.connected {
+		i.mu.Unlock()
+		return hidsvc.ErrDeviceNotConnected
+	}
+	i.inputState.ApplyReport(report).Release()
+	handles := slices.Clone(i.handles)
+	i.mu.Unlock()
+	for _, h := range handles {
+		h.deliver(slices.Clone(report))
+	}
+	return nil
+}
+
+// SetFeatureReport sets the feature report returned by GetFeatureReport.
+func (i *Input) SetFeatureReport(report []byte) {
+	i.featureState.ApplyReport(report).Release()
+}
+
+// OutputReports returns reports written to the device by the agent.
+func (i *Input) OutputReports() <-chan []byte {
+	return i.outputReports
+}
+
+// IsAcquired reports whether the agent has acquired the device.
+func (i *Input) IsAcquired() bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.acquired > 0
+}
+
+// WaitAcquired waits until the agent acquires the device.
+func (i *Input) WaitAcquired(ctx context.Context) error {
+	return i.wait(ctx, func() bool {
+		return i.acquired > 0
+	})
+}
+
+func (i *Input) wait(ctx context.Context, cond func() bool) error {
+	for {
+		i.mu.Lock()
+		if cond() {
+			i.mu.Unlock()
+			return nil
+		}
+		changed := i.changed
+		i.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (i *Input) notifyLocked() {
+	close(i.changed)
+	i.changed = make(chan struct{})
+}
+
+func (i *Input) open() (*inputHandle, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if !i.connected {
+		return nil, hidsvc.ErrDeviceNotConnected
+	}
+	h := &inputHandle{
+		input:   i,
+		reports: make(chan []byte, i.b.options.bufferSize),
+		closed:  make(chan struct{}),
+	}
+	i.handles = append(i.handles, h)
+	i.notifyLocked()
+	return h, nil
+}
+
+func (i *Input) removeHandle(h *inputHandle) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.handles = slices.DeleteFunc(i.handles, func(e *inputHandle) bool {
+		return e == h
+	})
+	i.notifyLocked()
+}
+
+type inputHandle struct {
+	input   *Input
+	reports chan []byte
+
+	once   sync.Once
+	err    error
+	closed chan struct{}
+}
+
+func (h *inputHandle) deliver(report []byte) {
+	select {
+	case h.reports <- report:
+	case <-h.closed:
+	default:
+		h.input.log.Warn("Dropped input report")
+	}
+}
+
+func (h *inputHandle) close(err error) {
+	h.once.Do(func() {
+		h.err = err
+		close(h.closed)
+	})
+}
+
+func (h *inputHandle) Read(buf []byte) (int, error) {
+	select {
+	case report := <-h.reports:
+		return copy(buf, report), nil
+	case <-h.closed:
+		return 0, h.err
+	}
+}
+
+func (h *inputHandle) Write(buf []byte) (int, error) {
+	select {
+	case <-h.closed:
+		return 0, h.err
+	default:
+	}
+	select {
+	case h.input.outputReports <- slices.Clone(buf):
+	default:
+		h.input.log.Warn("Dropped output report")
+	}
+	return len(buf), nil
+}
+
+func (h *inputHandle) Close() error {
+	h.close(context.Canceled)
+	h.input.removeHandle(h)
+	return nil
+}
+
+func (h *inputHandle) Acquire() (func(), error) {
+	i := h.input
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if !i.connected {
+		return nil, hidsvc.ErrDeviceNotConnected
+	}
+	i.acquired++
+	i.notifyLocked()
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			i.mu.Lock()
+			defer i.mu.Unlock()
+			if i.acquired > 0 {
+				i.acquired--
+			}
+			i.notifyLocked()
+		})
+	}, nil
+}
+
+func (h *inputHandle) GetReportDescriptor() ([]byte, error) {
+	return slices.Clone(h.input.descriptor), nil
+}
+
+func (h *inputHandle) GetInputReport(reportID uint8) ([]byte, error) {
+	return h.input.inputState.GetReport(reportID)
+}
+
+func (h *inputHandle) GetFeatureReport(reportID uint8) ([]byte, error) {
+	return h.input.featureState.GetReport(reportID)
+}
+
+func (h *inputHandle) SetFeatureReport(data []byte) (int, error) {
+	if len(data) == 0 {
+		return 0, fmt.Errorf("empty report")
+	}
+	h.input.SetFeatureReport(data)
+	return len(data), nil
+}
+
+// Output is a virtual output device. Reports written by the agent are captured, and the host side
+// can be simulated with InjectOutputReport and the device handler.
+type Output struct {
+	b    *Backend
+	log  *zap.Logger
+	id   string
+	name string
+
+	mu         sync.Mutex
+	connected  bool
+	handle     *outputHandle
+	handler    hidsvc.OutputDeviceHandler
+	descriptor []byte
+	changed    chan struct{}
+
+	// reports are input reports written by the agent.
+	reports chan CapturedReport
+}
+
+// CapturedReport is a report written to an output device.
+type CapturedReport struct {
+	Time time.Time
+	Data []byte
+}
+
+func newOutput(b *Backend, id, name string) *Output {
+	return &Output{
+		b:       b,
+		log:     b.log.With(zap.String("output", id)),
+		id:      id,
+		name:    name,
+		changed: make(chan struct{}),
+		reports: make(chan CapturedReport, b.options.bufferSize),
+	}
+}
+
+func (o *Output) ID() string {
+	return o.id
+}
+
+func (o *Output) backendDevice() hidsvc.BackendDevice {
+	return hidsvc.BackendDevice{
+		ID:   o.id,
+		Name: o.name,
+	}
+}
+
+func (o *Output) IsConnected() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.connected
+}
+
+func (o *Output) Connect() {
+	o.mu.Lock()
+	if o.connected {
+		o.mu.Unlock()
+		return
+	}
+	o.connected = true
+	o.notifyLocked()
+	o.mu.Unlock()
+	o.b.publish(hidsvc.BackendEvent{
+		OutputsChanged: &hidsvc.BackendEventOutputsChanged{
+			Connected: []hidsvc.BackendDevice{o.backendDevice()},
+		},
+	})
+}
+
+func (o *Output) Disconnect() {
+	o.mu.Lock()
+	if !o.connected {
+		o.mu.Unlock()
+		return
+	}
+	o.connected = false
+	handle := o.handle
+	o.handle = nil
+	o.notifyLocked()
+	o.mu.Unlock()
+	if handle != nil {
+		handle.close(hidsvc.ErrDeviceNotConnected)
+	}
+	o.b.publish(hidsvc.BackendEvent{
+		OutputsChanged: &hidsvc.BackendEventOutputsChanged{
+			Disconnected: []string{o.id},
+		},
+	})
+}
+
+// Reports returns input reports written to the device by the agent.
+func (o *Output) Reports() <-chan CapturedReport {
+	return o.reports
+}
+
+// Descriptor returns the report descriptor the device was opened with.
+func (o *Output) Descriptor() []byte {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return slices.Clone(o.descriptor)
+}
+
+// Handler returns the handler of the open device, which answers GetReport and SetReport requests of the host.
+func (o *Output) Handler() (hidsvc.OutputDeviceHandler, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.handler, o.handle != nil
+}
+
+// IsOpen reports whether the agent has opened the device.
+func (o *Output) IsOpen() bool {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.handle != nil
+}
+
+// WaitOpen waits until the agent opens the device.
+func (o *Output) WaitOpen(ctx context.Context) error {
+	for {
+		o.mu.Lock()
+		if o.handle != nil {
+			o.mu.Unlock()
+			return nil
+		}
+		changed := o.changed
+		o.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// InjectOutputReport sends an output report from the host, e.g. keyboard LEDs, to the agent.
+func (o *Output) InjectOutputReport(report []byte) error {
+	o.mu.Lock()
+	handle := o.handle
+	o.mu.Unlock()
+	if handle == nil {
+		return fmt.Errorf("output device %s is not open", o.id)
+	}
+	handle.deliver(slices.Clone(report))
+	return nil
+}
+
+func (o *Output) notifyLocked() {
+	close(o.changed)
+	o.changed = make(chan struct{})
+}
+
+func (o *Output) open(handler hidsvc.OutputDeviceHandler, descriptor []byte) (*outputHandle, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if !o.connected {
+		return nil, hidsvc.ErrDeviceNotConnected
+	}
+	if o.handle != nil {
+		return nil, fmt.Errorf("output device %s is already open", o.id)
+	}
+	h := &outputHandle{
+		output:        o,
+		outputReports: make(chan []byte, o.b.options.bufferSize),
+		closed:        make(chan struct{}),
+	}
+	o.handle = h
+	o.handler = handler
+	o.descriptor = slices.Clone(descriptor)
+	o.notifyLocked()
+	return h, nil
+}
+
+type outputHandle struct {
+	output        *Output
+	outputReports chan []byte
+
+	once   sync.Once
+	err    error
+	closed chan struct{}
+}
+
+func (h *outputHandle) deliver(report []byte) {
+	select {
+	case h.outputReports <- report:
+	case <-h.closed:
+	default:
+		h.output.log.Warn("Dropped output report")
+	}
+}
+
+func (h *outputHandle) close(err error) {
+	h.once.Do(func() {
+		h.err = err
+		close(h.closed)
+	})
+}
+
+func (h *outputHandle) Read(buf []byte) (int, error) {
+	select {
+	case report := <-h.outputReports:
+		return copy(buf, report), nil
+	case <-h.closed:
+		return 0, h.err
+	}
+}
+
+func (h *outputHandle) Write(buf []byte) (int, error) {
+	select {
+	case <-h.closed:
+		return 0, h.err
+	default:
+	}
+	select {
+	case h.output.reports <- CapturedReport{Time: h.output.b.options.clock.Now(), Data: slices.Clone(buf)}:
+	default:
+		h.output.log.Warn("Dropped input report")
+	}
+	return len(buf), nil
+}
+
+func (h *outputHandle) Close() error {
+	h.close(context.Canceled)
+	o := h.output
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.handle == h {
+		o.handle = nil
+		o.notifyLocked()
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package virtual
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/neuroplastio/neio-agent/internal/hidsvc"
+	"go.uber.org/zap"
+)
+
+func readDescriptor(t *testing.T, path string) []byte {
+	t.Helper()
+	desc, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return desc
+}
+
+func expectDescriptor(t *testing.T, svc *hidsvc.Service, addr hidsvc.Address, expected []byte) {
+	t.Helper()
+	desc, err := svc.GetReportDescriptor(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(desc, expected) {
+		t.Fatalf("expected descriptor %x, got %x", expected, desc)
+	}
+}
+
+func TestServiceImportInputDevice(t *testing.T) {
+	svc := startService(t, NewBackend(zap.NewNop()))
+	addr := hidsvc.Address{Backend: "virtual", ID: "kb"}
+	desc := readDescriptor(t, "../../../testdata/zsa-moonlander/1.desc")
+
+	if _, err := svc.ImportInputDevice(addr, "", []byte{0xff}); err == nil {
+		t.Fatal("expected an error for an invalid descriptor")
+	}
+	if _, err := svc.GetInputDevice(addr); err == nil {
+		t.Fatal("expected the device with an invalid descriptor not to be stored")
+	}
+
+	dev, err := svc.ImportInputDevice(addr, "", desc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.Address != addr || dev.Name != "kb" || dev.BackendDevice.ID != "kb" {
+		t.Fatalf("unexpected device: %+v", dev)
+	}
+	if !dev.FirstSeenAt.IsZero() || !dev.LastSeenAt.IsZero() {
+		t.Fatalf("expected zero times of a device that was never seen, got %+v", dev)
+	}
+	stored, err := svc.GetInputDevice(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored != dev {
+		t.Fatalf("expected stored device %+v, got %+v", dev, stored)
+	}
+	expectDescriptor(t, svc, addr, desc)
+	if svc.IsInputConnected(addr) {
+		t.Fatal("expected the imported device not to be connected")
+	}
+}
+
+func TestServiceImportInputDeviceName(t *testing.T) {
+	svc := startService(t, NewBackend(zap.NewNop()))
+	addr := hidsvc.Address{Backend: "virtual", ID: "kb"}
+	desc := readDescriptor(t, "../../../testdata/zsa-moonlander/1.desc")
+
+	for _, c := range []struct {
+		name     string
+		expected string
+	}{
+		{"Keyboard", "Keyboard"},
+		{"", "Keyboard"},
+		{"Moonlander", "Moonlander"},
+	} {
+		dev, err := svc.ImportInputDevice(addr, c.name, desc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dev.Name != c.expected {
+			t.Fatalf("import with name %q: expected name %q, got %q", c.name, c.expected, dev.Name)
+		}
+	}
+}
+
+func TestServiceImportInputDeviceConnect(t *testing.T) {
+	backend := NewBackend(zap.NewNop())
+	svc := startService(t, backend)
+	addr := hidsvc.Address{Backend: "virtual", ID: "kb"}
+	imported := readDescriptor(t, "../../../testdata/zsa-moonlander/1.desc")
+	desc := readDescriptor(t, "../../../testdata/zsa-moonlander/2.desc")
+
+	if _, err := svc.ImportInputDevice(addr, "Keyboard", imported); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backend.AddInput("kb", "ZSA Moonlander", desc); err != nil {
+		t.Fatal(err)
+	}
+	eventually(t, func() bool { return svc.IsInputConnected(addr) })
+
+	dev, err := svc.GetInputDevice(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.Name != "Keyboard" {
+		t.Fatalf("expected the imported name to be kept, got %q", dev.Name)
+	}
+	if dev.BackendDevice.Name != "ZSA Moonlander" {
+		t.Fatalf("expected the backend device of the connected device, got %+v", dev.BackendDevice)
+	}
+	if dev.FirstSeenAt.IsZero() || dev.LastSeenAt.IsZero() {
+		t.Fatalf("expected times of the connected device, got %+v", dev)
+	}
+	expectDescriptor(t, svc, addr, desc)
+
+	// importing a connected device keeps what the backend reported
+	dev, err = svc.ImportInputDevice(addr, "", imported)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dev.BackendDevice.Name != "ZSA Moonlander" || dev.FirstSeenAt.IsZero() {
+		t.Fatalf("expected the connected device to be kept, got %+v", dev)
+	}
+}
@@ -0,0 +1,225 @@
+package virtual
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/neuroplastio/neio-agent/internal/hidsvc"
+	"github.com/neuroplastio/neio-agent/pkg/clock"
+	"go.uber.org/zap"
+)
+
+var defaultBackendOptions = backendOptions{
+	bufferSize: 256,
+	clock:      clock.Real(),
+}
+
+type backendOptions struct {
+	bufferSize int
+	clock      clock.Clock
+}
+
+type Option func(*backendOptions)
+
+// WithBufferSize sets the number of reports buffered by every device queue. Reports are dropped when
+// the queue is full.
+func WithBufferSize(size int) Option {
+	return func(o *backendOptions) {
+		o.bufferSize = size
+	}
+}
+
+// WithClock sets the clock used to timestamp captured reports.
+func WithClock(c clock.Clock) Option {
+	return func(o *backendOptions) {
+		o.clock = c
+	}
+}
+
+// Backend implements the hidsvc.Backend interface in memory. Input devices are declared with report
+// descriptors, reports are injected and captured through Input and Output handles, so that flows can
+// run without hardware.
+type Backend struct {
+	log     *zap.Logger
+	options backendOptions
+
+	ready chan struct{}
+
+	mu        sync.Mutex
+	ctx       context.Context
+	publisher hidsvc.BackendPublisher
+	inputs    map[string]*Input
+	outputs   map[string]*Output
+}
+
+// Config declares virtual devices.
+type Config struct {
+	Inputs  []InputConfig  `yaml:"inputs"`
+	Outputs []OutputConfig `yaml:"outputs"`
+}
+
+type InputConfig struct {
+	ID   string `yaml:"id"`
+	Name string `yaml:"name"`
+	// Descriptor is a path to a binary HID report descriptor.
+	Descriptor string `yaml:"descriptor"`
+}
+
+type OutputConfig struct {
+	ID   string `yaml:"id"`
+	Name string `yaml:"name"`
+}
+
+func NewBackend(log *zap.Logger, opts ...Option) *Backend {
+	options := defaultBackendOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return &Backend{
+		log:     log,
+		options: options,
+		ready:   make(chan struct{}),
+		inputs:  make(map[string]*Input),
+		outputs: make(map[string]*Output),
+	}
+}
+
+// Load adds devices declared in the config.
+func (b *Backend) Load(cfg Config) error {
+	for _, input := range cfg.Inputs {
+		if _, err := b.AddInputFile(input.ID, input.Name, input.Descriptor); err != nil {
+			return err
+		}
+	}
+	for _, output := range cfg.Outputs {
+		if _, err := b.AddOutput(output.ID, output.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (b *Backend) Ready() <-chan struct{} {
+	return b.ready
+}
+
+func (b *Backend) Start(ctx context.Context, publisher hidsvc.BackendPublisher) error {
+	b.mu.Lock()
+	b.ctx = ctx
+	b.publisher = publisher
+	var inputs, outputs []hidsvc.BackendDevice
+	for _, input := range b.inputs {
+		if input.IsConnected() {
+			inputs = append(inputs, input.backendDevice())
+		}
+	}
+	for _, output := range b.outputs {
+		if output.IsConnected() {
+			outputs = append(outputs, output.backendDevice())
+		}
+	}
+	b.mu.Unlock()
+
+	if len(inputs) > 0 {
+		publisher(ctx, hidsvc.BackendEvent{
+			InputsChanged: &hidsvc.BackendEventInputsChanged{Connected: inputs},
+		})
+	}
+	if len(outputs) > 0 {
+		publisher(ctx, hidsvc.BackendEvent{
+			OutputsChanged: &hidsvc.BackendEventOutputsChanged{Connected: outputs},
+		})
+	}
+	select {
+	case <-b.ready:
+	default:
+		close(b.ready)
+	}
+	b.log.Info("Virtual HID backend started")
+	<-ctx.Done()
+	return nil
+}
+
+// AddInput declares a connected input device with the report descriptor.
+func (b *Backend) AddInput(id, name string, descriptor []byte) (*Input, error) {
+	b.mu.Lock()
+	if _, ok := b.inputs[id]; ok {
+		b.mu.Unlock()
+		return nil, fmt.Errorf("input device %s already exists", id)
+	}
+	input, err := newInput(b, id, name, descriptor)
+	if err != nil {
+		b.mu.Unlock()
+		return nil, err
+	}
+	b.inputs[id] = input
+	b.mu.Unlock()
+	input.Connect()
+	return input, nil
+}
+
+// AddInputFile declares a connected input device with the report descriptor read from the file.
+func (b *Backend) AddInputFile(id, name, path string) (*Input, error) {
+	descriptor, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read report descriptor: %w", err)
+	}
+	return b.AddInput(id, name, descriptor)
+}
+
+// AddOutput declares a connected output device. Its report descriptor is provided by the output node.
+func (b *Backend) AddOutput(id, name string) (*Output, error) {
+	b.mu.Lock()
+	if _, ok := b.outputs[id]; ok {
+		b.mu.Unlock()
+		return nil, fmt.Errorf("output device %s already exists", id)
+	}
+	output := newOutput(b, id, name)
+	b.outputs[id] = output
+	b.mu.Unlock()
+	output.Connect()
+	return output, nil
+}
+
+func (b *Backend) Input(id string) (*Input, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	input, ok := b.inputs[id]
+	return input, ok
+}
+
+func (b *Backend) Output(id string) (*Output, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	output, ok := b.outputs[id]
+	return output, ok
+}
+
+func (b *Backend) OpenInputDevice(id string) (hidsvc.InputDevice, error) {
+	input, ok := b.Input(id)
+	if !ok {
+		return nil, fmt.Errorf("device not found: %s", id)
+	}
+	return input.open()
+}
+
+func (b *Backend) OpenOutputDevice(id string, handler hidsvc.OutputDeviceHandler, descriptor []byte) (hidsvc.OutputDevice, error) {
+	output, ok := b.Output(id)
+	if !ok {
+		return nil, fmt.Errorf("device not found: %s", id)
+	}
+	return output.open(handler, descriptor)
+}
+
+// publish notifies the service about connection changes once the backend is started.
+func (b *Backend) publish(event hidsvc.BackendEvent) {
+	b.mu.Lock()
+	ctx, publisher := b.ctx, b.publisher
+	b.mu.Unlock()
+	if publisher == nil {
+		return
+	}
+	publisher(ctx, event)
+}
@@ -0,0 +1,132 @@
+package virtual
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/neuroplastio/neio-agent/internal/hidsvc"
+	"go.uber.org/zap"
+)
+
+func startService(t *testing.T, backend *Backend) *hidsvc.Service {
+	t.Helper()
+	opts := badger.DefaultOptions(t.TempDir())
+	opts.Logger = nil
+	db, err := badger.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	svc := hidsvc.New(db, zap.NewNop(), time.Now, hidsvc.WithBackend("virtual", backend))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		svc.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		db.Close()
+	})
+	select {
+	case <-svc.Ready():
+	case <-time.After(5 * time.Second):
+		t.Fatal("service did not start")
+	}
+	return svc
+}
+
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBackendInput(t *testing.T) {
+	backend := NewBackend(zap.NewNop())
+	input, err := backend.AddInputFile("kb", "Keyboard", "../../../testdata/zsa-moonlander/1.desc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := startService(t, backend)
+	addr := hidsvc.Address{Backend: "virtual", ID: "kb"}
+	eventually(t, func() bool { return svc.IsInputConnected(addr) })
+
+	dev, err := svc.OpenInputDevice(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+	report := []byte{0x02, 0, 0x04, 0, 0, 0, 0, 0}
+	if err := input.Inject(report); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 64)
+	n, err := dev.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != string(report) {
+		t.Fatalf("expected report %x, got %x", report, buf[:n])
+	}
+	current, err := dev.GetInputReport(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(current) != string(report) {
+		t.Fatalf("expected input report %x, got %x", report, current)
+	}
+
+	input.Disconnect()
+	if _, err := dev.Read(buf); !errors.Is(err, hidsvc.ErrDeviceNotConnected) {
+		t.Fatalf("expected disconnected error, got %v", err)
+	}
+	eventually(t, func() bool { return !svc.IsInputConnected(addr) })
+	input.Connect()
+	eventually(t, func() bool { return svc.IsInputConnected(addr) })
+}
+
+func TestBackendOutput(t *testing.T) {
+	backend := NewBackend(zap.NewNop())
+	output, err := backend.AddOutput("out", "Output")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := startService(t, backend)
+	addr := hidsvc.Address{Backend: "virtual", ID: "out"}
+	eventually(t, func() bool { return svc.IsOutputConnected(addr) })
+
+	dev, err := svc.OpenOutputDevice(addr, nil, []byte{0x05, 0x01})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dev.Close()
+	if !output.IsOpen() || string(output.Descriptor()) != "\x05\x01" {
+		t.Fatal("expected output device to be open with the descriptor")
+	}
+	if _, err := dev.Write([]byte{1, 2, 3}); err != nil {
+		t.Fatal(err)
+	}
+	if captured := <-output.Reports(); string(captured.Data) != "\x01\x02\x03" {
+		t.Fatalf("unexpected captured report %x", captured.Data)
+	}
+	if err := output.InjectOutputReport([]byte{0x01, 0x02}); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 8)
+	if n, err := dev.Read(buf); err != nil || string(buf[:n]) != "\x01\x02" {
+		t.Fatalf("unexpected output report %x: %v", buf[:n], err)
+	}
+	dev.Close()
+	if _, err := dev.Read(buf); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected closed device, got %v", err)
+	}
+}
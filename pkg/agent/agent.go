@@ -10,9 +10,12 @@ import (
 	"github.com/neuroplastio/neio-agent/components/actions"
 	"github.com/neuroplastio/neio-agent/components/nodes"
 	"github.com/neuroplastio/neio-agent/internal/configsvc"
+	"github.com/neuroplastio/neio-agent/internal/control"
 	"github.com/neuroplastio/neio-agent/internal/flowsvc"
 	"github.com/neuroplastio/neio-agent/internal/hidsvc"
 	"github.com/neuroplastio/neio-agent/internal/hidsvc/linux"
+	"github.com/neuroplastio/neio-agent/internal/hidsvc/replay"
+	"github.com/neuroplastio/neio-agent/pkg/metrics"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"golang.org/x/sync/errgroup"
@@ -21,6 +24,7 @@ import (
 type Agent struct {
 	config Config
 
+	log       *zap.Logger
 	db        *badger.DB
 	registry  *flowsvc.Registry
 	configSvc *configsvc.Service
@@ -48,16 +52,24 @@ func NewAgent(config Config) (*Agent, error) {
 
 	configSvc := configsvc.New(logger.Named("config"))
 	linuxHid := linux.NewBackend(logger.Named("hid.linux"), configSvc, config.UhidConfig)
-	hidSvc := hidsvc.New(db, logger.Named("hid"), time.Now, hidsvc.WithBackend("linux", linuxHid))
+	backends, err := replayBackends(logger.Named("hid.replay"), config.Replay, map[string]hidsvc.Backend{
+		"linux": linuxHid,
+	})
+	if err != nil {
+		return nil, err
+	}
+	hidOpts := make([]hidsvc.Option, 0, len(backends))
+	for name, backend := range backends {
+		hidOpts = append(hidOpts, hidsvc.WithBackend(name, backend))
+	}
+	hidSvc := hidsvc.New(db, logger.Named("hid"), time.Now, hidOpts...)
 
-	registry := flowsvc.NewRegistry()
-	nodes.Register(logger, registry)
-	hidSvc.RegisterNodes(registry)
-	actions.Register(registry)
+	registry := NewRegistry(logger, hidSvc)
 
 	flowSvc := flowsvc.New(logger.Named("flow"), configSvc, config.FlowConfig, registry)
 	return &Agent{
 		config:    config,
+		log:       logger,
 		db:        db,
 		registry:  registry,
 		configSvc: configSvc,
@@ -66,10 +78,43 @@ func NewAgent(config Config) (*Agent, error) {
 	}, nil
 }
 
+// NewRegistry returns a registry of the node types and actions of the agent.
+func NewRegistry(log *zap.Logger, hidSvc *hidsvc.Service) *flowsvc.Registry {
+	registry := flowsvc.NewRegistry()
+	nodes.Register(log, registry)
+	hidSvc.RegisterNodes(registry)
+	actions.Register(registry)
+	return registry
+}
+
 func (a *Agent) Close() error {
 	return nil
 }
 
+// replayBackends overlays recordings on top of the backends of their devices.
+func replayBackends(log *zap.Logger, paths []string, backends map[string]hidsvc.Backend) (map[string]hidsvc.Backend, error) {
+	recordings := make(map[string][]*replay.Recording)
+	for _, path := range paths {
+		rec, err := replay.LoadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		recordings[rec.Addr.Backend] = append(recordings[rec.Addr.Backend], rec)
+	}
+	for name, recs := range recordings {
+		var opts []replay.Option
+		if delegate, ok := backends[name]; ok {
+			opts = append(opts, replay.WithDelegate(delegate))
+		}
+		backend, err := replay.NewBackend(log, recs, opts...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create replay backend: %w", err)
+		}
+		backends[name] = backend
+	}
+	return backends, nil
+}
+
 type badgerLogger struct {
 	l *zap.Logger
 }
@@ -107,6 +152,20 @@ func (a *Agent) Run(ctx context.Context) error {
 	group.Go(func() error {
 		return a.flowSvc.Start(groupCtx)
 	})
+	if a.config.ControlSocket != "" {
+		server, err := control.NewServer(a.log.Named("control"), controlAgent{a: a})
+		if err != nil {
+			return err
+		}
+		group.Go(func() error {
+			return server.Serve(groupCtx, a.config.ControlSocket)
+		})
+	}
+	if a.config.Metrics != "" {
+		group.Go(func() error {
+			return metrics.Serve(groupCtx, a.log.Named("metrics"), a.config.Metrics, metrics.Default)
+		})
+	}
 
 	err := group.Wait()
 	if err != nil {
@@ -119,6 +178,10 @@ func (a *Agent) HID() *hidsvc.Service {
 	return a.hidSvc
 }
 
+func (a *Agent) Flow() *flowsvc.Service {
+	return a.flowSvc
+}
+
 func (a *Agent) Config() *configsvc.Service {
 	return a.configSvc
 }
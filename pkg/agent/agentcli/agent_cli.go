@@ -7,11 +7,20 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 
+	"github.com/neuroplastio/neio-agent/components/nodes"
 	"github.com/neuroplastio/neio-agent/hidapi/hiddesc"
+	"github.com/neuroplastio/neio-agent/internal/configsvc"
+	"github.com/neuroplastio/neio-agent/internal/control"
+	"github.com/neuroplastio/neio-agent/internal/flowsvc"
 	"github.com/neuroplastio/neio-agent/internal/hidsvc"
+	"github.com/neuroplastio/neio-agent/internal/hidsvc/monitor"
+	"github.com/neuroplastio/neio-agent/internal/hidsvc/replay"
 	"github.com/neuroplastio/neio-agent/pkg/agent"
+	"github.com/neuroplastio/neio-agent/pkg/flowtest"
 	"github.com/spf13/cobra"
+	"go.uber.org/zap"
 )
 
 func Main(ctx context.Context, args []string, in io.Reader, out, errOut io.Writer) error {
@@ -34,6 +43,8 @@ func NewRootCmd(configDir string) *cobra.Command {
 		DataDir:    filepath.Join(configDir, "data"),
 		FlowConfig: filepath.Join(configDir, "flow.yml"),
 		UhidConfig: filepath.Join(configDir, "uhid.yml"),
+
+		ControlSocket: filepath.Join(configDir, "agent.sock"),
 	}
 	agentCmd := &cobra.Command{
 		Use:   "neio-agent",
@@ -47,6 +58,9 @@ func NewRootCmd(configDir string) *cobra.Command {
 	agentCmd.PersistentFlags().StringVar(&cfg.DataDir, "data-dir", cfg.DataDir, "data directory")
 	agentCmd.PersistentFlags().StringVar(&cfg.FlowConfig, "flow-config", cfg.FlowConfig, "flow config file")
 	agentCmd.PersistentFlags().StringVar(&cfg.UhidConfig, "uhid-config", cfg.UhidConfig, "uhid config file")
+	agentCmd.PersistentFlags().StringVar(&cfg.ControlSocket, "control-socket", cfg.ControlSocket, "control API socket, empty to disable the API")
+	agentCmd.PersistentFlags().StringVar(&cfg.Metrics, "metrics", "", "serve Prometheus metrics at host:port or unix:<path>")
+	agentCmd.PersistentFlags().StringArrayVar(&cfg.Replay, "replay", nil, "replay a recording (.nrec) in place of its input device")
 	agentCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
 		var err error
 		a, err = agent.NewAgent(cfg)
@@ -55,6 +69,14 @@ func NewRootCmd(configDir string) *cobra.Command {
 	agentCmd.AddCommand(NewRun(agentProvider))
 	agentCmd.AddCommand(NewListDevices(agentProvider))
 	agentCmd.AddCommand(NewGetReportDescriptor(agentProvider))
+	agentCmd.AddCommand(NewMonitor(agentProvider))
+	agentCmd.AddCommand(NewRecord(agentProvider))
+	agentCmd.AddCommand(NewTest(&cfg.FlowConfig))
+	agentCmd.AddCommand(NewGraph(&cfg.FlowConfig))
+	agentCmd.AddCommand(NewAnalyze(agentProvider))
+	agentCmd.AddCommand(NewDescriptor(agentProvider))
+	agentCmd.AddCommand(NewTrace(&cfg.ControlSocket))
+	agentCmd.AddCommand(NewCtl(&cfg.ControlSocket))
 	return agentCmd
 }
 
@@ -90,7 +112,7 @@ func NewListDevices(agent agentProvider) *cobra.Command {
 }
 
 func NewGetReportDescriptor(agent agentProvider) *cobra.Command {
-	var raw bool
+	var raw, text bool
 	cmd := &cobra.Command{
 		Use:   "get-report-descriptor",
 		Short: "Get report descriptor",
@@ -120,6 +142,9 @@ func NewGetReportDescriptor(agent agentProvider) *cobra.Command {
 				cmd.OutOrStdout().Write(descRaw)
 				return nil
 			}
+			if text {
+				return hiddesc.FormatText(cmd.OutOrStdout(), descRaw)
+			}
 			desc, err := hiddesc.Decode(descRaw)
 			if err != nil {
 				return err
@@ -133,5 +158,481 @@ func NewGetReportDescriptor(agent agentProvider) *cobra.Command {
 		},
 	}
 	cmd.Flags().BoolVar(&raw, "raw", false, "print raw report descriptor")
+	cmd.Flags().BoolVar(&text, "text", false, "print report descriptor items in the text format")
+	return cmd
+}
+
+func NewMonitor(agent agentProvider) *cobra.Command {
+	var (
+		format        string
+		statsInterval time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "monitor <addr>",
+		Short: "Monitor input reports",
+		Long:  `Print usage events decoded from raw input reports of a HID device, with the report rate and jitter. The device is not acquired, so it keeps working while monitored.`,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			addr, err := hidsvc.ParseAddress(args[0])
+			if err != nil {
+				return err
+			}
+			f, err := monitor.ParseFormat(format)
+			if err != nil {
+				return err
+			}
+			go agent().Config().Start(cmd.Context())
+			go agent().HID().Start(cmd.Context())
+			<-agent().Config().Ready()
+			<-agent().HID().Ready()
+			dev, err := agent().HID().OpenInputDevice(addr)
+			if err != nil {
+				return err
+			}
+			defer dev.Close()
+			m, err := monitor.New(zap.NewNop(), dev, cmd.OutOrStdout(),
+				monitor.WithFormat(f),
+				monitor.WithStatsInterval(statsInterval),
+			)
+			if err != nil {
+				return err
+			}
+			return m.Run(cmd.Context())
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", string(monitor.FormatText), "output format: text or json")
+	cmd.Flags().DurationVar(&statsInterval, "stats-interval", time.Second, "interval of report rate and jitter statistics, 0 to only print them on exit")
+	return cmd
+}
+
+func NewRecord(agent agentProvider) *cobra.Command {
+	var (
+		output   string
+		duration time.Duration
+	)
+	cmd := &cobra.Command{
+		Use:   "record <addr>",
+		Short: "Record input reports",
+		Long:  `Record raw input reports of a HID device with their timing and its report descriptor. The device is not acquired, so it keeps working while recording. Recordings are replayed with --replay.`,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) (err error) {
+			addr, err := hidsvc.ParseAddress(args[0])
+			if err != nil {
+				return err
+			}
+			ctx := cmd.Context()
+			go agent().Config().Start(ctx)
+			go agent().HID().Start(ctx)
+			<-agent().Config().Ready()
+			<-agent().HID().Ready()
+			info, err := agent().HID().GetInputDevice(addr)
+			if err != nil {
+				return err
+			}
+			dev, err := agent().HID().OpenInputDevice(addr)
+			if err != nil {
+				return err
+			}
+			defer dev.Close()
+			descriptor, err := dev.GetReportDescriptor()
+			if err != nil {
+				return err
+			}
+
+			f, err := os.Create(output)
+			if err != nil {
+				return fmt.Errorf("failed to create recording: %w", err)
+			}
+			defer f.Close()
+			w, err := replay.NewWriter(f, replay.Header{
+				Addr:       addr,
+				Name:       info.Name,
+				Descriptor: descriptor,
+				Start:      time.Now(),
+			})
+			if err != nil {
+				return err
+			}
+			// reports recorded before a failure are kept
+			defer func() {
+				if flushErr := w.Flush(); flushErr != nil && err == nil {
+					err = fmt.Errorf("failed to write recording: %w", flushErr)
+				}
+			}()
+
+			if duration > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, duration)
+				defer cancel()
+			}
+			go func() {
+				<-ctx.Done()
+				dev.Close()
+			}()
+			fmt.Fprintf(cmd.ErrOrStderr(), "Recording %s to %s, press Ctrl+C to stop\n", addr, output)
+			buf := make([]byte, 4096)
+			count := 0
+			for {
+				n, err := dev.Read(buf)
+				if err != nil {
+					if ctx.Err() != nil {
+						break
+					}
+					return fmt.Errorf("failed to read report: %w", err)
+				}
+				if err := w.Write(time.Now(), buf[:n]); err != nil {
+					return err
+				}
+				count++
+			}
+			fmt.Fprintf(cmd.ErrOrStderr(), "Recorded %d reports\n", count)
+			return nil
+		},
+	}
+	cmd.Flags().StringVarP(&output, "output", "o", "", "recording file (.nrec)")
+	cmd.MarkFlagRequired("output")
+	cmd.Flags().DurationVar(&duration, "duration", 0, "stop recording after the duration")
+	return cmd
+}
+
+func NewTest(flowConfig *string) *cobra.Command {
+	var settle time.Duration
+	cmd := &cobra.Command{
+		Use:   "test <scenarios.yml>...",
+		Short: "Test flow config",
+		Long:  `Run scenario files against the flow config with simulated devices and report unexpected output events.`,
+		Args:  cobra.MinimumNArgs(1),
+		// scenarios run without the agent
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			flow, err := flowtest.LoadFlow(*flowConfig)
+			if err != nil {
+				return err
+			}
+			loggerConfig := zap.NewDevelopmentConfig()
+			loggerConfig.Level = zap.NewAtomicLevelAt(zap.WarnLevel)
+			logger, err := loggerConfig.Build()
+			if err != nil {
+				return fmt.Errorf("failed to create logger: %w", err)
+			}
+			runner := flowtest.NewRunner(logger, flow, flowtest.WithSettleTime(settle))
+			var total, failed int
+			for _, path := range args {
+				file, err := flowtest.LoadFile(path)
+				if err != nil {
+					return err
+				}
+				results, err := runner.RunFile(cmd.Context(), file)
+				if err != nil {
+					return fmt.Errorf("%s: %w", path, err)
+				}
+				for _, result := range results {
+					total++
+					if !result.Passed() {
+						failed++
+					}
+					fmt.Fprint(cmd.OutOrStdout(), result)
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d scenarios failed", failed, total)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().DurationVar(&settle, "settle", 10*time.Millisecond, "real time the flow has to stay idle before the virtual clock is advanced")
+	return cmd
+}
+
+func NewGraph(flowConfig *string) *cobra.Command {
+	var format string
+	cmd := &cobra.Command{
+		Use:   "graph",
+		Short: "Export the flow graph",
+		Long: `Print the node graph of the flow config with node types, edges, split patterns, mux fallback routes, and the node actions and signals referenced by bind maps.
+The flow is validated but nothing is started.`,
+		Example: `  neio-agent graph | dot -Tsvg -o flow.svg`,
+		Args:    cobra.NoArgs,
+		// the graph is exported without the agent
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "dot" && format != "mermaid" && format != "json" {
+				return fmt.Errorf("unknown format %q, expected dot, mermaid or json", format)
+			}
+			flow, err := configsvc.Load(*flowConfig, flowsvc.FlowConfig{})
+			if err != nil {
+				return err
+			}
+			logger := zap.NewNop()
+			// nodes are not run, so the HID service is never started
+			registry := agent.NewRegistry(logger, hidsvc.New(nil, logger, time.Now))
+			graph, err := flowsvc.ExportGraph(logger, registry, flow)
+			if err != nil {
+				return err
+			}
+			switch format {
+			case "mermaid":
+				return flowsvc.WriteMermaid(cmd.OutOrStdout(), graph)
+			case "json":
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(graph)
+			default:
+				return flowsvc.WriteDOT(cmd.OutOrStdout(), graph)
+			}
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "dot", "output format: dot, mermaid or json")
+	return cmd
+}
+
+func NewAnalyze(agent agentProvider) *cobra.Command {
+	var format string
+	cmd := &cobra.Command{
+		Use:   "analyze",
+		Short: "Analyze the flow config",
+		Long: `Propagate usages of input devices through the flow to the outputs, using report descriptors of devices that were connected before, and report:
+  unencodable  usages sent to an output that cannot encode them
+  unreachable  bind mappings that can never be triggered
+  unmatched    usages that match no split route
+  shadowed     mappings and routes hidden by other ones
+  overlap      mappings triggered by the same usages
+  unknown      devices with unknown report descriptors`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unknown format %q, expected text or json", format)
+			}
+			findings, err := agent().Flow().Analyze()
+			if err != nil {
+				return err
+			}
+			if format == "json" {
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(findings); err != nil {
+					return err
+				}
+			} else {
+				for _, finding := range findings {
+					fmt.Fprintln(cmd.OutOrStdout(), finding)
+				}
+			}
+			if len(findings) > 0 {
+				return fmt.Errorf("%d findings", len(findings))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "text", "output format: text or json")
+	return cmd
+}
+
+func NewTrace(socket *string) *cobra.Command {
+	var (
+		node     string
+		edge     string
+		format   string
+		perfetto string
+	)
+	cmd := &cobra.Command{
+		Use:   "trace",
+		Short: "Trace flow events",
+		Long:  `Print events passed between flow nodes of the running agent, with the path each event took and the latency of every hop. Use --node and --edge to only trace events received (up) or sent (down) by a node. Events are read over the control socket of the agent until the command is interrupted.`,
+		Args:  cobra.NoArgs,
+		// the agent is already running
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return nil
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := flowsvc.ParseTraceEdge(edge); err != nil {
+				return err
+			}
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unknown format %q, expected text or json", format)
+			}
+			client, err := control.Dial(*socket)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+
+			var traced []flowsvc.TraceRecord
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			err = client.Trace(cmd.Context(), control.TraceArgs{Node: node, Edge: edge}, func(record flowsvc.TraceRecord) error {
+				if perfetto != "" {
+					traced = append(traced, record)
+				}
+				var err error
+				if format == "json" {
+					err = enc.Encode(newTraceLine(record))
+				} else {
+					_, err = fmt.Fprintln(cmd.OutOrStdout(), record)
+				}
+				if err != nil {
+					return fmt.Errorf("failed to print event: %w", err)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			if perfetto != "" {
+				f, err := os.Create(perfetto)
+				if err != nil {
+					return fmt.Errorf("failed to create trace file: %w", err)
+				}
+				defer f.Close()
+				if err := flowsvc.WritePerfetto(f, traced); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&node, "node", "", "only trace events of the node")
+	cmd.Flags().StringVar(&edge, "edge", "any", "edge of the node: up, down or any")
+	cmd.Flags().StringVar(&format, "format", "text", "output format: text or json")
+	cmd.Flags().StringVar(&perfetto, "perfetto", "", "write a Chrome/Perfetto trace to the file on exit")
+	return cmd
+}
+
+type traceHop struct {
+	Node    string        `json:"node"`
+	Latency time.Duration `json:"latency"`
+}
+
+type traceLine struct {
+	Time      time.Time  `json:"time"`
+	From      string     `json:"from"`
+	To        string     `json:"to"`
+	Direction string     `json:"direction"`
+	Type      string     `json:"type"`
+	Usages    []string   `json:"usages"`
+	Path      []traceHop `json:"path"`
+}
+
+func newTraceLine(record flowsvc.TraceRecord) traceLine {
+	line := traceLine{
+		Time:      record.Time,
+		From:      record.From,
+		To:        record.To,
+		Direction: record.Direction.String(),
+		Type:      record.TypeName(),
+		Usages:    make([]string, len(record.Usages)),
+		Path:      make([]traceHop, len(record.Hops)),
+	}
+	for i, usage := range record.Usages {
+		line.Usages[i] = usage.String()
+	}
+	for i, latency := range record.Latencies() {
+		line.Path[i] = traceHop{Node: record.Hops[i].Node, Latency: latency}
+	}
+	return line
+}
+
+func NewCtl(socket *string) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "ctl",
+		Short: "Control a running agent",
+		Long:  `Query and control a running agent over its control socket.`,
+		// the agent is already running
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			return nil
+		},
+	}
+	withClient := func(fn func(cmd *cobra.Command, args []string, client *control.Client) error) func(cmd *cobra.Command, args []string) error {
+		return func(cmd *cobra.Command, args []string) error {
+			client, err := control.Dial(*socket)
+			if err != nil {
+				return err
+			}
+			defer client.Close()
+			return fn(cmd, args, client)
+		}
+	}
+	printJSON := func(cmd *cobra.Command, v any) error {
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "Show devices and flow nodes",
+		Args:  cobra.NoArgs,
+		RunE: withClient(func(cmd *cobra.Command, args []string, client *control.Client) error {
+			status, err := client.Status()
+			if err != nil {
+				return err
+			}
+			return printJSON(cmd, status)
+		}),
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:     "signal <signal>",
+		Short:   "Call a node signal",
+		Example: `  neio-agent ctl signal '$layer.set("nav")'`,
+		Args:    cobra.ExactArgs(1),
+		RunE: withClient(func(cmd *cobra.Command, args []string, client *control.Client) error {
+			return client.Signal(args[0])
+		}),
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "route <mux>",
+		Short: "Show the current route of a mux node",
+		Args:  cobra.ExactArgs(1),
+		RunE: withClient(func(cmd *cobra.Command, args []string, client *control.Client) error {
+			node, err := client.Node(args[0])
+			if err != nil {
+				return err
+			}
+			var status nodes.MuxStatus
+			if err := node.DecodeDetails(&status); err != nil {
+				return fmt.Errorf("node %s is not a mux: %w", node.ID, err)
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), status.Route)
+			return nil
+		}),
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "held <output>",
+		Short: "Show usages held by an output node",
+		Args:  cobra.ExactArgs(1),
+		RunE: withClient(func(cmd *cobra.Command, args []string, client *control.Client) error {
+			node, err := client.Node(args[0])
+			if err != nil {
+				return err
+			}
+			var status hidsvc.OutputStatus
+			if err := node.DecodeDetails(&status); err != nil {
+				return fmt.Errorf("node %s is not an output: %w", node.ID, err)
+			}
+			for _, usage := range status.Held {
+				fmt.Fprintln(cmd.OutOrStdout(), usage)
+			}
+			return nil
+		}),
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "reload",
+		Short: "Reload the flow config",
+		Args:  cobra.NoArgs,
+		RunE: withClient(func(cmd *cobra.Command, args []string, client *control.Client) error {
+			return client.Reload()
+		}),
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:     "inject <node> <event>...",
+		Short:   "Send usage events from a node to its downstream nodes",
+		Example: `  neio-agent ctl inject kb +LeftShift +A -A -LeftShift`,
+		Args:    cobra.MinimumNArgs(2),
+		RunE: withClient(func(cmd *cobra.Command, args []string, client *control.Client) error {
+			return client.Inject(args[0], args[1:]...)
+		}),
+	})
 	return cmd
 }
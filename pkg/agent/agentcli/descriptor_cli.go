@@ -0,0 +1,331 @@
+package agentcli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/neuroplastio/neio-agent/hidapi"
+	"github.com/neuroplastio/neio-agent/hidapi/hiddesc"
+	"github.com/neuroplastio/neio-agent/internal/hidsvc"
+	"github.com/spf13/cobra"
+)
+
+func NewDescriptor(agent agentProvider) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "descriptor",
+		Short: "Work with report descriptors",
+		Long:  `Inspect and compose HID report descriptors of devices and descriptor files.`,
+	}
+	cmd.AddCommand(NewDescriptorFormat(agent))
+	cmd.AddCommand(NewDescriptorCompile())
+	cmd.AddCommand(NewDescriptorCompose(agent))
+	cmd.AddCommand(NewDescriptorDiff(agent))
+	cmd.AddCommand(NewDescriptorImport(agent))
+	cmd.AddCommand(NewDescriptorExport(agent))
+	return cmd
+}
+
+// loadRawDescriptor reads a report descriptor file in the text or raw format, or the stored descriptor
+// of a device address, and returns it in the raw format.
+func loadRawDescriptor(agent agentProvider, arg string) ([]byte, error) {
+	if _, err := os.Stat(arg); err != nil {
+		addr, err := hidsvc.ParseAddress(arg)
+		if err != nil {
+			return nil, err
+		}
+		return agent().HID().GetReportDescriptor(addr)
+	}
+	raw, err := os.ReadFile(arg)
+	if err != nil {
+		return nil, err
+	}
+	if hiddesc.IsText(raw) {
+		raw, err = hiddesc.ParseText(raw)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", arg, err)
+		}
+	}
+	return raw, nil
+}
+
+// loadDescriptor reads and decodes a report descriptor, see loadRawDescriptor.
+func loadDescriptor(agent agentProvider, arg string) (hiddesc.ReportDescriptor, error) {
+	raw, err := loadRawDescriptor(agent, arg)
+	if err != nil {
+		return hiddesc.ReportDescriptor{}, err
+	}
+	desc, err := hiddesc.Decode(raw)
+	if err != nil {
+		return hiddesc.ReportDescriptor{}, fmt.Errorf("failed to decode report descriptor of %s: %w", arg, err)
+	}
+	return desc, nil
+}
+
+func NewDescriptorFormat(agent agentProvider) *cobra.Command {
+	return &cobra.Command{
+		Use:   "format <addr|file>",
+		Short: "Print a report descriptor in the text format",
+		Long: `Print items of the report descriptor of a device, stored when it was connected before, or of a descriptor file in the text format.
+Usages, units and flags are named, the text is compiled back with "descriptor compile" or used as a file of an output.`,
+		Example: `  neio-agent descriptor format linux/3297:1969.0 > moonlander.txt`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			raw, err := loadRawDescriptor(agent, args[0])
+			if err != nil {
+				return err
+			}
+			return hiddesc.FormatText(cmd.OutOrStdout(), raw)
+		},
+	}
+}
+
+func NewDescriptorCompile() *cobra.Command {
+	return &cobra.Command{
+		Use:     "compile <file>",
+		Short:   "Compile a report descriptor from the text format",
+		Long:    `Compile a report descriptor in the text format to the raw format, printed to stdout.`,
+		Example: `  neio-agent descriptor compile moonlander.txt > moonlander.desc`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			text, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			raw, err := hiddesc.ParseText(text)
+			if err != nil {
+				return fmt.Errorf("failed to parse %s: %w", args[0], err)
+			}
+			_, err = cmd.OutOrStdout().Write(raw)
+			return err
+		},
+	}
+}
+
+type composeConflict struct {
+	Kind     hidapi.ComposeConflictKind `json:"kind"`
+	Sources  []string                   `json:"sources"`
+	ReportID uint8                      `json:"reportId,omitempty"`
+	Usages   []string                   `json:"usages,omitempty"`
+}
+
+type composeMapping struct {
+	Source   string `json:"source"`
+	SourceID uint8  `json:"sourceId"`
+	ID       uint8  `json:"id"`
+}
+
+func NewDescriptorCompose(agent agentProvider) *cobra.Command {
+	var (
+		format string
+		raw    bool
+	)
+	cmd := &cobra.Command{
+		Use:   "compose <addr|file>...",
+		Short: "Compose report descriptors",
+		Long: `Compose report descriptors of devices, stored when they were connected before, or of descriptor files in the text or raw format, the way an output with these inputs does.
+Print the mapping of report IDs of the sources to report IDs of the composed descriptor, and the conflicts:
+  reportId  report IDs used by more than one source, they are remapped
+  usage     usages encoded by more than one source, events are encoded in one of the reports only`,
+		Example: `  neio-agent descriptor compose linux/3297:1969.0 linux/046d:c547.0
+  neio-agent descriptor compose --raw kb.desc mouse.desc > composed.desc`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unknown format %q, expected text or json", format)
+			}
+			sources := make([]hidapi.DescriptorSource, 0, len(args))
+			for _, arg := range args {
+				desc, err := loadDescriptor(agent, arg)
+				if err != nil {
+					return err
+				}
+				sources = append(sources, hidapi.DescriptorSource{Name: arg, Descriptor: desc})
+			}
+			composed, err := hidapi.ComposeDescriptors(sources)
+			if err != nil {
+				return err
+			}
+			if raw {
+				descRaw, err := hiddesc.Encode(composed.Descriptor)
+				if err != nil {
+					return err
+				}
+				_, err = cmd.OutOrStdout().Write(descRaw)
+				return err
+			}
+			if format == "json" {
+				result := struct {
+					Mappings  []composeMapping  `json:"mappings"`
+					Conflicts []composeConflict `json:"conflicts"`
+				}{
+					Mappings:  make([]composeMapping, 0, len(composed.Mappings)),
+					Conflicts: make([]composeConflict, 0, len(composed.Conflicts)),
+				}
+				for _, mapping := range composed.Mappings {
+					result.Mappings = append(result.Mappings, composeMapping(mapping))
+				}
+				for _, conflict := range composed.Conflicts {
+					usages := make([]string, 0, len(conflict.Usages))
+					for _, usage := range conflict.Usages {
+						usages = append(usages, usage.String())
+					}
+					result.Conflicts = append(result.Conflicts, composeConflict{
+						Kind:     conflict.Kind,
+						Sources:  conflict.Sources,
+						ReportID: conflict.ReportID,
+						Usages:   usages,
+					})
+				}
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				return enc.Encode(result)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "SOURCE\tSOURCE ID\tID")
+			for _, mapping := range composed.Mappings {
+				fmt.Fprintf(w, "%s\t%d\t%d\n", mapping.Source, mapping.SourceID, mapping.ID)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			for _, conflict := range composed.Conflicts {
+				fmt.Fprintln(cmd.OutOrStdout(), conflict)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "text", "output format: text or json")
+	cmd.Flags().BoolVar(&raw, "raw", false, "print the raw composed report descriptor")
+	return cmd
+}
+
+type descriptorDifference struct {
+	Path  string `json:"path"`
+	Field string `json:"field,omitempty"`
+	A     string `json:"a,omitempty"`
+	B     string `json:"b,omitempty"`
+}
+
+func NewDescriptorDiff(agent agentProvider) *cobra.Command {
+	var format string
+	cmd := &cobra.Command{
+		Use:   "diff <addr|file> <addr|file>",
+		Short: "Compare report descriptors",
+		Long: `Compare report descriptors of devices, stored when they were connected before, or of descriptor files in the text or raw format, by meaning rather than by bytes.
+Print fields of items that differ, and items that were added (+) or removed (-). Exits with an error when descriptors differ.`,
+		Example: `  neio-agent get-report-descriptor --raw linux/3297:1969.0 > current.desc
+  neio-agent descriptor diff linux/3297:1969.0 current.desc`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if format != "text" && format != "json" {
+				return fmt.Errorf("unknown format %q, expected text or json", format)
+			}
+			a, err := loadDescriptor(agent, args[0])
+			if err != nil {
+				return err
+			}
+			b, err := loadDescriptor(agent, args[1])
+			if err != nil {
+				return err
+			}
+			diffs := hiddesc.Diff(a, b)
+			if format == "json" {
+				result := make([]descriptorDifference, 0, len(diffs))
+				for _, diff := range diffs {
+					result = append(result, descriptorDifference(diff))
+				}
+				enc := json.NewEncoder(cmd.OutOrStdout())
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(result); err != nil {
+					return err
+				}
+			} else {
+				for _, diff := range diffs {
+					fmt.Fprintln(cmd.OutOrStdout(), diff)
+				}
+			}
+			if len(diffs) > 0 {
+				return fmt.Errorf("%d differences", len(diffs))
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&format, "format", "text", "output format: text or json")
+	return cmd
+}
+
+func NewDescriptorImport(agent agentProvider) *cobra.Command {
+	var addr, name string
+	cmd := &cobra.Command{
+		Use:   "import --addr <addr> <file>",
+		Short: "Import a report descriptor of an input device",
+		Long: `Store a report descriptor file in the text or raw format as the descriptor of an input device, as if the device was connected before.
+Outputs with the device in their descriptor inputs can be composed before the device is ever connected. The descriptor is replaced when the device connects.`,
+		Example: `  neio-agent descriptor import --addr linux/3297:1969.0 --name "ZSA Moonlander" moonlander.desc`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			deviceAddr, err := hidsvc.ParseAddress(addr)
+			if err != nil {
+				return err
+			}
+			raw, err := loadRawDescriptor(agent, args[0])
+			if err != nil {
+				return err
+			}
+			dev, err := agent().HID().ImportInputDevice(deviceAddr, name, raw)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "imported %s (%s)\n", dev.Address, dev.Name)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&addr, "addr", "", "address of the input device")
+	cmd.Flags().StringVar(&name, "name", "", "name of the input device, kept from the stored device by default")
+	cmd.MarkFlagRequired("addr")
+	return cmd
+}
+
+func NewDescriptorExport(agent agentProvider) *cobra.Command {
+	var text bool
+	cmd := &cobra.Command{
+		Use:   "export <addr> [file]",
+		Short: "Export the stored report descriptor of an input device",
+		Long:  `Write the report descriptor of an input device, stored when it was connected or imported, to a file or stdout, to be imported with "descriptor import".`,
+		Example: `  neio-agent descriptor export linux/3297:1969.0 moonlander.desc
+  neio-agent descriptor export --text linux/3297:1969.0 moonlander.txt`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			addr, err := hidsvc.ParseAddress(args[0])
+			if err != nil {
+				return err
+			}
+			raw, err := agent().HID().GetReportDescriptor(addr)
+			if err != nil {
+				return err
+			}
+			out := cmd.OutOrStdout()
+			if len(args) == 2 {
+				f, err := os.Create(args[1])
+				if err != nil {
+					return err
+				}
+				defer f.Close()
+				out = f
+			}
+			if text {
+				err = hiddesc.FormatText(out, raw)
+			} else {
+				_, err = out.Write(raw)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to write report descriptor: %w", err)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&text, "text", false, "write the report descriptor in the text format")
+	return cmd
+}
@@ -8,4 +8,11 @@ type Config struct {
 	FlowConfig   string `json:"flowConfig"`
 	DeviceConfig string `json:"deviceConfig"`
 	UhidConfig   string `json:"uhidConfig"`
+	// Replay lists recordings that replace input devices at their addresses.
+	Replay []string `json:"replay"`
+	// Metrics is the address metrics are served at, either host:port or unix:<path>.
+	// Metrics are not served if it is empty.
+	Metrics string `json:"metrics"`
+	// ControlSocket is the path of the Unix socket of the control API. The API is not served if it is empty.
+	ControlSocket string `json:"controlSocket"`
 }
@@ -0,0 +1,64 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/neuroplastio/neio-agent/flowapi"
+	"github.com/neuroplastio/neio-agent/hidapi"
+	"github.com/neuroplastio/neio-agent/internal/control"
+	"github.com/neuroplastio/neio-agent/internal/flowsvc"
+)
+
+// controlAgent exposes services of the agent to the control API.
+type controlAgent struct {
+	a *Agent
+}
+
+func (c controlAgent) Devices() ([]control.Device, error) {
+	inputs, err := c.a.hidSvc.ListInputDevices()
+	if err != nil {
+		return nil, err
+	}
+	outputs, err := c.a.hidSvc.ListOutputDevices()
+	if err != nil {
+		return nil, err
+	}
+	devices := make([]control.Device, 0, len(inputs)+len(outputs))
+	for _, dev := range inputs {
+		devices = append(devices, control.Device{
+			Addr:      dev.Address.String(),
+			Name:      dev.Name,
+			Kind:      "input",
+			Connected: c.a.hidSvc.IsInputConnected(dev.Address),
+		})
+	}
+	for _, dev := range outputs {
+		devices = append(devices, control.Device{
+			Addr:      dev.Address.String(),
+			Name:      dev.Name,
+			Kind:      "output",
+			Connected: c.a.hidSvc.IsOutputConnected(dev.Address),
+		})
+	}
+	return devices, nil
+}
+
+func (c controlAgent) Nodes() ([]flowsvc.NodeStatus, error) {
+	return c.a.flowSvc.Status()
+}
+
+func (c controlAgent) Signal(ctx context.Context, expr string) error {
+	return c.a.flowSvc.Signal(ctx, expr)
+}
+
+func (c controlAgent) Inject(nodeID string, usages []hidapi.UsageEvent) error {
+	return c.a.flowSvc.Inject(nodeID, flowapi.HIDEventTypeInput, usages)
+}
+
+func (c controlAgent) Reload() error {
+	return c.a.flowSvc.Reload()
+}
+
+func (c controlAgent) Trace(ctx context.Context, filter flowsvc.TraceFilter) <-chan flowsvc.TraceRecord {
+	return c.a.flowSvc.Tracer().Subscribe(ctx, filter, 1024)
+}
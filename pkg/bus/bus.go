@@ -3,6 +3,8 @@ package bus
 import (
 	"context"
 	"fmt"
+	"sync/atomic"
+	"time"
 
 	"github.com/puzpuzpuz/xsync/v3"
 	"go.uber.org/zap"
@@ -26,17 +28,22 @@ type Subscriber[K key, M message] func(ctx context.Context) <-chan Message[K, M]
 type MessageSubscriber[M message] func(ctx context.Context) <-chan M
 
 type Bus[K key, M message] struct {
-	log         *zap.Logger
-	concurrency int
-	ready       chan struct{}
+	log   *zap.Logger
+	opts  options[M]
+	ready chan struct{}
 
-	ch         chan Message[K, M]
-	keySubs    *xsync.MapOf[K, map[chan Message[K, M]]struct{}]
-	globalSubs *xsync.MapOf[chan Message[K, M], struct{}]
+	shards    []*laneQueue[K, M]
+	shardKeys *xsync.MapOf[K, int]
+	nextShard atomic.Uint32
 
-	eventCh      chan Message[K, EventType]
-	eventSubs    *xsync.MapOf[chan Message[K, EventType], struct{}]
-	keyEventSubs *xsync.MapOf[K, map[chan Message[K, EventType]]struct{}]
+	keySubs    *xsync.MapOf[K, []*mailbox[K, M]]
+	globalSubs *xsync.MapOf[*mailbox[K, M], struct{}]
+
+	eventSubs    *xsync.MapOf[*mailbox[K, EventType], struct{}]
+	keyEventSubs *xsync.MapOf[K, []*mailbox[K, EventType]]
+
+	dropped   atomic.Uint64
+	onDropped func(key K)
 }
 
 type EventType uint8
@@ -46,67 +53,166 @@ const (
 	EventTypeUnsubscribed
 )
 
-func NewBus[K key, M message](logger *zap.Logger) *Bus[K, M] {
-	return &Bus[K, M]{
-		log:         logger,
-		ready:       make(chan struct{}),
-		concurrency: 1,
+type options[M message] struct {
+	queueSize   int
+	mailboxSize int
+	shards      int
+	lane        LaneFunc[M]
+	merge       MergeFunc[M]
+	clone       func(M) M
+	release     func(M)
+}
+
+type Option[M message] func(o *options[M])
+
+// WithQueueSize sets the number of messages each lane can hold before publishers are blocked.
+func WithQueueSize[M message](size int) Option[M] {
+	return func(o *options[M]) {
+		o.queueSize = size
+	}
+}
+
+// WithMailboxSize sets the number of messages buffered for each subscriber.
+// Bulk messages to a subscriber with a full mailbox are dropped, priority messages wait for space.
+func WithMailboxSize[M message](size int) Option[M] {
+	return func(o *options[M]) {
+		o.mailboxSize = size
+	}
+}
+
+// WithShards sets the number of workers delivering messages. Each key is assigned to a single worker,
+// so messages published with the same key are always delivered in order.
+func WithShards[M message](shards int) Option[M] {
+	return func(o *options[M]) {
+		o.shards = shards
+	}
+}
+
+// WithLanes enables bulk lane for messages classified by lane function.
+// Bulk messages are delivered only when there are no priority messages waiting, unless a priority
+// message with the same key follows them, and pending bulk messages with the same key are merged with merge function (if provided).
+func WithLanes[M message](lane LaneFunc[M], merge MergeFunc[M]) Option[M] {
+	return func(o *options[M]) {
+		o.lane = lane
+		o.merge = merge
+	}
+}
+
+// WithOwnership makes every subscriber the single owner of messages it receives.
+// Messages delivered to more than one subscriber are copied with clone,
+// and messages that are dropped or have no subscribers are passed to release.
+func WithOwnership[M message](clone func(M) M, release func(M)) Option[M] {
+	return func(o *options[M]) {
+		o.clone = clone
+		o.release = release
+	}
+}
+
+func NewBus[K key, M message](logger *zap.Logger, opts ...Option[M]) *Bus[K, M] {
+	options := options[M]{
+		queueSize:   256,
+		mailboxSize: 1024,
+		shards:      1,
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	b := &Bus[K, M]{
+		log:   logger,
+		opts:  options,
+		ready: make(chan struct{}),
 
-		ch:         make(chan Message[K, M]),
-		keySubs:    xsync.NewMapOf[K, map[chan Message[K, M]]struct{}](),
-		globalSubs: xsync.NewMapOf[chan Message[K, M], struct{}](),
+		shardKeys:  xsync.NewMapOf[K, int](),
+		keySubs:    xsync.NewMapOf[K, []*mailbox[K, M]](),
+		globalSubs: xsync.NewMapOf[*mailbox[K, M], struct{}](),
 
-		eventCh:      make(chan Message[K, EventType]),
-		eventSubs:    xsync.NewMapOf[chan Message[K, EventType], struct{}](),
-		keyEventSubs: xsync.NewMapOf[K, map[chan Message[K, EventType]]struct{}](),
+		eventSubs:    xsync.NewMapOf[*mailbox[K, EventType], struct{}](),
+		keyEventSubs: xsync.NewMapOf[K, []*mailbox[K, EventType]](),
+	}
+	for i := 0; i < options.shards; i++ {
+		b.shards = append(b.shards, newLaneQueue[K, M](options.queueSize, options.lane, options.merge))
 	}
+	return b
 }
 
 func (b *Bus[K, M]) Start(ctx context.Context) error {
-	if b.concurrency < 1 {
-		return fmt.Errorf("concurrency must be at least 1")
+	if len(b.shards) < 1 {
+		return fmt.Errorf("at least one shard is required")
 	}
-	// TODO: thread pool?
-	for i := 0; i < b.concurrency; i++ {
-		b.startWorker(ctx)
+	for _, shard := range b.shards {
+		go b.runShard(ctx, shard)
 	}
 	close(b.ready)
 	return nil
 }
 
-func (b *Bus[K, M]) startWorker(ctx context.Context) {
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case msg := <-b.ch:
-				b.process(ctx, msg)
-			}
-		}
-	}()
-	go func() {
-		for {
-			select {
-			case <-ctx.Done():
-				return
-			case msg := <-b.eventCh:
-				b.processEvent(ctx, msg)
-			}
+func (b *Bus[K, M]) runShard(ctx context.Context, shard *laneQueue[K, M]) {
+	for {
+		msg, ok := shard.pop(ctx)
+		if !ok {
+			return
 		}
-	}()
+		b.process(ctx, msg)
+	}
 }
 
 func (b *Bus[K, M]) Ready() <-chan struct{} {
 	return b.ready
 }
 
+// Dropped returns the number of messages dropped due to full queues or mailboxes.
+func (b *Bus[K, M]) Dropped() uint64 {
+	return b.dropped.Load()
+}
+
+// OnDropped sets a function called with the key of every dropped message. It must be set before
+// the bus is started.
+func (b *Bus[K, M]) OnDropped(fn func(key K)) {
+	b.onDropped = fn
+}
+
+func (b *Bus[K, M]) drop(key K) {
+	b.dropped.Add(1)
+	if b.onDropped != nil {
+		b.onDropped(key)
+	}
+}
+
+// Publish queues the message for delivery. It only blocks if the lane of the message is full.
 func (b *Bus[K, M]) Publish(ctx context.Context, key K, msg M) {
-	select {
-	case <-ctx.Done():
+	if !b.shard(key).push(ctx, Message[K, M]{key, msg}) {
+		b.drop(key)
+		b.releaseMessage(msg)
+		b.log.Warn("Message dropped", zap.Any("key", key), zap.Error(ctx.Err()))
+	}
+}
+
+// PublishTimeout queues the message for delivery, waiting at most timeout if the lane of the message is full.
+// Only bulk messages are dropped after the timeout, priority messages wait like with Publish.
+func (b *Bus[K, M]) PublishTimeout(ctx context.Context, key K, msg M, timeout time.Duration) {
+	queue := b.shard(key)
+	if ok, _ := queue.tryPush(Message[K, M]{key, msg}); ok {
 		return
-	case b.ch <- Message[K, M]{key, msg}:
 	}
+	if queue.laneOf(Message[K, M]{key, msg}) != LaneBulk {
+		b.Publish(ctx, key, msg)
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	b.Publish(ctx, key, msg)
+}
+
+// shard returns the queue of the worker assigned to the key.
+// Keys are assigned to workers in round-robin order on first use.
+func (b *Bus[K, M]) shard(key K) *laneQueue[K, M] {
+	if len(b.shards) == 1 {
+		return b.shards[0]
+	}
+	idx, _ := b.shardKeys.LoadOrCompute(key, func() int {
+		return int(b.nextShard.Add(1)-1) % len(b.shards)
+	})
+	return b.shards[idx]
 }
 
 func (b *Bus[K, M]) CreatePublisher(key K) Publisher[M] {
@@ -115,6 +221,12 @@ func (b *Bus[K, M]) CreatePublisher(key K) Publisher[M] {
 	}
 }
 
+func (b *Bus[K, M]) CreateTimeoutPublisher(key K, timeout time.Duration) Publisher[M] {
+	return func(ctx context.Context, msg M) {
+		b.PublishTimeout(ctx, key, msg, timeout)
+	}
+}
+
 func (b *Bus[K, M]) CreateSubscriber(key ...K) Subscriber[K, M] {
 	return func(ctx context.Context) <-chan Message[K, M] {
 		return b.Subscribe(ctx, key...)
@@ -139,125 +251,142 @@ func (b *Bus[K, M]) CreateMessageSubscriber(key ...K) MessageSubscriber[M] {
 	}
 }
 
-func (b *Bus[K, M]) publishEvent(ctx context.Context, key K, e EventType) {
-	select {
-	case <-ctx.Done():
-		return
-	case b.eventCh <- Message[K, EventType]{key, e}:
-	}
-}
-
+// process delivers the message to all subscribers. With ownership enabled, the last subscriber
+// receives the original message and the others receive copies.
 func (b *Bus[K, M]) process(ctx context.Context, msg Message[K, M]) {
-	b.globalSubs.Range(func(sub chan Message[K, M], _ struct{}) bool {
-		select {
-		case <-ctx.Done():
-			return false
-		case sub <- msg:
+	var last *mailbox[K, M]
+	next := func(sub *mailbox[K, M]) {
+		if last != nil {
+			b.deliver(ctx, last, b.copyMessage(msg))
 		}
+		last = sub
+	}
+	b.globalSubs.Range(func(sub *mailbox[K, M], _ struct{}) bool {
+		next(sub)
 		return true
 	})
-	subs, ok := b.keySubs.Load(msg.Key)
-	if !ok {
+	subs, _ := b.keySubs.Load(msg.Key)
+	for _, sub := range subs {
+		next(sub)
+	}
+	if last == nil {
+		b.releaseMessage(msg.Message)
 		return
 	}
-	for sub := range subs {
-		select {
-		case <-ctx.Done():
-			return
-		case sub <- msg:
+	b.deliver(ctx, last, msg)
+}
+
+// deliver queues the message in the mailbox of the subscriber. Bulk messages are merged or dropped
+// when the mailbox is full, priority messages wait for the subscriber, so that key presses and
+// releases are never lost.
+func (b *Bus[K, M]) deliver(ctx context.Context, sub *mailbox[K, M], msg Message[K, M]) {
+	if sub.queue.laneOf(msg) == LaneBulk {
+		if ok, _ := sub.queue.tryPush(msg); !ok {
+			b.drop(msg.Key)
+			b.releaseMessage(msg.Message)
+			b.log.Warn("Subscriber mailbox is full, message dropped", zap.Any("key", msg.Key))
 		}
+		return
 	}
+	if !sub.push(ctx, msg) {
+		// the subscriber or the bus is gone
+		b.releaseMessage(msg.Message)
+	}
+}
+
+func (b *Bus[K, M]) copyMessage(msg Message[K, M]) Message[K, M] {
+	if b.opts.clone != nil {
+		msg.Message = b.opts.clone(msg.Message)
+	}
+	return msg
 }
 
-func (b *Bus[K, M]) processEvent(ctx context.Context, msg Message[K, EventType]) {
-	b.eventSubs.Range(func(sub chan Message[K, EventType], _ struct{}) bool {
-		select {
-		case <-ctx.Done():
-			return false
-		case sub <- msg:
+func (b *Bus[K, M]) releaseMessage(msg M) {
+	if b.opts.release != nil {
+		b.opts.release(msg)
+	}
+}
+
+// publishEvent delivers subscription event to event subscribers without blocking.
+func (b *Bus[K, M]) publishEvent(key K, e EventType) {
+	msg := Message[K, EventType]{key, e}
+	deliver := func(sub *mailbox[K, EventType]) {
+		if ok, _ := sub.queue.tryPush(msg); !ok {
+			b.log.Warn("Event mailbox is full, event dropped", zap.Any("key", key))
 		}
+	}
+	b.eventSubs.Range(func(sub *mailbox[K, EventType], _ struct{}) bool {
+		deliver(sub)
 		return true
 	})
-	subs, ok := b.keyEventSubs.Load(msg.Key)
-	if !ok {
-		return
-	}
-	for sub := range subs {
-		select {
-		case <-ctx.Done():
-			return
-		case sub <- msg:
-		}
+	subs, _ := b.keyEventSubs.Load(key)
+	for _, sub := range subs {
+		deliver(sub)
 	}
 }
 
 func (b *Bus[K, M]) Subscribe(ctx context.Context, key ...K) <-chan Message[K, M] {
-	ch := make(chan Message[K, M])
+	sub := newMailbox[K, M](ctx, b.opts.mailboxSize, b.opts.lane, b.mailboxMerge())
 	if len(key) == 0 {
-		b.globalSubs.Store(ch, struct{}{})
+		b.globalSubs.Store(sub, struct{}{})
 		var zeroKey K
-		b.publishEvent(ctx, zeroKey, EventTypeSubscribed)
+		b.publishEvent(zeroKey, EventTypeSubscribed)
 		go func() {
-			<-ctx.Done()
-			close(ch)
-			b.globalSubs.Delete(ch)
-			b.publishEvent(ctx, zeroKey, EventTypeUnsubscribed)
+			defer close(sub.ch)
+			sub.run(b.releaseMessage)
+			b.globalSubs.Delete(sub)
+			sub.drain(b.releaseMessage)
+			b.publishEvent(zeroKey, EventTypeUnsubscribed)
 		}()
-		return ch
+		return sub.ch
 	}
 	for _, k := range key {
-		b.keySubs.Compute(k, func(val map[chan Message[K, M]]struct{}, ok bool) (map[chan Message[K, M]]struct{}, bool) {
-			if !ok {
-				val = make(map[chan Message[K, M]]struct{}, 64)
-			}
-			val[ch] = struct{}{}
-			return val, false
-		})
-		b.publishEvent(ctx, k, EventTypeSubscribed)
+		addSubscriber(b.keySubs, k, sub)
+		b.publishEvent(k, EventTypeSubscribed)
 	}
 	go func() {
-		<-ctx.Done()
-		close(ch)
+		defer close(sub.ch)
+		sub.run(b.releaseMessage)
+		for _, k := range key {
+			removeSubscriber(b.keySubs, k, sub)
+		}
+		sub.drain(b.releaseMessage)
 		for _, k := range key {
-			b.keySubs.Compute(k, func(val map[chan Message[K, M]]struct{}, ok bool) (map[chan Message[K, M]]struct{}, bool) {
-				delete(val, ch)
-				return val, false
-			})
-			b.publishEvent(ctx, k, EventTypeUnsubscribed)
+			b.publishEvent(k, EventTypeUnsubscribed)
 		}
 	}()
-	return ch
+	return sub.ch
+}
+
+// mailboxMerge returns merge function for subscriber mailboxes. Messages can only be merged
+// in mailboxes when every subscriber owns its messages.
+func (b *Bus[K, M]) mailboxMerge() MergeFunc[M] {
+	if b.opts.clone == nil {
+		return nil
+	}
+	return b.opts.merge
 }
 
 func (b *Bus[K, M]) SubscribeEvents(ctx context.Context, key ...K) <-chan Message[K, EventType] {
-	ch := make(chan Message[K, EventType])
+	sub := newMailbox[K, EventType](ctx, b.opts.mailboxSize, nil, nil)
 	if len(key) == 0 {
-		b.eventSubs.Store(ch, struct{}{})
+		b.eventSubs.Store(sub, struct{}{})
 		go func() {
-			<-ctx.Done()
-			close(ch)
-			b.eventSubs.Delete(ch)
+			defer close(sub.ch)
+			sub.run(func(EventType) {})
+			b.eventSubs.Delete(sub)
 		}()
-		return ch
+		return sub.ch
 	}
 	for _, k := range key {
-		b.keyEventSubs.Compute(k, func(val map[chan Message[K, EventType]]struct{}, ok bool) (map[chan Message[K, EventType]]struct{}, bool) {
-			if !ok {
-				val = make(map[chan Message[K, EventType]]struct{}, 64)
-			}
-			val[ch] = struct{}{}
-			return val, false
-		})
+		addSubscriber(b.keyEventSubs, k, sub)
 	}
 	go func() {
-		<-ctx.Done()
-		close(ch)
+		defer close(sub.ch)
+		sub.run(func(EventType) {})
 		for _, k := range key {
-			b.keyEventSubs.Compute(k, func(val map[chan Message[K, EventType]]struct{}, ok bool) (map[chan Message[K, EventType]]struct{}, bool) {
-				delete(val, ch)
-				return val, false
-			})
+			removeSubscriber(b.keyEventSubs, k, sub)
 		}
 	}()
-	return ch
+	return sub.ch
 }
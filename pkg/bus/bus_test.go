@@ -0,0 +1,305 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+func TestBusSlowSubscriber(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := NewBus[int, int](zap.NewNop(), WithMailboxSize[int](4), WithLanes[int](func(int) Lane { return LaneBulk }, nil))
+	var droppedKeys atomic.Uint64
+	b.OnDropped(func(key int) {
+		if key == 1 {
+			droppedKeys.Add(1)
+		}
+	})
+	if err := b.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// never read
+	b.Subscribe(ctx, 1)
+	fast := b.Subscribe(ctx, 1)
+
+	const count = 100
+	for i := 0; i < count; i++ {
+		b.Publish(ctx, 1, i)
+		select {
+		case msg := <-fast:
+			if msg.Message != i {
+				t.Fatalf("expected %d, got %d", i, msg.Message)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("fast subscriber is blocked at message %d", i)
+		}
+	}
+	if b.Dropped() == 0 {
+		t.Fatal("expected dropped bulk messages for slow subscriber")
+	}
+	if droppedKeys.Load() != b.Dropped() {
+		t.Fatalf("expected %d dropped keys, got %d", b.Dropped(), droppedKeys.Load())
+	}
+}
+
+func TestBusPriorityBackpressure(t *testing.T) {
+	publishers := map[string]func(b *Bus[int, int], ctx context.Context, msg int){
+		"publish": func(b *Bus[int, int], ctx context.Context, msg int) {
+			b.Publish(ctx, 1, msg)
+		},
+		"timeout": func(b *Bus[int, int], ctx context.Context, msg int) {
+			b.PublishTimeout(ctx, 1, msg, time.Microsecond)
+		},
+	}
+	for name, publish := range publishers {
+		t.Run(name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			b := NewBus[int, int](zap.NewNop(), WithMailboxSize[int](2), WithQueueSize[int](2))
+			if err := b.Start(ctx); err != nil {
+				t.Fatal(err)
+			}
+			slow := b.Subscribe(ctx, 1)
+
+			const count = 20
+			published := make(chan struct{})
+			go func() {
+				defer close(published)
+				for i := 0; i < count; i++ {
+					publish(b, ctx, i)
+				}
+			}()
+			select {
+			case <-published:
+				t.Fatal("publisher should be blocked by a full mailbox")
+			case <-time.After(10 * time.Millisecond):
+			}
+			for i := 0; i < count; i++ {
+				select {
+				case msg := <-slow:
+					if msg.Message != i {
+						t.Fatalf("expected %d, got %d", i, msg.Message)
+					}
+				case <-time.After(time.Second):
+					t.Fatalf("timed out at message %d", i)
+				}
+			}
+			<-published
+			if b.Dropped() != 0 {
+				t.Fatalf("expected no dropped priority messages, got %d", b.Dropped())
+			}
+		})
+	}
+}
+
+func TestBusShardOrdering(t *testing.T) {
+	const keys, count = 8, 500
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := NewBus[int, int](zap.NewNop(), WithShards[int](4), WithMailboxSize[int](keys*count))
+	if err := b.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sub := b.Subscribe(ctx)
+	for k := 0; k < keys; k++ {
+		go func(k int) {
+			for i := 0; i < count; i++ {
+				b.Publish(ctx, k, i)
+			}
+		}(k)
+	}
+	next := make(map[int]int)
+	for i := 0; i < keys*count; i++ {
+		select {
+		case msg := <-sub:
+			if msg.Message != next[msg.Key] {
+				t.Fatalf("key %d: expected %d, got %d", msg.Key, next[msg.Key], msg.Message)
+			}
+			next[msg.Key]++
+		case <-time.After(time.Second):
+			t.Fatalf("timed out after %d messages", i)
+		}
+	}
+}
+
+func TestBusEventsDoNotBlock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	b := NewBus[int, int](zap.NewNop())
+	if err := b.Start(ctx); err != nil {
+		t.Fatal(err)
+	}
+	// never read
+	b.SubscribeEvents(ctx)
+	events := b.SubscribeEvents(ctx, 1)
+
+	subCtx, subCancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		b.Subscribe(subCtx, 1)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("subscribe is blocked by event subscriber")
+	}
+	subCancel()
+	for _, expected := range []EventType{EventTypeSubscribed, EventTypeUnsubscribed} {
+		select {
+		case e := <-events:
+			if e.Key != 1 || e.Message != expected {
+				t.Fatalf("expected %v, got %v", expected, e)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("timed out waiting for event %d", expected)
+		}
+	}
+}
+
+type benchBus interface {
+	Publish(ctx context.Context, key int, msg time.Time)
+	Subscribe(ctx context.Context, key ...int) <-chan Message[int, time.Time]
+}
+
+func newBenchBus(ctx context.Context, impl string, shards int) benchBus {
+	switch impl {
+	case "legacy":
+		b := newLegacyBus[int, time.Time](zap.NewNop())
+		_ = b.Start(ctx)
+		return b
+	default:
+		b := NewBus[int, time.Time](zap.NewNop(), WithShards[time.Time](shards))
+		_ = b.Start(ctx)
+		return b
+	}
+}
+
+// runBusBenchmark publishes b.N messages over keys and reports latency percentiles observed by
+// fast subscribers and the number of dropped messages. Slow subscribers are only used to apply backpressure.
+func runBusBenchmark(b *testing.B, impl string, shards, keys, subscribers, slow int) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	perKey := b.N / keys
+	total := perKey * keys
+	bus := newBenchBus(ctx, impl, shards)
+
+	for i := 0; i < slow; i++ {
+		ch := bus.Subscribe(ctx)
+		go func() {
+			for range ch {
+				time.Sleep(20 * time.Microsecond)
+			}
+		}()
+	}
+	// subscribers stop early when messages were dropped
+	stop := make(chan struct{})
+	latencies := make([][]time.Duration, subscribers)
+	var wg sync.WaitGroup
+	for i := 0; i < subscribers; i++ {
+		ch := bus.Subscribe(ctx)
+		latencies[i] = make([]time.Duration, 0, total)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for n := 0; n < total; n++ {
+				select {
+				case msg := <-ch:
+					latencies[i] = append(latencies[i], time.Since(msg.Message))
+				case <-stop:
+					return
+				}
+			}
+		}(i)
+	}
+
+	b.ResetTimer()
+	var pubWg sync.WaitGroup
+	for k := 0; k < keys; k++ {
+		pubWg.Add(1)
+		go func(k int) {
+			defer pubWg.Done()
+			for n := 0; n < perKey; n++ {
+				bus.Publish(ctx, k, time.Now())
+			}
+		}(k)
+	}
+	pubWg.Wait()
+	var dropped uint64
+	if bus, ok := bus.(*Bus[int, time.Time]); ok {
+		received := make(chan struct{})
+		go func() {
+			wg.Wait()
+			close(received)
+		}()
+		for done := false; !done; {
+			select {
+			case <-received:
+				done = true
+			case <-time.After(100 * time.Millisecond):
+				if bus.Dropped() > 0 {
+					close(stop)
+					<-received
+					done = true
+				}
+			}
+		}
+		dropped = bus.Dropped()
+	}
+	wg.Wait()
+	b.StopTimer()
+
+	b.ReportMetric(float64(dropped), "dropped")
+	var all []time.Duration
+	for _, l := range latencies {
+		all = append(all, l...)
+	}
+	if len(all) == 0 {
+		return
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	b.ReportMetric(float64(all[len(all)/2].Nanoseconds()), "p50-ns")
+	b.ReportMetric(float64(all[len(all)*99/100].Nanoseconds()), "p99-ns")
+}
+
+func BenchmarkBus(b *testing.B) {
+	cases := []struct {
+		impl   string
+		shards int
+	}{
+		{"legacy", 1},
+		{"mailbox", 1},
+		{"mailbox", 4},
+	}
+	for _, c := range cases {
+		name := fmt.Sprintf("%s/shards=%d", c.impl, c.shards)
+		b.Run(name+"/fanout", func(b *testing.B) {
+			runBusBenchmark(b, c.impl, c.shards, 8, 8, 0)
+		})
+		b.Run(name+"/slow-subscriber", func(b *testing.B) {
+			runBusBenchmark(b, c.impl, c.shards, 8, 8, 1)
+		})
+	}
+}
+
+// BenchmarkBusHop measures a single message passing from a publisher to a subscriber.
+func BenchmarkBusHop(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	bus := NewBus[int, int](zap.NewNop())
+	_ = bus.Start(ctx)
+	sub := bus.Subscribe(ctx, 1)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		bus.PublishTimeout(ctx, 1, i, time.Millisecond)
+		<-sub
+	}
+}
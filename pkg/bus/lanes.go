@@ -0,0 +1,250 @@
+package bus
+
+import (
+	"context"
+	"sync"
+)
+
+// Lane determines the delivery order of a message.
+// Messages on the priority lane are delivered ahead of messages queued on the bulk lane,
+// except for bulk messages with the same key, which are delivered first to keep the order of the key.
+type Lane uint8
+
+const (
+	LanePriority Lane = iota
+	LaneBulk
+)
+
+// LaneFunc assigns a lane to a message.
+type LaneFunc[M message] func(msg M) Lane
+
+// MergeFunc merges src into dst, which is still waiting in the queue under the same key.
+// It returns false if the messages cannot be merged.
+type MergeFunc[M message] func(dst, src M) (M, bool)
+
+// laneQueue is a bounded FIFO queue with two lanes.
+// Bulk messages with the same key are merged while they are waiting to be delivered.
+type laneQueue[K key, M message] struct {
+	lane     LaneFunc[M]
+	merge    MergeFunc[M]
+	capacity int
+
+	mu       sync.Mutex
+	priority ring[Message[K, M]]
+	bulk     ring[Message[K, M]]
+	// bulkKeys maps keys to the sequence number of the last pending bulk message.
+	bulkKeys map[K]uint64
+
+	// notify and space are signalled when a message is queued and when a lane has room.
+	// Wakeups are chained, so that coalesced signals are never lost with multiple waiters.
+	notify chan struct{}
+	space  [2]chan struct{}
+}
+
+func newLaneQueue[K key, M message](capacity int, lane LaneFunc[M], merge MergeFunc[M]) *laneQueue[K, M] {
+	return &laneQueue[K, M]{
+		lane:     lane,
+		merge:    merge,
+		capacity: capacity,
+		bulkKeys: make(map[K]uint64),
+		notify:   make(chan struct{}, 1),
+		space:    [2]chan struct{}{make(chan struct{}, 1), make(chan struct{}, 1)},
+	}
+}
+
+// push adds a message to the queue. It blocks while the lane of the message is full.
+func (q *laneQueue[K, M]) push(ctx context.Context, msg Message[K, M]) bool {
+	lane := q.laneOf(msg)
+	for {
+		ok, full := q.tryPush(msg)
+		if ok {
+			return true
+		}
+		if !full {
+			return false
+		}
+		select {
+		case <-ctx.Done():
+			return false
+		case <-q.space[lane]:
+		}
+	}
+}
+
+// tryPush adds a message to the queue without blocking. Bulk messages are merged with a pending
+// message of the same key when possible.
+func (q *laneQueue[K, M]) tryPush(msg Message[K, M]) (ok bool, full bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.laneOf(msg) == LanePriority {
+		if q.priority.len() >= q.capacity {
+			return false, true
+		}
+		if _, ok := q.bulkKeys[msg.Key]; ok {
+			q.flushBulk(msg.Key)
+		}
+		q.priority.push(msg)
+		q.signal(q.notify)
+		if q.priority.len() < q.capacity {
+			q.signal(q.space[LanePriority])
+		}
+		return true, false
+	}
+	if seq, ok := q.bulkKeys[msg.Key]; ok && q.merge != nil {
+		pending := q.bulk.at(seq)
+		merged, ok := q.merge(pending.Message, msg.Message)
+		if ok {
+			pending.Message = merged
+			return true, false
+		}
+	}
+	if q.bulk.len() >= q.capacity {
+		return false, true
+	}
+	q.bulkKeys[msg.Key] = q.bulk.push(msg)
+	q.signal(q.notify)
+	if q.bulk.len() < q.capacity {
+		q.signal(q.space[LaneBulk])
+	}
+	return true, false
+}
+
+// flushBulk moves pending bulk messages of the key to the priority lane, so that they are delivered
+// before the priority message queued after them.
+func (q *laneQueue[K, M]) flushBulk(key K) {
+	delete(q.bulkKeys, key)
+	next := q.bulk.head
+	for seq := q.bulk.head; seq < q.bulk.tail; seq++ {
+		msg := *q.bulk.at(seq)
+		if msg.Key == key {
+			q.priority.push(msg)
+			continue
+		}
+		*q.bulk.at(next) = msg
+		q.bulkKeys[msg.Key] = next
+		next++
+	}
+	q.bulk.truncate(next)
+	q.signal(q.space[LaneBulk])
+}
+
+// pop removes the next message from the queue, blocking until one is available.
+func (q *laneQueue[K, M]) pop(ctx context.Context) (Message[K, M], bool) {
+	for {
+		msg, ok := q.tryPop()
+		if ok {
+			return msg, true
+		}
+		select {
+		case <-ctx.Done():
+			return Message[K, M]{}, false
+		case <-q.notify:
+		}
+	}
+}
+
+func (q *laneQueue[K, M]) tryPop() (Message[K, M], bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if msg, ok := q.priority.pop(); ok {
+		q.signal(q.space[LanePriority])
+		q.signalPending()
+		return msg, true
+	}
+	seq := q.bulk.head
+	msg, ok := q.bulk.pop()
+	if !ok {
+		return msg, false
+	}
+	if last, ok := q.bulkKeys[msg.Key]; ok && last == seq {
+		delete(q.bulkKeys, msg.Key)
+	}
+	q.signal(q.space[LaneBulk])
+	q.signalPending()
+	return msg, true
+}
+
+func (q *laneQueue[K, M]) laneOf(msg Message[K, M]) Lane {
+	if q.lane == nil {
+		return LanePriority
+	}
+	return q.lane(msg.Message)
+}
+
+func (q *laneQueue[K, M]) signalPending() {
+	if q.priority.len()+q.bulk.len() > 0 {
+		q.signal(q.notify)
+	}
+}
+
+func (q *laneQueue[K, M]) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.priority.len() + q.bulk.len()
+}
+
+func (q *laneQueue[K, M]) signal(ch chan struct{}) {
+	select {
+	case ch <- struct{}{}:
+	default:
+	}
+}
+
+// ring is a growable FIFO ring buffer. Items are addressed by monotonically increasing sequence numbers.
+type ring[T any] struct {
+	items []T
+	head  uint64
+	tail  uint64
+}
+
+func (r *ring[T]) len() int {
+	return int(r.tail - r.head)
+}
+
+func (r *ring[T]) push(item T) uint64 {
+	if r.len() == len(r.items) {
+		r.grow()
+	}
+	seq := r.tail
+	r.items[seq%uint64(len(r.items))] = item
+	r.tail++
+	return seq
+}
+
+func (r *ring[T]) pop() (T, bool) {
+	var zero T
+	if r.len() == 0 {
+		return zero, false
+	}
+	idx := r.head % uint64(len(r.items))
+	item := r.items[idx]
+	r.items[idx] = zero
+	r.head++
+	return item, true
+}
+
+// at returns a pointer to the pending item with the given sequence number.
+func (r *ring[T]) at(seq uint64) *T {
+	return &r.items[seq%uint64(len(r.items))]
+}
+
+// truncate removes items from the given sequence number to the tail.
+func (r *ring[T]) truncate(tail uint64) {
+	var zero T
+	for seq := tail; seq < r.tail; seq++ {
+		*r.at(seq) = zero
+	}
+	r.tail = tail
+}
+
+func (r *ring[T]) grow() {
+	size := len(r.items) * 2
+	if size == 0 {
+		size = 16
+	}
+	items := make([]T, size)
+	for seq := r.head; seq < r.tail; seq++ {
+		items[seq%uint64(size)] = r.items[seq%uint64(len(r.items))]
+	}
+	r.items = items
+}
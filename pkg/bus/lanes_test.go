@@ -0,0 +1,143 @@
+package bus
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type laneTestMessage struct {
+	bulk  bool
+	value int
+}
+
+func laneTestQueue(capacity int) *laneQueue[string, laneTestMessage] {
+	return newLaneQueue[string, laneTestMessage](capacity,
+		func(msg laneTestMessage) Lane {
+			if msg.bulk {
+				return LaneBulk
+			}
+			return LanePriority
+		},
+		func(dst, src laneTestMessage) (laneTestMessage, bool) {
+			dst.value += src.value
+			return dst, true
+		},
+	)
+}
+
+func TestLaneQueuePriority(t *testing.T) {
+	q := laneTestQueue(16)
+	ctx := context.Background()
+	q.push(ctx, Message[string, laneTestMessage]{"mouse", laneTestMessage{bulk: true, value: 1}})
+	q.push(ctx, Message[string, laneTestMessage]{"kb", laneTestMessage{value: 10}})
+	q.push(ctx, Message[string, laneTestMessage]{"mouse", laneTestMessage{bulk: true, value: 2}})
+	q.push(ctx, Message[string, laneTestMessage]{"kb", laneTestMessage{value: 20}})
+
+	expected := []Message[string, laneTestMessage]{
+		{"kb", laneTestMessage{value: 10}},
+		{"kb", laneTestMessage{value: 20}},
+		{"mouse", laneTestMessage{bulk: true, value: 3}},
+	}
+	for i, exp := range expected {
+		msg, ok := q.tryPop()
+		if !ok {
+			t.Fatalf("message %d: queue is empty", i)
+		}
+		if msg != exp {
+			t.Fatalf("message %d: expected %v, got %v", i, exp, msg)
+		}
+	}
+	if _, ok := q.tryPop(); ok {
+		t.Fatal("queue should be empty")
+	}
+}
+
+func TestLaneQueueMergeAfterPop(t *testing.T) {
+	q := laneTestQueue(16)
+	ctx := context.Background()
+	q.push(ctx, Message[string, laneTestMessage]{"mouse", laneTestMessage{bulk: true, value: 1}})
+	if msg, _ := q.tryPop(); msg.Message.value != 1 {
+		t.Fatalf("expected 1, got %d", msg.Message.value)
+	}
+	q.push(ctx, Message[string, laneTestMessage]{"mouse", laneTestMessage{bulk: true, value: 2}})
+	q.push(ctx, Message[string, laneTestMessage]{"wheel", laneTestMessage{bulk: true, value: 5}})
+	q.push(ctx, Message[string, laneTestMessage]{"mouse", laneTestMessage{bulk: true, value: 3}})
+	if msg, _ := q.tryPop(); msg.Key != "mouse" || msg.Message.value != 5 {
+		t.Fatalf("expected merged mouse message, got %v", msg)
+	}
+	if msg, _ := q.tryPop(); msg.Key != "wheel" || msg.Message.value != 5 {
+		t.Fatalf("expected wheel message, got %v", msg)
+	}
+}
+
+func TestLaneQueueBackpressure(t *testing.T) {
+	q := laneTestQueue(2)
+	ctx := context.Background()
+	q.push(ctx, Message[string, laneTestMessage]{"kb", laneTestMessage{value: 1}})
+	q.push(ctx, Message[string, laneTestMessage]{"kb", laneTestMessage{value: 2}})
+
+	// bulk lane is not affected by a full priority lane
+	if ok, _ := q.tryPush(Message[string, laneTestMessage]{"mouse", laneTestMessage{bulk: true, value: 1}}); !ok {
+		t.Fatal("bulk message should not be blocked by priority lane")
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Millisecond)
+	defer cancel()
+	if q.push(timeoutCtx, Message[string, laneTestMessage]{"kb", laneTestMessage{value: 3}}) {
+		t.Fatal("push should time out on a full lane")
+	}
+
+	done := make(chan bool)
+	go func() {
+		done <- q.push(ctx, Message[string, laneTestMessage]{"kb", laneTestMessage{value: 3}})
+	}()
+	q.pop(ctx)
+	if !<-done {
+		t.Fatal("push should succeed after pop")
+	}
+	if q.len() != 3 {
+		t.Fatalf("expected 3 messages, got %d", q.len())
+	}
+}
+
+func TestLaneQueueKeyOrder(t *testing.T) {
+	q := laneTestQueue(16)
+	ctx := context.Background()
+	q.push(ctx, Message[string, laneTestMessage]{"mouse", laneTestMessage{value: 1}})
+	q.push(ctx, Message[string, laneTestMessage]{"wheel", laneTestMessage{bulk: true, value: 5}})
+	q.push(ctx, Message[string, laneTestMessage]{"mouse", laneTestMessage{bulk: true, value: 2}})
+	q.push(ctx, Message[string, laneTestMessage]{"mouse", laneTestMessage{value: 3}})
+
+	// the move of a drag is delivered before the release
+	expected := []Message[string, laneTestMessage]{
+		{"mouse", laneTestMessage{value: 1}},
+		{"mouse", laneTestMessage{bulk: true, value: 2}},
+		{"mouse", laneTestMessage{value: 3}},
+		{"wheel", laneTestMessage{bulk: true, value: 5}},
+	}
+	for i, exp := range expected {
+		msg, ok := q.tryPop()
+		if !ok {
+			t.Fatalf("message %d: queue is empty", i)
+		}
+		if msg != exp {
+			t.Fatalf("message %d: expected %v, got %v", i, exp, msg)
+		}
+	}
+
+	// pending messages of other keys are still merged after the flush
+	q.push(ctx, Message[string, laneTestMessage]{"wheel", laneTestMessage{bulk: true, value: 1}})
+	q.push(ctx, Message[string, laneTestMessage]{"mouse", laneTestMessage{bulk: true, value: 1}})
+	q.push(ctx, Message[string, laneTestMessage]{"mouse", laneTestMessage{value: 2}})
+	q.push(ctx, Message[string, laneTestMessage]{"wheel", laneTestMessage{bulk: true, value: 1}})
+	for _, exp := range []Message[string, laneTestMessage]{
+		{"mouse", laneTestMessage{bulk: true, value: 1}},
+		{"mouse", laneTestMessage{value: 2}},
+		{"wheel", laneTestMessage{bulk: true, value: 2}},
+	} {
+		if msg, _ := q.tryPop(); msg != exp {
+			t.Fatalf("expected %v, got %v", exp, msg)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package bus
+
+import (
+	"context"
+
+	"github.com/puzpuzpuz/xsync/v3"
+)
+
+// mailbox buffers messages of a single subscriber, so that a slow subscriber only blocks bus workers
+// when its mailbox is full of priority messages.
+type mailbox[K key, M message] struct {
+	ctx   context.Context
+	queue *laneQueue[K, M]
+	ch    chan Message[K, M]
+}
+
+func newMailbox[K key, M message](ctx context.Context, capacity int, lane LaneFunc[M], merge MergeFunc[M]) *mailbox[K, M] {
+	return &mailbox[K, M]{
+		ctx:   ctx,
+		queue: newLaneQueue[K, M](capacity, lane, merge),
+		ch:    make(chan Message[K, M]),
+	}
+}
+
+// push queues the message, blocking while the lane of the message is full until either the context
+// or the context of the subscriber is done.
+func (m *mailbox[K, M]) push(ctx context.Context, msg Message[K, M]) bool {
+	if ok, full := m.queue.tryPush(msg); ok || !full {
+		return ok
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stop := context.AfterFunc(m.ctx, cancel)
+	defer stop()
+	return m.queue.push(ctx, msg)
+}
+
+// run forwards queued messages to the subscriber channel until the context is done.
+// A popped message that cannot be forwarded is passed to release.
+func (m *mailbox[K, M]) run(release func(M)) {
+	for {
+		msg, ok := m.queue.pop(m.ctx)
+		if !ok {
+			return
+		}
+		select {
+		case <-m.ctx.Done():
+			release(msg.Message)
+			return
+		case m.ch <- msg:
+		}
+	}
+}
+
+// drain releases messages that were not delivered before the subscriber left.
+func (m *mailbox[K, M]) drain(release func(M)) {
+	for {
+		msg, ok := m.queue.tryPop()
+		if !ok {
+			return
+		}
+		release(msg.Message)
+	}
+}
+
+// addSubscriber and removeSubscriber replace subscriber slices instead of modifying them,
+// so that workers can iterate over them without locking.
+func addSubscriber[K key, T comparable](subs *xsync.MapOf[K, []T], k K, sub T) {
+	subs.Compute(k, func(val []T, _ bool) ([]T, bool) {
+		next := make([]T, len(val), len(val)+1)
+		copy(next, val)
+		return append(next, sub), false
+	})
+}
+
+func removeSubscriber[K key, T comparable](subs *xsync.MapOf[K, []T], k K, sub T) {
+	subs.Compute(k, func(val []T, _ bool) ([]T, bool) {
+		next := make([]T, 0, len(val))
+		for _, s := range val {
+			if s != sub {
+				next = append(next, s)
+			}
+		}
+		return next, len(next) == 0
+	})
+}
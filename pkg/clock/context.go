@@ -0,0 +1,19 @@
+package clock
+
+import "context"
+
+type contextKey struct{}
+
+// WithContext returns a context that carries the clock, so that components created deep in a flow
+// graph can share the clock of whoever runs it.
+func WithContext(ctx context.Context, c Clock) context.Context {
+	return context.WithValue(ctx, contextKey{}, c)
+}
+
+// FromContext returns the clock carried by the context, or the real clock.
+func FromContext(ctx context.Context) Clock {
+	if c, ok := ctx.Value(contextKey{}).(Clock); ok {
+		return c
+	}
+	return Real()
+}
@@ -0,0 +1,170 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Virtual is a clock that only moves when it is advanced. Timers fire in deadline order,
+// and the clock is set to the deadline of each timer while it fires.
+type Virtual struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	now    time.Time
+	seq    uint64
+	timers []*virtualTimer
+}
+
+func NewVirtual(start time.Time) *Virtual {
+	v := &Virtual{
+		now: start,
+	}
+	v.cond = sync.NewCond(&v.mu)
+	return v
+}
+
+func (v *Virtual) Now() time.Time {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.now
+}
+
+func (v *Virtual) Since(t time.Time) time.Duration {
+	return v.Now().Sub(t)
+}
+
+func (v *Virtual) After(d time.Duration) <-chan time.Time {
+	return v.NewTimer(d).C()
+}
+
+func (v *Virtual) NewTimer(d time.Duration) Timer {
+	t := &virtualTimer{
+		clock: v,
+		c:     make(chan time.Time, 1),
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.schedule(t, d)
+	return t
+}
+
+// Advance moves the clock forward, firing every timer with a deadline up to the new time.
+// Timers created by goroutines woken during Advance are fired by later calls.
+func (v *Virtual) Advance(d time.Duration) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	target := v.now.Add(d)
+	for {
+		t := v.next(target)
+		if t == nil {
+			break
+		}
+		v.now = t.deadline
+		v.remove(t)
+		t.fire(v.now)
+	}
+	v.now = target
+}
+
+// BlockUntil waits until at least n timers are pending. It lets tests synchronize with goroutines
+// that are about to wait on the clock.
+func (v *Virtual) BlockUntil(n int) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for len(v.timers) < n {
+		v.cond.Wait()
+	}
+}
+
+// Next returns the deadline of the earliest pending timer.
+func (v *Virtual) Next() (time.Time, bool) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if len(v.timers) == 0 {
+		return time.Time{}, false
+	}
+	next := v.timers[0].deadline
+	for _, t := range v.timers[1:] {
+		if t.deadline.Before(next) {
+			next = t.deadline
+		}
+	}
+	return next, true
+}
+
+// Pending returns the number of timers that have not fired or been stopped.
+func (v *Virtual) Pending() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.timers)
+}
+
+func (v *Virtual) schedule(t *virtualTimer, d time.Duration) {
+	t.deadline = v.now.Add(d)
+	if d <= 0 {
+		t.fire(v.now)
+		return
+	}
+	v.seq++
+	t.seq = v.seq
+	v.timers = append(v.timers, t)
+	v.cond.Broadcast()
+}
+
+// next returns the earliest timer due by the target time. Timers with equal deadlines fire
+// in creation order.
+func (v *Virtual) next(target time.Time) *virtualTimer {
+	var next *virtualTimer
+	for _, t := range v.timers {
+		if t.deadline.After(target) {
+			continue
+		}
+		if next == nil || t.deadline.Before(next.deadline) || (t.deadline.Equal(next.deadline) && t.seq < next.seq) {
+			next = t
+		}
+	}
+	return next
+}
+
+func (v *Virtual) remove(t *virtualTimer) bool {
+	for i, pending := range v.timers {
+		if pending == t {
+			v.timers = append(v.timers[:i], v.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+type virtualTimer struct {
+	clock    *Virtual
+	c        chan time.Time
+	deadline time.Time
+	seq      uint64
+}
+
+func (t *virtualTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *virtualTimer) Stop() bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	return t.clock.remove(t)
+}
+
+func (t *virtualTimer) Reset(d time.Duration) bool {
+	t.clock.mu.Lock()
+	defer t.clock.mu.Unlock()
+	active := t.clock.remove(t)
+	t.clock.schedule(t, d)
+	return active
+}
+
+// fire never blocks: like time.Timer, a timer that was not drained keeps the first value.
+func (t *virtualTimer) fire(now time.Time) {
+	select {
+	case t.c <- now:
+	default:
+	}
+}
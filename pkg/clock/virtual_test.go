@@ -0,0 +1,42 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+func TestVirtualAdvance(t *testing.T) {
+	start := time.Unix(0, 0)
+	v := NewVirtual(start)
+
+	late := v.After(20 * time.Millisecond)
+	early := v.After(10 * time.Millisecond)
+	stopped := v.NewTimer(5 * time.Millisecond)
+	stopped.Stop()
+
+	v.Advance(9 * time.Millisecond)
+	select {
+	case <-early:
+		t.Fatal("timer fired before its deadline")
+	default:
+	}
+	if v.Pending() != 2 {
+		t.Fatalf("expected 2 pending timers, got %d", v.Pending())
+	}
+
+	v.Advance(time.Second)
+	if at := <-early; !at.Equal(start.Add(10 * time.Millisecond)) {
+		t.Errorf("expected early timer at 10ms, got %s", at.Sub(start))
+	}
+	if at := <-late; !at.Equal(start.Add(20 * time.Millisecond)) {
+		t.Errorf("expected late timer at 20ms, got %s", at.Sub(start))
+	}
+	select {
+	case <-stopped.C():
+		t.Fatal("stopped timer fired")
+	default:
+	}
+	if now := v.Now(); !now.Equal(start.Add(time.Second + 9*time.Millisecond)) {
+		t.Errorf("unexpected time %s", now.Sub(start))
+	}
+}
@@ -0,0 +1,32 @@
+package flowtest
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestScenarios(t *testing.T) {
+	Test(t, "testdata/flow.yml", "testdata/mods.yml")
+}
+
+func TestBypass(t *testing.T) {
+	Test(t, "testdata/bypass_flow.yml", "testdata/bypass.yml")
+}
+
+func TestBypassMultipleDownstreams(t *testing.T) {
+	flow, err := LoadFlow("testdata/bypass_split_flow.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := LoadFile("testdata/bypass.yml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewRunner(zap.NewNop(), flow).Run(context.Background(), file, file.Scenarios[0])
+	if err == nil || !strings.Contains(err.Error(), "single downstream node") {
+		t.Fatalf("expected bypass of a node with two downstream nodes to be rejected, got %v", err)
+	}
+}
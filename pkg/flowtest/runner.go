@@ -0,0 +1,582 @@
+package flowtest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/goccy/go-yaml"
+	"github.com/neuroplastio/neio-agent/components/actions"
+	"github.com/neuroplastio/neio-agent/components/nodes"
+	"github.com/neuroplastio/neio-agent/hidapi"
+	"github.com/neuroplastio/neio-agent/hidapi/hiddesc"
+	"github.com/neuroplastio/neio-agent/internal/flowsvc"
+	"github.com/neuroplastio/neio-agent/internal/hidsvc"
+	"github.com/neuroplastio/neio-agent/internal/hidsvc/virtual"
+	"github.com/neuroplastio/neio-agent/pkg/clock"
+	"go.uber.org/zap"
+)
+
+var defaultRunnerOptions = runnerOptions{
+	settleTime:   10 * time.Millisecond,
+	startTimeout: 5 * time.Second,
+}
+
+type runnerOptions struct {
+	settleTime   time.Duration
+	startTimeout time.Duration
+}
+
+type Option func(*runnerOptions)
+
+// WithSettleTime sets how long the graph has to stay idle, in real time, before the virtual clock
+// is advanced. Slow machines may need a longer time.
+func WithSettleTime(d time.Duration) Option {
+	return func(o *runnerOptions) {
+		o.settleTime = d
+	}
+}
+
+// WithStartTimeout sets how long to wait for devices and nodes to start.
+func WithStartTimeout(d time.Duration) Option {
+	return func(o *runnerOptions) {
+		o.startTimeout = d
+	}
+}
+
+// Runner runs scenarios against a flow config. Every scenario builds the real graph from scratch.
+type Runner struct {
+	log     *zap.Logger
+	flow    flowsvc.FlowConfig
+	options runnerOptions
+}
+
+func NewRunner(log *zap.Logger, flow flowsvc.FlowConfig, opts ...Option) *Runner {
+	options := defaultRunnerOptions
+	for _, opt := range opts {
+		opt(&options)
+	}
+	return &Runner{
+		log:     log,
+		flow:    flow,
+		options: options,
+	}
+}
+
+// Result holds usage events captured from output nodes and differences from the expected ones.
+type Result struct {
+	Scenario string
+	Outputs  map[string]Timeline
+	Diffs    []Diff
+}
+
+func (r Result) Passed() bool {
+	return len(r.Diffs) == 0
+}
+
+func (r Result) String() string {
+	var sb strings.Builder
+	if r.Passed() {
+		fmt.Fprintf(&sb, "PASS %s\n", r.Scenario)
+		return sb.String()
+	}
+	fmt.Fprintf(&sb, "FAIL %s\n", r.Scenario)
+	for _, diff := range r.Diffs {
+		fmt.Fprintf(&sb, "  %s:\n%s", diff.Node, diff)
+	}
+	return sb.String()
+}
+
+// Diff lists expected and captured events of an output node, marking the ones that differ.
+type Diff struct {
+	Node  string
+	Lines []DiffLine
+}
+
+type DiffLine struct {
+	Kind  DiffKind
+	Event Event
+}
+
+type DiffKind uint8
+
+const (
+	DiffSame DiffKind = iota
+	DiffMissing
+	DiffUnexpected
+)
+
+func (d Diff) String() string {
+	var sb strings.Builder
+	for _, line := range d.Lines {
+		switch line.Kind {
+		case DiffSame:
+			fmt.Fprintf(&sb, "                %s\n", line.Event)
+		case DiffMissing:
+			fmt.Fprintf(&sb, "    missing     %s\n", line.Event)
+		case DiffUnexpected:
+			fmt.Fprintf(&sb, "    unexpected  %s\n", line.Event)
+		}
+	}
+	return sb.String()
+}
+
+// RunFile runs all scenarios of the file.
+func (r *Runner) RunFile(ctx context.Context, file *File) ([]Result, error) {
+	results := make([]Result, 0, len(file.Scenarios))
+	for _, scenario := range file.Scenarios {
+		result, err := r.Run(ctx, file, scenario)
+		if err != nil {
+			return results, fmt.Errorf("scenario %q: %w", scenario.Name, err)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// Run runs a scenario with devices of the file.
+func (r *Runner) Run(ctx context.Context, file *File, scenario Scenario) (Result, error) {
+	env, err := r.start(ctx, file, scenario)
+	if err != nil {
+		return Result{}, err
+	}
+	defer env.stop()
+
+	end := scenario.Duration
+	if end == 0 {
+		for _, timeline := range scenario.Input {
+			end = max(end, timeline.end())
+		}
+		for _, timeline := range scenario.Expect {
+			end = max(end, timeline.end())
+		}
+		end += time.Second
+	}
+	var steps []time.Duration
+	for _, timeline := range scenario.Input {
+		for _, event := range timeline {
+			steps = append(steps, event.At)
+		}
+	}
+	slices.Sort(steps)
+	steps = slices.Compact(steps)
+
+	for _, at := range steps {
+		if at > end {
+			break
+		}
+		env.advanceTo(at)
+		if err := env.inject(scenario.Input, at); err != nil {
+			return Result{}, err
+		}
+		env.settle()
+	}
+	env.advanceTo(end)
+
+	result := Result{
+		Scenario: scenario.Name,
+		Outputs:  env.captured(),
+	}
+	for _, nodeID := range sortedKeys(scenario.Expect) {
+		diff := diffTimelines(scenario.Expect[nodeID], result.Outputs[nodeID])
+		if diff != nil {
+			result.Diffs = append(result.Diffs, Diff{Node: nodeID, Lines: diff})
+		}
+	}
+	return result, nil
+}
+
+type nodeAddrConfig struct {
+	Addr hidsvc.Address `yaml:"addr"`
+}
+
+// env is a running graph with simulated devices.
+type env struct {
+	log     *zap.Logger
+	options runnerOptions
+	clock   *clock.Virtual
+	start   time.Time
+
+	cancel context.CancelFunc
+	done   sync.WaitGroup
+	dbDir  string
+	db     *badger.DB
+
+	inputs  map[string]*envInput
+	outputs map[string]*envOutput
+
+	mu       sync.Mutex
+	activity int
+}
+
+type envInput struct {
+	device *virtual.Input
+	state  *hidapi.ReportState
+}
+
+type envOutput struct {
+	device *virtual.Output
+	events Timeline
+}
+
+func (r *Runner) start(parent context.Context, file *File, scenario Scenario) (_ *env, err error) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	e := &env{
+		log:     r.log,
+		options: r.options,
+		clock:   clock.NewVirtual(start),
+		start:   start,
+		inputs:  make(map[string]*envInput),
+		outputs: make(map[string]*envOutput),
+	}
+	ctx, cancel := context.WithCancel(clock.WithContext(parent, e.clock))
+	e.cancel = cancel
+	defer func() {
+		if err != nil {
+			e.stop()
+		}
+	}()
+
+	inputAddrs := make(map[string]hidsvc.Address)
+	outputAddrs := make(map[string]hidsvc.Address)
+	for _, node := range r.flow.Nodes {
+		if node.Type != "input" && node.Type != "output" {
+			continue
+		}
+		cfg := nodeAddrConfig{}
+		if err := yaml.Unmarshal(node.Config, &cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse address of node %s: %w", node.ID, err)
+		}
+		if node.Type == "input" {
+			inputAddrs[node.ID] = cfg.Addr
+		} else {
+			outputAddrs[node.ID] = cfg.Addr
+		}
+	}
+	for nodeID := range scenario.Input {
+		addr, ok := inputAddrs[nodeID]
+		if !ok {
+			return nil, fmt.Errorf("input node %s not found", nodeID)
+		}
+		if _, ok := file.descriptors[addr]; !ok {
+			return nil, fmt.Errorf("no report descriptor for device %s of node %s", addr, nodeID)
+		}
+	}
+	for nodeID := range scenario.Expect {
+		if _, ok := outputAddrs[nodeID]; !ok {
+			return nil, fmt.Errorf("output node %s not found", nodeID)
+		}
+	}
+
+	backends := make(map[string]*virtual.Backend)
+	backend := func(name string) *virtual.Backend {
+		if _, ok := backends[name]; !ok {
+			backends[name] = virtual.NewBackend(r.log.Named("hid.virtual"), virtual.WithClock(e.clock))
+		}
+		return backends[name]
+	}
+	devices := make(map[hidsvc.Address]*virtual.Input, len(file.descriptors))
+	for addr, desc := range file.descriptors {
+		input, err := backend(addr.Backend).AddInput(addr.ID, addr.String(), desc)
+		if err != nil {
+			return nil, fmt.Errorf("failed to add input device %s: %w", addr, err)
+		}
+		devices[addr] = input
+	}
+	for nodeID, addr := range outputAddrs {
+		output, ok := backend(addr.Backend).Output(addr.ID)
+		if !ok {
+			output, err = backend(addr.Backend).AddOutput(addr.ID, addr.String())
+			if err != nil {
+				return nil, fmt.Errorf("failed to add output device %s: %w", addr, err)
+			}
+		}
+		e.outputs[nodeID] = &envOutput{device: output}
+	}
+
+	e.dbDir, err = os.MkdirTemp("", "neio-flowtest-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create database directory: %w", err)
+	}
+	dbOptions := badger.DefaultOptions(e.dbDir)
+	dbOptions.Logger = nil
+	e.db, err = badger.Open(dbOptions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open badger db: %w", err)
+	}
+
+	hidOpts := make([]hidsvc.Option, 0, len(backends))
+	for name, b := range backends {
+		hidOpts = append(hidOpts, hidsvc.WithBackend(name, b))
+	}
+	hidSvc := hidsvc.New(e.db, r.log.Named("hid"), e.clock.Now, hidOpts...)
+	e.goRun(func() {
+		if err := hidSvc.Start(ctx); err != nil {
+			r.log.Error("HID service failed", zap.Error(err))
+		}
+	})
+	if err := e.wait(ctx, "HID service", func() bool {
+		select {
+		case <-hidSvc.Ready():
+			return true
+		default:
+			return false
+		}
+	}); err != nil {
+		return nil, err
+	}
+	for addr := range devices {
+		if err := e.wait(ctx, "device "+addr.String(), func() bool { return hidSvc.IsInputConnected(addr) }); err != nil {
+			return nil, err
+		}
+	}
+	for _, addr := range outputAddrs {
+		if err := e.wait(ctx, "device "+addr.String(), func() bool { return hidSvc.IsOutputConnected(addr) }); err != nil {
+			return nil, err
+		}
+	}
+
+	registry := flowsvc.NewRegistry()
+	nodes.Register(r.log, registry)
+	hidSvc.RegisterNodes(registry)
+	actions.Register(registry)
+
+	flowBus := flowsvc.NewFlowBus(r.log.Named("flow"))
+	if err := flowBus.Start(ctx); err != nil {
+		return nil, fmt.Errorf("failed to start flow bus: %w", err)
+	}
+	graph, err := flowsvc.BuildGraph(ctx, r.log.Named("flow"), registry, flowBus, r.flow, nil)
+	if err != nil {
+		return nil, err
+	}
+	e.goRun(graph.Run)
+
+	for nodeID, output := range e.outputs {
+		if err := e.wait(ctx, "output node "+nodeID, output.device.IsOpen); err != nil {
+			return nil, err
+		}
+		desc, err := hiddesc.Decode(output.device.Descriptor())
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode report descriptor of node %s: %w", nodeID, err)
+		}
+		output := output
+		state := hidapi.NewReportState(r.log, hidapi.NewDataItemSet(desc).WithType(hiddesc.MainItemTypeInput), hidapi.WithReportClock(e.clock))
+		e.goRun(func() {
+			e.capture(ctx, output, state)
+		})
+	}
+	for nodeID := range scenario.Input {
+		input := devices[inputAddrs[nodeID]]
+		if err := e.wait(ctx, "input node "+nodeID, input.IsAcquired); err != nil {
+			return nil, err
+		}
+		desc, err := hiddesc.Decode(file.descriptors[inputAddrs[nodeID]])
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode report descriptor of node %s: %w", nodeID, err)
+		}
+		e.inputs[nodeID] = &envInput{
+			device: input,
+			state:  hidapi.NewReportState(r.log, hidapi.NewDataItemSet(desc).WithType(hiddesc.MainItemTypeInput), hidapi.WithReportClock(e.clock)),
+		}
+	}
+	e.settle()
+	// reports captured while nodes started are not part of the scenario
+	e.mu.Lock()
+	for _, output := range e.outputs {
+		output.events = nil
+	}
+	e.mu.Unlock()
+	return e, nil
+}
+
+func (e *env) goRun(fn func()) {
+	e.done.Add(1)
+	go func() {
+		defer e.done.Done()
+		fn()
+	}()
+}
+
+func (e *env) stop() {
+	e.cancel()
+	e.done.Wait()
+	if e.db != nil {
+		e.db.Close()
+	}
+	if e.dbDir != "" {
+		os.RemoveAll(e.dbDir)
+	}
+}
+
+// wait polls cond in real time until it holds.
+func (e *env) wait(ctx context.Context, what string, cond func() bool) error {
+	deadline := time.Now().Add(e.options.startTimeout)
+	for !cond() {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return nil
+}
+
+func (e *env) capture(ctx context.Context, output *envOutput, state *hidapi.ReportState) {
+	for {
+		select {
+		case report := <-output.device.Reports():
+			event := state.ApplyReport(report.Data)
+			at := report.Time.Sub(e.start)
+			e.mu.Lock()
+			for _, usage := range event.Usages() {
+				output.events = append(output.events, Event{At: at, Usage: usage})
+			}
+			e.activity++
+			e.mu.Unlock()
+			event.Release()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// settle waits until no reports are captured and no timers are added for the settle time.
+// Nodes run on their own goroutines, so idleness in real time is the only sign that they are done
+// with the current virtual time.
+func (e *env) settle() {
+	last := e.snapshot()
+	for {
+		time.Sleep(e.options.settleTime)
+		current := e.snapshot()
+		if current == last {
+			return
+		}
+		last = current
+	}
+}
+
+type envSnapshot struct {
+	activity int
+	pending  int
+	next     time.Time
+}
+
+func (e *env) snapshot() envSnapshot {
+	e.mu.Lock()
+	activity := e.activity
+	e.mu.Unlock()
+	next, _ := e.clock.Next()
+	return envSnapshot{
+		activity: activity,
+		pending:  e.clock.Pending(),
+		next:     next,
+	}
+}
+
+// advanceTo fires timers one deadline at a time, letting the graph settle after each of them.
+func (e *env) advanceTo(at time.Duration) {
+	target := e.start.Add(at)
+	for {
+		next, ok := e.clock.Next()
+		if !ok || next.After(target) {
+			break
+		}
+		e.clock.Advance(next.Sub(e.clock.Now()))
+		e.settle()
+	}
+	if d := target.Sub(e.clock.Now()); d > 0 {
+		e.clock.Advance(d)
+	}
+}
+
+// inject sends reports with events of all inputs at the time.
+func (e *env) inject(inputs map[string]Timeline, at time.Duration) error {
+	for _, nodeID := range sortedKeys(inputs) {
+		input := e.inputs[nodeID]
+		event := hidapi.NewEventAt(e.clock.Now())
+		for _, ev := range inputs[nodeID] {
+			if ev.At == at {
+				event.AddUsage(ev.Usage)
+			}
+		}
+		if event.IsEmpty() {
+			event.Release()
+			continue
+		}
+		reports := input.state.ApplyEvent(event)
+		event.Release()
+		for _, report := range reports {
+			if err := input.device.Inject(report); err != nil {
+				return fmt.Errorf("failed to inject report to node %s: %w", nodeID, err)
+			}
+		}
+	}
+	return nil
+}
+
+func (e *env) captured() map[string]Timeline {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	outputs := make(map[string]Timeline, len(e.outputs))
+	for nodeID, output := range e.outputs {
+		timeline := slices.Clone(output.events)
+		timeline.sort()
+		outputs[nodeID] = timeline
+	}
+	return outputs
+}
+
+// diffTimelines returns nil if the timelines are equal, or both timelines merged along their
+// longest common subsequence.
+func diffTimelines(expected, actual Timeline) []DiffLine {
+	if slices.Equal(expected, actual) {
+		return nil
+	}
+	n, m := len(expected), len(actual)
+	lcs := make([][]int, n+1)
+	for i := range lcs {
+		lcs[i] = make([]int, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if expected[i] == actual[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+	lines := make([]DiffLine, 0, max(n, m))
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && expected[i] == actual[j]:
+			lines = append(lines, DiffLine{Kind: DiffSame, Event: expected[i]})
+			i++
+			j++
+		case j < m && (i == n || lcs[i][j+1] >= lcs[i+1][j]):
+			lines = append(lines, DiffLine{Kind: DiffUnexpected, Event: actual[j]})
+			j++
+		default:
+			lines = append(lines, DiffLine{Kind: DiffMissing, Event: expected[i]})
+			i++
+		}
+	}
+	return lines
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
@@ -0,0 +1,172 @@
+// Package flowtest runs scenarios against a flow config. A scenario feeds timed usage events to input
+// nodes and checks usage events captured from output nodes, with devices simulated in memory and time
+// driven by a virtual clock.
+package flowtest
+
+import (
+	"cmp"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+	"github.com/neuroplastio/neio-agent/hidapi"
+	"github.com/neuroplastio/neio-agent/internal/flowsvc"
+	"github.com/neuroplastio/neio-agent/internal/hidsvc"
+)
+
+// File is a set of scenarios that share simulated devices.
+type File struct {
+	// Devices maps addresses of input devices to paths of their binary report descriptors.
+	// Relative paths are resolved against the directory of the file.
+	Devices   map[string]string `yaml:"devices"`
+	Scenarios []Scenario        `yaml:"scenarios"`
+
+	descriptors map[hidsvc.Address][]byte
+}
+
+type Scenario struct {
+	Name string `yaml:"name"`
+	// Input maps input node IDs to events sent by their devices.
+	Input map[string]Timeline `yaml:"input"`
+	// Expect maps output node IDs to events expected from them. Output nodes that are not listed
+	// are not checked.
+	Expect map[string]Timeline `yaml:"expect"`
+	// Duration is the time the scenario runs for. It defaults to one second after the last event.
+	Duration time.Duration `yaml:"duration"`
+}
+
+// LoadFile reads a scenario file and the report descriptors of its devices.
+func LoadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario file: %w", err)
+	}
+	file := &File{}
+	if err := yaml.Unmarshal(data, file); err != nil {
+		return nil, fmt.Errorf("failed to parse scenario file %s: %w", path, err)
+	}
+	file.descriptors = make(map[hidsvc.Address][]byte, len(file.Devices))
+	for addrStr, descPath := range file.Devices {
+		addr, err := hidsvc.ParseAddress(addrStr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid device address %q: %w", addrStr, err)
+		}
+		if !filepath.IsAbs(descPath) {
+			descPath = filepath.Join(filepath.Dir(path), descPath)
+		}
+		desc, err := os.ReadFile(descPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read report descriptor of %s: %w", addr, err)
+		}
+		file.descriptors[addr] = desc
+	}
+	return file, nil
+}
+
+// LoadFlow reads a flow config.
+func LoadFlow(path string) (flowsvc.FlowConfig, error) {
+	cfg := flowsvc.FlowConfig{}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read flow config: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse flow config: %w", err)
+	}
+	return cfg, nil
+}
+
+// Event is a usage event at a time relative to the start of a scenario.
+// It is written as "+LeftShift@0ms", "-LeftShift@50ms", "dsk.X+=5@10ms" or "dsk.X=-3@10ms".
+type Event struct {
+	At    time.Duration
+	Usage hidapi.UsageEvent
+}
+
+func (e Event) String() string {
+	return fmt.Sprintf("%s@%s", e.Usage, e.At)
+}
+
+func ParseEvent(str string) (Event, error) {
+	str = strings.TrimSpace(str)
+	idx := strings.LastIndex(str, "@")
+	if idx < 0 {
+		return Event{}, fmt.Errorf("missing time in event %q", str)
+	}
+	at, err := time.ParseDuration(str[idx+1:])
+	if err != nil {
+		return Event{}, fmt.Errorf("invalid time in event %q: %w", str, err)
+	}
+	usage, err := hidapi.ParseUsageEvent(str[:idx])
+	if err != nil {
+		return Event{}, fmt.Errorf("invalid event %q: %w", str, err)
+	}
+	return Event{At: at, Usage: usage}, nil
+}
+
+// Timeline is a list of events sorted by time. In YAML it is either a comma-separated string or
+// a list of strings.
+type Timeline []Event
+
+func ParseTimeline(str string) (Timeline, error) {
+	var timeline Timeline
+	for _, part := range strings.Split(str, ",") {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		event, err := ParseEvent(part)
+		if err != nil {
+			return nil, err
+		}
+		timeline = append(timeline, event)
+	}
+	timeline.sort()
+	return timeline, nil
+}
+
+func (t *Timeline) UnmarshalYAML(data []byte) error {
+	var parts []string
+	if err := yaml.Unmarshal(data, &parts); err != nil {
+		var str string
+		if err := yaml.Unmarshal(data, &str); err != nil {
+			return fmt.Errorf("expected a string or a list of events")
+		}
+		parts = []string{str}
+	}
+	timeline, err := ParseTimeline(strings.Join(parts, ","))
+	if err != nil {
+		return err
+	}
+	*t = timeline
+	return nil
+}
+
+func (t Timeline) String() string {
+	parts := make([]string, 0, len(t))
+	for _, event := range t {
+		parts = append(parts, event.String())
+	}
+	return strings.Join(parts, ", ")
+}
+
+// sort orders events by time. Events at the same time are ordered by their text, because reports
+// do not define an order of usages.
+func (t Timeline) sort() {
+	slices.SortStableFunc(t, func(a, b Event) int {
+		if c := cmp.Compare(a.At, b.At); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Usage.String(), b.Usage.String())
+	})
+}
+
+func (t Timeline) end() time.Duration {
+	if len(t) == 0 {
+		return 0
+	}
+	return t[len(t)-1].At
+}
@@ -0,0 +1,36 @@
+package flowtest
+
+import (
+	"context"
+	"testing"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest"
+)
+
+// Test runs scenarios of the files against the flow config, each as a subtest of t.
+func Test(t *testing.T, flowPath string, scenarioPaths ...string) {
+	t.Helper()
+	flow, err := LoadFlow(flowPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range scenarioPaths {
+		file, err := LoadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, scenario := range file.Scenarios {
+			t.Run(scenario.Name, func(t *testing.T) {
+				runner := NewRunner(zaptest.NewLogger(t, zaptest.Level(zap.WarnLevel)), flow)
+				result, err := runner.Run(context.Background(), file, scenario)
+				if err != nil {
+					t.Fatal(err)
+				}
+				for _, diff := range result.Diffs {
+					t.Errorf("unexpected events of %s:\n%s", diff.Node, diff)
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,234 @@
+// Package metrics provides counters and histograms exposed in the Prometheus text format.
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type metricType string
+
+const (
+	typeCounter   metricType = "counter"
+	typeGauge     metricType = "gauge"
+	typeHistogram metricType = "histogram"
+)
+
+// DefaultBuckets are histogram buckets in seconds, suited for latencies from tens of microseconds
+// to a second.
+var DefaultBuckets = []float64{0.00005, 0.0001, 0.00025, 0.0005, 0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1}
+
+// Registry holds metric families. Families are registered once and live as long as the registry.
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		families: make(map[string]*family),
+	}
+}
+
+// Default is the registry used by instrumented packages.
+var Default = NewRegistry()
+
+type family struct {
+	name    string
+	help    string
+	typ     metricType
+	labels  []string
+	buckets []float64
+
+	mu      sync.RWMutex
+	metrics map[string]*metric
+}
+
+type metric struct {
+	values []string
+	// bits is the counter or gauge value, or the sum of a histogram, as float64 bits.
+	bits    atomic.Uint64
+	count   atomic.Uint64
+	buckets []atomic.Uint64
+}
+
+func (r *Registry) register(name, help string, typ metricType, buckets []float64, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if f, ok := r.families[name]; ok {
+		if f.typ != typ || !slices.Equal(f.labels, labels) {
+			panic(fmt.Sprintf("metric %s is already registered with a different type or labels", name))
+		}
+		return f
+	}
+	f := &family{
+		name:    name,
+		help:    help,
+		typ:     typ,
+		labels:  labels,
+		buckets: buckets,
+		metrics: make(map[string]*metric),
+	}
+	r.families[name] = f
+	return f
+}
+
+func (f *family) with(values []string) *metric {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric %s has %d labels, got %d values", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+	f.mu.RLock()
+	m, ok := f.metrics[key]
+	f.mu.RUnlock()
+	if ok {
+		return m
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if m, ok := f.metrics[key]; ok {
+		return m
+	}
+	m = &metric{
+		values:  slices.Clone(values),
+		buckets: make([]atomic.Uint64, len(f.buckets)),
+	}
+	f.metrics[key] = m
+	return m
+}
+
+func (m *metric) add(v float64) {
+	for {
+		old := m.bits.Load()
+		if m.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+func (m *metric) value() float64 {
+	return math.Float64frombits(m.bits.Load())
+}
+
+// CounterVec is a family of counters partitioned by label values.
+type CounterVec struct {
+	f *family
+}
+
+// Counter registers a counter family. Registering the same name again returns the same family.
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{f: r.register(name, help, typeCounter, nil, labels)}
+}
+
+// NewCounter registers a counter family in the default registry.
+func NewCounter(name, help string, labels ...string) *CounterVec {
+	return Default.Counter(name, help, labels...)
+}
+
+// With returns the counter for the label values, in the order of label names.
+// Counters should be looked up once and kept by hot paths.
+func (v *CounterVec) With(values ...string) *Counter {
+	return &Counter{m: v.f.with(values)}
+}
+
+type Counter struct {
+	m *metric
+}
+
+func (c *Counter) Inc() {
+	c.m.count.Add(1)
+}
+
+func (c *Counter) Add(n uint64) {
+	c.m.count.Add(n)
+}
+
+func (c *Counter) Value() uint64 {
+	return c.m.count.Load()
+}
+
+// GaugeVec is a family of gauges partitioned by label values.
+type GaugeVec struct {
+	f *family
+}
+
+// Gauge registers a gauge family. Registering the same name again returns the same family.
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{f: r.register(name, help, typeGauge, nil, labels)}
+}
+
+// NewGauge registers a gauge family in the default registry.
+func NewGauge(name, help string, labels ...string) *GaugeVec {
+	return Default.Gauge(name, help, labels...)
+}
+
+func (v *GaugeVec) With(values ...string) *Gauge {
+	return &Gauge{m: v.f.with(values)}
+}
+
+type Gauge struct {
+	m *metric
+}
+
+func (g *Gauge) Set(v float64) {
+	g.m.bits.Store(math.Float64bits(v))
+}
+
+func (g *Gauge) Add(v float64) {
+	g.m.add(v)
+}
+
+func (g *Gauge) Value() float64 {
+	return g.m.value()
+}
+
+// HistogramVec is a family of histograms partitioned by label values.
+type HistogramVec struct {
+	f *family
+}
+
+// Histogram registers a histogram family with upper bounds of buckets in increasing order.
+// Registering the same name again returns the same family.
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if !slices.IsSorted(buckets) {
+		panic(fmt.Sprintf("buckets of metric %s are not sorted", name))
+	}
+	return &HistogramVec{f: r.register(name, help, typeHistogram, buckets, labels)}
+}
+
+// NewHistogram registers a histogram family in the default registry.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	return Default.Histogram(name, help, buckets, labels...)
+}
+
+func (v *HistogramVec) With(values ...string) *Histogram {
+	return &Histogram{m: v.f.with(values), bounds: v.f.buckets}
+}
+
+type Histogram struct {
+	m      *metric
+	bounds []float64
+}
+
+func (h *Histogram) Observe(v float64) {
+	// buckets are stored non-cumulative and summed when written
+	if i, _ := slices.BinarySearch(h.bounds, v); i < len(h.bounds) {
+		h.m.buckets[i].Add(1)
+	}
+	h.m.add(v)
+	h.m.count.Add(1)
+}
+
+// ObserveDuration observes the duration in seconds.
+func (h *Histogram) ObserveDuration(d time.Duration) {
+	h.Observe(d.Seconds())
+}
+
+func (h *Histogram) Count() uint64 {
+	return h.m.count.Load()
+}
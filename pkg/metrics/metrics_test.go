@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	events := r.Counter("test_events_total", "Events by node.", "node")
+	events.With("kb").Add(3)
+	events.With(`a"b`).Inc()
+	r.Gauge("test_queue", "Queue length.").With().Set(2.5)
+	latency := r.Histogram("test_latency_seconds", "Latency.", []float64{0.001, 0.01}, "node")
+	h := latency.With("out")
+	h.ObserveDuration(500 * time.Microsecond)
+	h.ObserveDuration(5 * time.Millisecond)
+	h.ObserveDuration(time.Second)
+	r.Counter("test_unused_total", "Never used.")
+
+	var sb strings.Builder
+	if err := r.WriteText(&sb); err != nil {
+		t.Fatal(err)
+	}
+	expected := `# HELP test_events_total Events by node.
+# TYPE test_events_total counter
+test_events_total{node="a\"b"} 1
+test_events_total{node="kb"} 3
+# HELP test_latency_seconds Latency.
+# TYPE test_latency_seconds histogram
+test_latency_seconds_bucket{node="out",le="0.001"} 1
+test_latency_seconds_bucket{node="out",le="0.01"} 2
+test_latency_seconds_bucket{node="out",le="+Inf"} 3
+test_latency_seconds_sum{node="out"} 1.0055
+test_latency_seconds_count{node="out"} 3
+# HELP test_queue Queue length.
+# TYPE test_queue gauge
+test_queue 2.5
+`
+	if sb.String() != expected {
+		t.Errorf("unexpected output:\n%s\nexpected:\n%s", sb.String(), expected)
+	}
+}
@@ -0,0 +1,160 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ContentType is the content type of the Prometheus text format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// WriteText writes all metrics in the Prometheus text format, sorted by name and label values.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	slices.SortFunc(families, func(a, b *family) int {
+		return strings.Compare(a.name, b.name)
+	})
+
+	bw := bufio.NewWriter(w)
+	for _, f := range families {
+		f.write(bw)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	return nil
+}
+
+func (f *family) write(w *bufio.Writer) {
+	f.mu.RLock()
+	metrics := make([]*metric, 0, len(f.metrics))
+	for _, m := range f.metrics {
+		metrics = append(metrics, m)
+	}
+	f.mu.RUnlock()
+	if len(metrics) == 0 {
+		return
+	}
+	slices.SortFunc(metrics, func(a, b *metric) int {
+		return slices.Compare(a.values, b.values)
+	})
+
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.typ)
+	for _, m := range metrics {
+		switch f.typ {
+		case typeCounter:
+			writeSample(w, f.name, f.labels, m.values, "", "", float64(m.count.Load()))
+		case typeGauge:
+			writeSample(w, f.name, f.labels, m.values, "", "", m.value())
+		case typeHistogram:
+			// count is loaded first, so that buckets never exceed it
+			count := m.count.Load()
+			sum := m.value()
+			var cumulative uint64
+			for i, bound := range f.buckets {
+				cumulative += m.buckets[i].Load()
+				writeSample(w, f.name+"_bucket", f.labels, m.values, "le", formatFloat(bound), float64(min(cumulative, count)))
+			}
+			writeSample(w, f.name+"_bucket", f.labels, m.values, "le", "+Inf", float64(count))
+			writeSample(w, f.name+"_sum", f.labels, m.values, "", "", sum)
+			writeSample(w, f.name+"_count", f.labels, m.values, "", "", float64(count))
+		}
+	}
+}
+
+func writeSample(w *bufio.Writer, name string, labels, values []string, extraLabel, extraValue string, v float64) {
+	w.WriteString(name)
+	if len(labels) > 0 || extraLabel != "" {
+		w.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", label, escapeLabel(values[i]))
+		}
+		if extraLabel != "" {
+			if len(labels) > 0 {
+				w.WriteByte(',')
+			}
+			fmt.Fprintf(w, "%s=\"%s\"", extraLabel, extraValue)
+		}
+		w.WriteByte('}')
+	}
+	w.WriteByte(' ')
+	w.WriteString(formatFloat(v))
+	w.WriteByte('\n')
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
+
+// Handler serves the metrics of the registry.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		r.WriteText(w)
+	})
+}
+
+// Serve exposes the metrics at /metrics until the context is cancelled. The address is either
+// a TCP address or a Unix socket path prefixed with "unix:".
+func Serve(ctx context.Context, log *zap.Logger, addr string, r *Registry) error {
+	network := "tcp"
+	if path, ok := strings.CutPrefix(addr, "unix:"); ok {
+		network, addr = "unix", path
+		if err := os.Remove(addr); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+	listener, err := net.Listen(network, addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen for metrics: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", r.Handler())
+	server := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	log.Info("Serving metrics", zap.String("addr", listener.Addr().String()))
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("failed to serve metrics: %w", err)
+	}
+	return nil
+}